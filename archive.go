@@ -0,0 +1,85 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// ArchivedTask is a dead task evicted from the dead queue.
+type ArchivedTask struct {
+	ID      string                 `json:"id"`
+	Type    string                 `json:"type"`
+	Queue   string                 `json:"queue"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the server could not read it.
+	Redacted bool `json:"redacted,omitempty"`
+
+	ErrorMsg string `json:"error_msg,omitempty"`
+	Retried  int    `json:"retried"`
+	MaxRetry int    `json:"max_retry"`
+
+	// DiedAt is the time the task was moved to the dead queue.
+	DiedAt time.Time `json:"died_at"`
+
+	// ArchivedAt is the time the task was passed to the archiver.
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// An Archiver keeps a record of tasks evicted from the dead queue.
+//
+// Archive is called with batches of evicted tasks. If it returns an error,
+// the whole batch is archived again later, so implementations should
+// tolerate duplicates.
+type Archiver interface {
+	Archive(tasks []*ArchivedTask) error
+}
+
+// FileArchiver is an Archiver which appends tasks to a file
+// in JSON lines format, one ArchivedTask per line.
+type FileArchiver struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileArchiver returns a new FileArchiver which appends tasks to the
+// file at path. The file is created if it does not exist.
+func NewFileArchiver(path string) (*FileArchiver, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileArchiver{f: f}, nil
+}
+
+// Archive appends the tasks to the file and syncs the file to disk.
+func (a *FileArchiver) Archive(tasks []*ArchivedTask) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w := bufio.NewWriter(a.f)
+	enc := json.NewEncoder(w)
+	for _, t := range tasks {
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+// Close closes the file.
+func (a *FileArchiver) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestFileArchiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asynq-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.jsonl")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	batches := [][]*ArchivedTask{
+		{
+			{ID: "id1", Type: "send_email", Queue: "default", Payload: map[string]interface{}{"to": "a@example.com"}, ErrorMsg: "timeout", Retried: 25, MaxRetry: 25, DiedAt: now, ArchivedAt: now},
+			{ID: "id2", Type: "reindex", Queue: "low", Redacted: true, ErrorMsg: "not found", DiedAt: now, ArchivedAt: now},
+		},
+		{
+			{ID: "id3", Type: "sync", Queue: "critical", DiedAt: now, ArchivedAt: now},
+		},
+	}
+
+	// Reopening the file should append to it.
+	for _, batch := range batches {
+		a, err := NewFileArchiver(path)
+		if err != nil {
+			t.Fatalf("NewFileArchiver(%q) returned error: %v", path, err)
+		}
+		if err := a.Archive(batch); err != nil {
+			t.Fatalf("(*FileArchiver).Archive returned error: %v", err)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []*ArchivedTask
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var task ArchivedTask
+		if err := json.Unmarshal(scanner.Bytes(), &task); err != nil {
+			t.Fatalf("could not decode line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, &task)
+	}
+	want := append(batches[0], batches[1]...)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("archive file contains %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
+
+// fakeArchiver records archived tasks and fails if err is set.
+type fakeArchiver struct {
+	mu    sync.Mutex
+	err   error
+	tasks []*ArchivedTask
+}
+
+func (a *fakeArchiver) Archive(tasks []*ArchivedTask) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	a.tasks = append(a.tasks, tasks...)
+	return nil
+}
+
+func TestDeadArchiver(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "a@example.com"})
+	m1.ErrorMsg = "timeout"
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2.PayloadRef = m2.ID.String() // blob store is not configured
+	diedAt := time.Now().Add(-48 * time.Hour).Unix()
+	retention := &base.DeadRetentionConfig{MaxTasks: 2, MaxAge: 24 * 60 * 60, Archive: true}
+
+	tests := []struct {
+		desc        string
+		archiveErr  error
+		wantTasks   []*ArchivedTask
+		wantEvicted int64    // number of tasks left in the evicted list
+		wantGarbage []string // blobs marked for collection
+	}{
+		{
+			desc: "archived",
+			wantTasks: []*ArchivedTask{
+				{ID: m1.ID.String(), Type: m1.Type, Queue: m1.Queue, Payload: m1.Payload, ErrorMsg: "timeout", MaxRetry: m1.Retry, DiedAt: time.Unix(diedAt, 0)},
+				{ID: m2.ID.String(), Type: m2.Type, Queue: m2.Queue, Redacted: true, MaxRetry: m2.Retry, DiedAt: time.Unix(diedAt, 0)},
+			},
+			wantEvicted: 0,
+			wantGarbage: []string{m2.PayloadRef},
+		},
+		{
+			desc:        "archiver failed",
+			archiveErr:  errors.New("disk full"),
+			wantTasks:   nil,
+			wantEvicted: 2,
+			wantGarbage: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		err := rdbClient.ReturnEvictedTasks([]*base.EvictedTask{
+			{DiedAt: diedAt, Message: m1},
+			{DiedAt: diedAt, Message: m2},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		archiver := &fakeArchiver{err: tc.archiveErr}
+		a := newDeadArchiver(deadArchiverParams{
+			logger:    testLogger,
+			broker:    rdbClient,
+			retention: retention,
+			archiver:  archiver,
+			interval:  time.Second,
+		})
+		var wg sync.WaitGroup
+		a.start(&wg)
+		time.Sleep(time.Second / 2)
+		a.terminate()
+		wg.Wait()
+
+		sortOpt := cmpopts.SortSlices(func(x, y *ArchivedTask) bool { return x.Type < y.Type })
+		ignoreOpt := cmpopts.IgnoreFields(ArchivedTask{}, "ArchivedAt")
+		if diff := cmp.Diff(tc.wantTasks, archiver.tasks, sortOpt, ignoreOpt); diff != "" {
+			t.Errorf("%s: archiver received %v, want %v; (-want,+got)\n%s", tc.desc, archiver.tasks, tc.wantTasks, diff)
+		}
+		if n := r.LLen(base.DeadEvicted).Val(); n != tc.wantEvicted {
+			t.Errorf("%s: %q has %d tasks, want %d", tc.desc, base.DeadEvicted, n, tc.wantEvicted)
+		}
+		gotGarbage := r.SMembers(base.BlobGarbage).Val()
+		if diff := cmp.Diff(tc.wantGarbage, gotGarbage, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want,+got)\n%s", tc.desc, base.BlobGarbage, diff)
+		}
+		var gotRetention base.DeadRetentionConfig
+		if err := json.Unmarshal([]byte(r.Get(base.DeadRetention).Val()), &gotRetention); err != nil {
+			t.Errorf("%s: could not decode %q: %v", tc.desc, base.DeadRetention, err)
+		} else if diff := cmp.Diff(retention, &gotRetention); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want,+got)\n%s", tc.desc, base.DeadRetention, diff)
+		}
+		// The config expires once no server refreshes it.
+		if ttl := r.TTL(base.DeadRetention).Val(); ttl <= 0 || ttl > a.retentionTTL() {
+			t.Errorf("%s: TTL %q = %v, want in (0, %v]", tc.desc, base.DeadRetention, ttl, a.retentionTTL())
+		}
+	}
+}
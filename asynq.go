@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/rdb"
 )
 
 // Task represents a unit of work to be performed.
@@ -64,6 +65,12 @@ type RedisClientOpt struct {
 	// TLS Config used to connect to a server.
 	// TLS will be negotiated only if this field is set.
 	TLSConfig *tls.Config
+
+	// Namespace of the redis keys and pub/sub channels.
+	// Applications with different namespaces can share a redis DB
+	// without seeing each other's queues, servers and stats.
+	// Default is "asynq".
+	Namespace string
 }
 
 // RedisFailoverClientOpt is used to creates a redis client that talks
@@ -95,23 +102,31 @@ type RedisFailoverClientOpt struct {
 	// TLS Config used to connect to a server.
 	// TLS will be negotiated only if this field is set.
 	TLSConfig *tls.Config
+
+	// Namespace of the redis keys and pub/sub channels.
+	// Applications with different namespaces can share a redis DB
+	// without seeing each other's queues, servers and stats.
+	// Default is "asynq".
+	Namespace string
 }
 
 // ParseRedisURI parses redis uri string and returns RedisConnOpt if uri is valid.
 // It returns a non-nil error if uri cannot be parsed.
 //
-// Three URI schemes are supported, which are redis:, redis-socket:, and redis-sentinel:.
+// Four URI schemes are supported, which are redis:, rediss:, redis-socket:, and redis-sentinel:.
+// The rediss: scheme connects to the server over TLS.
 // Supported formats are:
 //     redis://[:password@]host[:port][/dbnumber]
+//     rediss://[:password@]host[:port][/dbnumber]
 //     redis-socket://[:password@]path[?db=dbnumber]
-//     redis-sentinel://[:password@]host1[:port][,host2:[:port]][,hostN:[:port]][?master=masterName]
+//     redis-sentinel://[:password@]host1[:port][,host2:[:port]][,hostN:[:port]][/dbnumber][?master=masterName]
 func ParseRedisURI(uri string) (RedisConnOpt, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, fmt.Errorf("asynq: could not parse redis uri: %v", err)
 	}
 	switch u.Scheme {
-	case "redis":
+	case "redis", "rediss":
 		return parseRedisURI(u)
 	case "redis-socket":
 		return parseRedisSocketURI(u)
@@ -136,7 +151,11 @@ func parseRedisURI(u *url.URL) (RedisConnOpt, error) {
 	if v, ok := u.User.Password(); ok {
 		password = v
 	}
-	return RedisClientOpt{Addr: u.Host, DB: db, Password: password}, nil
+	var tlsConfig *tls.Config
+	if u.Scheme == "rediss" {
+		tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	return RedisClientOpt{Addr: u.Host, DB: db, Password: password, TLSConfig: tlsConfig}, nil
 }
 
 func parseRedisSocketURI(u *url.URL) (RedisConnOpt, error) {
@@ -163,11 +182,46 @@ func parseRedisSocketURI(u *url.URL) (RedisConnOpt, error) {
 func parseRedisSentinelURI(u *url.URL) (RedisConnOpt, error) {
 	addrs := strings.Split(u.Host, ",")
 	master := u.Query().Get("master")
+	var db int
+	var err error
+	if len(u.Path) > 0 {
+		xs := strings.Split(strings.Trim(u.Path, "/"), "/")
+		db, err = strconv.Atoi(xs[0])
+		if err != nil {
+			return nil, fmt.Errorf("asynq: could not parse redis sentinel uri: database number should be the first segment of the path")
+		}
+	}
 	var password string
 	if v, ok := u.User.Password(); ok {
 		password = v
 	}
-	return RedisFailoverClientOpt{MasterName: master, SentinelAddrs: addrs, Password: password}, nil
+	return RedisFailoverClientOpt{MasterName: master, SentinelAddrs: addrs, DB: db, Password: password}, nil
+}
+
+// createRDB returns an RDB which uses the redis client and the namespace
+// given by a redis connection configuration.
+//
+// Passing an unexpected type as a RedisConnOpt argument will cause panic.
+func createRDB(r RedisConnOpt) *rdb.RDB {
+	rdb := rdb.NewRDB(createRedisClient(r))
+	rdb.SetNamespace(redisNamespace(r))
+	return rdb
+}
+
+// redisNamespace returns the namespace given by a redis connection configuration.
+func redisNamespace(r RedisConnOpt) string {
+	switch r := r.(type) {
+	case *RedisClientOpt:
+		return r.Namespace
+	case RedisClientOpt:
+		return r.Namespace
+	case *RedisFailoverClientOpt:
+		return r.Namespace
+	case RedisFailoverClientOpt:
+		return r.Namespace
+	default:
+		return ""
+	}
 }
 
 // createRedisClient returns a redis client given a redis connection configuration.
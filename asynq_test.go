@@ -5,12 +5,14 @@
 package asynq
 
 import (
+	"crypto/tls"
 	"flag"
 	"sort"
 	"testing"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	h "github.com/hibiken/asynq/internal/asynqtest"
 	"github.com/hibiken/asynq/internal/log"
 )
@@ -78,6 +80,15 @@ func TestParseRedisURI(t *testing.T) {
 			"redis://:mypassword@127.0.0.1:6379/11",
 			RedisClientOpt{Addr: "127.0.0.1:6379", Password: "mypassword", DB: 11},
 		},
+		{
+			"rediss://:mypassword@redis.example.com:6380/2",
+			RedisClientOpt{
+				Addr:      "redis.example.com:6380",
+				Password:  "mypassword",
+				DB:        2,
+				TLSConfig: &tls.Config{ServerName: "redis.example.com"},
+			},
+		},
 		{
 			"redis-socket:///var/run/redis/redis.sock",
 			RedisClientOpt{Network: "unix", Addr: "/var/run/redis/redis.sock"},
@@ -109,6 +120,14 @@ func TestParseRedisURI(t *testing.T) {
 				Password:      "mypassword",
 			},
 		},
+		{
+			"redis-sentinel://localhost:5000,localhost:5001/3?master=mymaster",
+			RedisFailoverClientOpt{
+				MasterName:    "mymaster",
+				SentinelAddrs: []string{"localhost:5000", "localhost:5001"},
+				DB:            3,
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -118,7 +137,7 @@ func TestParseRedisURI(t *testing.T) {
 			continue
 		}
 
-		if diff := cmp.Diff(tc.want, got); diff != "" {
+		if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreUnexported(tls.Config{})); diff != "" {
 			t.Errorf("ParseRedisURI(%q) = %+v, want %+v\n(-want,+got)\n%s", tc.uri, got, tc.want, diff)
 		}
 	}
@@ -149,6 +168,10 @@ func TestParseRedisURIErrors(t *testing.T) {
 			"non integer for db numbers for socket",
 			"redis-socket:///some/path/to/redis?db=one",
 		},
+		{
+			"non integer for db number for sentinel",
+			"redis-sentinel://localhost:5000,localhost:5001/one?master=mymaster",
+		},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,157 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hibiken/asynq/internal/base"
+)
+
+// A BlobStore stores task payloads outside of redis.
+//
+// Client writes payloads larger than the configured threshold to the
+// blob store and only a reference to the blob is written to redis.
+// Server reads the payload from the blob store before calling the handler.
+//
+// Blobs are deleted by the server once the task is no longer referenced,
+// that is after the task is processed successfully or deleted from a queue.
+type BlobStore interface {
+	// Put writes the data under the given key.
+	Put(key string, data []byte) error
+
+	// Get returns the data stored under the given key.
+	Get(key string) ([]byte, error)
+
+	// Delete deletes the data stored under the given key.
+	// Deleting a key which does not exist is not an error.
+	Delete(key string) error
+}
+
+// FileBlobStore is a BlobStore which stores blobs as files in a directory.
+//
+// Clients and servers sharing a FileBlobStore need to have access to the
+// same directory, e.g. via a network file system.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore returns a FileBlobStore which stores blobs in the given
+// directory. The directory is created if it does not exist.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("asynq: could not create blob directory: %v", err)
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("asynq: invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes the data to a file named after the key.
+// The file is written to a temporary file first and renamed, so that
+// readers never observe a partially written blob.
+func (s *FileBlobStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.dir, ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get reads the file named after the key.
+func (s *FileBlobStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Delete removes the file named after the key.
+func (s *FileBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// blob is the content of a payload blob.
+// It holds either a plaintext or an encrypted payload.
+type blob struct {
+	Payload          map[string]interface{}
+	EncryptedPayload *base.EncryptedPayload `json:",omitempty"`
+}
+
+// offloadPayload writes the payload of the message to the blob store if the
+// serialized payload is larger than threshold bytes.
+// The payload fields of the message are replaced with a reference to the blob.
+func offloadPayload(store BlobStore, threshold int, msg *base.TaskMessage) error {
+	data, err := json.Marshal(blob{msg.Payload, msg.EncryptedPayload})
+	if err != nil {
+		return err
+	}
+	if len(data) <= threshold {
+		return nil
+	}
+	ref := msg.ID.String()
+	if err := store.Put(ref, data); err != nil {
+		return err
+	}
+	msg.Payload = nil
+	msg.EncryptedPayload = nil
+	msg.PayloadRef = ref
+	return nil
+}
+
+// loadPayload returns a copy of the message with its payload fields read
+// from the blob store. If the payload of the message was not offloaded,
+// it returns the message as is.
+func loadPayload(store BlobStore, msg *base.TaskMessage) (*base.TaskMessage, error) {
+	if msg.PayloadRef == "" {
+		return msg, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("asynq: task payload is in a blob store but no blob store is configured")
+	}
+	data, err := store.Get(msg.PayloadRef)
+	if err != nil {
+		return nil, err
+	}
+	var b blob
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	loaded := *msg
+	loaded.Payload = b.Payload
+	loaded.EncryptedPayload = b.EncryptedPayload
+	return &loaded, nil
+}
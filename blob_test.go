@@ -0,0 +1,258 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func newTestBlobStore(t *testing.T) (store *FileBlobStore, cleanup func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "asynq-blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestFileBlobStore(t *testing.T) {
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+	data := []byte(`{"Payload":{"user_id":42}}`)
+
+	if err := store.Put("blob1", data); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	got, err := store.Get("blob1")
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %q, want %q", got, data)
+	}
+	if err := store.Delete("blob1"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if _, err := store.Get("blob1"); !os.IsNotExist(err) {
+		t.Errorf("Get after Delete returned %v, want not-exist error", err)
+	}
+	if err := store.Delete("blob1"); err != nil {
+		t.Errorf("Delete of missing blob returned error: %v", err)
+	}
+
+	for _, key := range []string{"", ".", "..", "../blob", "dir/blob"} {
+		if err := store.Put(key, data); err == nil {
+			t.Errorf("Put(%q) returned nil error", key)
+		}
+	}
+}
+
+func TestClientOffloadsLargePayload(t *testing.T) {
+	r := setup(t)
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	client.SetBlobStore(store, 64)
+
+	small := map[string]interface{}{"user_id": float64(42)}
+	large := map[string]interface{}{"body": string(bytes.Repeat([]byte("a"), 100))}
+
+	tests := []struct {
+		payload     map[string]interface{}
+		wantOffload bool
+	}{
+		{small, false},
+		{large, true},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		if err := client.Enqueue(NewTask("send_email", tc.payload)); err != nil {
+			t.Fatal(err)
+		}
+		msgs := h.GetEnqueuedMessages(t, r)
+		if len(msgs) != 1 {
+			t.Fatalf("got %d enqueued messages, want 1", len(msgs))
+		}
+		msg := msgs[0]
+		if got := msg.PayloadRef != ""; got != tc.wantOffload {
+			t.Errorf("PayloadRef = %q, want offloaded=%t", msg.PayloadRef, tc.wantOffload)
+		}
+		if tc.wantOffload && msg.Payload != nil {
+			t.Errorf("Payload = %v, want nil", msg.Payload)
+		}
+		loaded, err := loadPayload(store, msg)
+		if err != nil {
+			t.Fatalf("loadPayload returned error: %v", err)
+		}
+		if diff := cmp.Diff(tc.payload, loaded.Payload); diff != "" {
+			t.Errorf("loaded payload mismatch (-want, +got)\n%s", diff)
+		}
+	}
+}
+
+func TestClientDeletesBlobOfFailedEnqueue(t *testing.T) {
+	setup(t)
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	client.SetBlobStore(store, 64)
+
+	task := NewTask("send_email", map[string]interface{}{"body": string(bytes.Repeat([]byte("a"), 100))})
+	if err := client.Enqueue(task, Unique(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Enqueue(task, Unique(time.Hour)); !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("second Enqueue returned %v, want ErrDuplicateTask", err)
+	}
+
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("blob store has %d blobs, want 1", len(files))
+	}
+}
+
+func TestProcessorLoadsOffloadedPayload(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+	payload := map[string]interface{}{"user_id": float64(42)}
+	m1 := h.NewTaskMessage("send_email", payload)
+	if err := offloadPayload(store, 0, m1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		store         BlobStore
+		wantProcessed []*Task // tasks passed to the handler
+		wantRetry     int     // number of tasks in retry queue at the end
+	}{
+		{
+			store:         store,
+			wantProcessed: []*Task{NewTask(m1.Type, payload)},
+			wantRetry:     0,
+		},
+		{
+			store:         nil, // server without the blob store cannot process the task.
+			wantProcessed: nil,
+			wantRetry:     1,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+		var mu sync.Mutex
+		var processed []*Task
+		handler := func(ctx context.Context, task *Task) error {
+			mu.Lock()
+			defer mu.Unlock()
+			processed = append(processed, task)
+			return nil
+		}
+		starting := make(chan *base.TaskMessage)
+		finished := make(chan *base.TaskMessage)
+		done := make(chan struct{})
+		defer func() { close(done) }()
+		go fakeHeartbeater(starting, finished, done)
+		p := newProcessor(processorParams{
+			logger:          testLogger,
+			broker:          rdbClient,
+			retryDelayFunc:  defaultDelayFunc,
+			syncCh:          nil,
+			cancelations:    base.NewCancelations(),
+			progress:        base.NewProgressReports(),
+			concurrency:     10,
+			queues:          defaultQueueConfig,
+			strictPriority:  false,
+			errHandler:      nil,
+			blobStore:       tc.store,
+			shutdownTimeout: defaultShutdownTimeout,
+			starting:        starting,
+			finished:        finished,
+		})
+		p.handler = HandlerFunc(handler)
+
+		p.start(&sync.WaitGroup{})
+		time.Sleep(2 * time.Second)
+		p.terminate()
+
+		mu.Lock()
+		if diff := cmp.Diff(tc.wantProcessed, processed, cmp.AllowUnexported(Payload{})); diff != "" {
+			t.Errorf("mismatch found in processed tasks; (-want, +got)\n%s", diff)
+		}
+		mu.Unlock()
+		if got := len(h.GetRetryMessages(t, r)); got != tc.wantRetry {
+			t.Errorf("%q has %d tasks, want %d", base.RetryQueue, got, tc.wantRetry)
+		}
+	}
+}
+
+func TestBlobCollector(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	store, cleanup := newTestBlobStore(t)
+	defer cleanup()
+	const interval = time.Second
+	c := newBlobCollector(blobCollectorParams{
+		logger:   testLogger,
+		broker:   rdbClient,
+		store:    store,
+		interval: interval,
+	})
+	for _, ref := range []string{"blob1", "blob2", "blob3"} {
+		if err := store.Put(ref, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.SAdd(base.BlobGarbage, "blob1", "blob2").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	c.start(&wg)
+	time.Sleep(interval * 2)
+	c.terminate()
+	wg.Wait()
+
+	for _, ref := range []string{"blob1", "blob2"} {
+		if _, err := store.Get(ref); !os.IsNotExist(err) {
+			t.Errorf("blob %q still exists after collection: err=%v", ref, err)
+		}
+	}
+	if _, err := store.Get("blob3"); err != nil {
+		t.Errorf("referenced blob %q was deleted: %v", "blob3", err)
+	}
+	if n := r.SCard(base.BlobGarbage).Val(); n != 0 {
+		t.Errorf("%q has %d members, want 0", base.BlobGarbage, n)
+	}
+}
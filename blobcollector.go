@@ -0,0 +1,96 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// blobCollector is responsible for deleting payload blobs which are
+// no longer referenced by any task.
+type blobCollector struct {
+	logger *log.Logger
+	broker base.Broker
+	store  BlobStore
+
+	// channel to communicate back to the long running "blobCollector" goroutine.
+	done chan struct{}
+
+	// interval between garbage collections.
+	interval time.Duration
+}
+
+type blobCollectorParams struct {
+	logger   *log.Logger
+	broker   base.Broker
+	store    BlobStore
+	interval time.Duration
+}
+
+func newBlobCollector(params blobCollectorParams) *blobCollector {
+	return &blobCollector{
+		logger:   params.logger,
+		broker:   params.broker,
+		store:    params.store,
+		done:     make(chan struct{}),
+		interval: params.interval,
+	}
+}
+
+func (c *blobCollector) terminate() {
+	if c.store == nil {
+		return
+	}
+	c.logger.Debug("Blob collector shutting down...")
+	// Signal the blob collector goroutine to stop.
+	c.done <- struct{}{}
+}
+
+// start starts the "blobCollector" goroutine.
+// It's a no-op if the server is not configured with a blob store.
+func (c *blobCollector) start(wg *sync.WaitGroup) {
+	if c.store == nil {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-c.done:
+				c.logger.Debug("Blob collector done")
+				return
+			case <-time.After(c.interval):
+				c.exec()
+			}
+		}
+	}()
+}
+
+// Maximum number of blobs to delete in one collection.
+const blobCollectionBatchSize = 100
+
+func (c *blobCollector) exec() {
+	refs, err := c.broker.ListBlobGarbage(blobCollectionBatchSize)
+	if err != nil {
+		c.logger.Errorf("Could not list unreferenced blobs: %v", err)
+		return
+	}
+	var deleted []string
+	for _, ref := range refs {
+		if err := c.store.Delete(ref); err != nil {
+			c.logger.Errorf("Could not delete blob %q: %v", ref, err)
+			continue
+		}
+		deleted = append(deleted, ref)
+	}
+	if err := c.broker.RemoveBlobGarbage(deleted...); err != nil {
+		c.logger.Errorf("Could not remove deleted blobs from garbage set: %v", err)
+	}
+}
@@ -5,6 +5,7 @@
 package asynq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sort"
@@ -24,14 +25,23 @@ import (
 //
 // Clients are safe for concurrent use by multiple goroutines.
 type Client struct {
-	mu   sync.Mutex
-	opts map[string][]Option
-	rdb  *rdb.RDB
+	mu        sync.Mutex
+	opts      map[string][]Option
+	encrypter Encrypter
+	rdb       *rdb.RDB
+
+	// blobStore and blobThreshold configure payload offloading.
+	blobStore     BlobStore
+	blobThreshold int
+
+	// eventHandler and publishEvents configure task events.
+	eventHandler  EventHandler
+	publishEvents bool
 }
 
 // NewClient and returns a new Client given a redis connection option.
 func NewClient(r RedisConnOpt) *Client {
-	rdb := rdb.NewRDB(createRedisClient(r))
+	rdb := createRDB(r)
 	return &Client{
 		opts: make(map[string][]Option),
 		rdb:  rdb,
@@ -48,6 +58,10 @@ type (
 	timeoutOption  time.Duration
 	deadlineOption time.Time
 	uniqueOption   time.Duration
+	expireAtOption time.Time
+	expireInOption time.Duration
+	webhookOption  string
+	priorityOption int
 )
 
 // MaxRetry returns an option to specify the max number of times
@@ -89,21 +103,84 @@ func Deadline(t time.Time) Option {
 //     - Task Type
 //     - Task Payload
 //     - Queue Name
+//
+// If the client has an encrypter, the payload is compared by its digest
+// (see Digester). The digest of an AESEncrypter depends on its primary key,
+// so a task enqueued after the primary key is rotated is not a duplicate of
+// a task enqueued before.
 func Unique(ttl time.Duration) Option {
 	return uniqueOption(ttl)
 }
 
+// ExpireAt returns an option to specify the time after which the task
+// should be discarded if it has not started processing.
+//
+// Expired tasks are moved to the dead queue with the error message "expired"
+// when they are dequeued or moved out of the scheduled or retry queue.
+// Enqueueing an expired task again with the Inspector clears its expiration.
+func ExpireAt(t time.Time) Option {
+	return expireAtOption(t)
+}
+
+// ExpireIn returns an option to specify the duration from the enqueue time
+// after which the task should be discarded if it has not started processing.
+//
+// See ExpireAt for how expired tasks are handled.
+func ExpireIn(d time.Duration) Option {
+	return expireInOption(d)
+}
+
+// Webhook returns an option to specify the URL to which an event is
+// posted when the task is processed successfully or moved to the dead queue.
+//
+// The event is a JSON encoded WebhookEvent signed with Config.WebhookSecret
+// of the server which processed the task. Failed deliveries are retried
+// with the retry delay of the server.
+//
+// Events are delivered by internal tasks in the "asynq_webhooks" queue,
+// which is processed by every server and is not subject to queue limits.
+// The queue is processed ahead of the other queues by up to a tenth of
+// the workers of a server, at least one, so that a burst of events cannot
+// take all the workers.
+// The events are encrypted with Config.Encrypter of the server, if set.
+func Webhook(url string) Option {
+	return webhookOption(url)
+}
+
+// MaxPriority is the highest priority of a task given by the Priority option.
+const MaxPriority = base.MaxPriority
+
+// Priority returns an option to specify the priority of the task in its queue,
+// from zero (the default) to MaxPriority.
+//
+// Servers process the tasks of higher priority in a queue first. Tasks of
+// the same priority are processed in the order they were enqueued.
+// Priority applies within a queue; the order in which servers pick queues
+// is still given by Config.Queues.
+func Priority(n int) Option {
+	return priorityOption(n)
+}
+
 // ErrDuplicateTask indicates that the given task could not be enqueued since it's a duplicate of another task.
 //
 // ErrDuplicateTask error only applies to tasks enqueued with a Unique option.
 var ErrDuplicateTask = errors.New("task already exists")
 
+// ErrQueueFull indicates that the given task could not be enqueued since
+// the queue has reached the maximum size set with Inspector.SetQueueLimit.
+//
+// ErrQueueFull error only applies to tasks enqueued to be processed immediately.
+var ErrQueueFull = errors.New("queue is full")
+
 type option struct {
 	retry     int
 	queue     string
 	timeout   time.Duration
 	deadline  time.Time
 	uniqueTTL time.Duration
+	expireAt  time.Time
+	webhook   string
+	priority  int
 }
 
 func composeOptions(opts ...Option) option {
@@ -125,6 +202,14 @@ func composeOptions(opts ...Option) option {
 			res.deadline = time.Time(opt)
 		case uniqueOption:
 			res.uniqueTTL = time.Duration(opt)
+		case expireAtOption:
+			res.expireAt = time.Time(opt)
+		case expireInOption:
+			res.expireAt = time.Now().Add(time.Duration(opt))
+		case webhookOption:
+			res.webhook = string(opt)
+		case priorityOption:
+			res.priority = int(opt)
 		default:
 			// ignore unexpected option
 		}
@@ -134,10 +219,16 @@ func composeOptions(opts ...Option) option {
 
 // uniqueKey computes the redis key used for the given task.
 // It returns an empty string if ttl is zero.
-func uniqueKey(t *Task, ttl time.Duration, qname string) string {
+//
+// If enc is not nil, a digest of the payload is used in place of the
+// payload to keep the payload data out of the key.
+func uniqueKey(t *Task, ttl time.Duration, qname string, enc Encrypter) string {
 	if ttl == 0 {
 		return ""
 	}
+	if enc != nil {
+		return fmt.Sprintf("%s:%s:%s", t.Type, hashPayload(enc, t.Payload.data), qname)
+	}
 	return fmt.Sprintf("%s:%s:%s", t.Type, serializePayload(t.Payload.data), qname)
 }
 
@@ -179,6 +270,49 @@ func (c *Client) SetDefaultOptions(taskType string, opts ...Option) {
 	c.opts[taskType] = opts
 }
 
+// SetEncrypter sets the encrypter used to encrypt task payloads.
+//
+// Once set, payloads of all tasks enqueued by the client are encrypted
+// before they are written to redis. Servers processing these tasks need
+// to be configured with an encrypter which can decrypt the payloads.
+func (c *Client) SetEncrypter(enc Encrypter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.encrypter = enc
+}
+
+// SetBlobStore sets the blob store used to store large task payloads.
+//
+// Payloads larger than threshold bytes are written to the blob store
+// and only a reference to the blob is written to redis. Servers processing
+// these tasks need to be configured with a blob store which can read
+// the blobs written by the client.
+func (c *Client) SetBlobStore(store BlobStore, threshold int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobStore = store
+	c.blobThreshold = threshold
+}
+
+// SetEventHandler sets the handler called when the client enqueues
+// or schedules a task.
+func (c *Client) SetEventHandler(h EventHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eventHandler = h
+}
+
+// SetPublishEvents sets whether the client publishes task events to redis
+// when it enqueues or schedules a task.
+//
+// Publishing is best-effort; errors publishing events are not
+// returned by the enqueue methods.
+func (c *Client) SetPublishEvents(publish bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.publishEvents = publish
+}
+
 // EnqueueAt schedules task to be enqueued at the specified time.
 //
 // EnqueueAt returns nil if the task is scheduled successfully, otherwise returns a non-nil error.
@@ -186,7 +320,14 @@ func (c *Client) SetDefaultOptions(taskType string, opts ...Option) {
 // The argument opts specifies the behavior of task processing.
 // If there are conflicting Option values the last one overrides others.
 func (c *Client) EnqueueAt(t time.Time, task *Task, opts ...Option) error {
-	return c.enqueueAt(t, task, opts...)
+	_, err := c.enqueueAt(context.Background(), t, task, opts...)
+	return err
+}
+
+// EnqueueAtInfo is like EnqueueAt, but also returns the information about
+// the enqueued or scheduled task, such as the ID given to the task.
+func (c *Client) EnqueueAtInfo(t time.Time, task *Task, opts ...Option) (*TaskInfo, error) {
+	return c.enqueueAt(context.Background(), t, task, opts...)
 }
 
 // Enqueue enqueues task to be processed immediately.
@@ -195,8 +336,22 @@ func (c *Client) EnqueueAt(t time.Time, task *Task, opts ...Option) error {
 //
 // The argument opts specifies the behavior of task processing.
 // If there are conflicting Option values the last one overrides others.
+//
+// If the queue is full, Enqueue returns ErrQueueFull, even if the limit of
+// the queue tells enqueue to block. Use EnqueueContext to wait for room
+// in the queue.
 func (c *Client) Enqueue(task *Task, opts ...Option) error {
-	return c.enqueueAt(time.Now(), task, opts...)
+	_, err := c.enqueueAt(context.Background(), time.Now(), task, opts...)
+	return err
+}
+
+// EnqueueContext is like Enqueue, but if the queue is full and its limit
+// tells enqueue to block, EnqueueContext waits until there is room in the
+// queue, or returns ctx.Err() once ctx is done.
+// If ctx can never be canceled, it returns ErrQueueFull instead of waiting.
+func (c *Client) EnqueueContext(ctx context.Context, task *Task, opts ...Option) error {
+	_, err := c.enqueueAt(ctx, time.Now(), task, opts...)
+	return err
 }
 
 // EnqueueIn schedules task to be enqueued after the specified delay.
@@ -206,7 +361,8 @@ func (c *Client) Enqueue(task *Task, opts ...Option) error {
 // The argument opts specifies the behavior of task processing.
 // If there are conflicting Option values the last one overrides others.
 func (c *Client) EnqueueIn(d time.Duration, task *Task, opts ...Option) error {
-	return c.enqueueAt(time.Now().Add(d), task, opts...)
+	_, err := c.enqueueAt(context.Background(), time.Now().Add(d), task, opts...)
+	return err
 }
 
 // Close closes the connection with redis server.
@@ -214,13 +370,82 @@ func (c *Client) Close() error {
 	return c.rdb.Close()
 }
 
-func (c *Client) enqueueAt(t time.Time, task *Task, opts ...Option) error {
+// Interval between attempts to enqueue a task to a full queue which blocks.
+const queueFullPollInterval = 100 * time.Millisecond
+
+func (c *Client) enqueueAt(ctx context.Context, t time.Time, task *Task, opts ...Option) (*TaskInfo, error) {
+	c.mu.Lock()
+	handler, publish := c.eventHandler, c.publishEvents
+	store, threshold := c.blobStore, c.blobThreshold
+	c.mu.Unlock()
+	msg, opt, err := c.newMessage(task, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		if err := offloadPayload(store, threshold, msg); err != nil {
+			return nil, fmt.Errorf("asynq: could not write payload to blob store: %v", err)
+		}
+	}
+	var state EventState
+	if time.Now().After(t) {
+		err = c.enqueue(ctx, msg, opt.uniqueTTL)
+		state = EventEnqueued
+	} else {
+		err = c.schedule(msg, t, opt.uniqueTTL)
+		state = EventScheduled
+	}
+	if err != nil && msg.PayloadRef != "" {
+		c.discardBlob(store, msg.PayloadRef)
+	}
+	switch {
+	case err == rdb.ErrDuplicateTask:
+		return nil, fmt.Errorf("%w", ErrDuplicateTask)
+	case errors.Is(err, rdb.ErrQueueFull):
+		return nil, fmt.Errorf("%w", ErrQueueFull)
+	case err != nil:
+		return nil, err
+	}
+	emitEvent(c.rdb, handler, publish, state, msg, nil, 0)
+	info := &TaskInfo{
+		Task:     task,
+		ID:       msg.ID.String(),
+		Queue:    msg.Queue,
+		Priority: msg.Priority,
+		State:    "enqueued",
+		MaxRetry: msg.Retry,
+	}
+	if state == EventScheduled {
+		info.State = "scheduled"
+		info.NextEnqueueAt = t
+	}
+	return info, nil
+}
+
+// discardBlob deletes the payload blob of a task which could not be enqueued.
+// If the blob cannot be deleted, it's marked for garbage collection instead.
+func (c *Client) discardBlob(store BlobStore, ref string) {
+	if err := store.Delete(ref); err != nil {
+		c.rdb.AddBlobGarbage(ref)
+	}
+}
+
+// newMessage returns the task message for task and the options applied to it.
+// The payload of the message is not offloaded to the blob store.
+func (c *Client) newMessage(task *Task, opts ...Option) (*base.TaskMessage, option, error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if defaults, ok := c.opts[task.Type]; ok {
 		opts = append(defaults, opts...)
 	}
+	enc := c.encrypter
+	c.mu.Unlock()
 	opt := composeOptions(opts...)
+	if opt.webhook != "" && !isWebhookURL(opt.webhook) {
+		return nil, opt, fmt.Errorf("asynq: invalid webhook URL %q", opt.webhook)
+	}
+	if opt.priority < 0 || opt.priority > MaxPriority {
+		return nil, opt, fmt.Errorf("asynq: priority %d is out of range [0, %d]", opt.priority, MaxPriority)
+	}
 	msg := &base.TaskMessage{
 		ID:        xid.New(),
 		Type:      task.Type,
@@ -229,25 +454,47 @@ func (c *Client) enqueueAt(t time.Time, task *Task, opts ...Option) error {
 		Retry:     opt.retry,
 		Timeout:   opt.timeout.String(),
 		Deadline:  opt.deadline.Format(time.RFC3339),
-		UniqueKey: uniqueKey(task, opt.uniqueTTL, opt.queue),
+		UniqueKey: c.rdb.Keys().UniqueKey(uniqueKey(task, opt.uniqueTTL, opt.queue, enc)),
+		Webhook:   opt.webhook,
+		Priority:  opt.priority,
 	}
-	var err error
-	if time.Now().After(t) {
-		err = c.enqueue(msg, opt.uniqueTTL)
-	} else {
-		err = c.schedule(msg, t, opt.uniqueTTL)
+	if !opt.expireAt.IsZero() {
+		msg.ExpireAt = opt.expireAt.Unix()
 	}
-	if err == rdb.ErrDuplicateTask {
-		return fmt.Errorf("%w", ErrDuplicateTask)
+	if enc != nil {
+		encrypted, err := encryptPayload(enc, task.Payload.data)
+		if err != nil {
+			return nil, opt, fmt.Errorf("asynq: could not encrypt payload: %v", err)
+		}
+		msg.Payload = nil
+		msg.EncryptedPayload = encrypted
 	}
-	return err
+	return msg, opt, nil
 }
 
-func (c *Client) enqueue(msg *base.TaskMessage, uniqueTTL time.Duration) error {
-	if uniqueTTL > 0 {
-		return c.rdb.EnqueueUnique(msg, uniqueTTL)
+// enqueue pushes msg to its queue. If the queue is full and blocks,
+// it retries until there is room in the queue or ctx is done.
+// It doesn't wait if ctx can never be done.
+func (c *Client) enqueue(ctx context.Context, msg *base.TaskMessage, uniqueTTL time.Duration) error {
+	for {
+		var err error
+		if uniqueTTL > 0 {
+			err = c.rdb.EnqueueUnique(msg, uniqueTTL)
+		} else {
+			err = c.rdb.Enqueue(msg)
+		}
+		if err != rdb.ErrQueueBlocked {
+			return err
+		}
+		if ctx.Done() == nil {
+			return rdb.ErrQueueFull
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(queueFullPollInterval):
+		}
 	}
-	return c.rdb.Enqueue(msg)
 }
 
 func (c *Client) schedule(msg *base.TaskMessage, t time.Time, uniqueTTL time.Duration) error {
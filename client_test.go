@@ -5,6 +5,7 @@
 package asynq
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -94,7 +95,7 @@ func TestClientEnqueueAt(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -106,6 +107,49 @@ func TestClientEnqueueAt(t *testing.T) {
 	}
 }
 
+func TestClientEnqueueAtInfo(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	task := NewTask("send_email", map[string]interface{}{"to": "customer@gmail.com"})
+	now := time.Now()
+
+	tests := []struct {
+		processAt time.Time
+		wantState string
+	}{
+		{now, "enqueued"},
+		{now.Add(time.Hour), "scheduled"},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		info, err := client.EnqueueAtInfo(tc.processAt, task, Queue("critical"), Priority(2))
+		if err != nil {
+			t.Errorf("EnqueueAtInfo(%v) returned error: %v", tc.processAt, err)
+			continue
+		}
+		if info.State != tc.wantState || info.Queue != "critical" || info.Priority != 2 || info.Type != task.Type {
+			t.Errorf("EnqueueAtInfo(%v) = state %q, queue %q, priority %d, type %q; want %q, %q, %d, %q",
+				tc.processAt, info.State, info.Queue, info.Priority, info.Type, tc.wantState, "critical", 2, task.Type)
+		}
+		got, err := inspector.GetTask(info.ID)
+		if err != nil {
+			t.Errorf("GetTask(%q) returned error: %v", info.ID, err)
+			continue
+		}
+		if got.State != info.State {
+			t.Errorf("GetTask(%q) returned state %q, want %q", info.ID, got.State, info.State)
+		}
+	}
+}
+
 func TestClientEnqueue(t *testing.T) {
 	r := setup(t)
 	client := NewClient(RedisClientOpt{
@@ -179,6 +223,46 @@ func TestClientEnqueue(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "With expire option",
+			task: task,
+			opts: []Option{
+				ExpireAt(time.Unix(1893456000, 0)),
+			},
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": {
+					{
+						Type:     task.Type,
+						Payload:  task.Payload.data,
+						Retry:    defaultMaxRetry,
+						Queue:    "default",
+						Timeout:  noTimeout,
+						Deadline: noDeadline,
+						ExpireAt: 1893456000,
+					},
+				},
+			},
+		},
+		{
+			desc: "With webhook option",
+			task: task,
+			opts: []Option{
+				Webhook("https://example.com/hooks/asynq"),
+			},
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default": {
+					{
+						Type:     task.Type,
+						Payload:  task.Payload.data,
+						Retry:    defaultMaxRetry,
+						Queue:    "default",
+						Timeout:  noTimeout,
+						Deadline: noDeadline,
+						Webhook:  "https://example.com/hooks/asynq",
+					},
+				},
+			},
+		},
 		{
 			desc: "With queue option",
 			task: task,
@@ -268,7 +352,7 @@ func TestClientEnqueue(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			got := h.GetEnqueuedMessages(t, r, qname)
-			if diff := cmp.Diff(want, got, h.IgnoreIDOpt); diff != "" {
+			if diff := cmp.Diff(want, got, h.IgnoreIDOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -344,7 +428,7 @@ func TestClientEnqueueIn(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.IgnoreIDOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s;\nmismatch found in %q; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -429,7 +513,7 @@ func TestClientDefaultOptions(t *testing.T) {
 			continue
 		}
 		got := enqueued[0]
-		if diff := cmp.Diff(tc.want, got, h.IgnoreIDOpt); diff != "" {
+		if diff := cmp.Diff(tc.want, got, h.IgnoreIDOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 			t.Errorf("%s;\nmismatch found in enqueued task message; (-want,+got)\n%s",
 				tc.desc, diff)
 		}
@@ -495,7 +579,7 @@ func TestUniqueKey(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		got := uniqueKey(tc.task, tc.ttl, tc.qname)
+		got := uniqueKey(tc.task, tc.ttl, tc.qname, nil)
 		if got != tc.want {
 			t.Errorf("%s: uniqueKey(%v, %v, %q) = %q, want %q", tc.desc, tc.task, tc.ttl, tc.qname, got, tc.want)
 		}
@@ -528,7 +612,7 @@ func TestEnqueueUnique(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		gotTTL := r.TTL(uniqueKey(tc.task, tc.ttl, base.DefaultQueueName)).Val()
+		gotTTL := r.TTL(uniqueKey(tc.task, tc.ttl, base.DefaultQueueName, nil)).Val()
 		if !cmp.Equal(tc.ttl.Seconds(), gotTTL.Seconds(), cmpopts.EquateApprox(0, 1)) {
 			t.Errorf("TTL = %v, want %v", gotTTL, tc.ttl)
 			continue
@@ -575,7 +659,7 @@ func TestEnqueueInUnique(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		gotTTL := r.TTL(uniqueKey(tc.task, tc.ttl, base.DefaultQueueName)).Val()
+		gotTTL := r.TTL(uniqueKey(tc.task, tc.ttl, base.DefaultQueueName, nil)).Val()
 		wantTTL := time.Duration(tc.ttl.Seconds()+tc.d.Seconds()) * time.Second
 		if !cmp.Equal(wantTTL.Seconds(), gotTTL.Seconds(), cmpopts.EquateApprox(0, 1)) {
 			t.Errorf("TTL = %v, want %v", gotTTL, wantTTL)
@@ -623,7 +707,7 @@ func TestEnqueueAtUnique(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		gotTTL := r.TTL(uniqueKey(tc.task, tc.ttl, base.DefaultQueueName)).Val()
+		gotTTL := r.TTL(uniqueKey(tc.task, tc.ttl, base.DefaultQueueName, nil)).Val()
 		wantTTL := tc.at.Add(tc.ttl).Sub(time.Now())
 		if !cmp.Equal(wantTTL.Seconds(), gotTTL.Seconds(), cmpopts.EquateApprox(0, 1)) {
 			t.Errorf("TTL = %v, want %v", gotTTL, wantTTL)
@@ -642,3 +726,127 @@ func TestEnqueueAtUnique(t *testing.T) {
 		}
 	}
 }
+
+func TestClientEnqueueInvalidWebhook(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	task := NewTask("send_email", nil)
+
+	for _, url := range []string{"", "example.com/hook", "ftp://example.com/hook", "http://"} {
+		h.FlushDB(t, r)
+		err := client.Enqueue(task, Webhook(url))
+		if url == "" {
+			if err != nil {
+				t.Errorf("Enqueue with empty webhook returned error: %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Enqueue with webhook %q returned nil error", url)
+		}
+		if n := r.LLen(base.DefaultQueue).Val(); n != 0 {
+			t.Errorf("Enqueue with webhook %q enqueued %d tasks, want 0", url, n)
+		}
+	}
+}
+
+func TestClientEnqueuePriority(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	task := NewTask("send_email", nil)
+
+	tests := []struct {
+		qname    string
+		priority int
+		wantKey  string
+	}{
+		{"default", 0, base.DefaultQueue},
+		{"default", 3, "asynq:prio:3:default"},
+		{"default", MaxPriority, "asynq:prio:9:default"},
+		{"default:p3", 0, "asynq:queues:default:p3"},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		if err := client.Enqueue(task, Queue(tc.qname), Priority(tc.priority)); err != nil {
+			t.Errorf("Enqueue to %q with priority %d returned error: %v", tc.qname, tc.priority, err)
+			continue
+		}
+		msgs := r.LRange(tc.wantKey, 0, -1).Val()
+		if len(msgs) != 1 || h.MustUnmarshal(t, msgs[0]).Priority != tc.priority {
+			t.Errorf("Enqueue to %q with priority %d: %q = %v, want a task of priority %d", tc.qname, tc.priority, tc.wantKey, msgs, tc.priority)
+		}
+	}
+
+	for _, priority := range []int{-1, MaxPriority + 1} {
+		if err := client.Enqueue(task, Priority(priority)); err == nil {
+			t.Errorf("Enqueue with priority %d returned nil error", priority)
+		}
+	}
+}
+
+func TestClientEnqueueQueueFull(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	task := NewTask("send_email", nil)
+
+	tests := []struct {
+		policy  QueueFullPolicy
+		timeout time.Duration
+		dequeue bool // dequeue a task while enqueue is blocked
+		wantErr error
+	}{
+		{policy: QueueFullReject, timeout: time.Second, wantErr: ErrQueueFull},
+		{policy: QueueFullDropOldest, timeout: time.Second, wantErr: nil},
+		{policy: QueueFullBlock, timeout: 300 * time.Millisecond, wantErr: context.DeadlineExceeded},
+		{policy: QueueFullBlock, timeout: 5 * time.Second, dequeue: true, wantErr: nil},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		if err := inspector.SetQueueLimit(base.DefaultQueueName, QueueLimit{MaxSize: 1, Policy: tc.policy}); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Enqueue(task); err != nil {
+			t.Fatalf("Enqueue to an empty queue returned error: %v", err)
+		}
+		if tc.dequeue {
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				r.RPop(base.DefaultQueue)
+			}()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+		err := client.EnqueueContext(ctx, task)
+		cancel()
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("EnqueueContext to a full queue with policy %q returned %v, want %v", tc.policy, err, tc.wantErr)
+		}
+		// The queue should never exceed its max size.
+		if n := r.LLen(base.DefaultQueue).Val(); n != 1 {
+			t.Errorf("queue with policy %q has %d tasks, want 1", tc.policy, n)
+		}
+	}
+
+	// Enqueue without a context which can be done doesn't wait under the block policy.
+	if err := client.Enqueue(task); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue to a full queue with policy %q returned %v, want %v", QueueFullBlock, err, ErrQueueFull)
+	}
+	if err := client.EnqueueContext(context.Background(), task); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("EnqueueContext(context.Background()) to a full queue with policy %q returned %v, want %v", QueueFullBlock, err, ErrQueueFull)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// configWatcher is responsible for reading the shared server config
+// from redis periodically and applying it to the server when it changes.
+type configWatcher struct {
+	logger *log.Logger
+	broker base.Broker
+
+	// enabled indicates whether the server watches the shared config.
+	enabled bool
+
+	// channel to communicate back to the long running "configWatcher" goroutine.
+	done chan struct{}
+
+	// interval between reads of the shared config.
+	interval time.Duration
+
+	// apply is called with the shared config when it changes.
+	// nil config means the shared config has been cleared.
+	apply func(cfg *base.ServerConfig)
+
+	// last is the last config passed to apply.
+	// It should be accessed only by the configWatcher goroutine.
+	last *base.ServerConfig
+}
+
+type configWatcherParams struct {
+	logger   *log.Logger
+	broker   base.Broker
+	enabled  bool
+	interval time.Duration
+	apply    func(cfg *base.ServerConfig)
+}
+
+func newConfigWatcher(params configWatcherParams) *configWatcher {
+	return &configWatcher{
+		logger:   params.logger,
+		broker:   params.broker,
+		enabled:  params.enabled,
+		done:     make(chan struct{}),
+		interval: params.interval,
+		apply:    params.apply,
+	}
+}
+
+func (w *configWatcher) terminate() {
+	if !w.enabled {
+		return
+	}
+	w.logger.Debug("Config watcher shutting down...")
+	// Signal the config watcher goroutine to stop.
+	w.done <- struct{}{}
+}
+
+// start starts the "configWatcher" goroutine.
+// It's a no-op if the server is not configured to watch the shared config.
+func (w *configWatcher) start(wg *sync.WaitGroup) {
+	if !w.enabled {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.exec()
+		timer := time.NewTimer(w.interval)
+		for {
+			select {
+			case <-w.done:
+				w.logger.Debug("Config watcher done")
+				timer.Stop()
+				return
+			case <-timer.C:
+				w.exec()
+				timer.Reset(w.interval)
+			}
+		}
+	}()
+}
+
+func (w *configWatcher) exec() {
+	cfg, err := w.broker.ReadServerConfig()
+	if err != nil {
+		w.logger.Errorf("Could not read server config: %v", err)
+		return
+	}
+	if reflect.DeepEqual(cfg, w.last) {
+		return
+	}
+	if cfg == nil {
+		w.logger.Info("Shared server config cleared, restoring local config")
+	} else {
+		w.logger.Infof("Applying shared server config: concurrency=%d queues=%v strict=%t",
+			cfg.Concurrency, cfg.Queues, cfg.StrictPriority)
+	}
+	w.last = cfg
+	w.apply(cfg)
+}
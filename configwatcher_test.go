@@ -0,0 +1,163 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	const interval = time.Second
+
+	var (
+		mu      sync.Mutex
+		applied []*base.ServerConfig
+	)
+	w := newConfigWatcher(configWatcherParams{
+		logger:   testLogger,
+		broker:   rdbClient,
+		enabled:  true,
+		interval: interval,
+		apply: func(cfg *base.ServerConfig) {
+			mu.Lock()
+			applied = append(applied, cfg)
+			mu.Unlock()
+		},
+	})
+
+	cfg := &base.ServerConfig{Concurrency: 3, Queues: map[string]int{"critical": 1}}
+	if err := rdbClient.WriteServerConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	w.start(&wg)
+	time.Sleep(interval * 2)
+	if err := rdbClient.ClearServerConfig(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(interval * 2)
+	w.terminate()
+	wg.Wait()
+
+	// Unchanged config should be applied only once.
+	want := []*base.ServerConfig{cfg, nil}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, applied); diff != "" {
+		t.Errorf("config watcher applied %v, want %v; (-want,+got)\n%s", applied, want, diff)
+	}
+}
+
+func TestServerSetConfig(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r)
+
+	type config struct {
+		Concurrency    int
+		Queues         map[string]int
+		OrderedQueues  []string
+		StrictPriority bool
+	}
+
+	tests := []struct {
+		desc   string
+		update func(srv *Server)
+		want   config
+	}{
+		{
+			desc:   "SetConcurrency",
+			update: func(srv *Server) { srv.SetConcurrency(20) },
+			want: config{
+				Concurrency: 20,
+				Queues:      map[string]int{"default": 1},
+			},
+		},
+		{
+			desc: "SetQueues",
+			update: func(srv *Server) {
+				srv.SetQueues(map[string]int{"critical": 2, "low": 1, "ignored": 0}, true)
+			},
+			want: config{
+				Concurrency:    10,
+				Queues:         map[string]int{"critical": 2, "low": 1},
+				OrderedQueues:  []string{"critical", "low"},
+				StrictPriority: true,
+			},
+		},
+		{
+			desc: "shared config takes precedence",
+			update: func(srv *Server) {
+				srv.applySharedConfig(&base.ServerConfig{Concurrency: 4})
+				srv.SetConcurrency(30)
+				srv.SetQueues(map[string]int{"low": 1}, false)
+			},
+			want: config{
+				Concurrency: 4,
+				Queues:      map[string]int{"low": 1},
+			},
+		},
+		{
+			desc: "clearing shared config restores local config",
+			update: func(srv *Server) {
+				srv.applySharedConfig(&base.ServerConfig{
+					Concurrency:    4,
+					Queues:         map[string]int{"a": 2, "b": 1},
+					StrictPriority: true,
+				})
+				srv.SetConcurrency(30)
+				srv.applySharedConfig(nil)
+			},
+			want: config{
+				Concurrency: 30,
+				Queues:      map[string]int{"default": 1},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		srv := NewServer(RedisClientOpt{Addr: redisAddr, DB: redisDB}, Config{
+			Concurrency: 10,
+			LogLevel:    testLogLevel,
+		})
+		tc.update(srv)
+
+		p := srv.processor
+		p.qmu.Lock()
+		got := config{
+			Concurrency:    p.sema.capacity,
+			Queues:         p.queueConfig,
+			OrderedQueues:  p.orderedQueues,
+			StrictPriority: p.orderedQueues != nil,
+		}
+		p.qmu.Unlock()
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: processor config = %+v, want %+v; (-want,+got)\n%s", tc.desc, got, tc.want, diff)
+		}
+
+		hb := srv.heartbeater
+		hb.mu.Lock()
+		got = config{
+			Concurrency:    hb.concurrency,
+			Queues:         hb.queues,
+			OrderedQueues:  tc.want.OrderedQueues,
+			StrictPriority: hb.strictPriority,
+		}
+		hb.mu.Unlock()
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: heartbeater config = %+v, want %+v; (-want,+got)\n%s", tc.desc, got, tc.want, diff)
+		}
+		srv.broker.Close()
+	}
+}
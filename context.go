@@ -6,6 +6,8 @@ package asynq
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/hibiken/asynq/internal/base"
@@ -26,6 +28,18 @@ type ctxKey int
 // Its value of zero is arbitrary.
 const metadataCtxKey ctxKey = 0
 
+// progressCtxKey is the context key for the progress reporter.
+const progressCtxKey ctxKey = 1
+
+// A progressReporter records the progress reported by a handler.
+type progressReporter func(percent int, message string)
+
+// resultCtxKey is the context key for the result recorder.
+const resultCtxKey ctxKey = 2
+
+// A resultRecorder records the JSON encoded result set by a handler.
+type resultRecorder func(data []byte)
+
 // createContext returns a context and cancel function for a given task message.
 func createContext(msg *base.TaskMessage) (ctx context.Context, cancel context.CancelFunc) {
 	metadata := taskMetadata{
@@ -83,3 +97,59 @@ func GetMaxRetry(ctx context.Context) (n int, ok bool) {
 	}
 	return metadata.maxRetry, true
 }
+
+// withProgressReporter returns a copy of ctx with the given progress reporter.
+func withProgressReporter(ctx context.Context, fn progressReporter) context.Context {
+	return context.WithValue(ctx, progressCtxKey, fn)
+}
+
+// ReportProgress reports the progress of the task associated with the context.
+//
+// Percent is clamped to the range [0, 100] and message is an optional
+// human readable description of the current step.
+// The latest reported progress and the time it was reported are published
+// along with the worker info, so that long running handlers can be monitored
+// and handlers which stopped reporting can be detected.
+//
+// ReportProgress returns false if the context is not associated with a task
+// being processed by a server.
+func ReportProgress(ctx context.Context, percent int, message string) bool {
+	report, ok := ctx.Value(progressCtxKey).(progressReporter)
+	if !ok {
+		return false
+	}
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	report(percent, message)
+	return true
+}
+
+// withResultRecorder returns a copy of ctx with the given result recorder.
+func withResultRecorder(ctx context.Context, fn resultRecorder) context.Context {
+	return context.WithValue(ctx, resultCtxKey, fn)
+}
+
+// SetResult sets the result of the task associated with the context.
+//
+// The result is encoded as JSON and included in the event posted to the
+// webhook of the task. See Webhook option.
+// If SetResult is called more than once, the last result is used.
+//
+// SetResult returns an error if the context is not associated with a task
+// being processed by a server or v cannot be encoded as JSON.
+func SetResult(ctx context.Context, v interface{}) error {
+	record, ok := ctx.Value(resultCtxKey).(resultRecorder)
+	if !ok {
+		return errors.New("asynq: context is not associated with a task")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	record(data)
+	return nil
+}
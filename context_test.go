@@ -155,3 +155,36 @@ func TestGetTaskMetadataFromContextError(t *testing.T) {
 		}
 	}
 }
+
+func TestReportProgress(t *testing.T) {
+	tests := []struct {
+		percent     int
+		message     string
+		wantPercent int
+	}{
+		{42, "transcoding", 42},
+		{-5, "", 0},
+		{150, "done", 100},
+	}
+
+	for _, tc := range tests {
+		var gotPercent int
+		var gotMessage string
+		ctx := withProgressReporter(context.Background(), func(percent int, message string) {
+			gotPercent, gotMessage = percent, message
+		})
+
+		if ok := ReportProgress(ctx, tc.percent, tc.message); !ok {
+			t.Errorf("ReportProgress(ctx, %d, %q) returned false", tc.percent, tc.message)
+			continue
+		}
+		if gotPercent != tc.wantPercent || gotMessage != tc.message {
+			t.Errorf("ReportProgress(ctx, %d, %q) reported (%d, %q), want (%d, %q)",
+				tc.percent, tc.message, gotPercent, gotMessage, tc.wantPercent, tc.message)
+		}
+	}
+
+	if ok := ReportProgress(context.Background(), 10, "step"); ok {
+		t.Errorf("ReportProgress with background context returned true")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// controller is responsible for executing commands sent to the server
+// via the control channel.
+type controller struct {
+	logger *log.Logger
+	broker base.Broker
+
+	// channel to communicate back to the long running "controller" goroutine.
+	done chan struct{}
+
+	// serverID is the ID of the server the controller belongs to.
+	serverID string
+
+	// quiet and stop are called when the corresponding command is received.
+	quiet func()
+	stop  func()
+
+	// time to wait before retrying to connect to redis.
+	retryTimeout time.Duration
+}
+
+type controllerParams struct {
+	logger   *log.Logger
+	broker   base.Broker
+	serverID string
+	quiet    func()
+	stop     func()
+}
+
+func newController(params controllerParams) *controller {
+	return &controller{
+		logger:       params.logger,
+		broker:       params.broker,
+		done:         make(chan struct{}),
+		serverID:     params.serverID,
+		quiet:        params.quiet,
+		stop:         params.stop,
+		retryTimeout: 5 * time.Second,
+	}
+}
+
+func (c *controller) terminate() {
+	c.logger.Debug("Controller shutting down...")
+	// Signal the controller goroutine to stop.
+	c.done <- struct{}{}
+}
+
+func (c *controller) start(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var (
+			pubsub *redis.PubSub
+			err    error
+		)
+		// Try until successfully connect to Redis.
+		for {
+			pubsub, err = c.broker.ControlPubSub()
+			if err != nil {
+				c.logger.Errorf("cannot subscribe to control channel: %v", err)
+				select {
+				case <-time.After(c.retryTimeout):
+					continue
+				case <-c.done:
+					c.logger.Debug("Controller done")
+					return
+				}
+			}
+			break
+		}
+		controlCh := pubsub.Channel()
+		for {
+			select {
+			case <-c.done:
+				pubsub.Close()
+				c.logger.Debug("Controller done")
+				return
+			case msg := <-controlCh:
+				c.exec(msg.Payload)
+			}
+		}
+	}()
+}
+
+func (c *controller) exec(payload string) {
+	var msg base.ControlMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		c.logger.Errorf("Could not decode control message %q: %v", payload, err)
+		return
+	}
+	if msg.ServerID != "" && msg.ServerID != c.serverID {
+		return
+	}
+	switch msg.Command {
+	case base.ControlQuiet:
+		c.logger.Info("Received quiet command")
+		c.quiet()
+	case base.ControlStop:
+		c.logger.Info("Received stop command")
+		// Stop terminates the controller, so it needs to run
+		// outside of the controller goroutine.
+		go c.stop()
+	default:
+		c.logger.Warnf("Unknown control command %q", msg.Command)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestController(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	tests := []struct {
+		publish   *base.ControlMessage // message to be published
+		wantQuiet bool                 // whether quiet func should be called
+		wantStop  bool                 // whether stop func should be called
+	}{
+		{&base.ControlMessage{Command: base.ControlQuiet, ServerID: "server1"}, true, false},
+		{&base.ControlMessage{Command: base.ControlStop, ServerID: "server1"}, false, true},
+		{&base.ControlMessage{Command: base.ControlQuiet, ServerID: ""}, true, false},
+		{&base.ControlMessage{Command: base.ControlStop, ServerID: "server2"}, false, false},
+	}
+
+	for _, tc := range tests {
+		var mu sync.Mutex
+		var quietCalled, stopCalled bool
+		c := newController(controllerParams{
+			logger:   testLogger,
+			broker:   rdbClient,
+			serverID: "server1",
+			quiet: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				quietCalled = true
+			},
+			stop: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				stopCalled = true
+			},
+		})
+		var wg sync.WaitGroup
+		c.start(&wg)
+
+		// wait for controller to establish connection to pubsub channel
+		time.Sleep(time.Second)
+
+		if err := rdbClient.PublishControl(tc.publish); err != nil {
+			t.Fatalf("could not publish control message: %v", err)
+		}
+
+		// wait for redis to publish message
+		time.Sleep(time.Second)
+		c.terminate()
+
+		mu.Lock()
+		if quietCalled != tc.wantQuiet || stopCalled != tc.wantStop {
+			t.Errorf("after publishing %+v: quiet called = %t, stop called = %t; want %t, %t",
+				tc.publish, quietCalled, stopCalled, tc.wantQuiet, tc.wantStop)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestServerStopRemotely(t *testing.T) {
+	setup(t)
+	srv := NewServer(RedisClientOpt{Addr: redisAddr, DB: redisDB}, Config{LogLevel: testLogLevel})
+	inspector := NewInspector(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer inspector.Close()
+
+	done := make(chan error)
+	go func() { done <- srv.Run(NewServeMux()) }()
+
+	// wait for heartbeater to write server info and controller to subscribe.
+	time.Sleep(2 * time.Second)
+
+	if err := inspector.StopServer("no-such-server"); err == nil {
+		t.Errorf("(*Inspector).StopServer with unknown ID returned nil error")
+	}
+
+	servers, err := inspector.ListServers()
+	if err != nil || len(servers) != 1 {
+		t.Fatalf("(*Inspector).ListServers() = %v, %v; want 1 server", servers, err)
+	}
+	if err := inspector.QuietServer(servers[0].ID); err != nil {
+		t.Fatalf("(*Inspector).QuietServer(%q) returned error: %v", servers[0].ID, err)
+	}
+	// wait for processor to stop, it may be sleeping on empty queues.
+	time.Sleep(2 * time.Second)
+	if got := srv.status.Get(); got != base.StatusQuiet {
+		t.Errorf("server status = %v, want %v", got, base.StatusQuiet)
+	}
+
+	if err := inspector.StopServer(servers[0].ID); err != nil {
+		t.Fatalf("(*Inspector).StopServer(%q) returned error: %v", servers[0].ID, err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("(*Server).Run returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not stop after receiving stop command")
+	}
+}
+
+func TestServerConcurrentStop(t *testing.T) {
+	setup(t)
+	srv := NewServer(RedisClientOpt{Addr: redisAddr, DB: redisDB}, Config{LogLevel: testLogLevel})
+	inspector := NewInspector(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer inspector.Close()
+
+	if err := srv.Start(NewServeMux()); err != nil {
+		t.Fatal(err)
+	}
+	// wait for heartbeater to write server info and controller to subscribe.
+	time.Sleep(2 * time.Second)
+
+	// A remote stop command races Stop and Shutdown called by the application.
+	if err := inspector.StopAllServers(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			srv.Stop()
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := srv.Shutdown(context.Background()); err != nil {
+				t.Errorf("(*Server).Shutdown returned error: %v", err)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("concurrent stops did not return")
+	}
+	if got := srv.status.Get(); got != base.StatusStopped {
+		t.Errorf("server status = %v, want %v", got, base.StatusStopped)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/log"
+)
+
+// deadArchiver is responsible for writing the dead queue retention config
+// to redis and passing tasks evicted from the dead queue to the archiver.
+type deadArchiver struct {
+	logger *log.Logger
+	broker base.Broker
+
+	// retention is the retention config of the server,
+	// nil if the server uses the default config.
+	retention *base.DeadRetentionConfig
+
+	// archiver, encrypter and store may be nil.
+	archiver  Archiver
+	encrypter Encrypter
+	store     BlobStore
+
+	// channel to communicate back to the long running "deadArchiver" goroutine.
+	done chan struct{}
+
+	// interval between archivals.
+	interval time.Duration
+}
+
+type deadArchiverParams struct {
+	logger    *log.Logger
+	broker    base.Broker
+	retention *base.DeadRetentionConfig
+	archiver  Archiver
+	encrypter Encrypter
+	store     BlobStore
+	interval  time.Duration
+}
+
+func newDeadArchiver(params deadArchiverParams) *deadArchiver {
+	return &deadArchiver{
+		logger:    params.logger,
+		broker:    params.broker,
+		retention: params.retention,
+		archiver:  params.archiver,
+		encrypter: params.encrypter,
+		store:     params.store,
+		done:      make(chan struct{}),
+		interval:  params.interval,
+	}
+}
+
+func (a *deadArchiver) enabled() bool {
+	return a.retention != nil
+}
+
+func (a *deadArchiver) terminate() {
+	if !a.enabled() {
+		return
+	}
+	a.logger.Debug("Dead archiver shutting down...")
+	// Signal the dead archiver goroutine to stop.
+	a.done <- struct{}{}
+}
+
+// start starts the "deadArchiver" goroutine.
+// It's a no-op if the server uses the default retention config.
+func (a *deadArchiver) start(wg *sync.WaitGroup) {
+	if !a.enabled() {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.exec()
+		timer := time.NewTimer(a.interval)
+		for {
+			select {
+			case <-a.done:
+				a.logger.Debug("Dead archiver done")
+				timer.Stop()
+				return
+			case <-timer.C:
+				a.exec()
+				timer.Reset(a.interval)
+			}
+		}
+	}()
+}
+
+// Maximum number of tasks to pass to the archiver at once.
+const archiveBatchSize = 100
+
+// retentionTTL returns how long the retention config written to redis is
+// kept. The config is refreshed every interval, so it expires only once no
+// server with the config is running, and the default config applies again.
+func (a *deadArchiver) retentionTTL() time.Duration {
+	if ttl := 3 * a.interval; ttl > time.Minute {
+		return ttl
+	}
+	return time.Minute
+}
+
+func (a *deadArchiver) exec() {
+	// Write the config every time to keep it from expiring,
+	// and in case redis has lost it.
+	if err := a.broker.WriteDeadRetention(a.retention, a.retentionTTL()); err != nil {
+		a.logger.Errorf("Could not write dead queue retention config: %v", err)
+		return
+	}
+	if a.archiver == nil {
+		return
+	}
+	for {
+		evicted, err := a.broker.ClaimEvictedTasks(archiveBatchSize)
+		if err != nil {
+			a.logger.Errorf("Could not claim evicted dead tasks: %v", err)
+			return
+		}
+		if len(evicted) == 0 {
+			return
+		}
+		if err := a.archive(evicted); err != nil {
+			a.logger.Errorf("Could not archive %d evicted dead tasks: %v", len(evicted), err)
+			if err := a.broker.ReturnEvictedTasks(evicted); err != nil {
+				a.logger.Errorf("Could not return %d evicted dead tasks: %v", len(evicted), err)
+			}
+			return
+		}
+		if len(evicted) < archiveBatchSize {
+			return
+		}
+	}
+}
+
+func (a *deadArchiver) archive(evicted []*base.EvictedTask) error {
+	now := time.Now()
+	var (
+		tasks []*ArchivedTask
+		refs  []string
+	)
+	for _, e := range evicted {
+		msg := e.Message
+		t := &ArchivedTask{
+			ID:         msg.ID.String(),
+			Type:       msg.Type,
+			Queue:      msg.Queue,
+			ErrorMsg:   msg.ErrorMsg,
+			Retried:    msg.Retried,
+			MaxRetry:   msg.Retry,
+			DiedAt:     time.Unix(e.DiedAt, 0),
+			ArchivedAt: now,
+		}
+		if loaded, err := loadPayload(a.store, msg); err != nil {
+			t.Redacted = true
+		} else if payload, err := decryptPayload(a.encrypter, loaded); err != nil {
+			t.Redacted = true
+		} else {
+			t.Payload = payload
+		}
+		if msg.PayloadRef != "" {
+			refs = append(refs, msg.PayloadRef)
+		}
+		tasks = append(tasks, t)
+	}
+	if err := a.archiver.Archive(tasks); err != nil {
+		return err
+	}
+	// Offloaded payloads are kept until the tasks are archived.
+	if err := a.broker.AddBlobGarbage(refs...); err != nil {
+		a.logger.Errorf("Could not mark payload blobs of archived tasks for collection: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hibiken/asynq/internal/base"
+)
+
+// An Encrypter encrypts and decrypts task payloads.
+//
+// Encrypt returns the ID of the key used to encrypt the data, which is
+// stored alongside the ciphertext so that Decrypt can select the matching
+// key even after the encryption key has been rotated.
+type Encrypter interface {
+	Encrypt(plaintext []byte) (keyID string, ciphertext []byte, err error)
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// A Digester computes keyed digests of task payloads.
+//
+// If the encrypter of a client also implements Digester, the uniqueness key
+// of a task enqueued with the Unique option contains the digest of its
+// payload. Otherwise it contains an unkeyed SHA-256 hash of the payload,
+// which may reveal payloads that are easy to guess.
+type Digester interface {
+	Digest(data []byte) []byte
+}
+
+// ErrUnknownKey indicates that the data was encrypted with a key
+// which is not available to the encrypter.
+var ErrUnknownKey = errors.New("asynq: unknown encryption key")
+
+// EncryptionKey is a named key used by AESEncrypter.
+type EncryptionKey struct {
+	// ID identifies the key. It is stored with each encrypted payload.
+	ID string
+
+	// Key is the AES key. It must be 16, 24, or 32 bytes long
+	// to select AES-128, AES-192, or AES-256 respectively.
+	Key []byte
+}
+
+// AESEncrypter is an Encrypter which uses AES in Galois/Counter Mode.
+//
+// AESEncrypter encrypts payloads with its primary key and can decrypt
+// payloads encrypted with any of its keys, which allows keys to be rotated
+// without losing access to tasks that are already in Redis.
+//
+// AESEncrypter implements Digester with HMAC-SHA256 keyed with a key
+// derived from the primary key.
+type AESEncrypter struct {
+	primary   string
+	aeads     map[string]cipher.AEAD
+	digestKey []byte
+}
+
+// NewAESEncrypter returns a new AESEncrypter which encrypts payloads with the
+// primary key. Additional keys are used only for decryption.
+func NewAESEncrypter(primary EncryptionKey, others ...EncryptionKey) (*AESEncrypter, error) {
+	e := &AESEncrypter{
+		primary: primary.ID,
+		aeads:   make(map[string]cipher.AEAD),
+	}
+	for _, k := range append([]EncryptionKey{primary}, others...) {
+		if k.ID == "" {
+			return nil, fmt.Errorf("asynq: encryption key ID cannot be empty")
+		}
+		if _, ok := e.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("asynq: duplicate encryption key ID %q", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("asynq: invalid encryption key %q: %v", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("asynq: invalid encryption key %q: %v", k.ID, err)
+		}
+		e.aeads[k.ID] = aead
+	}
+	mac := hmac.New(sha256.New, primary.Key)
+	mac.Write([]byte("asynq:digest"))
+	e.digestKey = mac.Sum(nil)
+	return e, nil
+}
+
+// Encrypt encrypts the plaintext with the primary key.
+// The random nonce is prepended to the returned ciphertext.
+func (e *AESEncrypter) Encrypt(plaintext []byte) (keyID string, ciphertext []byte, err error) {
+	aead := e.aeads[e.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	return e.primary, aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts the ciphertext with the key identified by keyID.
+// It returns ErrUnknownKey if the key is not known to the encrypter.
+func (e *AESEncrypter) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	aead, ok := e.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	n := aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, fmt.Errorf("asynq: ciphertext too short")
+	}
+	return aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
+
+// Digest returns the HMAC-SHA256 of data keyed with the primary key.
+// Digests change when the primary key is rotated.
+func (e *AESEncrypter) Digest(data []byte) []byte {
+	mac := hmac.New(sha256.New, e.digestKey)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// encryptPayload encrypts the payload and returns the envelope to store
+// in a task message.
+func encryptPayload(enc Encrypter, payload map[string]interface{}) (*base.EncryptedPayload, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	keyID, ciphertext, err := enc.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return &base.EncryptedPayload{KeyID: keyID, Data: ciphertext}, nil
+}
+
+// decryptPayload returns the plaintext payload of the given task message.
+// If the payload of the message is not encrypted, it returns the payload as is.
+func decryptPayload(enc Encrypter, msg *base.TaskMessage) (map[string]interface{}, error) {
+	if msg.EncryptedPayload == nil {
+		return msg.Payload, nil
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("asynq: task payload is encrypted but no encrypter is configured")
+	}
+	data, err := enc.Decrypt(msg.EncryptedPayload.KeyID, msg.EncryptedPayload.Data)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// hashPayload returns a digest of the serialized payload.
+// It is used in place of the payload in uniqueness keys so that encrypted
+// payloads are not written to redis in plaintext.
+//
+// The digest is computed by enc if it implements Digester.
+func hashPayload(enc Encrypter, payload map[string]interface{}) string {
+	data := []byte(serializePayload(payload))
+	if d, ok := enc.(Digester); ok {
+		return hex.EncodeToString(d.Digest(data))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+)
+
+var (
+	testKey1 = EncryptionKey{ID: "key1", Key: bytes.Repeat([]byte{1}, 32)}
+	testKey2 = EncryptionKey{ID: "key2", Key: bytes.Repeat([]byte{2}, 32)}
+)
+
+func TestAESEncrypter(t *testing.T) {
+	enc, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte(`{"user_id":42}`)
+
+	keyID, ciphertext, err := enc.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt returned error: %v", err)
+	}
+	if keyID != testKey1.ID {
+		t.Errorf("Encrypt returned key ID %q, want %q", keyID, testKey1.ID)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Errorf("ciphertext contains the plaintext")
+	}
+	got, err := enc.Decrypt(keyID, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt returned error: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt returned %q, want %q", got, plaintext)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 0xff
+	if _, err := enc.Decrypt(keyID, ciphertext); err == nil {
+		t.Errorf("Decrypt of tampered ciphertext returned nil error")
+	}
+}
+
+func TestAESEncrypterKeyRotation(t *testing.T) {
+	old, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewAESEncrypter(testKey2, testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("hello")
+
+	// Data encrypted with the old key should be readable after rotation.
+	keyID, ciphertext, err := old.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rotated.Decrypt(keyID, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt with rotated keys returned error: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt returned %q, want %q", got, plaintext)
+	}
+
+	// New data should be encrypted with the new primary key.
+	keyID, ciphertext, err = rotated.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != testKey2.ID {
+		t.Errorf("Encrypt returned key ID %q, want %q", keyID, testKey2.ID)
+	}
+	if _, err := old.Decrypt(keyID, ciphertext); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt with unknown key returned %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestNewAESEncrypterError(t *testing.T) {
+	tests := []struct {
+		desc    string
+		primary EncryptionKey
+		others  []EncryptionKey
+	}{
+		{"empty key ID", EncryptionKey{ID: "", Key: testKey1.Key}, nil},
+		{"invalid key size", EncryptionKey{ID: "bad", Key: []byte("short")}, nil},
+		{"duplicate key ID", testKey1, []EncryptionKey{{ID: testKey1.ID, Key: testKey2.Key}}},
+	}
+
+	for _, tc := range tests {
+		if _, err := NewAESEncrypter(tc.primary, tc.others...); err == nil {
+			t.Errorf("%s: NewAESEncrypter returned nil error", tc.desc)
+		}
+	}
+}
+
+func TestClientEncryptsPayload(t *testing.T) {
+	r := setup(t)
+	enc, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	client.SetEncrypter(enc)
+	payload := map[string]interface{}{"email": "user@example.com"}
+
+	err = client.Enqueue(NewTask("send_email", payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := h.GetEnqueuedMessages(t, r)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d enqueued messages, want 1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.Payload != nil {
+		t.Errorf("Payload = %v, want nil", msg.Payload)
+	}
+	if msg.EncryptedPayload == nil || msg.EncryptedPayload.KeyID != testKey1.ID {
+		t.Fatalf("EncryptedPayload = %+v, want envelope with key %q", msg.EncryptedPayload, testKey1.ID)
+	}
+	if raw := h.MustMarshal(t, msg); bytes.Contains([]byte(raw), []byte("user@example.com")) {
+		t.Errorf("message written to redis contains the plaintext payload: %s", raw)
+	}
+	got, err := decryptPayload(enc, msg)
+	if err != nil {
+		t.Fatalf("decryptPayload returned error: %v", err)
+	}
+	if diff := cmp.Diff(payload, got); diff != "" {
+		t.Errorf("decrypted payload mismatch (-want, +got)\n%s", diff)
+	}
+}
+
+type plainEncrypter struct{}
+
+func (plainEncrypter) Encrypt(plaintext []byte) (string, []byte, error) {
+	return "plain", plaintext, nil
+}
+
+func (plainEncrypter) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
+
+func TestUniqueKeyDigest(t *testing.T) {
+	enc1, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewAESEncrypter(testKey2, testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := NewTask("send_email", map[string]interface{}{"email": "user@example.com"})
+	other := NewTask("send_email", map[string]interface{}{"email": "admin@example.com"})
+	const ttl = time.Hour
+
+	got := uniqueKey(task, ttl, "default", enc1)
+	if strings.Contains(got, "user@example.com") {
+		t.Errorf("uniqueKey = %q, contains the plaintext payload", got)
+	}
+	if unkeyed := uniqueKey(task, ttl, "default", plainEncrypter{}); got == unkeyed {
+		t.Errorf("uniqueKey with AESEncrypter = %q, want it to differ from the unkeyed digest", got)
+	}
+	if again := uniqueKey(task, ttl, "default", enc1); got != again {
+		t.Errorf("uniqueKey = %q, then %q; want the same key for the same task", got, again)
+	}
+	if k := uniqueKey(other, ttl, "default", enc1); got == k {
+		t.Errorf("uniqueKey = %q for different payloads, want different keys", k)
+	}
+	if k := uniqueKey(task, ttl, "default", rotated); got == k {
+		t.Errorf("uniqueKey = %q after rotating the primary key, want a different key", k)
+	}
+}
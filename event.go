@@ -0,0 +1,129 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+)
+
+// EventState is the state a task has transitioned to.
+type EventState string
+
+// States reported in task events.
+const (
+	// EventEnqueued indicates that the task was enqueued by a client.
+	EventEnqueued EventState = "enqueued"
+
+	// EventScheduled indicates that the task was scheduled by a client
+	// to be processed in the future.
+	EventScheduled EventState = "scheduled"
+
+	// EventStarted indicates that a server started processing the task.
+	EventStarted EventState = "started"
+
+	// EventCompleted indicates that the task was processed successfully.
+	EventCompleted EventState = "completed"
+
+	// EventRetried indicates that the task handler failed and
+	// the task was scheduled for a retry.
+	EventRetried EventState = "retried"
+
+	// EventKilled indicates that the task handler failed and
+	// the task was moved to the dead queue.
+	EventKilled EventState = "killed"
+)
+
+// An Event describes a state transition of a task.
+type Event struct {
+	State    EventState
+	TaskID   string
+	TaskType string
+	Queue    string
+
+	// Error is the error returned by the task handler.
+	// It's set only for EventRetried and EventKilled.
+	Error string
+
+	// Duration is the time the task handler took to process the task.
+	// It's set only for EventCompleted, EventRetried and EventKilled.
+	Duration time.Duration
+
+	// Time is the time the transition happened.
+	Time time.Time
+}
+
+// An EventHandler handles task events.
+//
+// HandleEvent is called synchronously by the goroutine which made the
+// transition, so it should return quickly.
+type EventHandler interface {
+	HandleEvent(e *Event)
+}
+
+// The EventHandlerFunc type is an adapter to allow the use of ordinary functions as an EventHandler.
+// If f is a function with the appropriate signature, EventHandlerFunc(f) is an EventHandler that calls f.
+type EventHandlerFunc func(e *Event)
+
+// HandleEvent calls fn(e)
+func (fn EventHandlerFunc) HandleEvent(e *Event) {
+	fn(e)
+}
+
+type eventPublisher interface {
+	PublishEvent(e *base.TaskEvent) error
+}
+
+// emitEvent passes an event for msg to the handler if non-nil,
+// and publishes the event to redis if publish is true.
+func emitEvent(broker eventPublisher, handler EventHandler, publish bool,
+	state EventState, msg *base.TaskMessage, err error, d time.Duration) error {
+	if handler == nil && !publish {
+		return nil
+	}
+	e := &Event{
+		State:    state,
+		TaskID:   msg.ID.String(),
+		TaskType: msg.Type,
+		Queue:    msg.Queue,
+		Duration: d,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if handler != nil {
+		handler.HandleEvent(e)
+	}
+	if !publish {
+		return nil
+	}
+	return broker.PublishEvent(toTaskEvent(e))
+}
+
+func toTaskEvent(e *Event) *base.TaskEvent {
+	return &base.TaskEvent{
+		State:    string(e.State),
+		TaskID:   e.TaskID,
+		Type:     e.TaskType,
+		Queue:    e.Queue,
+		Error:    e.Error,
+		Duration: e.Duration,
+		Time:     e.Time,
+	}
+}
+
+func fromTaskEvent(e *base.TaskEvent) *Event {
+	return &Event{
+		State:    EventState(e.State),
+		TaskID:   e.TaskID,
+		TaskType: e.Type,
+		Queue:    e.Queue,
+		Error:    e.Error,
+		Duration: e.Duration,
+		Time:     e.Time,
+	}
+}
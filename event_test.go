@@ -0,0 +1,158 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// eventRecorder is an EventHandler which records the received events.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (r *eventRecorder) HandleEvent(e *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) get() []*Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Event(nil), r.events...)
+}
+
+// eventCmpOpts ignores fields which cannot be predicted in tests.
+var eventCmpOpts = []cmp.Option{
+	cmpopts.IgnoreFields(Event{}, "TaskID", "Duration", "Time"),
+	cmpopts.SortSlices(func(x, y *Event) bool {
+		if x.TaskType != y.TaskType {
+			return x.TaskType < y.TaskType
+		}
+		return x.State < y.State
+	}),
+}
+
+func TestClientEmitsEvents(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r)
+	client := NewClient(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer client.Close()
+	inspector := NewInspector(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer inspector.Close()
+
+	handled := &eventRecorder{}
+	client.SetEventHandler(handled)
+	client.SetPublishEvents(true)
+
+	published := &eventRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := inspector.TailEvents(ctx, published.HandleEvent); err != nil {
+			t.Errorf("TailEvents returned error: %v", err)
+		}
+	}()
+	// allow for the subscription to be established.
+	time.Sleep(time.Second)
+
+	if err := client.Enqueue(NewTask("send_email", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.EnqueueIn(time.Hour, NewTask("gen_thumbnail", nil), Queue("low")); err != nil {
+		t.Fatal(err)
+	}
+
+	// allow for events to reach the subscriber.
+	time.Sleep(time.Second)
+	cancel()
+	<-done
+
+	want := []*Event{
+		{State: EventEnqueued, TaskType: "send_email", Queue: "default"},
+		{State: EventScheduled, TaskType: "gen_thumbnail", Queue: "low"},
+	}
+	if diff := cmp.Diff(want, handled.get(), eventCmpOpts...); diff != "" {
+		t.Errorf("event handler received %v, want %v; (-want,+got)\n%s", handled.get(), want, diff)
+	}
+	if diff := cmp.Diff(want, published.get(), eventCmpOpts...); diff != "" {
+		t.Errorf("TailEvents received %v, want %v; (-want,+got)\n%s", published.get(), want, diff)
+	}
+}
+
+func TestProcessorEmitsEvents(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("gen_thumbnail", nil)
+	m3 := h.NewTaskMessage("reindex", nil)
+	m3.Retried = m3.Retry // m3 has reached its max retry count
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3})
+
+	starting := make(chan *base.TaskMessage)
+	finished := make(chan *base.TaskMessage)
+	done := make(chan struct{})
+	defer func() { close(done) }()
+	go fakeHeartbeater(starting, finished, done)
+	events := &eventRecorder{}
+	p := newProcessor(processorParams{
+		logger:          testLogger,
+		broker:          rdbClient,
+		retryDelayFunc:  defaultDelayFunc,
+		syncCh:          nil,
+		cancelations:    base.NewCancelations(),
+		progress:        base.NewProgressReports(),
+		concurrency:     10,
+		queues:          defaultQueueConfig,
+		strictPriority:  false,
+		eventHandler:    events,
+		shutdownTimeout: defaultShutdownTimeout,
+		starting:        starting,
+		finished:        finished,
+	})
+	p.handler = HandlerFunc(func(ctx context.Context, task *Task) error {
+		if task.Type == "send_email" {
+			return nil
+		}
+		return errors.New("something went wrong")
+	})
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	p.terminate()
+
+	want := []*Event{
+		{State: EventStarted, TaskType: "send_email", Queue: "default"},
+		{State: EventCompleted, TaskType: "send_email", Queue: "default"},
+		{State: EventStarted, TaskType: "gen_thumbnail", Queue: "default"},
+		{State: EventRetried, TaskType: "gen_thumbnail", Queue: "default", Error: "something went wrong"},
+		{State: EventStarted, TaskType: "reindex", Queue: "default"},
+		{State: EventKilled, TaskType: "reindex", Queue: "default", Error: "something went wrong"},
+	}
+	got := events.get()
+	if diff := cmp.Diff(want, got, eventCmpOpts...); diff != "" {
+		t.Errorf("event handler received %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+	for _, e := range got {
+		if e.State != EventStarted && e.Duration <= 0 {
+			t.Errorf("%s event for %q has duration %v, want positive duration", e.State, e.TaskType, e.Duration)
+		}
+	}
+}
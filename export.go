@@ -0,0 +1,247 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+// Export archives are in JSON lines format. The first line is a header
+// with the format name and version, followed by one line per task.
+const (
+	exportFormat  = "asynq-tasks"
+	exportVersion = 1
+)
+
+type exportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type exportRecord struct {
+	// State is one of "enqueued", "scheduled", "retry" or "dead".
+	State string `json:"state"`
+
+	// Score is the time in Unix seconds the task is scheduled to be
+	// processed, or the time the task died for dead tasks.
+	Score int64 `json:"score,omitempty"`
+
+	// Task is the task message as stored in redis.
+	Task json.RawMessage `json:"task"`
+}
+
+// zsetKey returns the key of the zset holding the tasks in the given state,
+// or false if the tasks in the state are not kept in a zset.
+func zsetKey(keys *base.Keys, state string) (string, bool) {
+	switch state {
+	case "scheduled":
+		return keys.ScheduledQueue, true
+	case "retry":
+		return keys.RetryQueue, true
+	case "dead":
+		return keys.DeadQueue, true
+	default:
+		return "", false
+	}
+}
+
+// ExportFilter specifies which tasks to export.
+// Empty fields match all tasks.
+type ExportFilter struct {
+	// Queues are the names of the queues of the tasks.
+	Queues []string
+
+	// States are the states of the tasks: "enqueued", "scheduled", "retry" or "dead".
+	States []string
+
+	// Types are the types of the tasks.
+	Types []string
+}
+
+func (f *ExportFilter) matchState(state string) bool {
+	return len(f.States) == 0 || contains(f.States, state)
+}
+
+func (f *ExportFilter) match(msg *base.TaskMessage) bool {
+	return (len(f.Queues) == 0 || contains(f.Queues, msg.Queue)) &&
+		(len(f.Types) == 0 || contains(f.Types, msg.Type))
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportTasks writes the tasks matching the filter to w in a versioned
+// JSON lines format which can be read by ImportTasks.
+// Task messages are written as stored in redis, so encrypted and offloaded
+// payloads are exported as they are.
+//
+// The export is not a consistent snapshot; tasks which change state while
+// exporting may be missed or exported twice.
+//
+// ExportTasks returns the number of exported tasks.
+func (i *Inspector) ExportTasks(w io.Writer, filter ExportFilter) (int, error) {
+	keys := i.rdb.Keys()
+	for _, state := range filter.States {
+		if _, ok := zsetKey(keys, state); !ok && state != "enqueued" {
+			return 0, fmt.Errorf("asynq: unsupported task state %q", state)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	header := exportHeader{Format: exportFormat, Version: exportVersion, ExportedAt: time.Now().UTC()}
+	if err := enc.Encode(&header); err != nil {
+		return 0, err
+	}
+	n := 0
+	export := func(state string) func(tasks []*rdb.RawTask) error {
+		return func(tasks []*rdb.RawTask) error {
+			for _, t := range tasks {
+				var msg base.TaskMessage
+				if err := json.Unmarshal([]byte(t.Data), &msg); err != nil || !filter.match(&msg) {
+					continue
+				}
+				rec := exportRecord{State: state, Score: t.Score, Task: json.RawMessage(t.Data)}
+				if err := enc.Encode(&rec); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		}
+	}
+	if filter.matchState("enqueued") {
+		qkeys, err := i.rdb.QueueKeys()
+		if err != nil {
+			return n, err
+		}
+		sort.Strings(qkeys)
+		for _, qkey := range qkeys {
+			qname := strings.TrimPrefix(qkey, keys.QueuePrefix)
+			if len(filter.Queues) > 0 && !contains(filter.Queues, qname) {
+				continue
+			}
+			for _, key := range keys.PriorityQueueKeys(qname) {
+				if err := i.rdb.ScanList(key, export("enqueued")); err != nil {
+					return n, err
+				}
+			}
+		}
+	}
+	for _, state := range []string{"scheduled", "retry", "dead"} {
+		if !filter.matchState(state) {
+			continue
+		}
+		key, _ := zsetKey(keys, state)
+		if err := i.rdb.ScanZSet(key, export(state)); err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+// Number of tasks to write to redis at once when importing.
+const importBatchSize = 100
+
+// ImportTasks reads tasks written by ExportTasks from r and adds them
+// to redis, preserving their IDs, scores and retry counts.
+//
+// Enqueued tasks are added to the front of their queues so that they are
+// processed before the tasks already in the queues. Uniqueness locks are
+// not restored. Importing the same tasks twice duplicates enqueued tasks.
+//
+// ImportTasks returns the number of imported tasks. If an error is
+// encountered, the tasks read before the error may have been imported.
+func (i *Inspector) ImportTasks(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("asynq: export header is missing")
+	}
+	var header exportHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != exportFormat {
+		return 0, fmt.Errorf("asynq: not an asynq export")
+	}
+	if header.Version != exportVersion {
+		return 0, fmt.Errorf("asynq: unsupported export version %d", header.Version)
+	}
+	var (
+		n     int
+		batch []*rdb.RawTask
+	)
+	flush := func() error {
+		if err := i.rdb.ImportTasks(batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for line := 2; scanner.Scan(); line++ {
+		t, err := decodeExportRecord(i.rdb.Keys(), scanner.Bytes())
+		if err != nil {
+			return n, fmt.Errorf("asynq: line %d: %v", line, err)
+		}
+		batch = append(batch, t)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+func decodeExportRecord(keys *base.Keys, data []byte) (*rdb.RawTask, error) {
+	var rec exportRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	var msg base.TaskMessage
+	if err := json.Unmarshal(rec.Task, &msg); err != nil {
+		return nil, fmt.Errorf("invalid task: %v", err)
+	}
+	if msg.ID.IsNil() || msg.Type == "" || msg.Queue == "" {
+		return nil, fmt.Errorf("invalid task: missing ID, type or queue")
+	}
+	var key string
+	switch rec.State {
+	case "enqueued":
+		key = keys.PriorityQueueKey(msg.Queue, msg.Priority)
+	default:
+		var ok bool
+		if key, ok = zsetKey(keys, rec.State); !ok {
+			return nil, fmt.Errorf("unsupported task state %q", rec.State)
+		}
+	}
+	// Re-encode the message so that it matches the encoding used by the
+	// server when the task is removed from the in-progress list.
+	encoded, err := json.Marshal(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return &rdb.RawTask{Key: key, Data: string(encoded), Score: rec.Score}, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+)
+
+func TestInspectorExportImportTasks(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "a@example.com"})
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	m4 := h.NewTaskMessage("gen_thumbnail", nil)
+	m5 := h.NewTaskMessage("send_email", nil)
+	m5.Retried = 3
+	m5.ErrorMsg = "timeout"
+	m6 := h.NewTaskMessageWithQueue("sync", nil, "critical")
+	m6.Retried = m6.Retry
+	m6.ErrorMsg = "not found"
+	now := time.Now()
+	scheduled := []h.ZSetEntry{{Msg: m4, Score: float64(now.Add(time.Hour).Unix())}}
+	retry := []h.ZSetEntry{{Msg: m5, Score: float64(now.Add(time.Minute).Unix())}}
+	dead := []h.ZSetEntry{{Msg: m6, Score: float64(now.Add(-time.Hour).Unix())}}
+
+	tests := []struct {
+		desc          string
+		filter        ExportFilter
+		wantN         int
+		wantEnqueued  map[string][]*base.TaskMessage
+		wantScheduled []h.ZSetEntry
+		wantRetry     []h.ZSetEntry
+		wantDead      []h.ZSetEntry
+	}{
+		{
+			desc:   "all tasks",
+			filter: ExportFilter{},
+			wantN:  6,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default":  {m1, m2},
+				"critical": {m3},
+			},
+			wantScheduled: scheduled,
+			wantRetry:     retry,
+			wantDead:      dead,
+		},
+		{
+			desc:   "by queue",
+			filter: ExportFilter{Queues: []string{"critical"}},
+			wantN:  2,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default":  {},
+				"critical": {m3},
+			},
+			wantDead: dead,
+		},
+		{
+			desc:   "by state and type",
+			filter: ExportFilter{States: []string{"enqueued", "retry"}, Types: []string{"send_email"}},
+			wantN:  3,
+			wantEnqueued: map[string][]*base.TaskMessage{
+				"default":  {m1},
+				"critical": {m3},
+			},
+			wantRetry: retry,
+		},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m3}, "critical")
+		h.SeedScheduledQueue(t, r, scheduled)
+		h.SeedRetryQueue(t, r, retry)
+		h.SeedDeadQueue(t, r, dead)
+
+		var buf bytes.Buffer
+		n, err := inspector.ExportTasks(&buf, tc.filter)
+		if err != nil {
+			t.Errorf("%s: ExportTasks returned error: %v", tc.desc, err)
+			continue
+		}
+		if n != tc.wantN {
+			t.Errorf("%s: ExportTasks exported %d tasks, want %d", tc.desc, n, tc.wantN)
+		}
+
+		h.FlushDB(t, r)
+		n, err = inspector.ImportTasks(&buf)
+		if err != nil {
+			t.Errorf("%s: ImportTasks returned error: %v", tc.desc, err)
+			continue
+		}
+		if n != tc.wantN {
+			t.Errorf("%s: ImportTasks imported %d tasks, want %d", tc.desc, n, tc.wantN)
+		}
+
+		for qname, want := range tc.wantEnqueued {
+			got := h.GetEnqueuedMessages(t, r, qname)
+			if diff := cmp.Diff(want, got, h.SortMsgOpt); diff != "" {
+				t.Errorf("%s: mismatch found in %q after import; (-want,+got)\n%s", tc.desc, base.QueueKey(qname), diff)
+			}
+		}
+		if diff := cmp.Diff(tc.wantScheduled, h.GetScheduledEntries(t, r), h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q after import; (-want,+got)\n%s", tc.desc, base.ScheduledQueue, diff)
+		}
+		if diff := cmp.Diff(tc.wantRetry, h.GetRetryEntries(t, r), h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q after import; (-want,+got)\n%s", tc.desc, base.RetryQueue, diff)
+		}
+		if diff := cmp.Diff(tc.wantDead, h.GetDeadEntries(t, r), h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q after import; (-want,+got)\n%s", tc.desc, base.DeadQueue, diff)
+		}
+	}
+}
+
+func TestInspectorImportTasksError(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r)
+	msg := h.MustMarshal(t, h.NewTaskMessage("send_email", nil))
+
+	tests := []struct {
+		desc  string
+		input string
+	}{
+		{"empty input", ""},
+		{"not an export", `{"foo":"bar"}`},
+		{"unsupported version", `{"format":"asynq-tasks","version":99}`},
+		{"unsupported state", `{"format":"asynq-tasks","version":1}` + "\n" + `{"state":"in_progress","task":` + msg + `}`},
+		{"invalid task", `{"format":"asynq-tasks","version":1}` + "\n" + `{"state":"enqueued","task":{}}`},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		n, err := inspector.ImportTasks(strings.NewReader(tc.input))
+		if err == nil {
+			t.Errorf("%s: ImportTasks returned nil error", tc.desc)
+		}
+		if n != 0 {
+			t.Errorf("%s: ImportTasks imported %d tasks, want 0", tc.desc, n)
+		}
+	}
+}
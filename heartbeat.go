@@ -27,9 +27,13 @@ type heartbeater struct {
 	interval time.Duration
 
 	// following fields are initialized at construction time and are immutable.
-	host           string
-	pid            int
-	serverID       string
+	host     string
+	pid      int
+	serverID string
+
+	// following fields can be changed while the server is running
+	// and are guarded by mu.
+	mu             sync.Mutex
 	concurrency    int
 	queues         map[string]int
 	strictPriority bool
@@ -43,6 +47,9 @@ type heartbeater struct {
 	// status is shared with other goroutine but is concurrency safe.
 	status *base.ServerStatus
 
+	// progress is shared with the processor but is concurrency safe.
+	progress *base.ProgressReports
+
 	// channels to receive updates on active workers.
 	starting <-chan *base.TaskMessage
 	finished <-chan *base.TaskMessage
@@ -56,6 +63,7 @@ type heartbeaterParams struct {
 	queues         map[string]int
 	strictPriority bool
 	status         *base.ServerStatus
+	progress       *base.ProgressReports
 	starting       <-chan *base.TaskMessage
 	finished       <-chan *base.TaskMessage
 }
@@ -80,6 +88,7 @@ func newHeartbeater(params heartbeaterParams) *heartbeater {
 		strictPriority: params.strictPriority,
 
 		status:   params.status,
+		progress: params.progress,
 		workers:  make(map[string]workerStat),
 		starting: params.starting,
 		finished: params.finished,
@@ -131,7 +140,17 @@ func (h *heartbeater) start(wg *sync.WaitGroup) {
 	}()
 }
 
+// setConfig updates the processing config reported by the heartbeater.
+func (h *heartbeater) setConfig(concurrency int, queues map[string]int, strict bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.concurrency = concurrency
+	h.queues = queues
+	h.strictPriority = strict
+}
+
 func (h *heartbeater) beat() {
+	h.mu.Lock()
 	info := base.ServerInfo{
 		Host:              h.host,
 		PID:               h.pid,
@@ -143,10 +162,11 @@ func (h *heartbeater) beat() {
 		Started:           h.started,
 		ActiveWorkerCount: len(h.workers),
 	}
+	h.mu.Unlock()
 
 	var ws []*base.WorkerInfo
 	for id, stat := range h.workers {
-		ws = append(ws, &base.WorkerInfo{
+		w := &base.WorkerInfo{
 			Host:    h.host,
 			PID:     h.pid,
 			ID:      id,
@@ -154,7 +174,14 @@ func (h *heartbeater) beat() {
 			Queue:   stat.msg.Queue,
 			Payload: stat.msg.Payload,
 			Started: stat.started,
-		})
+
+			EncryptedPayload: stat.msg.EncryptedPayload,
+			PayloadRef:       stat.msg.PayloadRef,
+		}
+		if p, ok := h.progress.Get(id); ok {
+			w.Progress = &p
+		}
+		ws = append(ws, w)
 	}
 
 	// Note: Set TTL to be long enough so that it won't expire before we write again
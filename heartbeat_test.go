@@ -46,6 +46,7 @@ func TestHeartbeater(t *testing.T) {
 			queues:         tc.queues,
 			strictPriority: false,
 			status:         status,
+			progress:       base.NewProgressReports(),
 			starting:       make(chan *base.TaskMessage),
 			finished:       make(chan *base.TaskMessage),
 		})
@@ -137,6 +138,7 @@ func TestHeartbeaterWithRedisDown(t *testing.T) {
 		queues:         map[string]int{"default": 1},
 		strictPriority: false,
 		status:         base.NewServerStatus(base.StatusRunning),
+		progress:       base.NewProgressReports(),
 		starting:       make(chan *base.TaskMessage),
 		finished:       make(chan *base.TaskMessage),
 	})
@@ -150,3 +152,45 @@ func TestHeartbeaterWithRedisDown(t *testing.T) {
 
 	hb.terminate()
 }
+
+func TestHeartbeaterPublishesProgress(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	const interval = time.Second
+	progress := base.NewProgressReports()
+	starting := make(chan *base.TaskMessage)
+	hb := newHeartbeater(heartbeaterParams{
+		logger:         testLogger,
+		broker:         rdbClient,
+		interval:       interval,
+		concurrency:    10,
+		queues:         map[string]int{"default": 1},
+		strictPriority: false,
+		status:         base.NewServerStatus(base.StatusRunning),
+		progress:       progress,
+		starting:       starting,
+		finished:       make(chan *base.TaskMessage),
+	})
+	msg := h.NewTaskMessage("transcode", nil)
+	want := base.Progress{Percent: 42, Message: "encoding", Updated: time.Now()}
+
+	var wg sync.WaitGroup
+	hb.start(&wg)
+	starting <- msg
+	progress.Set(msg.ID.String(), want)
+
+	// allow for heartbeater to write to redis
+	time.Sleep(interval * 2)
+
+	workers, err := rdbClient.ListWorkers()
+	hb.terminate()
+	if err != nil {
+		t.Fatalf("(*RDB).ListWorkers() returned error: %v", err)
+	}
+	if len(workers) != 1 {
+		t.Fatalf("(*RDB).ListWorkers() returned %d workers, want 1", len(workers))
+	}
+	if diff := cmp.Diff(&want, workers[0].Progress, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("mismatch found in worker progress; (-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,1196 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/rs/xid"
+)
+
+// Inspector is a client interface to inspect tasks and queues.
+//
+// Inspectors are safe for concurrent use by multiple goroutines.
+type Inspector struct {
+	mu        sync.Mutex
+	encrypter Encrypter
+	blobStore BlobStore
+	rdb       *rdb.RDB
+}
+
+// NewInspector returns a new instance of Inspector given a redis connection option.
+func NewInspector(r RedisConnOpt) *Inspector {
+	return &Inspector{
+		rdb: createRDB(r),
+	}
+}
+
+// Close closes the connection with redis server.
+func (i *Inspector) Close() error {
+	return i.rdb.Close()
+}
+
+// SetEncrypter sets the encrypter used to decrypt task payloads.
+//
+// Without an encrypter, encrypted payloads are redacted.
+func (i *Inspector) SetEncrypter(enc Encrypter) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.encrypter = enc
+}
+
+// SetBlobStore sets the blob store used to read offloaded task payloads.
+//
+// Without a blob store, offloaded payloads are redacted.
+func (i *Inspector) SetBlobStore(store BlobStore) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.blobStore = store
+}
+
+// payload returns the payload to report for a task.
+// It reports whether the payload is redacted because it is encrypted
+// or offloaded and could not be read.
+func (i *Inspector) payload(data map[string]interface{}, encrypted *base.EncryptedPayload, ref string) (p Payload, redacted bool) {
+	if encrypted == nil && ref == "" {
+		return Payload{data}, false
+	}
+	i.mu.Lock()
+	enc, store := i.encrypter, i.blobStore
+	i.mu.Unlock()
+	msg, err := loadPayload(store, &base.TaskMessage{EncryptedPayload: encrypted, PayloadRef: ref})
+	if err != nil {
+		return Payload{}, true
+	}
+	decrypted, err := decryptPayload(enc, msg)
+	if err != nil {
+		return Payload{}, true
+	}
+	return Payload{decrypted}, false
+}
+
+// EnqueuedTask is a task in a queue and is ready to be processed.
+type EnqueuedTask struct {
+	*Task
+	ID       string
+	Queue    string
+	Priority int
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// InProgressTask is a task that's currently being processed.
+type InProgressTask struct {
+	*Task
+	ID string
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// ScheduledTask is a task scheduled to be processed in the future.
+type ScheduledTask struct {
+	*Task
+	ID            string
+	Queue         string
+	NextEnqueueAt time.Time
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// RetryTask is a task scheduled to be retried in the future.
+type RetryTask struct {
+	*Task
+	ID            string
+	Queue         string
+	NextEnqueueAt time.Time
+	MaxRetry      int
+	Retried       int
+	ErrorMsg      string
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// DeadTask is a task exhausted its retries.
+// DeadTask won't be retried automatically.
+type DeadTask struct {
+	*Task
+	ID           string
+	Queue        string
+	LastFailedAt time.Time
+	ErrorMsg     string
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// TaskInfo describes a task in any state.
+type TaskInfo struct {
+	*Task
+	ID       string
+	Queue    string
+	Priority int
+
+	// State is the state of the task: "enqueued", "inprogress",
+	// "scheduled", "retry" or "dead".
+	State string
+
+	MaxRetry int
+	Retried  int
+	ErrorMsg string
+
+	// NextEnqueueAt is the time the task is enqueued.
+	// It's set only for scheduled and retry tasks.
+	NextEnqueueAt time.Time
+
+	// LastFailedAt is the time the task exhausted its retries.
+	// It's set only for dead tasks.
+	LastFailedAt time.Time
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// WorkerInfo describes a worker processing a task.
+type WorkerInfo struct {
+	Host    string
+	PID     int
+	ID      string
+	Type    string
+	Queue   string
+	Payload Payload
+	Started time.Time
+
+	// Progress is the latest progress reported by the handler.
+	// It is nil if the handler has not reported any progress.
+	Progress *Progress
+
+	// Redacted indicates that the payload is encrypted or offloaded
+	// and the Inspector could not read it.
+	Redacted bool
+}
+
+// Progress is the progress of a task reported by its handler
+// with ReportProgress.
+type Progress struct {
+	Percent int
+	Message string
+	Updated time.Time
+}
+
+// LastActive returns the time the worker last reported progress,
+// or the time it started processing the task if it has not reported any.
+func (w *WorkerInfo) LastActive() time.Time {
+	if w.Progress != nil {
+		return w.Progress.Updated
+	}
+	return w.Started
+}
+
+// IsStale reports whether the worker has not reported progress for longer than d.
+func (w *WorkerInfo) IsStale(d time.Duration) bool {
+	return time.Since(w.LastActive()) > d
+}
+
+// ServerInfo describes a running server.
+type ServerInfo struct {
+	ID             string
+	Host           string
+	PID            int
+	Concurrency    int
+	Queues         map[string]int
+	StrictPriority bool
+	Started        time.Time
+
+	// Status is the state of the server: "running", "quiet" or "stopped".
+	Status string
+
+	// ActiveWorkers is the number of tasks currently being processed.
+	ActiveWorkers int
+}
+
+// SharedConfig is the processing config picked up by servers
+// configured with Config.WatchConfig.
+//
+// Zero values leave the servers' own config in effect.
+type SharedConfig struct {
+	Concurrency int
+	Queues      map[string]int
+
+	// StrictPriority is applied only if Queues is set.
+	StrictPriority bool
+}
+
+// Stats represents the state of tasks and queues at a point in time.
+type Stats struct {
+	// Number of tasks in each state.
+	Enqueued   int
+	InProgress int
+	Scheduled  int
+	Retry      int
+	Dead       int
+
+	// Number of tasks processed, failed and expired in the current day (UTC).
+	Processed int
+	Failed    int
+	Expired   int
+
+	// Queues are sorted by name.
+	Queues []*QueueInfo
+
+	Timestamp time.Time
+}
+
+// QueueInfo represents the state of a queue.
+type QueueInfo struct {
+	Name   string
+	Paused bool
+
+	// Size is the number of tasks in the queue.
+	Size int
+
+	// OldestPendingAge is how long the next task to be processed,
+	// i.e. the oldest task in the queue, has been waiting.
+	// Zero if the queue is empty or the enqueue time of the task is unknown.
+	OldestPendingAge time.Duration
+
+	// WaitP50 and WaitP99 are the 50th and 99th percentile of the time
+	// the last 1000 tasks dequeued from the queue waited in the queue.
+	// Zero if no tasks have been dequeued.
+	WaitP50 time.Duration
+	WaitP99 time.Duration
+
+	// Limit is the limit set with SetQueueLimit.
+	// Nil if the queue has no limit.
+	Limit *QueueLimit
+}
+
+// CurrentStats returns the current state of tasks and queues.
+func (i *Inspector) CurrentStats() (*Stats, error) {
+	s, err := i.rdb.CurrentStats()
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{
+		Enqueued:   s.Enqueued,
+		InProgress: s.InProgress,
+		Scheduled:  s.Scheduled,
+		Retry:      s.Retry,
+		Dead:       s.Dead,
+		Processed:  s.Processed,
+		Failed:     s.Failed,
+		Expired:    s.Expired,
+		Timestamp:  s.Timestamp,
+	}
+	for _, q := range s.Queues {
+		stats.Queues = append(stats.Queues, &QueueInfo{
+			Name:             q.Name,
+			Paused:           q.Paused,
+			Size:             q.Size,
+			OldestPendingAge: q.OldestPendingAge,
+			WaitP50:          q.WaitP50,
+			WaitP99:          q.WaitP99,
+			Limit:            queueLimit(q.Limit),
+		})
+	}
+	return stats, nil
+}
+
+// Granularity is the size of the time buckets of processing statistics.
+type Granularity string
+
+// Granularities of processing statistics.
+const (
+	Minute Granularity = "minute"
+	Hour   Granularity = "hour"
+	Day    Granularity = "day"
+)
+
+// ProcessingStats holds the number of tasks of a queue or a task type
+// processed, failed and retried in a time bucket.
+//
+// Processed counts every attempt to process a task, including failed ones.
+type ProcessingStats struct {
+	Processed int
+	Failed    int
+	Retried   int
+
+	// Time is the start of the time bucket.
+	Time time.Time
+}
+
+func toProcessingStats(in []*rdb.ProcessingStats) []*ProcessingStats {
+	var res []*ProcessingStats
+	for _, s := range in {
+		res = append(res, &ProcessingStats{
+			Processed: s.Processed,
+			Failed:    s.Failed,
+			Retried:   s.Retried,
+			Time:      s.Time,
+		})
+	}
+	return res
+}
+
+// QueueHistory returns the processing statistics of the queue in the last n
+// time buckets of granularity g, starting from the current bucket.
+func (i *Inspector) QueueHistory(qname string, g Granularity, n int) ([]*ProcessingStats, error) {
+	stats, err := i.rdb.QueueHistory(qname, base.Granularity(g), n)
+	if err != nil {
+		return nil, err
+	}
+	return toProcessingStats(stats), nil
+}
+
+// TaskTypeHistory returns the processing statistics of the task type in the
+// last n time buckets of granularity g, starting from the current bucket.
+func (i *Inspector) TaskTypeHistory(typename string, g Granularity, n int) ([]*ProcessingStats, error) {
+	stats, err := i.rdb.TypeHistory(typename, base.Granularity(g), n)
+	if err != nil {
+		return nil, err
+	}
+	return toProcessingStats(stats), nil
+}
+
+// CurrentProcessingStats returns the processing statistics of each queue
+// and each task type in the current time bucket of granularity g.
+// The maps are keyed by queue name and task type respectively.
+func (i *Inspector) CurrentProcessingStats(g Granularity) (queues, types map[string]*ProcessingStats, err error) {
+	qstats, tstats, err := i.rdb.CurrentProcessingStats(base.Granularity(g))
+	if err != nil {
+		return nil, nil, err
+	}
+	queues = make(map[string]*ProcessingStats)
+	for qname, s := range qstats {
+		queues[qname] = toProcessingStats([]*rdb.ProcessingStats{s})[0]
+	}
+	types = make(map[string]*ProcessingStats)
+	for typename, s := range tstats {
+		types[typename] = toProcessingStats([]*rdb.ProcessingStats{s})[0]
+	}
+	return queues, types, nil
+}
+
+// ListOption specifies behavior of list operation.
+type ListOption interface{}
+
+// Internal list option representations.
+type (
+	pageSizeOpt      int
+	pageNumOpt       int
+	taskTypeOpt      string
+	payloadEqualsOpt struct{ key, value string }
+	errorContainsOpt string
+	timeRangeOpt     struct{ from, to time.Time }
+)
+
+type listOption struct {
+	pageSize int
+	pageNum  int
+	filter   rdb.Filter
+}
+
+const (
+	// Page size used by default in list operation.
+	defaultPageSize = 30
+
+	// Page number used by default in list operation.
+	defaultPageNum = 1
+)
+
+func composeListOptions(opts ...ListOption) listOption {
+	res := listOption{
+		pageSize: defaultPageSize,
+		pageNum:  defaultPageNum,
+	}
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case pageSizeOpt:
+			res.pageSize = int(opt)
+		case pageNumOpt:
+			res.pageNum = int(opt)
+		case taskTypeOpt:
+			res.filter.Type = string(opt)
+		case payloadEqualsOpt:
+			if res.filter.Payload == nil {
+				res.filter.Payload = make(map[string]string)
+			}
+			res.filter.Payload[opt.key] = opt.value
+		case errorContainsOpt:
+			res.filter.ErrorContains = string(opt)
+		case timeRangeOpt:
+			res.filter.MinScore, res.filter.MaxScore = 0, 0
+			if !opt.from.IsZero() {
+				res.filter.MinScore = opt.from.Unix()
+			}
+			if !opt.to.IsZero() {
+				res.filter.MaxScore = opt.to.Unix()
+			}
+		default:
+			// ignore unexpected option
+		}
+	}
+	return res
+}
+
+func (opt listOption) pagination() rdb.Pagination {
+	// Page number is one-indexed for the public API.
+	return rdb.Pagination{Size: opt.pageSize, Page: opt.pageNum - 1}
+}
+
+// TaskType returns an option to list only the tasks of the given type.
+func TaskType(typename string) ListOption {
+	return taskTypeOpt(typename)
+}
+
+// PayloadEquals returns an option to list only the tasks whose payload
+// has the given value for the key. The option can be passed multiple times
+// to match multiple keys.
+//
+// The value is compared with the string, number or boolean value in the
+// payload. Encrypted and offloaded payloads never match.
+func PayloadEquals(key string, value interface{}) ListOption {
+	return payloadEqualsOpt{key, fmt.Sprint(value)}
+}
+
+// ErrorContains returns an option to list only the tasks whose last error
+// message contains the given string.
+func ErrorContains(s string) ListOption {
+	return errorContainsOpt(s)
+}
+
+// TimeRange returns an option to list only the tasks with the time in the
+// given range, inclusive. The time is the time the task is scheduled to be
+// processed for scheduled and retry tasks, and the time the task died for
+// dead tasks. Zero time leaves the range unbounded on that side.
+//
+// The option is ignored when listing enqueued and in-progress tasks.
+func TimeRange(from, to time.Time) ListOption {
+	return timeRangeOpt{from, to}
+}
+
+// PageSize returns an option to specify the page size for list operation.
+//
+// Negative page size is treated as zero.
+func PageSize(n int) ListOption {
+	if n < 0 {
+		n = 0
+	}
+	return pageSizeOpt(n)
+}
+
+// Page returns an option to specify the page number for list operation.
+// The value 1 fetches the first page.
+//
+// Page number less than one is treated as one.
+func Page(n int) ListOption {
+	if n < 1 {
+		n = 1
+	}
+	return pageNumOpt(n)
+}
+
+// ListEnqueuedTasks retrieves enqueued tasks from the specified queue,
+// in the order they are processed: by priority, then by enqueue time.
+//
+// By default, it retrieves the first 30 tasks.
+// Use TaskType, PayloadEquals and ErrorContains options to retrieve
+// only the matching tasks.
+func (i *Inspector) ListEnqueuedTasks(qname string, opts ...ListOption) ([]*EnqueuedTask, error) {
+	opt := composeListOptions(opts...)
+	tasks, err := i.rdb.ListEnqueued(qname, opt.pagination(), &opt.filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []*EnqueuedTask
+	for _, t := range tasks {
+		payload, redacted := i.payload(t.Payload, t.EncryptedPayload, t.PayloadRef)
+		res = append(res, &EnqueuedTask{
+			Task:     &Task{Type: t.Type, Payload: payload},
+			ID:       t.ID.String(),
+			Queue:    t.Queue,
+			Priority: t.Priority,
+			Redacted: redacted,
+		})
+	}
+	return res, nil
+}
+
+// ListInProgressTasks retrieves in-progress tasks.
+//
+// By default, it retrieves the first 30 tasks.
+// Use TaskType, PayloadEquals and ErrorContains options to retrieve
+// only the matching tasks.
+func (i *Inspector) ListInProgressTasks(opts ...ListOption) ([]*InProgressTask, error) {
+	opt := composeListOptions(opts...)
+	tasks, err := i.rdb.ListInProgress(opt.pagination(), &opt.filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []*InProgressTask
+	for _, t := range tasks {
+		payload, redacted := i.payload(t.Payload, t.EncryptedPayload, t.PayloadRef)
+		res = append(res, &InProgressTask{
+			Task:     &Task{Type: t.Type, Payload: payload},
+			ID:       t.ID.String(),
+			Redacted: redacted,
+		})
+	}
+	return res, nil
+}
+
+// ListScheduledTasks retrieves tasks currently scheduled to be processed.
+//
+// By default, it retrieves the first 30 tasks.
+// Use TaskType, PayloadEquals, ErrorContains and TimeRange options
+// to retrieve only the matching tasks.
+func (i *Inspector) ListScheduledTasks(opts ...ListOption) ([]*ScheduledTask, error) {
+	opt := composeListOptions(opts...)
+	tasks, err := i.rdb.ListScheduled(opt.pagination(), &opt.filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []*ScheduledTask
+	for _, t := range tasks {
+		payload, redacted := i.payload(t.Payload, t.EncryptedPayload, t.PayloadRef)
+		res = append(res, &ScheduledTask{
+			Task:          &Task{Type: t.Type, Payload: payload},
+			ID:            t.ID.String(),
+			Queue:         t.Queue,
+			NextEnqueueAt: t.ProcessAt,
+			Redacted:      redacted,
+		})
+	}
+	return res, nil
+}
+
+// ListRetryTasks retrieves tasks currently scheduled to be retried.
+//
+// By default, it retrieves the first 30 tasks.
+// Use TaskType, PayloadEquals, ErrorContains and TimeRange options
+// to retrieve only the matching tasks.
+func (i *Inspector) ListRetryTasks(opts ...ListOption) ([]*RetryTask, error) {
+	opt := composeListOptions(opts...)
+	tasks, err := i.rdb.ListRetry(opt.pagination(), &opt.filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []*RetryTask
+	for _, t := range tasks {
+		payload, redacted := i.payload(t.Payload, t.EncryptedPayload, t.PayloadRef)
+		res = append(res, &RetryTask{
+			Task:          &Task{Type: t.Type, Payload: payload},
+			ID:            t.ID.String(),
+			Queue:         t.Queue,
+			NextEnqueueAt: t.ProcessAt,
+			MaxRetry:      t.Retry,
+			Retried:       t.Retried,
+			ErrorMsg:      t.ErrorMsg,
+			Redacted:      redacted,
+		})
+	}
+	return res, nil
+}
+
+// ListDeadTasks retrieves tasks which have exhausted their retries.
+//
+// By default, it retrieves the first 30 tasks.
+// Use TaskType, PayloadEquals, ErrorContains and TimeRange options
+// to retrieve only the matching tasks.
+func (i *Inspector) ListDeadTasks(opts ...ListOption) ([]*DeadTask, error) {
+	opt := composeListOptions(opts...)
+	tasks, err := i.rdb.ListDead(opt.pagination(), &opt.filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []*DeadTask
+	for _, t := range tasks {
+		payload, redacted := i.payload(t.Payload, t.EncryptedPayload, t.PayloadRef)
+		res = append(res, &DeadTask{
+			Task:         &Task{Type: t.Type, Payload: payload},
+			ID:           t.ID.String(),
+			Queue:        t.Queue,
+			LastFailedAt: t.LastFailedAt,
+			ErrorMsg:     t.ErrorMsg,
+			Redacted:     redacted,
+		})
+	}
+	return res, nil
+}
+
+// ErrTaskNotFound indicates that no task has the given ID.
+var ErrTaskNotFound = errors.New("asynq: task not found")
+
+// GetTask retrieves the task with the given ID in any queue or state.
+//
+// ErrTaskNotFound is returned if no task has the given ID.
+func (i *Inspector) GetTask(id string) (*TaskInfo, error) {
+	tid, err := xid.FromString(id)
+	if err != nil {
+		return nil, ErrTaskNotFound
+	}
+	t, err := i.rdb.GetTask(tid)
+	if err == rdb.ErrTaskNotFound {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i.taskInfo(t), nil
+}
+
+// taskInfo returns the public representation of the task t.
+func (i *Inspector) taskInfo(t *rdb.TaskInfo) *TaskInfo {
+	payload, redacted := i.payload(t.Msg.Payload, t.Msg.EncryptedPayload, t.Msg.PayloadRef)
+	info := &TaskInfo{
+		Task:     &Task{Type: t.Msg.Type, Payload: payload},
+		ID:       t.Msg.ID.String(),
+		Queue:    t.Msg.Queue,
+		Priority: t.Msg.Priority,
+		MaxRetry: t.Msg.Retry,
+		Retried:  t.Msg.Retried,
+		ErrorMsg: t.Msg.ErrorMsg,
+		Redacted: redacted,
+	}
+	switch keys := i.rdb.Keys(); t.Key {
+	case keys.InProgressQueue:
+		info.State = "inprogress"
+	case keys.ScheduledQueue:
+		info.State = "scheduled"
+		info.NextEnqueueAt = time.Unix(t.Score, 0)
+	case keys.RetryQueue:
+		info.State = "retry"
+		info.NextEnqueueAt = time.Unix(t.Score, 0)
+	case keys.DeadQueue:
+		info.State = "dead"
+		info.LastFailedAt = time.Unix(t.Score, 0)
+	default:
+		info.State = "enqueued"
+	}
+	return info
+}
+
+// ErrTaskNotUpdatable indicates that the task is not in a state which allows
+// the operation, e.g. the payload of an in-progress task cannot be updated.
+var ErrTaskNotUpdatable = errors.New("asynq: task cannot be changed in its current state")
+
+// UpdateOption specifies behavior of update operation.
+type UpdateOption interface{}
+
+// Internal update option representations.
+type resetRetriedOpt struct{}
+
+// ResetRetried returns an option to reset the retry count of the task,
+// so that it gets the full number of retries again.
+func ResetRetried() UpdateOption {
+	return resetRetriedOpt{}
+}
+
+// Maximum number of attempts to update a task which keeps changing.
+const maxUpdateAttempts = 3
+
+// updateTask reads the task with the given ID and calls fn to update it.
+// fn returns rdb.ErrTaskNotFound if the task changed since it was read,
+// in which case the task is read again and fn is retried.
+func (i *Inspector) updateTask(id string, fn func(t *rdb.TaskInfo) error) error {
+	tid, err := xid.FromString(id)
+	if err != nil {
+		return ErrTaskNotFound
+	}
+	for attempt := 1; ; attempt++ {
+		t, err := i.rdb.GetTask(tid)
+		if err == rdb.ErrTaskNotFound {
+			return ErrTaskNotFound
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(t)
+		if err == rdb.ErrTaskNotFound {
+			if attempt < maxUpdateAttempts {
+				continue // task changed since it was read
+			}
+			return fmt.Errorf("asynq: task %s kept changing during the update", id)
+		}
+		return err
+	}
+}
+
+// notUpdatable returns the error for the task t which is not in a state
+// which allows the operation.
+func (i *Inspector) notUpdatable(t *rdb.TaskInfo) error {
+	return fmt.Errorf("%w: task is %s", ErrTaskNotUpdatable, i.taskInfo(t).State)
+}
+
+// UpdateTaskPayload applies patch to the payload of the scheduled, retry or
+// dead task with the given ID, and returns the updated task.
+// Each key in patch sets the top-level key of the payload to the value,
+// or removes it from the payload if the value is nil.
+//
+// The task keeps its ID, state and history. It is updated atomically,
+// so a task which is enqueued in the meantime is never updated.
+// Encrypted payloads are encrypted again with the encrypter of the inspector,
+// and offloaded payloads are stored in the task after the update.
+//
+// ErrTaskNotFound is returned if no task has the given ID, and
+// ErrTaskNotUpdatable is returned if the task is enqueued or in progress.
+func (i *Inspector) UpdateTaskPayload(id string, patch map[string]interface{}, opts ...UpdateOption) (*TaskInfo, error) {
+	var resetRetried bool
+	for _, opt := range opts {
+		switch opt.(type) {
+		case resetRetriedOpt:
+			resetRetried = true
+		default:
+			// ignore unexpected option
+		}
+	}
+	i.mu.Lock()
+	enc, store := i.encrypter, i.blobStore
+	i.mu.Unlock()
+	var info *TaskInfo
+	keys := i.rdb.Keys()
+	err := i.updateTask(id, func(t *rdb.TaskInfo) error {
+		if t.Key != keys.ScheduledQueue && t.Key != keys.RetryQueue && t.Key != keys.DeadQueue {
+			return i.notUpdatable(t)
+		}
+		loaded, err := loadPayload(store, t.Msg)
+		if err != nil {
+			return err
+		}
+		payload, err := decryptPayload(enc, loaded)
+		if err != nil {
+			return err
+		}
+		updated := make(map[string]interface{}, len(payload)+len(patch))
+		for k, v := range payload {
+			updated[k] = v
+		}
+		for k, v := range patch {
+			if v == nil {
+				delete(updated, k)
+			} else {
+				updated[k] = v
+			}
+		}
+		msg := *t.Msg
+		msg.Payload, msg.EncryptedPayload, msg.PayloadRef = updated, nil, ""
+		if loaded.EncryptedPayload != nil {
+			if msg.EncryptedPayload, err = encryptPayload(enc, updated); err != nil {
+				return err
+			}
+			msg.Payload = nil
+		}
+		if resetRetried {
+			msg.Retried = 0
+		}
+		if err := i.rdb.UpdateTask(t.Key, t.Msg, &msg); err != nil {
+			return err
+		}
+		t.Msg = &msg
+		info = i.taskInfo(t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// RescheduleTask changes the time to process the scheduled or retry task
+// with the given ID. The task keeps its uniqueness lock, if any, and the
+// lock expiration moves with the process time, so that the lock is held for
+// the same time after the task is processed.
+//
+// ErrTaskNotFound is returned if no task has the given ID, and
+// ErrTaskNotUpdatable is returned if the task is not scheduled or retry.
+func (i *Inspector) RescheduleTask(id string, processAt time.Time) error {
+	keys := i.rdb.Keys()
+	return i.updateTask(id, func(t *rdb.TaskInfo) error {
+		if t.Key != keys.ScheduledQueue && t.Key != keys.RetryQueue {
+			return i.notUpdatable(t)
+		}
+		return i.rdb.RescheduleTask(t.Key, t.Msg, processAt)
+	})
+}
+
+// MoveTask moves the enqueued task with the given ID to the end of the
+// specified queue. The uniqueness lock of the task, if any, is moved
+// along with it, so the task stays unique in the new queue.
+//
+// ErrTaskNotFound is returned if no task has the given ID,
+// ErrTaskNotUpdatable is returned if the task is not enqueued, and
+// ErrDuplicateTask is returned if another task holds the uniqueness lock
+// in the specified queue.
+func (i *Inspector) MoveTask(id, qname string) error {
+	qname = strings.ToLower(qname)
+	if qname == "" {
+		return fmt.Errorf("asynq: queue name cannot be empty")
+	}
+	keys := i.rdb.Keys()
+	return i.updateTask(id, func(t *rdb.TaskInfo) error {
+		if keys.State(t.Key) != "enqueued" {
+			return i.notUpdatable(t)
+		}
+		if t.Msg.Queue == qname {
+			return nil
+		}
+		err := i.rdb.MoveTask(t.Msg, qname)
+		if err == rdb.ErrDuplicateTask {
+			return ErrDuplicateTask
+		}
+		return err
+	})
+}
+
+// CancelTask cancels the task with the given ID.
+//
+// Enqueued, scheduled and retry tasks are deleted before they start
+// processing, and their uniqueness locks are released. For in-progress
+// tasks, a cancelation signal is sent to the goroutine processing the task.
+//
+// ErrTaskNotFound is returned if no task has the given ID, and
+// ErrTaskNotUpdatable is returned if the task is dead.
+func (i *Inspector) CancelTask(id string) error {
+	keys := i.rdb.Keys()
+	return i.updateTask(id, func(t *rdb.TaskInfo) error {
+		switch {
+		case t.Key == keys.InProgressQueue:
+			return i.rdb.PublishCancelation(t.Msg.ID.String())
+		case t.Key == keys.DeadQueue:
+			return i.notUpdatable(t)
+		default:
+			return i.rdb.CancelTask(t.Key, t.Msg)
+		}
+	})
+}
+
+// CancelOption specifies the in-progress tasks to cancel and how.
+type CancelOption interface{}
+
+// Internal cancel option representations.
+type (
+	cancelTypeOpt   string
+	cancelQueueOpt  string
+	cancelServerOpt string
+	killCanceledOpt struct{}
+)
+
+// CancelType returns an option to cancel only the tasks whose type matches
+// the pattern. The pattern syntax is the one of path.Match, e.g. "report:*".
+func CancelType(pattern string) CancelOption {
+	return cancelTypeOpt(pattern)
+}
+
+// CancelQueue returns an option to cancel only the tasks in the queues whose
+// name matches the pattern. The pattern syntax is the one of path.Match.
+func CancelQueue(pattern string) CancelOption {
+	return cancelQueueOpt(pattern)
+}
+
+// CancelServer returns an option to cancel only the tasks processed by the
+// server with the given ID.
+func CancelServer(id string) CancelOption {
+	return cancelServerOpt(id)
+}
+
+// KillCanceled returns an option to move the canceled tasks to the dead
+// queue instead of retrying them.
+func KillCanceled() CancelOption {
+	return killCanceledOpt{}
+}
+
+// CancelTasks sends a cancelation signal to the goroutines processing the
+// in-progress tasks given by the options. At least one of CancelType,
+// CancelQueue and CancelServer options is required.
+//
+// Canceled tasks are retried, or moved to the dead queue if KillCanceled
+// option is given. Handler implementation needs to be context aware for
+// cancelation signal to actually cancel the processing.
+func (i *Inspector) CancelTasks(opts ...CancelOption) error {
+	var req base.CancelationRequest
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case cancelTypeOpt:
+			req.Type = string(opt)
+		case cancelQueueOpt:
+			req.Queue = string(opt)
+		case cancelServerOpt:
+			req.ServerID = string(opt)
+		case killCanceledOpt:
+			req.Kill = true
+		default:
+			// ignore unexpected option
+		}
+	}
+	if req.Type == "" && req.Queue == "" && req.ServerID == "" {
+		return fmt.Errorf("asynq: at least one of CancelType, CancelQueue and CancelServer options is required")
+	}
+	for _, pattern := range []string{req.Type, req.Queue} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("asynq: invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return i.rdb.PublishCancelationRequest(&req)
+}
+
+// ListWorkers retrieves information about all active workers.
+func (i *Inspector) ListWorkers() ([]*WorkerInfo, error) {
+	workers, err := i.rdb.ListWorkers()
+	if err != nil {
+		return nil, err
+	}
+	var res []*WorkerInfo
+	for _, w := range workers {
+		payload, redacted := i.payload(w.Payload, w.EncryptedPayload, w.PayloadRef)
+		info := &WorkerInfo{
+			Host:     w.Host,
+			PID:      w.PID,
+			ID:       w.ID,
+			Type:     w.Type,
+			Queue:    w.Queue,
+			Payload:  payload,
+			Started:  w.Started,
+			Redacted: redacted,
+		}
+		if w.Progress != nil {
+			info.Progress = &Progress{
+				Percent: w.Progress.Percent,
+				Message: w.Progress.Message,
+				Updated: w.Progress.Updated,
+			}
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+// ListServers retrieves information about all running servers.
+func (i *Inspector) ListServers() ([]*ServerInfo, error) {
+	servers, err := i.rdb.ListServers()
+	if err != nil {
+		return nil, err
+	}
+	var res []*ServerInfo
+	for _, s := range servers {
+		res = append(res, &ServerInfo{
+			ID:             s.ServerID,
+			Host:           s.Host,
+			PID:            s.PID,
+			Concurrency:    s.Concurrency,
+			Queues:         s.Queues,
+			StrictPriority: s.StrictPriority,
+			Started:        s.Started,
+			Status:         s.Status,
+			ActiveWorkers:  s.ActiveWorkerCount,
+		})
+	}
+	return res, nil
+}
+
+// ErrServerNotFound indicates that no running server has the given ID.
+var ErrServerNotFound = errors.New("asynq: server not found")
+
+// QuietServer signals the server with the given ID to stop pulling new
+// tasks off queues. It has the same effect as sending TSTP signal to
+// the server process.
+//
+// ErrServerNotFound is returned if no running server has the given ID.
+func (i *Inspector) QuietServer(id string) error {
+	return i.control(base.ControlQuiet, id)
+}
+
+// QuietAllServers signals all running servers to stop pulling new tasks
+// off queues.
+func (i *Inspector) QuietAllServers() error {
+	return i.control(base.ControlQuiet, "")
+}
+
+// StopServer signals the server with the given ID to shut down.
+// It has the same effect as sending TERM signal to the server process.
+//
+// ErrServerNotFound is returned if no running server has the given ID.
+func (i *Inspector) StopServer(id string) error {
+	return i.control(base.ControlStop, id)
+}
+
+// StopAllServers signals all running servers to shut down.
+func (i *Inspector) StopAllServers() error {
+	return i.control(base.ControlStop, "")
+}
+
+func (i *Inspector) control(cmd, serverID string) error {
+	if serverID != "" {
+		servers, err := i.rdb.ListServers()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, s := range servers {
+			if s.ServerID == serverID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: id=%q", ErrServerNotFound, serverID)
+		}
+	}
+	return i.rdb.PublishControl(&base.ControlMessage{Command: cmd, ServerID: serverID})
+}
+
+// SetSharedConfig sets the processing config picked up by servers
+// configured with Config.WatchConfig.
+func (i *Inspector) SetSharedConfig(cfg SharedConfig) error {
+	for qname, p := range cfg.Queues {
+		if p < 1 {
+			return fmt.Errorf("asynq: queue %q has non-positive priority %d", qname, p)
+		}
+	}
+	return i.rdb.WriteServerConfig(&base.ServerConfig{
+		Concurrency:    cfg.Concurrency,
+		Queues:         cfg.Queues,
+		StrictPriority: cfg.StrictPriority,
+	})
+}
+
+// GetSharedConfig returns the processing config set by SetSharedConfig.
+// It returns nil if the config is not set.
+func (i *Inspector) GetSharedConfig() (*SharedConfig, error) {
+	cfg, err := i.rdb.ReadServerConfig()
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	return &SharedConfig{
+		Concurrency:    cfg.Concurrency,
+		Queues:         cfg.Queues,
+		StrictPriority: cfg.StrictPriority,
+	}, nil
+}
+
+// ClearSharedConfig deletes the processing config set by SetSharedConfig.
+// Servers watching the config restore their own config.
+func (i *Inspector) ClearSharedConfig() error {
+	return i.rdb.ClearServerConfig()
+}
+
+// QueueFullPolicy is what happens when a task is enqueued to a full queue.
+type QueueFullPolicy string
+
+// Policies applied when a task is enqueued to a full queue.
+const (
+	// QueueFullReject makes the enqueue fail with ErrQueueFull.
+	QueueFullReject QueueFullPolicy = base.QueueFullReject
+
+	// QueueFullDropOldest deletes the oldest tasks in the queue to make room.
+	QueueFullDropOldest QueueFullPolicy = base.QueueFullDropOldest
+
+	// QueueFullBlock makes the enqueue wait until there is room in the queue.
+	// See Client.EnqueueContext.
+	QueueFullBlock QueueFullPolicy = base.QueueFullBlock
+)
+
+// QueueLimit is the maximum size of a queue.
+//
+// The limit is checked when a task is enqueued to be processed immediately.
+// Scheduled and retry tasks, and tasks enqueued with the Inspector,
+// are moved to the queue regardless of the limit.
+type QueueLimit struct {
+	// MaxSize is the maximum number of tasks in the queue.
+	MaxSize int
+
+	// Policy is applied when a task is enqueued to the queue while it's full.
+	// The default is QueueFullReject.
+	Policy QueueFullPolicy
+}
+
+func queueLimit(l *base.QueueLimit) *QueueLimit {
+	if l == nil {
+		return nil
+	}
+	return &QueueLimit{MaxSize: int(l.MaxSize), Policy: QueueFullPolicy(l.Policy)}
+}
+
+// SetQueueLimit sets the limit of the given queue, which is applied by
+// clients enqueueing tasks to the queue.
+func (i *Inspector) SetQueueLimit(qname string, limit QueueLimit) error {
+	if limit.MaxSize < 1 {
+		return fmt.Errorf("asynq: queue %q has non-positive max size %d", qname, limit.MaxSize)
+	}
+	switch limit.Policy {
+	case "":
+		limit.Policy = QueueFullReject
+	case QueueFullReject, QueueFullDropOldest, QueueFullBlock:
+	default:
+		return fmt.Errorf("asynq: unknown queue full policy %q", limit.Policy)
+	}
+	return i.rdb.SetQueueLimit(qname, &base.QueueLimit{
+		MaxSize: int64(limit.MaxSize),
+		Policy:  string(limit.Policy),
+	})
+}
+
+// GetQueueLimits returns the limits set with SetQueueLimit by queue name.
+func (i *Inspector) GetQueueLimits() (map[string]*QueueLimit, error) {
+	limits, err := i.rdb.QueueLimits()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*QueueLimit)
+	for qname, l := range limits {
+		res[qname] = queueLimit(l)
+	}
+	return res, nil
+}
+
+// ClearQueueLimit deletes the limit of the given queue.
+func (i *Inspector) ClearQueueLimit(qname string) error {
+	return i.rdb.ClearQueueLimit(qname)
+}
+
+// TailEvents calls fn for each task event published by servers and clients
+// configured to publish events, until ctx is done.
+//
+// Events published before TailEvents is called are not delivered.
+func (i *Inspector) TailEvents(ctx context.Context, fn func(e *Event)) error {
+	pubsub, err := i.rdb.EventPubSub()
+	if err != nil {
+		return err
+	}
+	defer pubsub.Close()
+	eventCh := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-eventCh:
+			if !ok {
+				return errors.New("asynq: event subscription closed")
+			}
+			var e base.TaskEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				continue // skip malformed events
+			}
+			fn(fromTaskEvent(&e))
+		}
+	}
+}
@@ -0,0 +1,673 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+func TestInspectorListEnqueuedTasksRedactsPayload(t *testing.T) {
+	r := setup(t)
+	enc, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]interface{}{"email": "user@example.com"}
+	encrypted, err := encryptPayload(enc, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.EncryptedPayload = encrypted
+	m2 := h.NewTaskMessage("reindex", map[string]interface{}{"index": "users"})
+
+	tests := []struct {
+		encrypter Encrypter
+		want      []*EnqueuedTask
+	}{
+		{
+			encrypter: nil,
+			want: []*EnqueuedTask{
+				{Task: NewTask(m1.Type, nil), ID: m1.ID.String(), Queue: m1.Queue, Redacted: true},
+				{Task: NewTask(m2.Type, m2.Payload), ID: m2.ID.String(), Queue: m2.Queue},
+			},
+		},
+		{
+			encrypter: enc,
+			want: []*EnqueuedTask{
+				{Task: NewTask(m1.Type, payload), ID: m1.ID.String(), Queue: m1.Queue},
+				{Task: NewTask(m2.Type, m2.Payload), ID: m2.ID.String(), Queue: m2.Queue},
+			},
+		},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2})
+		inspector.SetEncrypter(tc.encrypter)
+
+		got, err := inspector.ListEnqueuedTasks(base.DefaultQueueName)
+		if err != nil {
+			t.Errorf("ListEnqueuedTasks returned error: %v", err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(Payload{})); diff != "" {
+			t.Errorf("ListEnqueuedTasks returned %v, want %v; (-want, +got)\n%s", got, tc.want, diff)
+		}
+	}
+}
+
+func TestInspectorListDeadTasksWithFilter(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("email:send", map[string]interface{}{"user_id": 42})
+	m1.ErrorMsg = "smtp: connection timeout"
+	m2 := h.NewTaskMessage("email:send", map[string]interface{}{"user_id": 7})
+	m2.ErrorMsg = "smtp: connection timeout"
+	m3 := h.NewTaskMessage("reindex", map[string]interface{}{"user_id": 42})
+	m3.ErrorMsg = "index not found"
+	h.FlushDB(t, r)
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{
+		{Msg: m1, Score: float64(now.Add(-2 * time.Hour).Unix())},
+		{Msg: m2, Score: float64(now.Add(-1 * time.Hour).Unix())},
+		{Msg: m3, Score: float64(now.Add(-1 * time.Hour).Unix())},
+	})
+
+	tests := []struct {
+		desc string
+		opts []ListOption
+		want []string
+	}{
+		{
+			desc: "by type and payload",
+			opts: []ListOption{TaskType("email:send"), PayloadEquals("user_id", 42)},
+			want: []string{m1.ID.String()},
+		},
+		{
+			desc: "by error message",
+			opts: []ListOption{ErrorContains("timeout")},
+			want: []string{m1.ID.String(), m2.ID.String()},
+		},
+		{
+			desc: "by time range",
+			opts: []ListOption{TaskType("email:send"), TimeRange(now.Add(-90*time.Minute), time.Time{})},
+			want: []string{m2.ID.String()},
+		},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		got, err := inspector.ListDeadTasks(tc.opts...)
+		if err != nil {
+			t.Errorf("%s: ListDeadTasks returned error: %v", tc.desc, err)
+			continue
+		}
+		var gotIDs []string
+		for _, task := range got {
+			gotIDs = append(gotIDs, task.ID)
+		}
+		if diff := cmp.Diff(tc.want, gotIDs); diff != "" {
+			t.Errorf("%s: ListDeadTasks returned unexpected tasks; (-want, +got)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestInspectorGetTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessageWithQueue("send_email", map[string]interface{}{"to": "user@example.com"}, "critical")
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2.Retried = 3
+	m2.ErrorMsg = "timeout"
+	m3 := h.NewTaskMessage("sync", nil)
+	m3.Retried = m3.Retry
+	m3.ErrorMsg = "not found"
+	h.FlushDB(t, r)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1}, "critical")
+	h.SeedRetryQueue(t, r, []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Minute).Unix())}})
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m3, Score: float64(now.Unix())}})
+
+	tests := []struct {
+		id      string
+		want    *TaskInfo
+		wantErr error
+	}{
+		{
+			id: m1.ID.String(),
+			want: &TaskInfo{
+				Task:     NewTask(m1.Type, m1.Payload),
+				ID:       m1.ID.String(),
+				Queue:    "critical",
+				State:    "enqueued",
+				MaxRetry: m1.Retry,
+			},
+		},
+		{
+			id: m2.ID.String(),
+			want: &TaskInfo{
+				Task:          NewTask(m2.Type, nil),
+				ID:            m2.ID.String(),
+				Queue:         "default",
+				State:         "retry",
+				MaxRetry:      m2.Retry,
+				Retried:       3,
+				ErrorMsg:      "timeout",
+				NextEnqueueAt: time.Unix(now.Add(time.Minute).Unix(), 0),
+			},
+		},
+		{
+			id: m3.ID.String(),
+			want: &TaskInfo{
+				Task:         NewTask(m3.Type, nil),
+				ID:           m3.ID.String(),
+				Queue:        "default",
+				State:        "dead",
+				MaxRetry:     m3.Retry,
+				Retried:      m3.Retry,
+				ErrorMsg:     "not found",
+				LastFailedAt: time.Unix(now.Unix(), 0),
+			},
+		},
+		{
+			id:      "bnogo8gt6toe23vhef0g",
+			wantErr: ErrTaskNotFound,
+		},
+		{
+			id:      "not-an-id",
+			wantErr: ErrTaskNotFound,
+		},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		got, err := inspector.GetTask(tc.id)
+		if err != tc.wantErr {
+			t.Errorf("GetTask(%q) returned error %v, want %v", tc.id, err, tc.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(Payload{})); diff != "" {
+			t.Errorf("GetTask(%q) = %v, want %v; (-want, +got)\n%s", tc.id, got, tc.want, diff)
+		}
+	}
+}
+
+func TestInspectorUpdateTaskPayload(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "bad@", "cc": "admin@example.com"})
+	m1.Retried = m1.Retry
+	m1.ErrorMsg = "invalid address"
+	m2 := h.NewTaskMessage("reindex", map[string]interface{}{"index": "users"})
+	m3 := h.NewTaskMessage("sync", nil)
+	m4 := h.NewTaskMessage("sync", nil)
+
+	tests := []struct {
+		desc     string
+		id       string
+		patch    map[string]interface{}
+		opts     []UpdateOption
+		want     *TaskInfo
+		wantErr  error
+		wantDead []h.ZSetEntry
+	}{
+		{
+			desc:  "dead task with reset retry count",
+			id:    m1.ID.String(),
+			patch: map[string]interface{}{"to": "user@example.com", "cc": nil},
+			opts:  []UpdateOption{ResetRetried()},
+			want: &TaskInfo{
+				Task:         NewTask(m1.Type, map[string]interface{}{"to": "user@example.com"}),
+				ID:           m1.ID.String(),
+				Queue:        "default",
+				State:        "dead",
+				MaxRetry:     m1.Retry,
+				Retried:      0,
+				ErrorMsg:     "invalid address",
+				LastFailedAt: time.Unix(now.Unix(), 0),
+			},
+			wantDead: []h.ZSetEntry{
+				{
+					Msg: &base.TaskMessage{
+						ID:       m1.ID,
+						Type:     m1.Type,
+						Queue:    m1.Queue,
+						Payload:  map[string]interface{}{"to": "user@example.com"},
+						Retry:    m1.Retry,
+						ErrorMsg: m1.ErrorMsg,
+					},
+					Score: float64(now.Unix()),
+				},
+			},
+		},
+		{
+			desc:  "scheduled task",
+			id:    m2.ID.String(),
+			patch: map[string]interface{}{"full": true},
+			want: &TaskInfo{
+				Task:          NewTask(m2.Type, map[string]interface{}{"index": "users", "full": true}),
+				ID:            m2.ID.String(),
+				Queue:         "default",
+				State:         "scheduled",
+				MaxRetry:      m2.Retry,
+				NextEnqueueAt: time.Unix(now.Add(time.Hour).Unix(), 0),
+			},
+			wantDead: []h.ZSetEntry{{Msg: m1, Score: float64(now.Unix())}},
+		},
+		{
+			desc:     "enqueued task",
+			id:       m3.ID.String(),
+			patch:    map[string]interface{}{"user_id": 1},
+			wantErr:  ErrTaskNotUpdatable,
+			wantDead: []h.ZSetEntry{{Msg: m1, Score: float64(now.Unix())}},
+		},
+		{
+			desc:     "in-progress task",
+			id:       m4.ID.String(),
+			patch:    map[string]interface{}{"user_id": 1},
+			wantErr:  ErrTaskNotUpdatable,
+			wantDead: []h.ZSetEntry{{Msg: m1, Score: float64(now.Unix())}},
+		},
+		{
+			desc:     "task not found",
+			id:       "bnogo8gt6toe23vhef0g",
+			patch:    map[string]interface{}{"user_id": 1},
+			wantErr:  ErrTaskNotFound,
+			wantDead: []h.ZSetEntry{{Msg: m1, Score: float64(now.Unix())}},
+		},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m1, Score: float64(now.Unix())}})
+		h.SeedScheduledQueue(t, r, []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}})
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m3})
+		h.SeedInProgressQueue(t, r, []*base.TaskMessage{m4})
+
+		got, err := inspector.UpdateTaskPayload(tc.id, tc.patch, tc.opts...)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: UpdateTaskPayload(%q, %v) returned error %v, want %v", tc.desc, tc.id, tc.patch, err, tc.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(Payload{})); diff != "" {
+			t.Errorf("%s: UpdateTaskPayload(%q, %v) = %v, want %v; (-want, +got)\n%s", tc.desc, tc.id, tc.patch, got, tc.want, diff)
+		}
+		gotDead := h.GetDeadEntries(t, r)
+		if diff := cmp.Diff(tc.wantDead, gotDead, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, base.DeadQueue, diff)
+		}
+	}
+}
+
+func TestInspectorUpdateTaskPayloadEncrypted(t *testing.T) {
+	r := setup(t)
+	enc, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := encryptPayload(enc, map[string]interface{}{"to": "bad@"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.EncryptedPayload = encrypted
+	h.SeedRetryQueue(t, r, []h.ZSetEntry{{Msg: msg, Score: float64(time.Now().Unix())}})
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	patch := map[string]interface{}{"to": "user@example.com"}
+	if _, err := inspector.UpdateTaskPayload(msg.ID.String(), patch); err == nil {
+		t.Errorf("UpdateTaskPayload(%q, %v) without encrypter returned nil error", msg.ID, patch)
+	}
+
+	inspector.SetEncrypter(enc)
+	if _, err := inspector.UpdateTaskPayload(msg.ID.String(), patch); err != nil {
+		t.Fatalf("UpdateTaskPayload(%q, %v) returned error: %v", msg.ID, patch, err)
+	}
+	entries := h.GetRetryEntries(t, r)
+	if len(entries) != 1 || entries[0].Msg.EncryptedPayload == nil || entries[0].Msg.Payload != nil {
+		t.Fatalf("retry queue = %v, want one task with encrypted payload", entries)
+	}
+	got, err := decryptPayload(enc, entries[0].Msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(patch, got); diff != "" {
+		t.Errorf("decrypted payload = %v, want %v; (-want, +got)\n%s", got, patch, diff)
+	}
+}
+
+func TestInspectorRescheduleTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessage("sync", nil)
+
+	tests := []struct {
+		id            string
+		processAt     time.Time
+		wantErr       error
+		wantScheduled []h.ZSetEntry
+		wantRetry     []h.ZSetEntry
+	}{
+		{
+			id:            m1.ID.String(),
+			processAt:     now.Add(24 * time.Hour),
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(24 * time.Hour).Unix())}},
+			wantRetry:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Minute).Unix())}},
+		},
+		{
+			id:            m2.ID.String(),
+			processAt:     now,
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			wantRetry:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Unix())}},
+		},
+		{
+			id:            m3.ID.String(),
+			processAt:     now,
+			wantErr:       ErrTaskNotUpdatable,
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			wantRetry:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Minute).Unix())}},
+		},
+		{
+			id:            "bnogo8gt6toe23vhef0g",
+			processAt:     now,
+			wantErr:       ErrTaskNotFound,
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			wantRetry:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Minute).Unix())}},
+		},
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedScheduledQueue(t, r, []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}})
+		h.SeedRetryQueue(t, r, []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Minute).Unix())}})
+		h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m3, Score: float64(now.Unix())}})
+
+		if err := inspector.RescheduleTask(tc.id, tc.processAt); !errors.Is(err, tc.wantErr) {
+			t.Errorf("RescheduleTask(%q, %v) returned error %v, want %v", tc.id, tc.processAt, err, tc.wantErr)
+			continue
+		}
+		gotScheduled := h.GetScheduledEntries(t, r)
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.ScheduledQueue, diff)
+		}
+		gotRetry := h.GetRetryEntries(t, r)
+		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.RetryQueue, diff)
+		}
+	}
+}
+
+func TestInspectorMoveTask(t *testing.T) {
+	r := setup(t)
+	client := NewClient(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+	defer client.Close()
+	var last *Event
+	client.SetEventHandler(EventHandlerFunc(func(e *Event) { last = e }))
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	h.FlushDB(t, r)
+
+	task := NewTask("send_email", map[string]interface{}{"to": "user@example.com"})
+	if err := client.Enqueue(task, Unique(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	id := last.TaskID
+	// Queue names are case-insensitive like the Queue option.
+	if err := inspector.MoveTask(id, "Critical"); err != nil {
+		t.Fatalf("MoveTask(%q, %q) returned error: %v", id, "Critical", err)
+	}
+	if err := inspector.MoveTask(id, "CRITICAL"); err != nil {
+		t.Fatalf("MoveTask(%q, %q) returned error: %v", id, "CRITICAL", err)
+	}
+	got, err := inspector.GetTask(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Queue != "critical" || got.State != "enqueued" {
+		t.Errorf("GetTask(%q) = queue %q, state %q; want queue %q, state %q", id, got.Queue, got.State, "critical", "enqueued")
+	}
+	if n := r.LLen(base.QueueKey("critical")).Val(); n != 1 {
+		t.Errorf("%q has %d tasks, want 1", base.QueueKey("critical"), n)
+	}
+	// The uniqueness lock moved with the task.
+	if err := client.Enqueue(task, Queue("critical"), Unique(time.Hour)); !errors.Is(err, ErrDuplicateTask) {
+		t.Errorf("Enqueue to critical after the move returned %v, want %v", err, ErrDuplicateTask)
+	}
+	if err := client.Enqueue(task, Unique(time.Hour)); err != nil {
+		t.Errorf("Enqueue to default after the move returned %v, want nil", err)
+	}
+	// A duplicate of the task holds the lock in default now.
+	if err := inspector.MoveTask(id, "default"); !errors.Is(err, ErrDuplicateTask) {
+		t.Errorf("MoveTask(%q, %q) returned %v, want %v", id, "default", err, ErrDuplicateTask)
+	}
+
+	m := h.NewTaskMessage("reindex", nil)
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m, Score: float64(time.Now().Unix())}})
+	if err := inspector.MoveTask(m.ID.String(), "critical"); !errors.Is(err, ErrTaskNotUpdatable) {
+		t.Errorf("MoveTask(%q, %q) for dead task returned %v, want %v", m.ID, "critical", err, ErrTaskNotUpdatable)
+	}
+}
+
+func TestInspectorCancelTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessage("sync", nil)
+	m4 := h.NewTaskMessage("gen_thumbnail", nil)
+	h.FlushDB(t, r)
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+	h.SeedScheduledQueue(t, r, []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}})
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{m3})
+	h.SeedDeadQueue(t, r, []h.ZSetEntry{{Msg: m4, Score: float64(now.Unix())}})
+
+	pubsub := r.Subscribe(base.CancelChannel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(); err != nil {
+		t.Fatal(err)
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	tests := []struct {
+		id      string
+		wantErr error
+	}{
+		{m1.ID.String(), nil},
+		{m2.ID.String(), nil},
+		{m3.ID.String(), nil},
+		{m4.ID.String(), ErrTaskNotUpdatable},
+		{"bnogo8gt6toe23vhef0g", ErrTaskNotFound},
+	}
+	for _, tc := range tests {
+		if err := inspector.CancelTask(tc.id); !errors.Is(err, tc.wantErr) {
+			t.Errorf("CancelTask(%q) returned error %v, want %v", tc.id, err, tc.wantErr)
+		}
+	}
+
+	if got := h.GetEnqueuedMessages(t, r); len(got) != 0 {
+		t.Errorf("enqueued tasks = %v, want none", got)
+	}
+	if got := h.GetScheduledMessages(t, r); len(got) != 0 {
+		t.Errorf("scheduled tasks = %v, want none", got)
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{m3}, h.GetInProgressMessages(t, r)); diff != "" {
+		t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.InProgressQueue, diff)
+	}
+	select {
+	case msg := <-pubsub.Channel():
+		if msg.Payload != m3.ID.String() {
+			t.Errorf("cancelation published for %q, want %q", msg.Payload, m3.ID)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no cancelation published for the in-progress task")
+	}
+}
+
+func TestInspectorCancelTasks(t *testing.T) {
+	r := setup(t)
+	pubsub := r.Subscribe(base.CancelChannel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+
+	tests := []struct {
+		opts []CancelOption
+		want *base.CancelationRequest
+	}{
+		{
+			opts: []CancelOption{CancelType("report:*")},
+			want: &base.CancelationRequest{Type: "report:*"},
+		},
+		{
+			opts: []CancelOption{CancelQueue("low"), CancelServer("server1"), KillCanceled()},
+			want: &base.CancelationRequest{Queue: "low", ServerID: "server1", Kill: true},
+		},
+	}
+	for _, tc := range tests {
+		if err := inspector.CancelTasks(tc.opts...); err != nil {
+			t.Errorf("CancelTasks(%v) returned error: %v", tc.opts, err)
+			continue
+		}
+		select {
+		case msg := <-pubsub.Channel():
+			var got base.CancelationRequest
+			if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, &got); diff != "" {
+				t.Errorf("CancelTasks(%v) published %v, want %v; (-want, +got)\n%s", tc.opts, got, tc.want, diff)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("CancelTasks(%v) published no request", tc.opts)
+		}
+	}
+
+	for _, opts := range [][]CancelOption{nil, {KillCanceled()}, {CancelType("[")}} {
+		if err := inspector.CancelTasks(opts...); err == nil {
+			t.Errorf("CancelTasks(%v) returned nil error, want error", opts)
+		}
+	}
+}
+
+func TestInspectorProcessingStats(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	m2 := h.NewTaskMessageWithQueue("send_email", nil, "critical")
+	h.SeedInProgressQueue(t, r, []*base.TaskMessage{m1, m2})
+	broker := rdb.NewRDB(r)
+	if err := broker.Done(m1); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Retry(m2, time.Now().Add(time.Minute), "timeout"); err != nil {
+		t.Fatal(err)
+	}
+
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	got, err := inspector.TaskTypeHistory("send_email", Day, 3)
+	if err != nil {
+		t.Fatalf("TaskTypeHistory returned error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("TaskTypeHistory returned %d buckets, want 3", len(got))
+	}
+	want := &ProcessingStats{Processed: 2, Failed: 1, Retried: 1, Time: time.Now().UTC().Truncate(24 * time.Hour)}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("TaskTypeHistory returned %+v for today, want %+v; (-want, +got)\n%s", got[0], want, diff)
+	}
+
+	queues, types, err := inspector.CurrentProcessingStats(Day)
+	if err != nil {
+		t.Fatalf("CurrentProcessingStats returned error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]*ProcessingStats{"critical": want}, queues); diff != "" {
+		t.Errorf("CurrentProcessingStats returned unexpected queue stats; (-want, +got)\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]*ProcessingStats{"send_email": want}, types); diff != "" {
+		t.Errorf("CurrentProcessingStats returned unexpected type stats; (-want, +got)\n%s", diff)
+	}
+}
+
+func TestInspectorQueueLimits(t *testing.T) {
+	r := setup(t)
+	inspector := NewInspector(RedisClientOpt{
+		Addr: redisAddr,
+		DB:   redisDB,
+	})
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{h.NewTaskMessage("send_email", nil)}, "critical")
+
+	for _, limit := range []QueueLimit{{MaxSize: 0}, {MaxSize: 10, Policy: "drop_newest"}} {
+		if err := inspector.SetQueueLimit("critical", limit); err == nil {
+			t.Errorf("SetQueueLimit(%q, %+v) returned nil error", "critical", limit)
+		}
+	}
+	if err := inspector.SetQueueLimit("critical", QueueLimit{MaxSize: 10}); err != nil {
+		t.Fatalf("SetQueueLimit returned error: %v", err)
+	}
+	if err := inspector.SetQueueLimit("low", QueueLimit{MaxSize: 5, Policy: QueueFullBlock}); err != nil {
+		t.Fatalf("SetQueueLimit returned error: %v", err)
+	}
+	if err := inspector.ClearQueueLimit("low"); err != nil {
+		t.Fatalf("ClearQueueLimit returned error: %v", err)
+	}
+
+	want := &QueueLimit{MaxSize: 10, Policy: QueueFullReject}
+	got, err := inspector.GetQueueLimits()
+	if err != nil {
+		t.Fatalf("GetQueueLimits returned error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]*QueueLimit{"critical": want}, got); diff != "" {
+		t.Errorf("GetQueueLimits() = %v; (-want,+got)\n%s", got, diff)
+	}
+	stats, err := inspector.CurrentStats()
+	if err != nil {
+		t.Fatalf("CurrentStats returned error: %v", err)
+	}
+	if len(stats.Queues) != 1 || !cmp.Equal(stats.Queues[0].Limit, want) {
+		t.Errorf("CurrentStats().Queues = %v, want queue %q with limit %+v", stats.Queues, "critical", want)
+	}
+}
@@ -8,6 +8,7 @@ package asynqtest
 import (
 	"encoding/json"
 	"sort"
+	"strconv"
 	"testing"
 
 	"github.com/go-redis/redis/v7"
@@ -72,6 +73,9 @@ var SortStringSliceOpt = cmp.Transformer("SortStringSlice", func(in []string) []
 // IgnoreIDOpt is an cmp.Option to ignore ID field in task messages when comparing.
 var IgnoreIDOpt = cmpopts.IgnoreFields(base.TaskMessage{}, "ID")
 
+// IgnoreEnqueuedAtOpt is an cmp.Option to ignore EnqueuedAt field in task messages when comparing.
+var IgnoreEnqueuedAtOpt = cmpopts.IgnoreFields(base.TaskMessage{}, "EnqueuedAt")
+
 // NewTaskMessage returns a new instance of TaskMessage given a task type and payload.
 func NewTaskMessage(taskType string, payload map[string]interface{}) *base.TaskMessage {
 	return &base.TaskMessage{
@@ -186,20 +190,32 @@ func SeedDeadQueue(tb testing.TB, r *redis.Client, entries []ZSetEntry) {
 }
 
 func seedRedisList(tb testing.TB, c *redis.Client, key string, msgs []*base.TaskMessage) {
-	data := MustMarshalSlice(tb, msgs)
-	for _, s := range data {
-		if err := c.LPush(key, s).Err(); err != nil {
+	for _, msg := range msgs {
+		data := MustMarshal(tb, msg)
+		if err := c.LPush(key, data).Err(); err != nil {
 			tb.Fatal(err)
 		}
+		seedTaskIndex(tb, c, msg.ID.String(), key)
 	}
 }
 
 func seedRedisZSet(tb testing.TB, c *redis.Client, key string, items []ZSetEntry) {
 	for _, item := range items {
-		z := &redis.Z{Member: MustMarshal(tb, item.Msg), Score: float64(item.Score)}
+		data := MustMarshal(tb, item.Msg)
+		z := &redis.Z{Member: data, Score: float64(item.Score)}
 		if err := c.ZAdd(key, z).Err(); err != nil {
 			tb.Fatal(err)
 		}
+		seedTaskIndex(tb, c, item.Msg.ID.String(), key+"\n"+strconv.FormatInt(int64(item.Score), 10))
+	}
+}
+
+// seedTaskIndex records the task in the task index the way the rdb
+// package does, i.e. the key holding the task, followed by the score
+// of the task separated by a newline for tasks in a zset.
+func seedTaskIndex(tb testing.TB, c *redis.Client, id, entry string) {
+	if err := c.HSet(base.TaskIndex, id, entry).Err(); err != nil {
+		tb.Fatal(err)
 	}
 }
 
@@ -19,6 +19,11 @@ import (
 // DefaultQueueName is the queue name used if none are specified by user.
 const DefaultQueueName = "default"
 
+// WebhookQueueName is the name of the internal queue of the tasks which
+// deliver webhook events. Every server processes the queue, and the queue
+// cannot be limited.
+const WebhookQueueName = "asynq_webhooks"
+
 // Redis keys
 const (
 	AllServers      = "asynq:servers"                // ZSET
@@ -27,7 +32,11 @@ const (
 	workersPrefix   = "asynq:workers:"               // HASH   - asynq:workers:<host:<pid>:<serverid>
 	processedPrefix = "asynq:processed:"             // STRING - asynq:processed:<yyyy-mm-dd>
 	failurePrefix   = "asynq:failure:"               // STRING - asynq:failure:<yyyy-mm-dd>
+	expiredPrefix   = "asynq:expired:"               // STRING - asynq:expired:<yyyy-mm-dd>
+	statsPrefix     = "asynq:stats:"                 // HASH   - asynq:stats:<granularity>:<bucket>
+	WaitTimesPrefix = "asynq:wait_times:"            // LIST   - asynq:wait_times:<qname>
 	QueuePrefix     = "asynq:queues:"                // LIST   - asynq:queues:<qname>
+	PriorityPrefix  = "asynq:prio:"                  // LIST   - asynq:prio:<priority>:<qname>
 	AllQueues       = "asynq:queues"                 // SET
 	DefaultQueue    = QueuePrefix + DefaultQueueName // LIST
 	ScheduledQueue  = "asynq:scheduled"              // ZSET
@@ -36,31 +45,280 @@ const (
 	InProgressQueue = "asynq:in_progress"            // LIST
 	PausedQueues    = "asynq:paused"                 // SET
 	CancelChannel   = "asynq:cancel"                 // PubSub channel
+	ControlChannel  = "asynq:control"                // PubSub channel
+	EventChannel    = "asynq:events"                 // PubSub channel
+	BlobGarbage     = "asynq:blob_garbage"           // SET
+	ServerConfigKey = "asynq:server_config"          // STRING
+	DeadRetention   = "asynq:dead_retention"         // STRING
+	DeadEvicted     = "asynq:dead_evicted"           // LIST
+	TaskIndex       = "asynq:task_index"             // HASH   - task ID -> key of the list or zset holding the task
+	QueueLimits     = "asynq:queue_limits"           // HASH   - asynq:queues:<qname> -> JSON QueueLimit
 )
 
+// DefaultNamespace is the namespace of the redis keys used if none is specified by user.
+// The key constants above are the keys in the default namespace.
+const DefaultNamespace = "asynq"
+
+// MaxPriority is the highest priority of a task.
+// Tasks of priority zero are the lowest priority tasks.
+const MaxPriority = 9
+
+// Keys holds the redis keys and pub/sub channels used in a namespace.
+//
+// The field names match the key constants of the default namespace,
+// e.g. keys.ScheduledQueue is "<namespace>:scheduled".
+type Keys struct {
+	Namespace string
+
+	AllServers      string
+	AllWorkers      string
+	WaitTimesPrefix string
+	QueuePrefix     string
+	PriorityPrefix  string
+	AllQueues       string
+	DefaultQueue    string
+	ScheduledQueue  string
+	RetryQueue      string
+	DeadQueue       string
+	InProgressQueue string
+	PausedQueues    string
+	CancelChannel   string
+	ControlChannel  string
+	EventChannel    string
+	BlobGarbage     string
+	ServerConfigKey string
+	DeadRetention   string
+	DeadEvicted     string
+	TaskIndex       string
+	QueueLimits     string
+
+	serversPrefix   string
+	workersPrefix   string
+	processedPrefix string
+	failurePrefix   string
+	expiredPrefix   string
+	statsPrefix     string
+}
+
+// DefaultKeys holds the redis keys of the default namespace.
+var DefaultKeys = NewKeys(DefaultNamespace)
+
+// NewKeys returns the redis keys of the given namespace.
+// An empty namespace means the default namespace.
+func NewKeys(ns string) *Keys {
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	p := ns + ":"
+	return &Keys{
+		Namespace:       ns,
+		AllServers:      p + "servers",
+		AllWorkers:      p + "workers",
+		WaitTimesPrefix: p + "wait_times:",
+		QueuePrefix:     p + "queues:",
+		PriorityPrefix:  p + "prio:",
+		AllQueues:       p + "queues",
+		DefaultQueue:    p + "queues:" + DefaultQueueName,
+		ScheduledQueue:  p + "scheduled",
+		RetryQueue:      p + "retry",
+		DeadQueue:       p + "dead",
+		InProgressQueue: p + "in_progress",
+		PausedQueues:    p + "paused",
+		CancelChannel:   p + "cancel",
+		ControlChannel:  p + "control",
+		EventChannel:    p + "events",
+		BlobGarbage:     p + "blob_garbage",
+		ServerConfigKey: p + "server_config",
+		DeadRetention:   p + "dead_retention",
+		DeadEvicted:     p + "dead_evicted",
+		TaskIndex:       p + "task_index",
+		QueueLimits:     p + "queue_limits",
+		serversPrefix:   p + "servers:",
+		workersPrefix:   p + "workers:",
+		processedPrefix: p + "processed:",
+		failurePrefix:   p + "failure:",
+		expiredPrefix:   p + "expired:",
+		statsPrefix:     p + "stats:",
+	}
+}
+
+// QueueKey returns a redis key for the given queue name.
+func (k *Keys) QueueKey(qname string) string {
+	return k.QueuePrefix + strings.ToLower(qname)
+}
+
+// PriorityQueueKey returns a redis key for the tasks of the given priority
+// in the given queue. Tasks of priority zero are kept in the queue key.
+// Keys of the other priorities have their own prefix, so they never
+// collide with the key of a queue.
+func (k *Keys) PriorityQueueKey(qname string, priority int) string {
+	if priority <= 0 {
+		return k.QueueKey(qname)
+	}
+	return fmt.Sprintf("%s%d:%s", k.PriorityPrefix, priority, strings.ToLower(qname))
+}
+
+// PriorityQueueKeys returns the redis keys for the tasks of all priorities
+// in the given queue, from the highest priority to the lowest.
+func (k *Keys) PriorityQueueKeys(qname string) []string {
+	keys := make([]string, 0, MaxPriority+1)
+	for p := MaxPriority; p >= 0; p-- {
+		keys = append(keys, k.PriorityQueueKey(qname, p))
+	}
+	return keys
+}
+
+// WaitTimesKey returns a redis key for the wait times of the tasks
+// recently dequeued from the given queue.
+func (k *Keys) WaitTimesKey(qname string) string {
+	return k.WaitTimesPrefix + strings.ToLower(qname)
+}
+
+// ProcessedKey returns a redis key for processed count for the given day.
+func (k *Keys) ProcessedKey(t time.Time) string {
+	return k.processedPrefix + t.UTC().Format("2006-01-02")
+}
+
+// FailureKey returns a redis key for failure count for the given day.
+func (k *Keys) FailureKey(t time.Time) string {
+	return k.failurePrefix + t.UTC().Format("2006-01-02")
+}
+
+// ExpiredKey returns a redis key for expired count for the given day.
+func (k *Keys) ExpiredKey(t time.Time) string {
+	return k.expiredPrefix + t.UTC().Format("2006-01-02")
+}
+
+// StatsKey returns a redis key for the per-queue and per-task-type
+// processing stats in the time bucket of granularity g containing t.
+func (k *Keys) StatsKey(g Granularity, t time.Time) string {
+	return k.statsPrefix + string(g) + ":" + t.UTC().Truncate(g.Duration()).Format("2006-01-02T15:04")
+}
+
+// ServerInfoKey returns a redis key for process info.
+func (k *Keys) ServerInfoKey(hostname string, pid int, sid string) string {
+	return fmt.Sprintf("%s%s:%d:%s", k.serversPrefix, hostname, pid, sid)
+}
+
+// WorkersKey returns a redis key for the workers given hostname, pid, and server ID.
+func (k *Keys) WorkersKey(hostname string, pid int, sid string) string {
+	return fmt.Sprintf("%s%s:%d:%s", k.workersPrefix, hostname, pid, sid)
+}
+
+// UniqueKey returns a redis key for the uniqueness lock with the given key.
+// Keys of the default namespace are not prefixed to keep the locks
+// compatible with the previous versions.
+func (k *Keys) UniqueKey(key string) string {
+	if key == "" || k.Namespace == DefaultNamespace {
+		return key
+	}
+	return k.Namespace + ":" + key
+}
+
+// State returns the state of the tasks in the given key:
+// "enqueued", "inprogress", "scheduled", "retry" or "dead".
+// It returns an empty string if the key holds no tasks.
+func (k *Keys) State(key string) string {
+	switch {
+	case key == k.InProgressQueue:
+		return "inprogress"
+	case key == k.ScheduledQueue:
+		return "scheduled"
+	case key == k.RetryQueue:
+		return "retry"
+	case key == k.DeadQueue:
+		return "dead"
+	case strings.HasPrefix(key, k.QueuePrefix), strings.HasPrefix(key, k.PriorityPrefix):
+		return "enqueued"
+	default:
+		return ""
+	}
+}
+
 // QueueKey returns a redis key for the given queue name.
 func QueueKey(qname string) string {
-	return QueuePrefix + strings.ToLower(qname)
+	return DefaultKeys.QueueKey(qname)
+}
+
+// PriorityQueueKey returns a redis key for the tasks of the given priority
+// in the given queue. Tasks of priority zero are kept in the queue key.
+func PriorityQueueKey(qname string, priority int) string {
+	return DefaultKeys.PriorityQueueKey(qname, priority)
+}
+
+// PriorityQueueKeys returns the redis keys for the tasks of all priorities
+// in the given queue, from the highest priority to the lowest.
+func PriorityQueueKeys(qname string) []string {
+	return DefaultKeys.PriorityQueueKeys(qname)
+}
+
+// WaitTimesKey returns a redis key for the wait times of the tasks
+// recently dequeued from the given queue.
+func WaitTimesKey(qname string) string {
+	return DefaultKeys.WaitTimesKey(qname)
 }
 
 // ProcessedKey returns a redis key for processed count for the given day.
 func ProcessedKey(t time.Time) string {
-	return processedPrefix + t.UTC().Format("2006-01-02")
+	return DefaultKeys.ProcessedKey(t)
 }
 
 // FailureKey returns a redis key for failure count for the given day.
 func FailureKey(t time.Time) string {
-	return failurePrefix + t.UTC().Format("2006-01-02")
+	return DefaultKeys.FailureKey(t)
+}
+
+// ExpiredKey returns a redis key for expired count for the given day.
+func ExpiredKey(t time.Time) string {
+	return DefaultKeys.ExpiredKey(t)
+}
+
+// Granularity is the size of the time buckets of the per-queue and
+// per-task-type processing stats.
+type Granularity string
+
+// Granularities of the processing stats.
+const (
+	Minute Granularity = "minute"
+	Hour   Granularity = "hour"
+	Day    Granularity = "day"
+)
+
+// Granularities lists all granularities of the processing stats.
+var Granularities = []Granularity{Minute, Hour, Day}
+
+// Duration returns the length of a time bucket of granularity g,
+// or zero if g is not a valid granularity.
+func (g Granularity) Duration() time.Duration {
+	switch g {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	case Day:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// StatsKey returns a redis key for the per-queue and per-task-type
+// processing stats in the time bucket of granularity g containing t.
+//
+// The hash fields are in the format <counter>:queue:<qname> and
+// <counter>:type:<typename>, where counter is one of "processed",
+// "failed" and "retried".
+func StatsKey(g Granularity, t time.Time) string {
+	return DefaultKeys.StatsKey(g, t)
 }
 
 // ServerInfoKey returns a redis key for process info.
 func ServerInfoKey(hostname string, pid int, sid string) string {
-	return fmt.Sprintf("%s%s:%d:%s", serversPrefix, hostname, pid, sid)
+	return DefaultKeys.ServerInfoKey(hostname, pid, sid)
 }
 
 // WorkersKey returns a redis key for the workers given hostname, pid, and server ID.
 func WorkersKey(hostname string, pid int, sid string) string {
-	return fmt.Sprintf("%s%s:%d:%s", workersPrefix, hostname, pid, sid)
+	return DefaultKeys.WorkersKey(hostname, pid, sid)
 }
 
 // TaskMessage is the internal representation of a task with additional metadata fields.
@@ -104,6 +362,54 @@ type TaskMessage struct {
 	//
 	// Empty string indicates that no uniqueness lock was used.
 	UniqueKey string
+
+	// EncryptedPayload holds the encrypted payload data if the client
+	// was configured with an encrypter.
+	//
+	// Payload is nil when EncryptedPayload is set.
+	EncryptedPayload *EncryptedPayload `json:",omitempty"`
+
+	// PayloadRef holds the key of the blob which holds the payload data
+	// if the payload was offloaded to a blob store by the client.
+	//
+	// Payload and EncryptedPayload are nil when PayloadRef is set.
+	PayloadRef string `json:",omitempty"`
+
+	// ExpireAt is the time in Unix seconds after which the task should
+	// not be processed. Expired tasks are moved to the dead queue
+	// instead of being processed.
+	//
+	// Zero means no expiration.
+	ExpireAt int64 `json:",omitempty"`
+
+	// Webhook is the URL to which an event is posted when the task
+	// is processed successfully or moved to the dead queue.
+	//
+	// Empty string indicates no webhook.
+	Webhook string `json:",omitempty"`
+
+	// Priority is the priority of the task in its queue, from zero to MaxPriority.
+	// Tasks of higher priority are processed first, and tasks of the same
+	// priority are processed in the order they were enqueued.
+	Priority int `json:",omitempty"`
+
+	// EnqueuedAt is the time in Unix nanoseconds the task was last added
+	// to its queue, either by the client or by moving it from another set.
+	//
+	// Zero means the time is unknown.
+	//
+	// Note: EnqueuedAt must be the last field, since lua scripts update
+	// it in place at the end of the encoded message.
+	EnqueuedAt int64 `json:",omitempty"`
+}
+
+// EncryptedPayload is an envelope for an encrypted task payload.
+type EncryptedPayload struct {
+	// KeyID identifies the key used to encrypt the data.
+	KeyID string
+
+	// Data holds the encrypted payload.
+	Data []byte
 }
 
 // ServerStatus represents status of a server.
@@ -165,6 +471,104 @@ func (s *ServerStatus) Set(v ServerStatusValue) {
 	s.mu.Unlock()
 }
 
+// Commands which can be sent to servers via ControlChannel.
+const (
+	// ControlQuiet tells the server to stop pulling new tasks off queues.
+	ControlQuiet = "quiet"
+
+	// ControlStop tells the server to shut down.
+	ControlStop = "stop"
+)
+
+// ControlMessage is a command sent to servers via ControlChannel.
+type ControlMessage struct {
+	Command string
+
+	// ServerID is the ID of the server the command is sent to.
+	// Empty string means all servers.
+	ServerID string
+}
+
+// CancelationRequest is a request to cancel in-progress tasks sent to
+// servers via CancelChannel. Messages on the channel are either the ID of
+// the task to cancel or a JSON encoded CancelationRequest.
+type CancelationRequest struct {
+	// Type and Queue are patterns in path.Match syntax matched against the
+	// type and queue of in-progress tasks. Empty pattern matches all tasks.
+	Type  string `json:",omitempty"`
+	Queue string `json:",omitempty"`
+
+	// ServerID is the ID of the server to cancel the tasks of.
+	// Empty string means all servers.
+	ServerID string `json:",omitempty"`
+
+	// Kill tells the servers to move the canceled tasks to the dead queue
+	// instead of retrying them.
+	Kill bool `json:",omitempty"`
+}
+
+// TaskEvent describes a state transition of a task.
+type TaskEvent struct {
+	State    string
+	TaskID   string
+	Type     string
+	Queue    string
+	Error    string        `json:",omitempty"`
+	Duration time.Duration `json:",omitempty"`
+	Time     time.Time
+}
+
+// DeadRetentionConfig specifies which tasks are kept in the dead queue.
+// It's stored in DeadRetention and read by every command which
+// adds tasks to the dead queue.
+type DeadRetentionConfig struct {
+	// MaxTasks is the maximum number of tasks in the dead queue.
+	// Zero means the default limit, negative means no limit.
+	MaxTasks int `json:",omitempty"`
+
+	// MaxAge is the maximum age of tasks in the dead queue in seconds.
+	// Zero means the default limit, negative means no limit.
+	MaxAge int64 `json:",omitempty"`
+
+	// Archive indicates whether tasks evicted from the dead queue
+	// are pushed to DeadEvicted for archival instead of being discarded.
+	Archive bool `json:",omitempty"`
+}
+
+// Policies applied when a task is enqueued to a full queue.
+const (
+	QueueFullReject     = "reject"      // enqueue fails
+	QueueFullDropOldest = "drop_oldest" // oldest tasks are deleted to make room
+	QueueFullBlock      = "block"       // enqueue waits until there is room
+)
+
+// QueueLimit is the maximum size of a queue and the policy applied
+// when a task is enqueued to the queue while it's full.
+// It's stored in QueueLimits and read by the enqueue commands.
+type QueueLimit struct {
+	MaxSize int64
+	Policy  string
+}
+
+// EvictedTask is a task evicted from the dead queue for archival.
+type EvictedTask struct {
+	// DiedAt is the time in Unix seconds the task was moved to the dead queue.
+	DiedAt  int64
+	Message *TaskMessage
+}
+
+// ServerConfig holds the processing config shared by servers
+// which watch ServerConfigKey.
+//
+// Zero values mean the servers should use their own config.
+type ServerConfig struct {
+	Concurrency int            `json:",omitempty"`
+	Queues      map[string]int `json:",omitempty"`
+
+	// StrictPriority is applied only if Queues is set.
+	StrictPriority bool `json:",omitempty"`
+}
+
 // ServerInfo holds information about a running server.
 type ServerInfo struct {
 	Host              string
@@ -187,6 +591,57 @@ type WorkerInfo struct {
 	Queue   string
 	Payload map[string]interface{}
 	Started time.Time
+
+	EncryptedPayload *EncryptedPayload `json:",omitempty"`
+	PayloadRef       string            `json:",omitempty"`
+
+	// Progress is the latest progress reported by the handler, if any.
+	Progress *Progress `json:",omitempty"`
+}
+
+// Progress is the progress of a task reported by its handler.
+type Progress struct {
+	Percent int
+	Message string
+	Updated time.Time
+}
+
+// ProgressReports is a collection that holds the latest progress reported
+// for in-progress tasks.
+//
+// ProgressReports are safe for concurrent use by multiple goroutines.
+type ProgressReports struct {
+	mu      sync.Mutex
+	reports map[string]Progress
+}
+
+// NewProgressReports returns a ProgressReports instance.
+func NewProgressReports() *ProgressReports {
+	return &ProgressReports{
+		reports: make(map[string]Progress),
+	}
+}
+
+// Set records the progress for the task with the given id.
+func (r *ProgressReports) Set(id string, p Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports[id] = p
+}
+
+// Delete deletes the progress for the task with the given id.
+func (r *ProgressReports) Delete(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reports, id)
+}
+
+// Get returns the progress for the task with the given id.
+func (r *ProgressReports) Get(id string) (p Progress, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok = r.reports[id]
+	return p, ok
 }
 
 // Cancelations is a collection that holds cancel functions for all in-progress tasks.
@@ -195,12 +650,18 @@ type WorkerInfo struct {
 type Cancelations struct {
 	mu          sync.Mutex
 	cancelFuncs map[string]context.CancelFunc
+	// tasks holds the messages of the tasks added with AddTask.
+	tasks map[string]*TaskMessage
+	// killed holds the IDs of the tasks canceled to be killed.
+	killed map[string]bool
 }
 
 // NewCancelations returns a Cancelations instance.
 func NewCancelations() *Cancelations {
 	return &Cancelations{
 		cancelFuncs: make(map[string]context.CancelFunc),
+		tasks:       make(map[string]*TaskMessage),
+		killed:      make(map[string]bool),
 	}
 }
 
@@ -211,11 +672,22 @@ func (c *Cancelations) Add(id string, fn context.CancelFunc) {
 	c.cancelFuncs[id] = fn
 }
 
+// AddTask adds a new cancel func for the task to the collection.
+// Unlike Add, the task can be matched by its type and queue with Match.
+func (c *Cancelations) AddTask(msg *TaskMessage, fn context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelFuncs[msg.ID.String()] = fn
+	c.tasks[msg.ID.String()] = msg
+}
+
 // Delete deletes a cancel func from the collection given an id.
 func (c *Cancelations) Delete(id string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	delete(c.cancelFuncs, id)
+	delete(c.tasks, id)
+	delete(c.killed, id)
 }
 
 // Get returns a cancel func given an id.
@@ -237,6 +709,43 @@ func (c *Cancelations) GetAll() []context.CancelFunc {
 	return res
 }
 
+// Match returns the IDs of the tasks added with AddTask for which
+// the given function returns true.
+func (c *Cancelations) Match(fn func(msg *TaskMessage) bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id, msg := range c.tasks {
+		if fn(msg) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Cancel calls the cancel func given an id, and reports whether the id
+// is in the collection. If kill is true, the task is marked to be killed
+// instead of retried; see Killed.
+func (c *Cancelations) Cancel(id string, kill bool) bool {
+	c.mu.Lock()
+	fn, ok := c.cancelFuncs[id]
+	if ok && kill {
+		c.killed[id] = true
+	}
+	c.mu.Unlock()
+	if ok {
+		fn()
+	}
+	return ok
+}
+
+// Killed reports whether the task with the given id was canceled to be killed.
+func (c *Cancelations) Killed(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.killed[id]
+}
+
 // Broker is a message broker that supports operations to manage task queues.
 //
 // See rdb.RDB as a reference implementation.
@@ -256,5 +765,15 @@ type Broker interface {
 	ClearServerState(host string, pid int, serverID string) error
 	CancelationPubSub() (*redis.PubSub, error) // TODO: Need to decouple from redis to support other brokers
 	PublishCancelation(id string) error
+	ControlPubSub() (*redis.PubSub, error) // TODO: Need to decouple from redis to support other brokers
+	PublishControl(msg *ControlMessage) error
+	PublishEvent(e *TaskEvent) error
+	ListBlobGarbage(n int) ([]string, error)
+	RemoveBlobGarbage(refs ...string) error
+	AddBlobGarbage(refs ...string) error
+	WriteDeadRetention(cfg *DeadRetentionConfig, ttl time.Duration) error
+	ClaimEvictedTasks(n int) ([]*EvictedTask, error)
+	ReturnEvictedTasks(tasks []*EvictedTask) error
+	ReadServerConfig() (*ServerConfig, error)
 	Close() error
 }
@@ -6,9 +6,13 @@ package base
 
 import (
 	"context"
+	"sort"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rs/xid"
 )
 
 func TestQueueKey(t *testing.T) {
@@ -27,6 +31,111 @@ func TestQueueKey(t *testing.T) {
 	}
 }
 
+func TestPriorityQueueKey(t *testing.T) {
+	tests := []struct {
+		qname    string
+		priority int
+		want     string
+	}{
+		{"custom", 0, "asynq:queues:custom"},
+		{"custom", 3, "asynq:prio:3:custom"},
+		{"Critical", MaxPriority, "asynq:prio:9:critical"},
+		{"custom:p3", 0, "asynq:queues:custom:p3"},
+	}
+
+	for _, tc := range tests {
+		got := PriorityQueueKey(tc.qname, tc.priority)
+		if got != tc.want {
+			t.Errorf("PriorityQueueKey(%q, %d) = %q, want %q", tc.qname, tc.priority, got, tc.want)
+		}
+	}
+
+	keys := PriorityQueueKeys("custom")
+	if len(keys) != MaxPriority+1 || keys[0] != "asynq:prio:9:custom" || keys[MaxPriority] != "asynq:queues:custom" {
+		t.Errorf("PriorityQueueKeys(%q) = %v, want keys from priority %d to 0", "custom", keys, MaxPriority)
+	}
+}
+
+func TestNewKeys(t *testing.T) {
+	if got := NewKeys(""); got.Namespace != DefaultNamespace {
+		t.Errorf("NewKeys(%q).Namespace = %q, want %q", "", got.Namespace, DefaultNamespace)
+	}
+
+	dk := NewKeys(DefaultNamespace)
+	now := time.Now()
+	defaults := []struct {
+		got, want string
+	}{
+		{dk.AllServers, AllServers},
+		{dk.AllWorkers, AllWorkers},
+		{dk.AllQueues, AllQueues},
+		{dk.DefaultQueue, DefaultQueue},
+		{dk.ScheduledQueue, ScheduledQueue},
+		{dk.RetryQueue, RetryQueue},
+		{dk.DeadQueue, DeadQueue},
+		{dk.InProgressQueue, InProgressQueue},
+		{dk.PausedQueues, PausedQueues},
+		{dk.CancelChannel, CancelChannel},
+		{dk.ControlChannel, ControlChannel},
+		{dk.EventChannel, EventChannel},
+		{dk.BlobGarbage, BlobGarbage},
+		{dk.ServerConfigKey, ServerConfigKey},
+		{dk.DeadRetention, DeadRetention},
+		{dk.DeadEvicted, DeadEvicted},
+		{dk.TaskIndex, TaskIndex},
+		{dk.QueueLimits, QueueLimits},
+		{dk.ProcessedKey(now), "asynq:processed:" + now.UTC().Format("2006-01-02")},
+		{dk.StatsKey(Hour, now), "asynq:stats:hour:" + now.UTC().Truncate(time.Hour).Format("2006-01-02T15:04")},
+		{dk.UniqueKey("send_email:nil:default"), "send_email:nil:default"},
+	}
+	for _, tc := range defaults {
+		if tc.got != tc.want {
+			t.Errorf("key in default namespace = %q, want %q", tc.got, tc.want)
+		}
+	}
+
+	k := NewKeys("myapp")
+	tests := []struct {
+		got, want string
+	}{
+		{k.AllServers, "myapp:servers"},
+		{k.QueueKey("Default"), "myapp:queues:default"},
+		{k.PriorityQueueKey("default", 2), "myapp:prio:2:default"},
+		{k.WaitTimesKey("default"), "myapp:wait_times:default"},
+		{k.ScheduledQueue, "myapp:scheduled"},
+		{k.CancelChannel, "myapp:cancel"},
+		{k.ControlChannel, "myapp:control"},
+		{k.EventChannel, "myapp:events"},
+		{k.TaskIndex, "myapp:task_index"},
+		{k.ProcessedKey(now), "myapp:processed:" + now.UTC().Format("2006-01-02")},
+		{k.ServerInfoKey("localhost", 9876, "abc"), "myapp:servers:localhost:9876:abc"},
+		{k.WorkersKey("localhost", 9876, "abc"), "myapp:workers:localhost:9876:abc"},
+		{k.UniqueKey("send_email:nil:default"), "myapp:send_email:nil:default"},
+		{k.UniqueKey(""), ""},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("key in namespace %q = %q, want %q", k.Namespace, tc.got, tc.want)
+		}
+	}
+
+	states := map[string]string{
+		k.QueueKey("default"):            "enqueued",
+		k.PriorityQueueKey("default", 3): "enqueued",
+		k.InProgressQueue:                "inprogress",
+		k.ScheduledQueue:                 "scheduled",
+		k.RetryQueue:                     "retry",
+		k.DeadQueue:                      "dead",
+		ScheduledQueue:                   "",
+		QueueKey("default"):              "",
+	}
+	for key, want := range states {
+		if got := k.State(key); got != want {
+			t.Errorf("State(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
 func TestProcessedKey(t *testing.T) {
 	tests := []struct {
 		input time.Time
@@ -63,6 +172,24 @@ func TestFailureKey(t *testing.T) {
 	}
 }
 
+func TestExpiredKey(t *testing.T) {
+	tests := []struct {
+		input time.Time
+		want  string
+	}{
+		{time.Date(2019, 11, 14, 10, 30, 1, 1, time.UTC), "asynq:expired:2019-11-14"},
+		{time.Date(2020, 12, 1, 1, 0, 1, 1, time.UTC), "asynq:expired:2020-12-01"},
+		{time.Date(2020, 1, 6, 15, 02, 1, 1, time.UTC), "asynq:expired:2020-01-06"},
+	}
+
+	for _, tc := range tests {
+		got := ExpiredKey(tc.input)
+		if got != tc.want {
+			t.Errorf("ExpiredKey(%v) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
 func TestServerInfoKey(t *testing.T) {
 	tests := []struct {
 		hostname string
@@ -176,3 +303,51 @@ func TestCancelationsConcurrentAccess(t *testing.T) {
 		t.Errorf("(*Cancelations).GetAll() returns %d functions, want 2", len(funcs))
 	}
 }
+
+func TestCancelationsCancel(t *testing.T) {
+	c := NewCancelations()
+	m1 := &TaskMessage{ID: xid.New(), Type: "report:generate", Queue: "default"}
+	m2 := &TaskMessage{ID: xid.New(), Type: "report:generate", Queue: "critical"}
+	m3 := &TaskMessage{ID: xid.New(), Type: "send_email", Queue: "default"}
+	canceled := make(map[string]bool)
+	for _, msg := range []*TaskMessage{m1, m2, m3} {
+		id := msg.ID.String()
+		c.AddTask(msg, func() { canceled[id] = true })
+	}
+
+	got := c.Match(func(msg *TaskMessage) bool { return msg.Type == "report:generate" })
+	sort.Strings(got)
+	want := []string{m1.ID.String(), m2.ID.String()}
+	sort.Strings(want)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*Cancelations).Match returned %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+
+	if !c.Cancel(m1.ID.String(), true) {
+		t.Errorf("(*Cancelations).Cancel(%q, true) = false, want true", m1.ID)
+	}
+	if !c.Cancel(m3.ID.String(), false) {
+		t.Errorf("(*Cancelations).Cancel(%q, false) = false, want true", m3.ID)
+	}
+	if c.Cancel("unknown", true) {
+		t.Errorf("(*Cancelations).Cancel(%q, true) = true, want false", "unknown")
+	}
+	wantCanceled := map[string]bool{m1.ID.String(): true, m3.ID.String(): true}
+	if diff := cmp.Diff(wantCanceled, canceled); diff != "" {
+		t.Errorf("canceled tasks = %v, want %v; (-want,+got)\n%s", canceled, wantCanceled, diff)
+	}
+	if !c.Killed(m1.ID.String()) {
+		t.Errorf("(*Cancelations).Killed(%q) = false, want true", m1.ID)
+	}
+	if c.Killed(m3.ID.String()) {
+		t.Errorf("(*Cancelations).Killed(%q) = true, want false", m3.ID)
+	}
+
+	c.Delete(m1.ID.String())
+	if c.Killed(m1.ID.String()) {
+		t.Errorf("(*Cancelations).Killed(%q) = true after Delete, want false", m1.ID)
+	}
+	if got := c.Match(func(*TaskMessage) bool { return true }); len(got) != 2 {
+		t.Errorf("(*Cancelations).Match returned %d tasks after Delete, want 2", len(got))
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// RawTask is a task message as stored in redis.
+type RawTask struct {
+	// Key is the key of the queue or zset the task is stored in.
+	Key string
+
+	// Data is the encoded task message.
+	Data string
+
+	// Score is the score of the task in the zset.
+	// It's zero for tasks in queues.
+	Score int64
+}
+
+// Number of tasks to read from redis at once when scanning.
+const scanBatchSize = 1000
+
+// QueueKeys returns the keys of all queues.
+func (r *RDB) QueueKeys() ([]string, error) {
+	return r.client.SMembers(r.keys.AllQueues).Result()
+}
+
+// ScanList calls fn with batches of tasks in the list at key,
+// from the head of the list to the tail.
+//
+// Tasks added or removed while scanning may be missed or seen twice.
+func (r *RDB) ScanList(key string, fn func(tasks []*RawTask) error) error {
+	for start := int64(0); ; start += scanBatchSize {
+		data, err := r.client.LRange(key, start, start+scanBatchSize-1).Result()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		var tasks []*RawTask
+		for _, s := range data {
+			tasks = append(tasks, &RawTask{Key: key, Data: s})
+		}
+		if err := fn(tasks); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanZSet calls fn with batches of tasks in the zset at key,
+// in ascending order of score.
+//
+// Tasks added or removed while scanning may be missed or seen twice.
+func (r *RDB) ScanZSet(key string, fn func(tasks []*RawTask) error) error {
+	for start := int64(0); ; start += scanBatchSize {
+		data, err := r.client.ZRangeWithScores(key, start, start+scanBatchSize-1).Result()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		var tasks []*RawTask
+		for _, z := range data {
+			s, ok := z.Member.(string)
+			if !ok {
+				continue // bad data, ignore and continue
+			}
+			tasks = append(tasks, &RawTask{Key: key, Data: s, Score: int64(z.Score)})
+		}
+		if err := fn(tasks); err != nil {
+			return err
+		}
+	}
+}
+
+// ImportTasks writes the tasks to their keys in a transaction.
+//
+// Tasks for a queue are pushed to the tail of the queue in the given order,
+// so that they are processed before the tasks already in the queue.
+// Tasks for a zset are added with their scores.
+func (r *RDB) ImportTasks(tasks []*RawTask) error {
+	ids := make([]string, len(tasks))
+	qkeys := make([]string, len(tasks))
+	for i, t := range tasks {
+		if !r.isZSetKey(t.Key) && r.keys.State(t.Key) != "enqueued" {
+			return fmt.Errorf("cannot import task to %q", t.Key)
+		}
+		var msg struct{ ID, Queue string }
+		if err := json.Unmarshal([]byte(t.Data), &msg); err != nil {
+			return err
+		}
+		ids[i] = msg.ID
+		qkeys[i] = r.keys.QueueKey(msg.Queue)
+	}
+	_, err := r.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for i, t := range tasks {
+			pipe.HSet(r.keys.TaskIndex, ids[i], r.indexEntry(t.Key, t.Score))
+			if r.isZSetKey(t.Key) {
+				pipe.ZAdd(t.Key, &redis.Z{Member: t.Data, Score: float64(t.Score)})
+				continue
+			}
+			pipe.RPush(t.Key, t.Data)
+			pipe.SAdd(r.keys.AllQueues, qkeys[i])
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RDB) isZSetKey(key string) bool {
+	return key == r.keys.ScheduledQueue || key == r.keys.RetryQueue || key == r.keys.DeadQueue
+}
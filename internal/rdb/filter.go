@@ -0,0 +1,300 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Filter specifies which tasks to match in list and bulk operations.
+// Conditions are evaluated in redis. A nil or zero Filter matches all tasks.
+type Filter struct {
+	// Type matches tasks of the type.
+	Type string `json:",omitempty"`
+
+	// Queue matches tasks of the queue.
+	Queue string `json:",omitempty"`
+
+	// Payload matches tasks whose payload has all of the key-value pairs.
+	// Values are compared with the string, number or boolean value of
+	// the payload field.
+	// Encrypted and offloaded payloads never match.
+	Payload map[string]string `json:",omitempty"`
+
+	// ErrorContains matches tasks whose last error message contains the string.
+	ErrorContains string `json:",omitempty"`
+
+	// MinScore and MaxScore match tasks in a zset with the score in the range,
+	// i.e. the time in Unix seconds the task is scheduled to be processed
+	// or the time the task died. Zero means unbounded.
+	// They are ignored for tasks in lists.
+	MinScore int64 `json:"-"`
+	MaxScore int64 `json:"-"`
+}
+
+// conditions returns the encoded task conditions of the filter to pass
+// to matchTask, or an empty string if the filter has no task conditions.
+func (f *Filter) conditions() (string, error) {
+	if f == nil || (f.Type == "" && f.Queue == "" && len(f.Payload) == 0 && f.ErrorContains == "") {
+		return "", nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// scoreRange returns the score range of the filter to pass to ZRANGEBYSCORE.
+func (f *Filter) scoreRange() (min, max string) {
+	min, max = "-inf", "+inf"
+	if f == nil {
+		return min, max
+	}
+	if f.MinScore != 0 {
+		min = strconv.FormatInt(f.MinScore, 10)
+	}
+	if f.MaxScore != 0 {
+		max = strconv.FormatInt(f.MaxScore, 10)
+	}
+	return min, max
+}
+
+// matchTaskFn is a lua snippet which defines a function to report whether
+// the given task message matches the conditions encoded by Filter.conditions.
+// An empty string matches all tasks.
+//
+// matchTask(<task message>, <filter conditions>)
+const matchTaskFn = `
+local function matchTask(msg, conditions)
+	if conditions == "" then
+		return true
+	end
+	local filter = cjson.decode(conditions)
+	local decoded = cjson.decode(msg)
+	if filter["Type"] and decoded["Type"] ~= filter["Type"] then
+		return false
+	end
+	if filter["Queue"] and decoded["Queue"] ~= filter["Queue"] then
+		return false
+	end
+	if filter["ErrorContains"] then
+		local errmsg = decoded["ErrorMsg"]
+		if type(errmsg) ~= "string" or not string.find(errmsg, filter["ErrorContains"], 1, true) then
+			return false
+		end
+	end
+	if filter["Payload"] then
+		local payload = decoded["Payload"]
+		if type(payload) ~= "table" then
+			return false
+		end
+		for k, v in pairs(filter["Payload"]) do
+			local x = payload[k]
+			if type(x) == "number" then
+				if tonumber(v) ~= x then
+					return false
+				end
+			elseif type(x) == "string" or type(x) == "boolean" then
+				if tostring(x) ~= v then
+					return false
+				end
+			else
+				return false
+			end
+		end
+	end
+	return true
+end
+`
+
+// KEYS[1] -> list to search
+// ARGV[1] -> filter conditions
+// ARGV[2] -> start index
+// ARGV[3] -> stop index
+//
+// Returns the number of examined tasks followed by the matching tasks,
+// from the tail of the range to the head.
+var searchListCmd = redis.NewScript(matchTaskFn + `
+local msgs = redis.call("LRANGE", KEYS[1], ARGV[2], ARGV[3])
+local res = {table.getn(msgs)}
+for i = table.getn(msgs), 1, -1 do
+	if matchTask(msgs[i], ARGV[1]) then
+		table.insert(res, msgs[i])
+	end
+end
+return res`)
+
+// KEYS[1] -> zset to search
+// ARGV[1] -> filter conditions
+// ARGV[2] -> min score
+// ARGV[3] -> max score
+// ARGV[4] -> offset
+// ARGV[5] -> number of tasks to examine
+//
+// Returns the number of examined tasks followed by the matching tasks
+// and their scores, in ascending order of score.
+var searchZSetCmd = redis.NewScript(matchTaskFn + `
+local entries = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[2], ARGV[3], "WITHSCORES", "LIMIT", ARGV[4], ARGV[5])
+local res = {table.getn(entries) / 2}
+for i = 1, table.getn(entries), 2 do
+	if matchTask(entries[i], ARGV[1]) then
+		table.insert(res, entries[i])
+		table.insert(res, entries[i+1])
+	end
+end
+return res`)
+
+// searchList returns the tasks in the page of the tasks matching the filter
+// in the list at key, from the tail of the list to the head.
+//
+// The list is examined in batches, so tasks added or removed while searching
+// may be missed or returned twice.
+func (r *RDB) searchList(key string, f *Filter, pgn Pagination) ([]string, error) {
+	return r.searchLists([]string{key}, f, pgn)
+}
+
+// searchLists is like searchList, but searches the lists at keys one after
+// another, as if they were a single list.
+func (r *RDB) searchLists(keys []string, f *Filter, pgn Pagination) ([]string, error) {
+	conds, err := f.conditions()
+	if err != nil {
+		return nil, err
+	}
+	skip := pgn.start()
+	var res []string
+	for _, key := range keys {
+		if len(res) >= pgn.Size {
+			break
+		}
+		data, skipped, err := r.searchListFrom(key, conds, skip, pgn.Size-len(res))
+		if err != nil {
+			return nil, err
+		}
+		skip -= skipped
+		res = append(res, data...)
+	}
+	return res, nil
+}
+
+// searchListFrom returns up to n tasks matching conds in the list at key,
+// from the tail of the list to the head, after skipping the first skip
+// matching tasks. It also returns the number of tasks skipped.
+func (r *RDB) searchListFrom(key, conds string, skip int64, n int) (res []string, skipped int64, err error) {
+	if conds == "" {
+		size, err := r.client.LLen(key).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		if size <= skip {
+			return nil, size, nil
+		}
+		// Note: Because we use LPUSH to redis list, we need to calculate the
+		// correct range and reverse the list to get the tasks with pagination.
+		data, err := r.client.LRange(key, -skip-int64(n), -skip-1).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		reverse(data)
+		return data, skip, nil
+	}
+	for off := int64(0); len(res) < n; off += scanBatchSize {
+		vals, err := searchListCmd.Run(r.client, []string{key},
+			conds, -off-scanBatchSize, -off-1).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		examined, msgs, err := parseSearchResult(vals)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, msg := range msgs {
+			if skipped < skip {
+				skipped++
+				continue
+			}
+			if len(res) < n {
+				res = append(res, msg)
+			}
+		}
+		if examined < scanBatchSize {
+			break
+		}
+	}
+	return res, skipped, nil
+}
+
+// searchZSet returns the tasks in the page of the tasks matching the filter
+// in the zset at key, in ascending order of score.
+//
+// The zset is examined in batches, so tasks added or removed while searching
+// may be missed or returned twice.
+func (r *RDB) searchZSet(key string, f *Filter, pgn Pagination) ([]redis.Z, error) {
+	conds, err := f.conditions()
+	if err != nil {
+		return nil, err
+	}
+	min, max := f.scoreRange()
+	if conds == "" {
+		return r.client.ZRangeByScoreWithScores(key, &redis.ZRangeBy{
+			Min:    min,
+			Max:    max,
+			Offset: pgn.start(),
+			Count:  int64(pgn.Size),
+		}).Result()
+	}
+	skip := pgn.start()
+	var res []redis.Z
+	for off := int64(0); len(res) < pgn.Size; off += scanBatchSize {
+		vals, err := searchZSetCmd.Run(r.client, []string{key},
+			conds, min, max, off, scanBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		examined, data, err := parseSearchResult(vals)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(data); i += 2 {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			score, err := strconv.ParseFloat(data[i+1], 64)
+			if err != nil {
+				continue // bad data, ignore and continue
+			}
+			if len(res) < pgn.Size {
+				res = append(res, redis.Z{Member: data[i], Score: score})
+			}
+		}
+		if examined < scanBatchSize {
+			break
+		}
+	}
+	return res, nil
+}
+
+func parseSearchResult(vals interface{}) (examined int64, data []string, err error) {
+	res, ok := vals.([]interface{})
+	if !ok || len(res) == 0 {
+		return 0, nil, fmt.Errorf("unexpected search result: %v", vals)
+	}
+	if examined, ok = res[0].(int64); !ok {
+		return 0, nil, fmt.Errorf("could not cast %v to int64", res[0])
+	}
+	for _, v := range res[1:] {
+		s, ok := v.(string)
+		if !ok {
+			return 0, nil, fmt.Errorf("could not cast %v to string", v)
+		}
+		data = append(data, s)
+	}
+	return examined, data, nil
+}
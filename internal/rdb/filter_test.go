@@ -0,0 +1,260 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/rs/xid"
+)
+
+func TestListDeadWithFilter(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("email:send", map[string]interface{}{"user_id": 42, "plan": "pro"})
+	m1.ErrorMsg = "smtp: connection timeout"
+	m2 := h.NewTaskMessage("email:send", map[string]interface{}{"user_id": 7, "plan": "free"})
+	m2.ErrorMsg = "smtp: mailbox not found"
+	m3 := h.NewTaskMessageWithQueue("reindex", map[string]interface{}{"user_id": "42", "full": true}, "low")
+	m3.ErrorMsg = "timeout"
+	m4 := h.NewTaskMessage("email:send", nil)
+	m4.ErrorMsg = "timeout"
+	dead := []h.ZSetEntry{
+		{Msg: m1, Score: float64(now.Add(-4 * time.Hour).Unix())},
+		{Msg: m2, Score: float64(now.Add(-3 * time.Hour).Unix())},
+		{Msg: m3, Score: float64(now.Add(-2 * time.Hour).Unix())},
+		{Msg: m4, Score: float64(now.Add(-1 * time.Hour).Unix())},
+	}
+
+	tests := []struct {
+		desc   string
+		filter *Filter
+		pgn    Pagination
+		want   []xid.ID
+	}{
+		{
+			desc:   "nil filter",
+			filter: nil,
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m1.ID, m2.ID, m3.ID, m4.ID},
+		},
+		{
+			desc:   "by type",
+			filter: &Filter{Type: "email:send"},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m1.ID, m2.ID, m4.ID},
+		},
+		{
+			desc:   "by queue",
+			filter: &Filter{Queue: "low"},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m3.ID},
+		},
+		{
+			desc:   "by number and string payload values",
+			filter: &Filter{Payload: map[string]string{"user_id": "42"}},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m1.ID, m3.ID},
+		},
+		{
+			desc:   "by boolean payload value",
+			filter: &Filter{Payload: map[string]string{"full": "true"}},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m3.ID},
+		},
+		{
+			desc:   "by multiple payload values",
+			filter: &Filter{Payload: map[string]string{"user_id": "42", "plan": "pro"}},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m1.ID},
+		},
+		{
+			desc:   "by error message",
+			filter: &Filter{ErrorContains: "timeout"},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m1.ID, m3.ID, m4.ID},
+		},
+		{
+			desc:   "by time range",
+			filter: &Filter{MinScore: now.Add(-3 * time.Hour).Unix(), MaxScore: now.Add(-2 * time.Hour).Unix()},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   []xid.ID{m2.ID, m3.ID},
+		},
+		{
+			desc:   "with pagination",
+			filter: &Filter{ErrorContains: "timeout"},
+			pgn:    Pagination{Size: 2, Page: 1},
+			want:   []xid.ID{m4.ID},
+		},
+		{
+			desc:   "no match",
+			filter: &Filter{Type: "email:send", ErrorContains: "panic"},
+			pgn:    Pagination{Size: 20, Page: 0},
+			want:   nil,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedDeadQueue(t, r.client, dead)
+
+		got, err := r.ListDead(tc.pgn, tc.filter)
+		if err != nil {
+			t.Errorf("%s: r.ListDead returned error: %v", tc.desc, err)
+			continue
+		}
+		var gotIDs []xid.ID
+		for _, task := range got {
+			gotIDs = append(gotIDs, task.ID)
+		}
+		if diff := cmp.Diff(tc.want, gotIDs); diff != "" {
+			t.Errorf("%s: r.ListDead returned unexpected tasks; (-want,+got)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestListEnqueuedWithFilter(t *testing.T) {
+	r := setup(t)
+	// Seed more tasks than a search batch to search across batches.
+	var msgs, matches []*base.TaskMessage
+	for i := 0; i < 2500; i++ {
+		msg := h.NewTaskMessage("reindex", nil)
+		if i%500 == 0 {
+			msg = h.NewTaskMessage("email:send", map[string]interface{}{"seq": i})
+			matches = append(matches, msg)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	tests := []struct {
+		desc string
+		pgn  Pagination
+		want []*base.TaskMessage
+	}{
+		{
+			desc: "first page",
+			pgn:  Pagination{Size: 3, Page: 0},
+			want: matches[:3],
+		},
+		{
+			desc: "second page",
+			pgn:  Pagination{Size: 3, Page: 1},
+			want: matches[3:],
+		},
+		{
+			desc: "beyond last page",
+			pgn:  Pagination{Size: 3, Page: 2},
+			want: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		// Tasks are pushed to the head of the queue, so the first task
+		// in msgs is processed first.
+		for _, msg := range msgs {
+			if err := r.Enqueue(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := r.ListEnqueued(base.DefaultQueueName, tc.pgn, &Filter{Type: "email:send"})
+		if err != nil {
+			t.Errorf("%s: r.ListEnqueued returned error: %v", tc.desc, err)
+			continue
+		}
+		var gotIDs, wantIDs []string
+		for _, task := range got {
+			gotIDs = append(gotIDs, task.ID.String())
+		}
+		for _, msg := range tc.want {
+			wantIDs = append(wantIDs, msg.ID.String())
+		}
+		if diff := cmp.Diff(wantIDs, gotIDs); diff != "" {
+			t.Errorf("%s: r.ListEnqueued returned unexpected tasks; (-want,+got)\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestBulkOperationsWithFilter(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("email:send", map[string]interface{}{"user_id": "42"})
+	m2 := h.NewTaskMessage("email:send", map[string]interface{}{"user_id": "7"})
+	m3 := h.NewTaskMessage("reindex", map[string]interface{}{"user_id": "42"})
+	entries := []h.ZSetEntry{
+		{Msg: m1, Score: float64(now.Unix())},
+		{Msg: m2, Score: float64(now.Unix())},
+		{Msg: m3, Score: float64(now.Unix())},
+	}
+	filter := &Filter{Type: "email:send", Payload: map[string]string{"user_id": "42"}}
+
+	tests := []struct {
+		desc string
+		seed func()
+		op   func() (int64, error)
+		want map[string][]*base.TaskMessage
+	}{
+		{
+			desc: "EnqueueAllDeadTasks",
+			seed: func() { h.SeedDeadQueue(t, r.client, entries) },
+			op:   func() (int64, error) { return r.EnqueueAllDeadTasks(filter) },
+			want: map[string][]*base.TaskMessage{
+				base.DeadQueue:    {m2, m3},
+				base.DefaultQueue: {m1},
+			},
+		},
+		{
+			desc: "KillAllRetryTasks",
+			seed: func() { h.SeedRetryQueue(t, r.client, entries) },
+			op:   func() (int64, error) { return r.KillAllRetryTasks(filter) },
+			want: map[string][]*base.TaskMessage{
+				base.RetryQueue: {m2, m3},
+				base.DeadQueue:  {m1},
+			},
+		},
+		{
+			desc: "DeleteAllScheduledTasks",
+			seed: func() { h.SeedScheduledQueue(t, r.client, entries) },
+			op:   func() (int64, error) { return r.DeleteAllScheduledTasks(filter) },
+			want: map[string][]*base.TaskMessage{
+				base.ScheduledQueue: {m2, m3},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		tc.seed()
+
+		n, err := tc.op()
+		if err != nil || n != 1 {
+			t.Errorf("%s: got %d, %v; want 1, nil", tc.desc, n, err)
+			continue
+		}
+		for key, want := range tc.want {
+			var got []*base.TaskMessage
+			switch key {
+			case base.DefaultQueue:
+				got = h.GetEnqueuedMessages(t, r.client)
+			case base.ScheduledQueue:
+				got = h.GetScheduledMessages(t, r.client)
+			case base.RetryQueue:
+				got = h.GetRetryMessages(t, r.client)
+			case base.DeadQueue:
+				got = h.GetDeadMessages(t, r.client)
+			default:
+				t.Fatalf("unexpected key %q", key)
+			}
+			if diff := cmp.Diff(want, got, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
+				t.Errorf("%s: mismatch found in %q; (-want,+got)\n%s", tc.desc, key, diff)
+			}
+		}
+	}
+}
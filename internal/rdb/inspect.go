@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,6 +27,7 @@ type Stats struct {
 	Dead       int
 	Processed  int
 	Failed     int
+	Expired    int
 	Queues     []*Queue
 	Timestamp  time.Time
 }
@@ -42,6 +44,21 @@ type Queue struct {
 
 	// Size is the number of tasks in the queue.
 	Size int
+
+	// OldestPendingAge is how long the oldest task in the queue, i.e. the
+	// next task to be processed, has been waiting.
+	// Zero if the queue is empty or the enqueue time of the task is unknown.
+	OldestPendingAge time.Duration
+
+	// WaitP50 and WaitP99 are the 50th and 99th percentile of the time
+	// the recently dequeued tasks waited in the queue.
+	// Zero if no tasks have been dequeued.
+	WaitP50 time.Duration
+	WaitP99 time.Duration
+
+	// Limit is the maximum size of the queue and the policy applied when
+	// the queue is full. Nil if the queue has no limit.
+	Limit *base.QueueLimit
 }
 
 // DailyStats holds aggregate data for a given day.
@@ -51,12 +68,32 @@ type DailyStats struct {
 	Time      time.Time
 }
 
+// ProcessingStats holds the number of tasks of a queue or a task type
+// processed, failed and retried in a time bucket.
+type ProcessingStats struct {
+	Processed int
+	Failed    int
+	Retried   int
+
+	// Time is the start of the time bucket.
+	Time time.Time
+}
+
 // EnqueuedTask is a task in a queue and is ready to be processed.
 type EnqueuedTask struct {
 	ID      xid.ID
 	Type    string
 	Payload map[string]interface{}
 	Queue   string
+
+	// EncryptedPayload is set if the payload was encrypted by the client.
+	EncryptedPayload *base.EncryptedPayload
+
+	// PayloadRef is set if the payload was offloaded to a blob store by the client.
+	PayloadRef string
+
+	// Priority is the priority of the task in the queue.
+	Priority int
 }
 
 // InProgressTask is a task that's currently being processed.
@@ -64,6 +101,12 @@ type InProgressTask struct {
 	ID      xid.ID
 	Type    string
 	Payload map[string]interface{}
+
+	// EncryptedPayload is set if the payload was encrypted by the client.
+	EncryptedPayload *base.EncryptedPayload
+
+	// PayloadRef is set if the payload was offloaded to a blob store by the client.
+	PayloadRef string
 }
 
 // ScheduledTask is a task that's scheduled to be processed in the future.
@@ -74,6 +117,12 @@ type ScheduledTask struct {
 	ProcessAt time.Time
 	Score     int64
 	Queue     string
+
+	// EncryptedPayload is set if the payload was encrypted by the client.
+	EncryptedPayload *base.EncryptedPayload
+
+	// PayloadRef is set if the payload was offloaded to a blob store by the client.
+	PayloadRef string
 }
 
 // RetryTask is a task that's in retry queue because worker failed to process the task.
@@ -88,6 +137,12 @@ type RetryTask struct {
 	Retry     int
 	Score     int64
 	Queue     string
+
+	// EncryptedPayload is set if the payload was encrypted by the client.
+	EncryptedPayload *base.EncryptedPayload
+
+	// PayloadRef is set if the payload was offloaded to a blob store by the client.
+	PayloadRef string
 }
 
 // DeadTask is a task in that has exhausted all retries.
@@ -99,6 +154,12 @@ type DeadTask struct {
 	ErrorMsg     string
 	Score        int64
 	Queue        string
+
+	// EncryptedPayload is set if the payload was encrypted by the client.
+	EncryptedPayload *base.EncryptedPayload
+
+	// PayloadRef is set if the payload was offloaded to a blob store by the client.
+	PayloadRef string
 }
 
 // KEYS[1] -> asynq:queues
@@ -108,12 +169,17 @@ type DeadTask struct {
 // KEYS[5] -> asynq:dead
 // KEYS[6] -> asynq:processed:<yyyy-mm-dd>
 // KEYS[7] -> asynq:failure:<yyyy-mm-dd>
-var currentStatsCmd = redis.NewScript(`
+// KEYS[8] -> asynq:expired:<yyyy-mm-dd>
+var currentStatsCmd = redis.NewScript(queueKeysFn + `
 local res = {}
 local queues = redis.call("SMEMBERS", KEYS[1])
 for _, qkey in ipairs(queues) do
+	local n = 0
+	for _, key in ipairs(queueKeys(qkey)) do
+		n = n + redis.call("LLEN", key)
+	end
 	table.insert(res, qkey)
-	table.insert(res, redis.call("LLEN", qkey))
+	table.insert(res, n)
 end
 table.insert(res, KEYS[2])
 table.insert(res, redis.call("LLEN", KEYS[2]))
@@ -137,19 +203,27 @@ if f then
 end
 table.insert(res, "failed")
 table.insert(res, fcount)
+local ecount = 0
+local e = redis.call("GET", KEYS[8])
+if e then
+	ecount = tonumber(e)
+end
+table.insert(res, "expired")
+table.insert(res, ecount)
 return res`)
 
 // CurrentStats returns a current state of the queues.
 func (r *RDB) CurrentStats() (*Stats, error) {
 	now := time.Now()
 	res, err := currentStatsCmd.Run(r.client, []string{
-		base.AllQueues,
-		base.InProgressQueue,
-		base.ScheduledQueue,
-		base.RetryQueue,
-		base.DeadQueue,
-		base.ProcessedKey(now),
-		base.FailureKey(now),
+		r.keys.AllQueues,
+		r.keys.InProgressQueue,
+		r.keys.ScheduledQueue,
+		r.keys.RetryQueue,
+		r.keys.DeadQueue,
+		r.keys.ProcessedKey(now),
+		r.keys.FailureKey(now),
+		r.keys.ExpiredKey(now),
 	}).Result()
 	if err != nil {
 		return nil, err
@@ -158,7 +232,11 @@ func (r *RDB) CurrentStats() (*Stats, error) {
 	if err != nil {
 		return nil, err
 	}
-	paused, err := r.client.SMembersMap(base.PausedQueues).Result()
+	paused, err := r.client.SMembersMap(r.keys.PausedQueues).Result()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := r.QueueLimits()
 	if err != nil {
 		return nil, err
 	}
@@ -171,36 +249,107 @@ func (r *RDB) CurrentStats() (*Stats, error) {
 		val := cast.ToInt(data[i+1])
 
 		switch {
-		case strings.HasPrefix(key, base.QueuePrefix):
+		case strings.HasPrefix(key, r.keys.QueuePrefix):
 			stats.Enqueued += val
 			q := Queue{
-				Name: strings.TrimPrefix(key, base.QueuePrefix),
+				Name: strings.TrimPrefix(key, r.keys.QueuePrefix),
 				Size: val,
 			}
 			if _, exist := paused[key]; exist {
 				q.Paused = true
 			}
+			q.Limit = limits[q.Name]
 			stats.Queues = append(stats.Queues, &q)
-		case key == base.InProgressQueue:
+		case key == r.keys.InProgressQueue:
 			stats.InProgress = val
-		case key == base.ScheduledQueue:
+		case key == r.keys.ScheduledQueue:
 			stats.Scheduled = val
-		case key == base.RetryQueue:
+		case key == r.keys.RetryQueue:
 			stats.Retry = val
-		case key == base.DeadQueue:
+		case key == r.keys.DeadQueue:
 			stats.Dead = val
 		case key == "processed":
 			stats.Processed = val
 		case key == "failed":
 			stats.Failed = val
+		case key == "expired":
+			stats.Expired = val
 		}
 	}
 	sort.Slice(stats.Queues, func(i, j int) bool {
 		return stats.Queues[i].Name < stats.Queues[j].Name
 	})
+	if err := r.queueLatency(stats.Queues, now); err != nil {
+		return nil, err
+	}
 	return stats, nil
 }
 
+// queueLatency sets the oldest pending task age and the wait time
+// percentiles of the queues.
+func (r *RDB) queueLatency(queues []*Queue, now time.Time) error {
+	if len(queues) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	oldest := make([][]*redis.StringCmd, len(queues))
+	waits := make([]*redis.StringSliceCmd, len(queues))
+	for i, q := range queues {
+		for _, key := range r.keys.PriorityQueueKeys(q.Name) {
+			oldest[i] = append(oldest[i], pipe.LIndex(key, -1))
+		}
+		waits[i] = pipe.LRange(r.keys.WaitTimesKey(q.Name), 0, -1)
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return err
+	}
+	for i, q := range queues {
+		// The oldest task of each priority is at the head of its list.
+		for _, cmd := range oldest[i] {
+			data, err := cmd.Result()
+			if err != nil {
+				continue
+			}
+			var msg base.TaskMessage
+			if err := json.Unmarshal([]byte(data), &msg); err == nil && msg.EnqueuedAt > 0 {
+				if age := now.Sub(time.Unix(0, msg.EnqueuedAt)); age > q.OldestPendingAge {
+					q.OldestPendingAge = age
+				}
+			}
+		}
+		vals, err := waits[i].Result()
+		if err != nil {
+			return err
+		}
+		var samples []time.Duration
+		for _, v := range vals {
+			us, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue // bad data, ignore and continue
+			}
+			samples = append(samples, time.Duration(us)*time.Microsecond)
+		}
+		q.WaitP50 = percentile(samples, 50)
+		q.WaitP99 = percentile(samples, 99)
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile of the samples using the
+// nearest-rank method, or zero if there are no samples.
+// It sorts the samples in place.
+func percentile(samples []time.Duration, p int) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	rank := (p*len(samples) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return samples[rank-1]
+}
+
 var historicalStatsCmd = redis.NewScript(`
 local res = {}
 for _, key in ipairs(KEYS) do
@@ -224,8 +373,8 @@ func (r *RDB) HistoricalStats(n int) ([]*DailyStats, error) {
 	for i := 0; i < n; i++ {
 		ts := now.Add(-time.Duration(i) * day)
 		days = append(days, ts)
-		keys = append(keys, base.ProcessedKey(ts))
-		keys = append(keys, base.FailureKey(ts))
+		keys = append(keys, r.keys.ProcessedKey(ts))
+		keys = append(keys, r.keys.FailureKey(ts))
 	}
 	res, err := historicalStatsCmd.Run(r.client, keys, len(keys)).Result()
 	if err != nil {
@@ -246,6 +395,98 @@ func (r *RDB) HistoricalStats(n int) ([]*DailyStats, error) {
 	return stats, nil
 }
 
+// QueueHistory returns the processing stats of the queue in the last n
+// time buckets of granularity g, starting from the current bucket.
+func (r *RDB) QueueHistory(qname string, g base.Granularity, n int) ([]*ProcessingStats, error) {
+	return r.processingHistory("queue:"+qname, g, n)
+}
+
+// TypeHistory returns the processing stats of the task type in the last n
+// time buckets of granularity g, starting from the current bucket.
+func (r *RDB) TypeHistory(typename string, g base.Granularity, n int) ([]*ProcessingStats, error) {
+	return r.processingHistory("type:"+typename, g, n)
+}
+
+func (r *RDB) processingHistory(field string, g base.Granularity, n int) ([]*ProcessingStats, error) {
+	d := g.Duration()
+	if d == 0 {
+		return nil, fmt.Errorf("unknown granularity %q", g)
+	}
+	if n < 1 {
+		return []*ProcessingStats{}, nil
+	}
+	now := time.Now().UTC().Truncate(d)
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, n)
+	for i := 0; i < n; i++ {
+		key := r.keys.StatsKey(g, now.Add(-time.Duration(i)*d))
+		cmds[i] = pipe.HMGet(key, "processed:"+field, "failed:"+field, "retried:"+field)
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	var stats []*ProcessingStats
+	for i, cmd := range cmds {
+		vals, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &ProcessingStats{
+			Processed: cast.ToInt(vals[0]),
+			Failed:    cast.ToInt(vals[1]),
+			Retried:   cast.ToInt(vals[2]),
+			Time:      now.Add(-time.Duration(i) * d),
+		})
+	}
+	return stats, nil
+}
+
+// CurrentProcessingStats returns the processing stats of each queue and
+// each task type in the current time bucket of granularity g.
+func (r *RDB) CurrentProcessingStats(g base.Granularity) (queues, types map[string]*ProcessingStats, err error) {
+	d := g.Duration()
+	if d == 0 {
+		return nil, nil, fmt.Errorf("unknown granularity %q", g)
+	}
+	now := time.Now().UTC().Truncate(d)
+	data, err := r.client.HGetAll(r.keys.StatsKey(g, now)).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	queues = make(map[string]*ProcessingStats)
+	types = make(map[string]*ProcessingStats)
+	for field, val := range data {
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) != 3 {
+			continue // bad data, ignore and continue
+		}
+		var m map[string]*ProcessingStats
+		switch parts[1] {
+		case "queue":
+			m = queues
+		case "type":
+			m = types
+		default:
+			continue
+		}
+		s, ok := m[parts[2]]
+		if !ok {
+			s = &ProcessingStats{Time: now}
+			m[parts[2]] = s
+		}
+		n := cast.ToInt(val)
+		switch parts[0] {
+		case "processed":
+			s.Processed = n
+		case "failed":
+			s.Failed = n
+		case "retried":
+			s.Retried = n
+		}
+	}
+	return queues, types, nil
+}
+
 // RedisInfo returns a map of redis info.
 func (r *RDB) RedisInfo() (map[string]string, error) {
 	res, err := r.client.Info().Result()
@@ -288,21 +529,18 @@ func (p Pagination) stop() int64 {
 	return int64(p.Size*p.Page + p.Size - 1)
 }
 
-// ListEnqueued returns enqueued tasks that are ready to be processed.
-func (r *RDB) ListEnqueued(qname string, pgn Pagination) ([]*EnqueuedTask, error) {
-	qkey := base.QueueKey(qname)
-	if !r.client.SIsMember(base.AllQueues, qkey).Val() {
+// ListEnqueued returns enqueued tasks that are ready to be processed,
+// in the order they are processed.
+// Only the tasks matching f are returned if f is not nil.
+func (r *RDB) ListEnqueued(qname string, pgn Pagination, f *Filter) ([]*EnqueuedTask, error) {
+	qkey := r.keys.QueueKey(qname)
+	if !r.client.SIsMember(r.keys.AllQueues, qkey).Val() {
 		return nil, fmt.Errorf("queue %q does not exist", qname)
 	}
-	// Note: Because we use LPUSH to redis list, we need to calculate the
-	// correct range and reverse the list to get the tasks with pagination.
-	stop := -pgn.start() - 1
-	start := -pgn.stop() - 1
-	data, err := r.client.LRange(qkey, start, stop).Result()
+	data, err := r.searchLists(r.keys.PriorityQueueKeys(qname), f, pgn)
 	if err != nil {
 		return nil, err
 	}
-	reverse(data)
 	var tasks []*EnqueuedTask
 	for _, s := range data {
 		var msg base.TaskMessage
@@ -311,26 +549,25 @@ func (r *RDB) ListEnqueued(qname string, pgn Pagination) ([]*EnqueuedTask, error
 			continue // bad data, ignore and continue
 		}
 		tasks = append(tasks, &EnqueuedTask{
-			ID:      msg.ID,
-			Type:    msg.Type,
-			Payload: msg.Payload,
-			Queue:   msg.Queue,
+			ID:               msg.ID,
+			Type:             msg.Type,
+			Payload:          msg.Payload,
+			EncryptedPayload: msg.EncryptedPayload,
+			PayloadRef:       msg.PayloadRef,
+			Queue:            msg.Queue,
+			Priority:         msg.Priority,
 		})
 	}
 	return tasks, nil
 }
 
 // ListInProgress returns all tasks that are currently being processed.
-func (r *RDB) ListInProgress(pgn Pagination) ([]*InProgressTask, error) {
-	// Note: Because we use LPUSH to redis list, we need to calculate the
-	// correct range and reverse the list to get the tasks with pagination.
-	stop := -pgn.start() - 1
-	start := -pgn.stop() - 1
-	data, err := r.client.LRange(base.InProgressQueue, start, stop).Result()
+// Only the tasks matching f are returned if f is not nil.
+func (r *RDB) ListInProgress(pgn Pagination, f *Filter) ([]*InProgressTask, error) {
+	data, err := r.searchList(r.keys.InProgressQueue, f, pgn)
 	if err != nil {
 		return nil, err
 	}
-	reverse(data)
 	var tasks []*InProgressTask
 	for _, s := range data {
 		var msg base.TaskMessage
@@ -339,18 +576,20 @@ func (r *RDB) ListInProgress(pgn Pagination) ([]*InProgressTask, error) {
 			continue // bad data, ignore and continue
 		}
 		tasks = append(tasks, &InProgressTask{
-			ID:      msg.ID,
-			Type:    msg.Type,
-			Payload: msg.Payload,
+			ID:               msg.ID,
+			Type:             msg.Type,
+			Payload:          msg.Payload,
+			EncryptedPayload: msg.EncryptedPayload,
+			PayloadRef:       msg.PayloadRef,
 		})
 	}
 	return tasks, nil
 }
 
 // ListScheduled returns all tasks that are scheduled to be processed
-// in the future.
-func (r *RDB) ListScheduled(pgn Pagination) ([]*ScheduledTask, error) {
-	data, err := r.client.ZRangeWithScores(base.ScheduledQueue, pgn.start(), pgn.stop()).Result()
+// in the future. Only the tasks matching f are returned if f is not nil.
+func (r *RDB) ListScheduled(pgn Pagination, f *Filter) ([]*ScheduledTask, error) {
+	data, err := r.searchZSet(r.keys.ScheduledQueue, f, pgn)
 	if err != nil {
 		return nil, err
 	}
@@ -367,21 +606,23 @@ func (r *RDB) ListScheduled(pgn Pagination) ([]*ScheduledTask, error) {
 		}
 		processAt := time.Unix(int64(z.Score), 0)
 		tasks = append(tasks, &ScheduledTask{
-			ID:        msg.ID,
-			Type:      msg.Type,
-			Payload:   msg.Payload,
-			Queue:     msg.Queue,
-			ProcessAt: processAt,
-			Score:     int64(z.Score),
+			ID:               msg.ID,
+			Type:             msg.Type,
+			Payload:          msg.Payload,
+			EncryptedPayload: msg.EncryptedPayload,
+			PayloadRef:       msg.PayloadRef,
+			Queue:            msg.Queue,
+			ProcessAt:        processAt,
+			Score:            int64(z.Score),
 		})
 	}
 	return tasks, nil
 }
 
 // ListRetry returns all tasks that have failed before and willl be retried
-// in the future.
-func (r *RDB) ListRetry(pgn Pagination) ([]*RetryTask, error) {
-	data, err := r.client.ZRangeWithScores(base.RetryQueue, pgn.start(), pgn.stop()).Result()
+// in the future. Only the tasks matching f are returned if f is not nil.
+func (r *RDB) ListRetry(pgn Pagination, f *Filter) ([]*RetryTask, error) {
+	data, err := r.searchZSet(r.keys.RetryQueue, f, pgn)
 	if err != nil {
 		return nil, err
 	}
@@ -398,23 +639,26 @@ func (r *RDB) ListRetry(pgn Pagination) ([]*RetryTask, error) {
 		}
 		processAt := time.Unix(int64(z.Score), 0)
 		tasks = append(tasks, &RetryTask{
-			ID:        msg.ID,
-			Type:      msg.Type,
-			Payload:   msg.Payload,
-			ErrorMsg:  msg.ErrorMsg,
-			Retry:     msg.Retry,
-			Retried:   msg.Retried,
-			Queue:     msg.Queue,
-			ProcessAt: processAt,
-			Score:     int64(z.Score),
+			ID:               msg.ID,
+			Type:             msg.Type,
+			Payload:          msg.Payload,
+			EncryptedPayload: msg.EncryptedPayload,
+			PayloadRef:       msg.PayloadRef,
+			ErrorMsg:         msg.ErrorMsg,
+			Retry:            msg.Retry,
+			Retried:          msg.Retried,
+			Queue:            msg.Queue,
+			ProcessAt:        processAt,
+			Score:            int64(z.Score),
 		})
 	}
 	return tasks, nil
 }
 
 // ListDead returns all tasks that have exhausted its retry limit.
-func (r *RDB) ListDead(pgn Pagination) ([]*DeadTask, error) {
-	data, err := r.client.ZRangeWithScores(base.DeadQueue, pgn.start(), pgn.stop()).Result()
+// Only the tasks matching f are returned if f is not nil.
+func (r *RDB) ListDead(pgn Pagination, f *Filter) ([]*DeadTask, error) {
+	data, err := r.searchZSet(r.keys.DeadQueue, f, pgn)
 	if err != nil {
 		return nil, err
 	}
@@ -431,23 +675,345 @@ func (r *RDB) ListDead(pgn Pagination) ([]*DeadTask, error) {
 		}
 		lastFailedAt := time.Unix(int64(z.Score), 0)
 		tasks = append(tasks, &DeadTask{
-			ID:           msg.ID,
-			Type:         msg.Type,
-			Payload:      msg.Payload,
-			ErrorMsg:     msg.ErrorMsg,
-			Queue:        msg.Queue,
-			LastFailedAt: lastFailedAt,
-			Score:        int64(z.Score),
+			ID:               msg.ID,
+			Type:             msg.Type,
+			Payload:          msg.Payload,
+			EncryptedPayload: msg.EncryptedPayload,
+			PayloadRef:       msg.PayloadRef,
+			ErrorMsg:         msg.ErrorMsg,
+			Queue:            msg.Queue,
+			LastFailedAt:     lastFailedAt,
+			Score:            int64(z.Score),
 		})
 	}
 	return tasks, nil
 }
 
+// TaskInfo describes a task and where it is stored.
+type TaskInfo struct {
+	Msg *base.TaskMessage
+
+	// Key is the key of the list or zset holding the task.
+	Key string
+
+	// Score is the score of the task in the zset.
+	// It's zero for tasks in lists.
+	Score int64
+}
+
+// indexEntry returns the value recorded in asynq:task_index for a task
+// in the given key with the given score. See indexTaskFn.
+func (r *RDB) indexEntry(key string, score int64) string {
+	if r.isZSetKey(key) {
+		return key + "\n" + strconv.FormatInt(score, 10)
+	}
+	return key
+}
+
+// KEYS[1] -> asynq:task_index
+// ARGV[1] -> task ID
+//
+// Returns the key, the task message and the score of the task
+// in the key recorded in the index, or nil if the task is not there.
+// Tasks in a zset are read by their score recorded in the index,
+// and tasks in a list are found by scanning the list.
+var getTaskCmd = redis.NewScript(`
+local entry = redis.call("HGET", KEYS[1], ARGV[1])
+if not entry then
+	return nil
+end
+local function match(msg)
+	return string.find(msg, ARGV[1], 1, true) and cjson.decode(msg)["ID"] == ARGV[1]
+end
+local i = string.find(entry, "\n", 1, true)
+if i then
+	local key, score = string.sub(entry, 1, i-1), string.sub(entry, i+1)
+	for _, msg in ipairs(redis.call("ZRANGEBYSCORE", key, score, score)) do
+		if match(msg) then
+			return {key, msg, score}
+		end
+	end
+	return nil
+end
+for _, msg in ipairs(redis.call("LRANGE", entry, 0, -1)) do
+	if match(msg) then
+		return {entry, msg, "0"}
+	end
+end
+return nil`)
+
+// GetTask finds the task with the given id in any queue or state.
+// It returns ErrTaskNotFound if the task does not exist.
+//
+// GetTask looks up the key holding the task in the task index, so tasks
+// missing from the index, e.g. tasks created before the index existed,
+// are not found.
+func (r *RDB) GetTask(id xid.ID) (*TaskInfo, error) {
+	res, err := getTaskCmd.Run(r.client, []string{r.keys.TaskIndex}, id.String()).Result()
+	if err == redis.Nil {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	vals, err := cast.ToStringSliceE(res)
+	if err != nil || len(vals) != 3 {
+		return nil, fmt.Errorf("unexpected return value from lua script: %v", res)
+	}
+	var msg base.TaskMessage
+	if err := json.Unmarshal([]byte(vals[1]), &msg); err != nil {
+		return nil, err
+	}
+	score, err := strconv.ParseFloat(vals[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskInfo{Msg: &msg, Key: vals[0], Score: int64(score)}, nil
+}
+
+// KEYS[1] -> ZSET holding the task (e.g., dead queue)
+// KEYS[2] -> asynq:blob_garbage
+// ARGV[1] -> task message to replace
+// ARGV[2] -> updated task message
+var updateTaskCmd = redis.NewScript(collectBlobFn + `
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("ZADD", KEYS[1], score, ARGV[2])
+if cjson.decode(ARGV[1])["PayloadRef"] ~= cjson.decode(ARGV[2])["PayloadRef"] then
+	collectBlob(ARGV[1], KEYS[2])
+end
+return 1`)
+
+// UpdateTask replaces the task message old with msg in the scheduled, retry
+// or dead queue given by key, keeping the score of the task.
+// The payload blob of old is marked for garbage collection unless msg
+// refers to the same blob.
+//
+// It returns ErrTaskNotFound if old is no longer in the queue, e.g. because
+// the task was enqueued or changed since it was read.
+func (r *RDB) UpdateTask(key string, old, msg *base.TaskMessage) error {
+	if key != r.keys.ScheduledQueue && key != r.keys.RetryQueue && key != r.keys.DeadQueue {
+		return fmt.Errorf("cannot update a task in %q", key)
+	}
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	res, err := updateTaskCmd.Run(r.client, []string{key, r.keys.BlobGarbage}, oldData, data).Result()
+	if err != nil {
+		return err
+	}
+	return checkTaskUpdated(res)
+}
+
+// KEYS[1] -> ZSET holding the task (e.g., scheduled queue)
+// KEYS[2] -> unique key of the task
+// KEYS[3] -> asynq:task_index
+// ARGV[1] -> task message
+// ARGV[2] -> new score (process_at timestamp)
+// ARGV[3] -> task ID
+//
+// The TTL of the uniqueness lock held by the task is shifted by the change
+// in the score, and the lock is deleted if it would have expired.
+var rescheduleTaskCmd = redis.NewScript(indexTaskFn + `
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+indexTask(KEYS[3], ARGV[3], KEYS[1], ARGV[2])
+if string.len(KEYS[2]) > 0 and redis.call("GET", KEYS[2]) == ARGV[3] then
+	local ttl = redis.call("PTTL", KEYS[2])
+	if ttl > 0 then
+		ttl = ttl + (tonumber(ARGV[2]) - tonumber(score)) * 1000
+		if ttl > 0 then
+			redis.call("PEXPIRE", KEYS[2], ttl)
+		else
+			redis.call("DEL", KEYS[2])
+		end
+	end
+end
+return 1`)
+
+// RescheduleTask changes the time to process the task msg in the scheduled
+// or retry queue given by key.
+// The uniqueness lock held by the task, if any, is kept for the same time
+// after the new time to process the task, like the lock acquired by
+// ScheduleUnique.
+//
+// It returns ErrTaskNotFound if msg is no longer in the queue, e.g. because
+// the task was enqueued or changed since it was read.
+func (r *RDB) RescheduleTask(key string, msg *base.TaskMessage, processAt time.Time) error {
+	if key != r.keys.ScheduledQueue && key != r.keys.RetryQueue {
+		return fmt.Errorf("cannot reschedule a task in %q", key)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	res, err := rescheduleTaskCmd.Run(r.client, []string{key, msg.UniqueKey, r.keys.TaskIndex},
+		data, processAt.Unix(), msg.ID.String()).Result()
+	if err != nil {
+		return err
+	}
+	return checkTaskUpdated(res)
+}
+
+// KEYS[1] -> list holding the task in asynq:queues:<qname>
+// KEYS[2] -> list for the priority of the task in the new queue
+// KEYS[3] -> asynq:queues
+// KEYS[4] -> asynq:task_index
+// KEYS[5] -> unique key of the task
+// KEYS[6] -> unique key of the task in the new queue
+// KEYS[7] -> asynq:queues:<qname> to move the task to
+// ARGV[1] -> task message to move
+// ARGV[2] -> task message in the new queue
+// ARGV[3] -> task ID
+//
+// The uniqueness lock held by the task is moved to the new unique key
+// with the remaining TTL.
+var moveTaskCmd = redis.NewScript(indexTaskFn + `
+if string.len(KEYS[6]) > 0 then
+	local owner = redis.call("GET", KEYS[6])
+	if owner and owner ~= ARGV[3] then
+		return -1
+	end
+end
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+if string.len(KEYS[5]) > 0 and redis.call("GET", KEYS[5]) == ARGV[3] then
+	local ttl = redis.call("PTTL", KEYS[5])
+	redis.call("DEL", KEYS[5])
+	if ttl > 0 then
+		redis.call("SET", KEYS[6], ARGV[3], "PX", ttl)
+	end
+end
+redis.call("LPUSH", KEYS[2], ARGV[2])
+redis.call("SADD", KEYS[3], KEYS[7])
+indexTask(KEYS[4], ARGV[3], KEYS[2])
+return 1`)
+
+// MoveTask moves the enqueued task msg to the end of the given queue,
+// behind the tasks of the same priority.
+// The uniqueness lock of the task, if any, is moved to the unique key of
+// the task in the new queue. On success, it sets the queue, unique key and
+// EnqueuedAt of msg accordingly.
+//
+// It returns ErrDuplicateTask if another task holds the uniqueness lock in
+// the new queue, and ErrTaskNotFound if msg is no longer in its queue,
+// e.g. because the task was dequeued or changed since it was read.
+func (r *RDB) MoveTask(msg *base.TaskMessage, qname string) error {
+	old, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	moved := *msg
+	if suffix := ":" + msg.Queue; strings.HasSuffix(msg.UniqueKey, suffix) {
+		moved.UniqueKey = strings.TrimSuffix(msg.UniqueKey, suffix) + ":" + qname
+	}
+	moved.Queue = qname
+	moved.EnqueuedAt = time.Now().UnixNano()
+	data, err := json.Marshal(&moved)
+	if err != nil {
+		return err
+	}
+	res, err := moveTaskCmd.Run(r.client,
+		[]string{r.keys.PriorityQueueKey(msg.Queue, msg.Priority), r.keys.PriorityQueueKey(qname, msg.Priority),
+			r.keys.AllQueues, r.keys.TaskIndex, msg.UniqueKey, moved.UniqueKey, r.keys.QueueKey(qname)},
+		old, data, msg.ID.String()).Result()
+	if err != nil {
+		return err
+	}
+	if n, ok := res.(int64); ok && n == -1 {
+		return ErrDuplicateTask
+	}
+	if err := checkTaskUpdated(res); err != nil {
+		return err
+	}
+	*msg = moved
+	return nil
+}
+
+// KEYS[1] -> LIST or ZSET holding the task
+// KEYS[2] -> unique key of the task
+// KEYS[3] -> asynq:blob_garbage
+// KEYS[4] -> asynq:task_index
+// ARGV[1] -> task message
+// ARGV[2] -> task ID
+// ARGV[3] -> "zset" if KEYS[1] is a ZSET
+var cancelTaskCmd = redis.NewScript(collectBlobFn + `
+local n
+if ARGV[3] == "zset" then
+	n = redis.call("ZREM", KEYS[1], ARGV[1])
+else
+	n = redis.call("LREM", KEYS[1], 1, ARGV[1])
+end
+if n == 0 then
+	return 0
+end
+if string.len(KEYS[2]) > 0 and redis.call("GET", KEYS[2]) == ARGV[2] then
+	redis.call("DEL", KEYS[2])
+end
+collectBlob(ARGV[1], KEYS[3])
+redis.call("HDEL", KEYS[4], ARGV[2])
+return 1`)
+
+// CancelTask deletes the task msg which has not started processing from the
+// queue given by key. It removes the uniqueness lock held by the task, if any,
+// and marks the payload blob of the task, if any, for garbage collection.
+//
+// It returns ErrTaskNotFound if msg is no longer in the queue, e.g. because
+// the task was dequeued or changed since it was read.
+func (r *RDB) CancelTask(key string, msg *base.TaskMessage) error {
+	var typ string
+	switch {
+	case key == r.keys.ScheduledQueue || key == r.keys.RetryQueue:
+		typ = "zset"
+	case r.keys.State(key) == "enqueued":
+		typ = "list"
+	default:
+		return fmt.Errorf("cannot cancel a task in %q", key)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	res, err := cancelTaskCmd.Run(r.client,
+		[]string{key, msg.UniqueKey, r.keys.BlobGarbage, r.keys.TaskIndex},
+		data, msg.ID.String(), typ).Result()
+	if err != nil {
+		return err
+	}
+	return checkTaskUpdated(res)
+}
+
+// checkTaskUpdated returns the error for the result of a script which
+// updates a task if it has not changed. The script returns 1 if the task
+// is updated, or 0 if it is not found.
+func checkTaskUpdated(res interface{}) error {
+	n, ok := res.(int64)
+	if !ok {
+		return fmt.Errorf("could not cast %v to int64", res)
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
 // EnqueueDeadTask finds a task that matches the given id and score from dead queue
 // and enqueues it for processing. If a task that matches the id and score
 // does not exist, it returns ErrTaskNotFound.
 func (r *RDB) EnqueueDeadTask(id xid.ID, score int64) error {
-	n, err := r.removeAndEnqueue(base.DeadQueue, id.String(), float64(score))
+	n, err := r.removeAndEnqueue(r.keys.DeadQueue, id.String(), float64(score))
 	if err != nil {
 		return err
 	}
@@ -461,7 +1027,7 @@ func (r *RDB) EnqueueDeadTask(id xid.ID, score int64) error {
 // and enqueues it for processing. If a task that matches the id and score
 // does not exist, it returns ErrTaskNotFound.
 func (r *RDB) EnqueueRetryTask(id xid.ID, score int64) error {
-	n, err := r.removeAndEnqueue(base.RetryQueue, id.String(), float64(score))
+	n, err := r.removeAndEnqueue(r.keys.RetryQueue, id.String(), float64(score))
 	if err != nil {
 		return err
 	}
@@ -475,7 +1041,7 @@ func (r *RDB) EnqueueRetryTask(id xid.ID, score int64) error {
 // and enqueues it for processing. If a task that matches the id and score does not
 // exist, it returns ErrTaskNotFound.
 func (r *RDB) EnqueueScheduledTask(id xid.ID, score int64) error {
-	n, err := r.removeAndEnqueue(base.ScheduledQueue, id.String(), float64(score))
+	n, err := r.removeAndEnqueue(r.keys.ScheduledQueue, id.String(), float64(score))
 	if err != nil {
 		return err
 	}
@@ -485,39 +1051,54 @@ func (r *RDB) EnqueueScheduledTask(id xid.ID, score int64) error {
 	return nil
 }
 
-// EnqueueAllScheduledTasks enqueues all tasks from scheduled queue
-// and returns the number of tasks enqueued.
-func (r *RDB) EnqueueAllScheduledTasks() (int64, error) {
-	return r.removeAndEnqueueAll(base.ScheduledQueue)
+// EnqueueAllScheduledTasks enqueues all tasks matching f from scheduled queue
+// and returns the number of tasks enqueued. A nil f matches all tasks.
+func (r *RDB) EnqueueAllScheduledTasks(f *Filter) (int64, error) {
+	return r.removeAndEnqueueAll(r.keys.ScheduledQueue, f)
 }
 
-// EnqueueAllRetryTasks enqueues all tasks from retry queue
-// and returns the number of tasks enqueued.
-func (r *RDB) EnqueueAllRetryTasks() (int64, error) {
-	return r.removeAndEnqueueAll(base.RetryQueue)
+// EnqueueAllRetryTasks enqueues all tasks matching f from retry queue
+// and returns the number of tasks enqueued. A nil f matches all tasks.
+func (r *RDB) EnqueueAllRetryTasks(f *Filter) (int64, error) {
+	return r.removeAndEnqueueAll(r.keys.RetryQueue, f)
 }
 
-// EnqueueAllDeadTasks enqueues all tasks from dead queue
-// and returns the number of tasks enqueued.
-func (r *RDB) EnqueueAllDeadTasks() (int64, error) {
-	return r.removeAndEnqueueAll(base.DeadQueue)
+// EnqueueAllDeadTasks enqueues all tasks matching f from dead queue
+// and returns the number of tasks enqueued. A nil f matches all tasks.
+func (r *RDB) EnqueueAllDeadTasks(f *Filter) (int64, error) {
+	return r.removeAndEnqueueAll(r.keys.DeadQueue, f)
 }
 
-var removeAndEnqueueCmd = redis.NewScript(`
+// KEYS[1] -> ZSET to move task from (e.g., dead queue)
+// KEYS[2] -> asynq:task_index
+// ARGV[1] -> score of the task to enqueue
+// ARGV[2] -> id of the task to enqueue
+// ARGV[3] -> queue key prefix
+// ARGV[4] -> current time in unix nanoseconds
+// ARGV[5] -> current unix time
+//
+// The limit of the queue is not checked; see checkQueueLimitFn.
+// ExpireAt of the task is cleared if it has passed, so that a task which
+// died because it expired is processed instead of expiring again.
+var removeAndEnqueueCmd = redis.NewScript(indexTaskFn + messageFieldFn + setEnqueuedAtFn + queueKeyFn + `
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])
 for _, msg in ipairs(msgs) do
 	local decoded = cjson.decode(msg)
 	if decoded["ID"] == ARGV[2] then
-		local qkey = ARGV[3] .. decoded["Queue"]
-		redis.call("LPUSH", qkey, msg)
+		local qkey = queueKey(ARGV[3], decoded)
+		local data = setEnqueuedAt(clearExpireAt(msg, ARGV[5]), ARGV[4])
+		redis.call("LPUSH", qkey, data)
 		redis.call("ZREM", KEYS[1], msg)
+		indexTask(KEYS[2], ARGV[2], qkey)
 		return 1
 	end
 end
 return 0`)
 
 func (r *RDB) removeAndEnqueue(zset, id string, score float64) (int64, error) {
-	res, err := removeAndEnqueueCmd.Run(r.client, []string{zset}, score, id, base.QueuePrefix).Result()
+	now := time.Now()
+	res, err := removeAndEnqueueCmd.Run(r.client, []string{zset, r.keys.TaskIndex},
+		score, id, r.keys.QueuePrefix, strconv.FormatInt(now.UnixNano(), 10), now.Unix()).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -528,18 +1109,41 @@ func (r *RDB) removeAndEnqueue(zset, id string, score float64) (int64, error) {
 	return n, nil
 }
 
-var removeAndEnqueueAllCmd = redis.NewScript(`
-local msgs = redis.call("ZRANGE", KEYS[1], 0, -1)
-for _, msg in ipairs(msgs) do
-	local decoded = cjson.decode(msg)
-	local qkey = ARGV[1] .. decoded["Queue"]
-	redis.call("LPUSH", qkey, msg)
-	redis.call("ZREM", KEYS[1], msg)
+// KEYS[1] -> ZSET to move tasks from (e.g., dead queue)
+// KEYS[2] -> asynq:task_index
+// ARGV[1] -> queue key prefix
+// ARGV[2] -> filter conditions
+// ARGV[3] -> min score
+// ARGV[4] -> max score
+// ARGV[5] -> current time in unix nanoseconds
+// ARGV[6] -> current unix time
+//
+// The limit of the queue is not checked; see checkQueueLimitFn.
+// Passed ExpireAt of the tasks are cleared like in removeAndEnqueueCmd.
+var removeAndEnqueueAllCmd = redis.NewScript(indexTaskFn + messageFieldFn + matchTaskFn + setEnqueuedAtFn + queueKeyFn + `
+local n = 0
+for _, msg in ipairs(redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[3], ARGV[4])) do
+	if matchTask(msg, ARGV[2]) then
+		local decoded = cjson.decode(msg)
+		local qkey = queueKey(ARGV[1], decoded)
+		local data = setEnqueuedAt(clearExpireAt(msg, ARGV[6]), ARGV[5])
+		redis.call("LPUSH", qkey, data)
+		redis.call("ZREM", KEYS[1], msg)
+		indexTask(KEYS[2], decoded["ID"], qkey)
+		n = n + 1
+	end
 end
-return table.getn(msgs)`)
+return n`)
 
-func (r *RDB) removeAndEnqueueAll(zset string) (int64, error) {
-	res, err := removeAndEnqueueAllCmd.Run(r.client, []string{zset}, base.QueuePrefix).Result()
+func (r *RDB) removeAndEnqueueAll(zset string, f *Filter) (int64, error) {
+	conds, err := f.conditions()
+	if err != nil {
+		return 0, err
+	}
+	min, max := f.scoreRange()
+	now := time.Now()
+	res, err := removeAndEnqueueAllCmd.Run(r.client, []string{zset, r.keys.TaskIndex},
+		r.keys.QueuePrefix, conds, min, max, strconv.FormatInt(now.UnixNano(), 10), now.Unix()).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -554,7 +1158,7 @@ func (r *RDB) removeAndEnqueueAll(zset string) (int64, error) {
 // and moves it to dead queue. If a task that maches the id and score does not exist,
 // it returns ErrTaskNotFound.
 func (r *RDB) KillRetryTask(id xid.ID, score int64) error {
-	n, err := r.removeAndKill(base.RetryQueue, id.String(), float64(score))
+	n, err := r.removeAndKill(r.keys.RetryQueue, id.String(), float64(score))
 	if err != nil {
 		return err
 	}
@@ -568,7 +1172,7 @@ func (r *RDB) KillRetryTask(id xid.ID, score int64) error {
 // and moves it to dead queue. If a task that maches the id and score does not exist,
 // it returns ErrTaskNotFound.
 func (r *RDB) KillScheduledTask(id xid.ID, score int64) error {
-	n, err := r.removeAndKill(base.ScheduledQueue, id.String(), float64(score))
+	n, err := r.removeAndKill(r.keys.ScheduledQueue, id.String(), float64(score))
 	if err != nil {
 		return err
 	}
@@ -578,34 +1182,36 @@ func (r *RDB) KillScheduledTask(id xid.ID, score int64) error {
 	return nil
 }
 
-// KillAllRetryTasks moves all tasks from retry queue to dead queue and
-// returns the number of tasks that were moved.
-func (r *RDB) KillAllRetryTasks() (int64, error) {
-	return r.removeAndKillAll(base.RetryQueue)
+// KillAllRetryTasks moves all tasks matching f from retry queue to dead queue
+// and returns the number of tasks that were moved. A nil f matches all tasks.
+func (r *RDB) KillAllRetryTasks(f *Filter) (int64, error) {
+	return r.removeAndKillAll(r.keys.RetryQueue, f)
 }
 
-// KillAllScheduledTasks moves all tasks from scheduled queue to dead queue and
-// returns the number of tasks that were moved.
-func (r *RDB) KillAllScheduledTasks() (int64, error) {
-	return r.removeAndKillAll(base.ScheduledQueue)
+// KillAllScheduledTasks moves all tasks matching f from scheduled queue to dead queue
+// and returns the number of tasks that were moved. A nil f matches all tasks.
+func (r *RDB) KillAllScheduledTasks(f *Filter) (int64, error) {
+	return r.removeAndKillAll(r.keys.ScheduledQueue, f)
 }
 
 // KEYS[1] -> ZSET to move task from (e.g., retry queue)
 // KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:blob_garbage
+// KEYS[4] -> asynq:dead_retention
+// KEYS[5] -> asynq:dead_evicted
+// KEYS[6] -> asynq:task_index
 // ARGV[1] -> score of the task to kill
 // ARGV[2] -> id of the task to kill
 // ARGV[3] -> current timestamp
-// ARGV[4] -> cutoff timestamp (e.g., 90 days ago)
-// ARGV[5] -> max number of tasks in dead queue (e.g., 100)
-var removeAndKillCmd = redis.NewScript(`
+var removeAndKillCmd = redis.NewScript(indexTaskFn + collectBlobFn + trimDeadFn + `
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])
 for _, msg in ipairs(msgs) do
 	local decoded = cjson.decode(msg)
 	if decoded["ID"] == ARGV[2] then
 		redis.call("ZREM", KEYS[1], msg)
 		redis.call("ZADD", KEYS[2], ARGV[3], msg)
-		redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[4])
-		redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[5])
+		indexTask(KEYS[6], ARGV[2], KEYS[2], ARGV[3])
+		trimDead(KEYS[2], ARGV[3], KEYS[3], KEYS[4], KEYS[5], KEYS[6])
 		return 1
 	end
 end
@@ -613,10 +1219,9 @@ return 0`)
 
 func (r *RDB) removeAndKill(zset, id string, score float64) (int64, error) {
 	now := time.Now()
-	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
 	res, err := removeAndKillCmd.Run(r.client,
-		[]string{zset, base.DeadQueue},
-		score, id, now.Unix(), limit, maxDeadTasks).Result()
+		[]string{zset, r.keys.DeadQueue, r.keys.BlobGarbage, r.keys.DeadRetention, r.keys.DeadEvicted, r.keys.TaskIndex},
+		score, id, now.Unix()).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -629,24 +1234,37 @@ func (r *RDB) removeAndKill(zset, id string, score float64) (int64, error) {
 
 // KEYS[1] -> ZSET to move task from (e.g., retry queue)
 // KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:blob_garbage
+// KEYS[4] -> asynq:dead_retention
+// KEYS[5] -> asynq:dead_evicted
+// KEYS[6] -> asynq:task_index
 // ARGV[1] -> current timestamp
-// ARGV[2] -> cutoff timestamp (e.g., 90 days ago)
-// ARGV[3] -> max number of tasks in dead queue (e.g., 100)
-var removeAndKillAllCmd = redis.NewScript(`
-local msgs = redis.call("ZRANGE", KEYS[1], 0, -1)
-for _, msg in ipairs(msgs) do
-	redis.call("ZADD", KEYS[2], ARGV[1], msg)
-	redis.call("ZREM", KEYS[1], msg)
-	redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[2])
-	redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[3])
+// ARGV[2] -> filter conditions
+// ARGV[3] -> min score
+// ARGV[4] -> max score
+var removeAndKillAllCmd = redis.NewScript(indexTaskFn + collectBlobFn + trimDeadFn + matchTaskFn + `
+local n = 0
+for _, msg in ipairs(redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[3], ARGV[4])) do
+	if matchTask(msg, ARGV[2]) then
+		redis.call("ZADD", KEYS[2], ARGV[1], msg)
+		redis.call("ZREM", KEYS[1], msg)
+		indexTask(KEYS[6], cjson.decode(msg)["ID"], KEYS[2], ARGV[1])
+		n = n + 1
+	end
 end
-return table.getn(msgs)`)
+trimDead(KEYS[2], ARGV[1], KEYS[3], KEYS[4], KEYS[5], KEYS[6])
+return n`)
 
-func (r *RDB) removeAndKillAll(zset string) (int64, error) {
+func (r *RDB) removeAndKillAll(zset string, f *Filter) (int64, error) {
+	conds, err := f.conditions()
+	if err != nil {
+		return 0, err
+	}
+	min, max := f.scoreRange()
 	now := time.Now()
-	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
-	res, err := removeAndKillAllCmd.Run(r.client, []string{zset, base.DeadQueue},
-		now.Unix(), limit, maxDeadTasks).Result()
+	res, err := removeAndKillAllCmd.Run(r.client,
+		[]string{zset, r.keys.DeadQueue, r.keys.BlobGarbage, r.keys.DeadRetention, r.keys.DeadEvicted, r.keys.TaskIndex},
+		now.Unix(), conds, min, max).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -661,36 +1279,43 @@ func (r *RDB) removeAndKillAll(zset string) (int64, error) {
 // and deletes it. If a task that matches the id and score does not exist,
 // it returns ErrTaskNotFound.
 func (r *RDB) DeleteDeadTask(id xid.ID, score int64) error {
-	return r.deleteTask(base.DeadQueue, id.String(), float64(score))
+	return r.deleteTask(r.keys.DeadQueue, id.String(), float64(score))
 }
 
 // DeleteRetryTask finds a task that matches the given id and score from retry queue
 // and deletes it. If a task that matches the id and score does not exist,
 // it returns ErrTaskNotFound.
 func (r *RDB) DeleteRetryTask(id xid.ID, score int64) error {
-	return r.deleteTask(base.RetryQueue, id.String(), float64(score))
+	return r.deleteTask(r.keys.RetryQueue, id.String(), float64(score))
 }
 
 // DeleteScheduledTask finds a task that matches the given id and score from
 // scheduled queue  and deletes it. If a task that matches the id and score
-//does not exist, it returns ErrTaskNotFound.
+// does not exist, it returns ErrTaskNotFound.
 func (r *RDB) DeleteScheduledTask(id xid.ID, score int64) error {
-	return r.deleteTask(base.ScheduledQueue, id.String(), float64(score))
+	return r.deleteTask(r.keys.ScheduledQueue, id.String(), float64(score))
 }
 
-var deleteTaskCmd = redis.NewScript(`
+// KEYS[1] -> ZSET to delete task from (e.g., dead queue)
+// KEYS[2] -> asynq:blob_garbage
+// KEYS[3] -> asynq:task_index
+// ARGV[1] -> score of the task to delete
+// ARGV[2] -> id of the task to delete
+var deleteTaskCmd = redis.NewScript(collectBlobFn + `
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[1])
 for _, msg in ipairs(msgs) do
 	local decoded = cjson.decode(msg)
 	if decoded["ID"] == ARGV[2] then
 		redis.call("ZREM", KEYS[1], msg)
+		redis.call("HDEL", KEYS[3], ARGV[2])
+		collectBlob(msg, KEYS[2])
 		return 1
 	end
 end
 return 0`)
 
 func (r *RDB) deleteTask(zset, id string, score float64) error {
-	res, err := deleteTaskCmd.Run(r.client, []string{zset}, score, id).Result()
+	res, err := deleteTaskCmd.Run(r.client, []string{zset, r.keys.BlobGarbage, r.keys.TaskIndex}, score, id).Result()
 	if err != nil {
 		return err
 	}
@@ -704,19 +1329,57 @@ func (r *RDB) deleteTask(zset, id string, score float64) error {
 	return nil
 }
 
-// DeleteAllDeadTasks deletes all tasks from the dead queue.
-func (r *RDB) DeleteAllDeadTasks() error {
-	return r.client.Del(base.DeadQueue).Err()
+// DeleteAllDeadTasks deletes all tasks matching f from the dead queue
+// and returns the number of tasks deleted. A nil f matches all tasks.
+func (r *RDB) DeleteAllDeadTasks(f *Filter) (int64, error) {
+	return r.deleteAll(r.keys.DeadQueue, f)
+}
+
+// DeleteAllRetryTasks deletes all tasks matching f from the retry queue
+// and returns the number of tasks deleted. A nil f matches all tasks.
+func (r *RDB) DeleteAllRetryTasks(f *Filter) (int64, error) {
+	return r.deleteAll(r.keys.RetryQueue, f)
 }
 
-// DeleteAllRetryTasks deletes all tasks from the dead queue.
-func (r *RDB) DeleteAllRetryTasks() error {
-	return r.client.Del(base.RetryQueue).Err()
+// DeleteAllScheduledTasks deletes all tasks matching f from the scheduled queue
+// and returns the number of tasks deleted. A nil f matches all tasks.
+func (r *RDB) DeleteAllScheduledTasks(f *Filter) (int64, error) {
+	return r.deleteAll(r.keys.ScheduledQueue, f)
 }
 
-// DeleteAllScheduledTasks deletes all tasks from the dead queue.
-func (r *RDB) DeleteAllScheduledTasks() error {
-	return r.client.Del(base.ScheduledQueue).Err()
+// KEYS[1] -> ZSET to delete tasks from (e.g., dead queue)
+// KEYS[2] -> asynq:blob_garbage
+// KEYS[3] -> asynq:task_index
+// ARGV[1] -> filter conditions
+// ARGV[2] -> min score
+// ARGV[3] -> max score
+var deleteAllCmd = redis.NewScript(collectBlobFn + matchTaskFn + `
+local n = 0
+for _, msg in ipairs(redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[2], ARGV[3])) do
+	if matchTask(msg, ARGV[1]) then
+		collectBlob(msg, KEYS[2])
+		redis.call("ZREM", KEYS[1], msg)
+		redis.call("HDEL", KEYS[3], cjson.decode(msg)["ID"])
+		n = n + 1
+	end
+end
+return n`)
+
+func (r *RDB) deleteAll(zset string, f *Filter) (int64, error) {
+	conds, err := f.conditions()
+	if err != nil {
+		return 0, err
+	}
+	min, max := f.scoreRange()
+	res, err := deleteAllCmd.Run(r.client, []string{zset, r.keys.BlobGarbage, r.keys.TaskIndex}, conds, min, max).Result()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := res.(int64)
+	if !ok {
+		return 0, fmt.Errorf("could not cast %v to int64", res)
+	}
+	return n, nil
 }
 
 // ErrQueueNotFound indicates specified queue does not exist.
@@ -737,25 +1400,38 @@ func (e *ErrQueueNotEmpty) Error() string {
 	return fmt.Sprintf("queue %q is not empty", e.qname)
 }
 
+// KEYS[1] -> asynq:queues
+// KEYS[2] -> asynq:queues:<qname>
+// KEYS[3] -> asynq:task_index
+// KEYS[4] -> asynq:wait_times:<qname>
+//
 // Skip checking whether queue is empty before removing.
-var removeQueueForceCmd = redis.NewScript(`
+var removeQueueForceCmd = redis.NewScript(queueKeysFn + `
 local n = redis.call("SREM", KEYS[1], KEYS[2])
 if n == 0 then
 	return redis.error_reply("LIST NOT FOUND")
 end
-redis.call("DEL", KEYS[2])
+for _, key in ipairs(queueKeys(KEYS[2])) do
+	for _, msg in ipairs(redis.call("LRANGE", key, 0, -1)) do
+		redis.call("HDEL", KEYS[3], cjson.decode(msg)["ID"])
+	end
+	redis.call("DEL", key)
+end
+redis.call("DEL", KEYS[4])
 return redis.status_reply("OK")`)
 
 // Checks whether queue is empty before removing.
-var removeQueueCmd = redis.NewScript(`
-local l = redis.call("LLEN", KEYS[2]) if l > 0 then
-	return redis.error_reply("LIST NOT EMPTY")
+var removeQueueCmd = redis.NewScript(queueKeysFn + `
+for _, key in ipairs(queueKeys(KEYS[2])) do
+	if redis.call("LLEN", key) > 0 then
+		return redis.error_reply("LIST NOT EMPTY")
+	end
 end
 local n = redis.call("SREM", KEYS[1], KEYS[2])
 if n == 0 then
 	return redis.error_reply("LIST NOT FOUND")
 end
-redis.call("DEL", KEYS[2])
+redis.call("DEL", KEYS[4])
 return redis.status_reply("OK")`)
 
 // RemoveQueue removes the specified queue.
@@ -772,7 +1448,7 @@ func (r *RDB) RemoveQueue(qname string, force bool) error {
 		script = removeQueueCmd
 	}
 	err := script.Run(r.client,
-		[]string{base.AllQueues, base.QueueKey(qname)},
+		[]string{r.keys.AllQueues, r.keys.QueueKey(qname), r.keys.TaskIndex, r.keys.WaitTimesKey(qname)},
 		force).Err()
 	if err != nil {
 		switch err.Error() {
@@ -804,7 +1480,7 @@ return res`)
 // ListServers returns the list of server info.
 func (r *RDB) ListServers() ([]*base.ServerInfo, error) {
 	res, err := listServersCmd.Run(r.client,
-		[]string{base.AllServers}, time.Now().UTC().Unix()).Result()
+		[]string{r.keys.AllServers}, time.Now().UTC().Unix()).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -840,7 +1516,7 @@ return res`)
 
 // ListWorkers returns the list of worker stats.
 func (r *RDB) ListWorkers() ([]*base.WorkerInfo, error) {
-	res, err := listWorkersCmd.Run(r.client, []string{base.AllWorkers}, time.Now().UTC().Unix()).Result()
+	res, err := listWorkersCmd.Run(r.client, []string{r.keys.AllWorkers}, time.Now().UTC().Unix()).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -871,8 +1547,8 @@ return redis.call("SADD", KEYS[1], ARGV[1])`)
 
 // Pause pauses processing of tasks from the given queue.
 func (r *RDB) Pause(qname string) error {
-	qkey := base.QueueKey(qname)
-	return pauseCmd.Run(r.client, []string{base.PausedQueues}, qkey).Err()
+	qkey := r.keys.QueueKey(qname)
+	return pauseCmd.Run(r.client, []string{r.keys.PausedQueues}, qkey).Err()
 }
 
 // KEYS[1] -> asynq:paused
@@ -886,6 +1562,6 @@ return redis.call("SREM", KEYS[1], ARGV[1])`)
 
 // Unpause resumes processing of tasks from the given queue.
 func (r *RDB) Unpause(qname string) error {
-	qkey := base.QueueKey(qname)
-	return unpauseCmd.Run(r.client, []string{base.PausedQueues}, qkey).Err()
+	qkey := r.keys.QueueKey(qname)
+	return unpauseCmd.Run(r.client, []string{r.keys.PausedQueues}, qkey).Err()
 }
@@ -7,9 +7,11 @@ package rdb
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	h "github.com/hibiken/asynq/internal/asynqtest"
@@ -171,6 +173,66 @@ func TestCurrentStats(t *testing.T) {
 	}
 }
 
+func TestCurrentStatsLatency(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	var msgs []*base.TaskMessage
+	for _, age := range []time.Duration{40 * time.Second, 30 * time.Second, 20 * time.Second, 10 * time.Second} {
+		msg := h.NewTaskMessage("send_email", nil)
+		msg.EnqueuedAt = now.Add(-age).UnixNano()
+		msgs = append(msgs, msg)
+	}
+	h.SeedEnqueuedQueue(t, r.client, msgs)
+	h.SeedEnqueuedQueue(t, r.client, nil, "low")
+	limit := &base.QueueLimit{MaxSize: 10, Policy: base.QueueFullReject}
+	if err := r.SetQueueLimit("low", limit); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dequeue the tasks which waited for 40s and 30s.
+	for i := 0; i < 2; i++ {
+		if _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+			t.Fatalf("r.Dequeue returned error: %v", err)
+		}
+	}
+
+	stats, err := r.CurrentStats()
+	if err != nil {
+		t.Fatalf("r.CurrentStats returned error: %v", err)
+	}
+	approx := cmpopts.EquateApprox(0, float64(2*time.Second))
+	want := []*Queue{
+		{Name: "default", Size: 2, OldestPendingAge: 20 * time.Second, WaitP50: 30 * time.Second, WaitP99: 40 * time.Second},
+		{Name: "low", Size: 0, Limit: limit},
+	}
+	if diff := cmp.Diff(want, stats.Queues, approx, cmp.Transformer("Float", func(d time.Duration) float64 { return float64(d) })); diff != "" {
+		t.Errorf("r.CurrentStats returned unexpected queues; (-want,+got)\n%s", diff)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 200; i++ {
+		samples = append(samples, time.Duration(201-i)*time.Millisecond)
+	}
+	tests := []struct {
+		samples []time.Duration
+		p       int
+		want    time.Duration
+	}{
+		{nil, 50, 0},
+		{[]time.Duration{time.Second}, 99, time.Second},
+		{samples, 50, 100 * time.Millisecond},
+		{samples, 99, 198 * time.Millisecond},
+		{samples, 100, 200 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		if got := percentile(tc.samples, tc.p); got != tc.want {
+			t.Errorf("percentile(%d samples, %d) = %v, want %v", len(tc.samples), tc.p, got, tc.want)
+		}
+	}
+}
+
 func TestCurrentStatsWithoutData(t *testing.T) {
 	r := setup(t)
 
@@ -245,6 +307,128 @@ func TestHistoricalStats(t *testing.T) {
 
 }
 
+func TestProcessingHistory(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("email:send", nil)
+	m2 := h.NewTaskMessage("email:send", nil)
+	m3 := h.NewTaskMessageWithQueue("reindex", nil, "low")
+	m4 := h.NewTaskMessageWithQueue("email:send", nil, "low")
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{m1, m2, m3, m4})
+
+	if err := r.Done(m1); err != nil {
+		t.Fatalf("r.Done returned error: %v", err)
+	}
+	if err := r.Retry(m2, time.Now().Add(time.Minute), "error"); err != nil {
+		t.Fatalf("r.Retry returned error: %v", err)
+	}
+	if err := r.Kill(m3, "error"); err != nil {
+		t.Fatalf("r.Kill returned error: %v", err)
+	}
+	if err := r.Done(m4); err != nil {
+		t.Fatalf("r.Done returned error: %v", err)
+	}
+
+	// sum adds up the stats of the last two buckets in case
+	// the operations above were recorded across a bucket boundary.
+	sum := func(stats []*ProcessingStats) ProcessingStats {
+		var res ProcessingStats
+		for _, s := range stats {
+			res.Processed += s.Processed
+			res.Failed += s.Failed
+			res.Retried += s.Retried
+		}
+		return res
+	}
+
+	tests := []struct {
+		desc string
+		get  func(g base.Granularity) ([]*ProcessingStats, error)
+		want ProcessingStats
+	}{
+		{
+			desc: "default queue",
+			get:  func(g base.Granularity) ([]*ProcessingStats, error) { return r.QueueHistory("default", g, 2) },
+			want: ProcessingStats{Processed: 2, Failed: 1, Retried: 1},
+		},
+		{
+			desc: "low queue",
+			get:  func(g base.Granularity) ([]*ProcessingStats, error) { return r.QueueHistory("low", g, 2) },
+			want: ProcessingStats{Processed: 2, Failed: 1, Retried: 0},
+		},
+		{
+			desc: "email:send tasks",
+			get:  func(g base.Granularity) ([]*ProcessingStats, error) { return r.TypeHistory("email:send", g, 2) },
+			want: ProcessingStats{Processed: 3, Failed: 1, Retried: 1},
+		},
+		{
+			desc: "unknown task type",
+			get:  func(g base.Granularity) ([]*ProcessingStats, error) { return r.TypeHistory("unknown", g, 2) },
+			want: ProcessingStats{},
+		},
+	}
+
+	for _, tc := range tests {
+		for _, g := range base.Granularities {
+			got, err := tc.get(g)
+			if err != nil {
+				t.Errorf("%s: %s history returned error: %v", tc.desc, g, err)
+				continue
+			}
+			if len(got) != 2 {
+				t.Errorf("%s: %s history returned %d buckets, want 2", tc.desc, g, len(got))
+				continue
+			}
+			if want := time.Now().UTC().Truncate(g.Duration()); got[0].Time.After(want) || got[0].Time.Sub(got[1].Time) != g.Duration() {
+				t.Errorf("%s: %s history returned buckets starting at %v and %v", tc.desc, g, got[0].Time, got[1].Time)
+			}
+			if diff := cmp.Diff(tc.want, sum(got)); diff != "" {
+				t.Errorf("%s: %s history mismatch; (-want,+got)\n%s", tc.desc, g, diff)
+			}
+		}
+	}
+
+	queues, types, err := r.CurrentProcessingStats(base.Day)
+	if err != nil {
+		t.Fatalf("r.CurrentProcessingStats returned error: %v", err)
+	}
+	if len(queues) != 2 || len(types) != 2 {
+		t.Errorf("r.CurrentProcessingStats returned stats of %d queues and %d types, want 2 and 2", len(queues), len(types))
+	}
+	if s := types["reindex"]; s == nil || s.Processed != 1 || s.Failed != 1 {
+		t.Errorf("r.CurrentProcessingStats returned %+v for reindex tasks, want 1 processed and 1 failed", s)
+	}
+
+	if _, err := r.QueueHistory("default", base.Granularity("week"), 2); err == nil {
+		t.Errorf("r.QueueHistory with unknown granularity returned nil error")
+	}
+}
+
+func TestStatsRetention(t *testing.T) {
+	r := setup(t)
+	r.SetStatsRetention(base.Minute, -1)
+	r.SetStatsRetention(base.Hour, 2*time.Hour)
+	msg := h.NewTaskMessage("email:send", nil)
+	h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{msg})
+
+	now := time.Now()
+	if err := r.Done(msg); err != nil {
+		t.Fatalf("r.Done returned error: %v", err)
+	}
+
+	if n := r.client.Exists(base.StatsKey(base.Minute, now)).Val(); n != 0 {
+		t.Errorf("minute stats are recorded while disabled")
+	}
+	// The hour bucket expires 2 hours after the end of the hour.
+	ttl := r.client.TTL(base.StatsKey(base.Hour, now)).Val()
+	if ttl <= 2*time.Hour || ttl > 3*time.Hour {
+		t.Errorf("TTL of hour stats = %v, want between 2 and 3 hours", ttl)
+	}
+	ttl = r.client.TTL(base.StatsKey(base.Day, now)).Val()
+	if ttl <= statsTTL || ttl > statsTTL+24*time.Hour {
+		t.Errorf("TTL of day stats = %v, want between %v and %v", ttl, statsTTL, statsTTL+24*time.Hour)
+	}
+}
+
 func TestRedisInfo(t *testing.T) {
 	r := setup(t)
 
@@ -324,7 +508,7 @@ func TestListEnqueued(t *testing.T) {
 			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
 		}
 
-		got, err := r.ListEnqueued(tc.qname, Pagination{Size: 20, Page: 0})
+		got, err := r.ListEnqueued(tc.qname, Pagination{Size: 20, Page: 0}, nil)
 		op := fmt.Sprintf("r.ListEnqueued(%q, Pagination{Size: 20, Page: 0})", tc.qname)
 		if err != nil {
 			t.Errorf("%s = %v, %v, want %v, nil", op, got, err, tc.want)
@@ -379,7 +563,7 @@ func TestListEnqueuedPagination(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		got, err := r.ListEnqueued(tc.qname, Pagination{Size: tc.size, Page: tc.page})
+		got, err := r.ListEnqueued(tc.qname, Pagination{Size: tc.size, Page: tc.page}, nil)
 		op := fmt.Sprintf("r.ListEnqueued(%q, Pagination{Size: %d, Page: %d})", tc.qname, tc.size, tc.page)
 		if err != nil {
 			t.Errorf("%s; %s returned error %v", tc.desc, op, err)
@@ -434,8 +618,8 @@ func TestListInProgress(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedInProgressQueue(t, r.client, tc.inProgress)
 
-		got, err := r.ListInProgress(Pagination{Size: 20, Page: 0})
-		op := "r.ListInProgress(Pagination{Size: 20, Page: 0})"
+		got, err := r.ListInProgress(Pagination{Size: 20, Page: 0}, nil)
+		op := "r.ListInProgress(Pagination{Size: 20, Page: 0}, nil)"
 		if err != nil {
 			t.Errorf("%s = %v, %v, want %v, nil", op, got, err, tc.want)
 			continue
@@ -479,7 +663,7 @@ func TestListInProgressPagination(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		got, err := r.ListInProgress(Pagination{Size: tc.size, Page: tc.page})
+		got, err := r.ListInProgress(Pagination{Size: tc.size, Page: tc.page}, nil)
 		op := fmt.Sprintf("r.ListInProgress(Pagination{Size: %d, Page: %d})", tc.size, tc.page)
 		if err != nil {
 			t.Errorf("%s; %s returned error %v", tc.desc, op, err)
@@ -539,8 +723,8 @@ func TestListScheduled(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedScheduledQueue(t, r.client, tc.scheduled)
 
-		got, err := r.ListScheduled(Pagination{Size: 20, Page: 0})
-		op := "r.ListScheduled(Pagination{Size: 20, Page: 0})"
+		got, err := r.ListScheduled(Pagination{Size: 20, Page: 0}, nil)
+		op := "r.ListScheduled(Pagination{Size: 20, Page: 0}, nil)"
 		if err != nil {
 			t.Errorf("%s = %v, %v, want %v, nil", op, got, err, tc.want)
 			continue
@@ -585,7 +769,7 @@ func TestListScheduledPagination(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		got, err := r.ListScheduled(Pagination{Size: tc.size, Page: tc.page})
+		got, err := r.ListScheduled(Pagination{Size: tc.size, Page: tc.page}, nil)
 		op := fmt.Sprintf("r.ListScheduled(Pagination{Size: %d, Page: %d})", tc.size, tc.page)
 		if err != nil {
 			t.Errorf("%s; %s returned error %v", tc.desc, op, err)
@@ -681,8 +865,8 @@ func TestListRetry(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedRetryQueue(t, r.client, tc.retry)
 
-		got, err := r.ListRetry(Pagination{Size: 20, Page: 0})
-		op := "r.ListRetry(Pagination{Size: 20, Page: 0})"
+		got, err := r.ListRetry(Pagination{Size: 20, Page: 0}, nil)
+		op := "r.ListRetry(Pagination{Size: 20, Page: 0}, nil)"
 		if err != nil {
 			t.Errorf("%s = %v, %v, want %v, nil", op, got, err, tc.want)
 			continue
@@ -730,7 +914,7 @@ func TestListRetryPagination(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		got, err := r.ListRetry(Pagination{Size: tc.size, Page: tc.page})
+		got, err := r.ListRetry(Pagination{Size: tc.size, Page: tc.page}, nil)
 		op := fmt.Sprintf("r.ListRetry(Pagination{Size: %d, Page: %d})", tc.size, tc.page)
 		if err != nil {
 			t.Errorf("%s; %s returned error %v", tc.desc, op, err)
@@ -818,8 +1002,8 @@ func TestListDead(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedDeadQueue(t, r.client, tc.dead)
 
-		got, err := r.ListDead(Pagination{Size: 20, Page: 0})
-		op := "r.ListDead(Pagination{Size: 20, Page: 0})"
+		got, err := r.ListDead(Pagination{Size: 20, Page: 0}, nil)
+		op := "r.ListDead(Pagination{Size: 20, Page: 0}, nil)"
 		if err != nil {
 			t.Errorf("%s = %v, %v, want %v, nil", op, got, err, tc.want)
 			continue
@@ -863,7 +1047,7 @@ func TestListDeadPagination(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		got, err := r.ListDead(Pagination{Size: tc.size, Page: tc.page})
+		got, err := r.ListDead(Pagination{Size: tc.size, Page: tc.page}, nil)
 		op := fmt.Sprintf("r.ListDead(Pagination{Size: %d, Page: %d})", tc.size, tc.page)
 		if err != nil {
 			t.Errorf("%s; %s returned error %v", tc.desc, op, err)
@@ -895,6 +1079,49 @@ func TestListDeadPagination(t *testing.T) {
 
 var timeCmpOpt = cmpopts.EquateApproxTime(time.Second)
 
+func TestEnqueueDeadTaskClearsExpireAt(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"ExpireAt": 1.0})
+	t1.ExpireAt = now.Add(-time.Minute).Unix()
+	t1.ErrorMsg = "expired"
+	t2 := h.NewTaskMessage("gen_thumbnail", nil)
+	t2.ExpireAt = now.Add(time.Hour).Unix()
+	t3 := h.NewTaskMessage("reindex", nil)
+	t3.ExpireAt = now.Add(-time.Minute).Unix()
+	t3.ErrorMsg = "expired"
+	enqueued1 := *t1
+	enqueued1.ExpireAt = 0
+	enqueued3 := *t3
+	enqueued3.ExpireAt = 0
+
+	h.FlushDB(t, r.client)
+	h.SeedDeadQueue(t, r.client, []h.ZSetEntry{
+		{Msg: t1, Score: float64(now.Unix())},
+		{Msg: t2, Score: float64(now.Unix())},
+		{Msg: t3, Score: float64(now.Add(-time.Hour).Unix())},
+	})
+
+	if err := r.EnqueueDeadTask(t3.ID, now.Add(-time.Hour).Unix()); err != nil {
+		t.Fatalf("r.EnqueueDeadTask(%s) returned error: %v", t3.ID, err)
+	}
+	if n, err := r.EnqueueAllDeadTasks(nil); n != 2 || err != nil {
+		t.Fatalf("r.EnqueueAllDeadTasks(nil) = %d, %v; want 2, nil", n, err)
+	}
+
+	// Passed ExpireAt is cleared so that the tasks are processed instead of expiring again.
+	want := []*base.TaskMessage{&enqueued1, t2, &enqueued3}
+	got := h.GetEnqueuedMessages(t, r.client)
+	if diff := cmp.Diff(want, got, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
+		t.Errorf("mismatch found in %q; (-want,+got)\n%s", base.DefaultQueue, diff)
+	}
+	for range want {
+		if _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+			t.Errorf("r.Dequeue() returned error: %v", err)
+		}
+	}
+}
+
 func TestEnqueueDeadTask(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
@@ -967,7 +1194,7 @@ func TestEnqueueDeadTask(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.QueueKey(qname), diff)
 			}
 		}
@@ -1051,7 +1278,7 @@ func TestEnqueueRetryTask(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.QueueKey(qname), diff)
 			}
 		}
@@ -1135,7 +1362,7 @@ func TestEnqueueScheduledTask(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.QueueKey(qname), diff)
 			}
 		}
@@ -1205,7 +1432,7 @@ func TestEnqueueAllScheduledTasks(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedScheduledQueue(t, r.client, tc.scheduled)
 
-		got, err := r.EnqueueAllScheduledTasks()
+		got, err := r.EnqueueAllScheduledTasks(nil)
 		if err != nil {
 			t.Errorf("%s; r.EnqueueAllScheduledTasks = %v, %v; want %v, nil",
 				tc.desc, got, err, tc.want)
@@ -1219,7 +1446,7 @@ func TestEnqueueAllScheduledTasks(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s; mismatch found in %q; (-want, +got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -1284,7 +1511,7 @@ func TestEnqueueAllRetryTasks(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedRetryQueue(t, r.client, tc.retry)
 
-		got, err := r.EnqueueAllRetryTasks()
+		got, err := r.EnqueueAllRetryTasks(nil)
 		if err != nil {
 			t.Errorf("%s; r.EnqueueAllRetryTasks = %v, %v; want %v, nil",
 				tc.desc, got, err, tc.want)
@@ -1298,7 +1525,7 @@ func TestEnqueueAllRetryTasks(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s; mismatch found in %q; (-want, +got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -1363,7 +1590,7 @@ func TestEnqueueAllDeadTasks(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedDeadQueue(t, r.client, tc.dead)
 
-		got, err := r.EnqueueAllDeadTasks()
+		got, err := r.EnqueueAllDeadTasks(nil)
 		if err != nil {
 			t.Errorf("%s; r.EnqueueAllDeadTasks = %v, %v; want %v, nil",
 				tc.desc, got, err, tc.want)
@@ -1377,7 +1604,7 @@ func TestEnqueueAllDeadTasks(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("%s; mismatch found in %q; (-want, +got)\n%s", tc.desc, base.QueueKey(qname), diff)
 			}
 		}
@@ -1599,7 +1826,7 @@ func TestKillAllRetryTasks(t *testing.T) {
 		h.SeedRetryQueue(t, r.client, tc.retry)
 		h.SeedDeadQueue(t, r.client, tc.dead)
 
-		got, err := r.KillAllRetryTasks()
+		got, err := r.KillAllRetryTasks(nil)
 		if got != tc.want || err != nil {
 			t.Errorf("(*RDB).KillAllRetryTasks() = %v, %v; want %v, nil",
 				got, err, tc.want)
@@ -1681,7 +1908,7 @@ func TestKillAllScheduledTasks(t *testing.T) {
 		h.SeedScheduledQueue(t, r.client, tc.scheduled)
 		h.SeedDeadQueue(t, r.client, tc.dead)
 
-		got, err := r.KillAllScheduledTasks()
+		got, err := r.KillAllScheduledTasks(nil)
 		if got != tc.want || err != nil {
 			t.Errorf("(*RDB).KillAllScheduledTasks() = %v, %v; want %v, nil",
 				got, err, tc.want)
@@ -1890,7 +2117,7 @@ func TestDeleteAllDeadTasks(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedDeadQueue(t, r.client, tc.dead)
 
-		err := r.DeleteAllDeadTasks()
+		_, err := r.DeleteAllDeadTasks(nil)
 		if err != nil {
 			t.Errorf("r.DeleteAllDeaadTasks = %v, want nil", err)
 		}
@@ -1926,7 +2153,7 @@ func TestDeleteAllRetryTasks(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedRetryQueue(t, r.client, tc.retry)
 
-		err := r.DeleteAllRetryTasks()
+		_, err := r.DeleteAllRetryTasks(nil)
 		if err != nil {
 			t.Errorf("r.DeleteAllDeaadTasks = %v, want nil", err)
 		}
@@ -1962,7 +2189,7 @@ func TestDeleteAllScheduledTasks(t *testing.T) {
 		h.FlushDB(t, r.client) // clean up db before each test case
 		h.SeedScheduledQueue(t, r.client, tc.scheduled)
 
-		err := r.DeleteAllScheduledTasks()
+		_, err := r.DeleteAllScheduledTasks(nil)
 		if err != nil {
 			t.Errorf("r.DeleteAllDeaadTasks = %v, want nil", err)
 		}
@@ -2362,3 +2589,633 @@ func TestUnpauseError(t *testing.T) {
 		}
 	}
 }
+
+func TestGetTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("gen_thumbnail", nil, "low")
+	m4 := h.NewTaskMessage("sync", nil)
+	m5 := h.NewTaskMessage("send_email", nil)
+	m5Retried := *m5
+	m5Retried.Retried = 1
+	m5Retried.ErrorMsg = "some error"
+
+	tests := []struct {
+		desc      string
+		setup     func() // populates the state with or without the index
+		id        xid.ID
+		want      *TaskInfo
+		wantEntry string // entry recorded in the index after the lookup
+	}{
+		{
+			desc: "enqueued task",
+			setup: func() {
+				if err := r.Enqueue(m1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			id:        m1.ID,
+			want:      &TaskInfo{Msg: m1, Key: base.DefaultQueue},
+			wantEntry: base.DefaultQueue,
+		},
+		{
+			desc: "in-progress task",
+			setup: func() {
+				if err := r.Enqueue(m1); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Dequeue(base.DefaultQueueName); err != nil {
+					t.Fatal(err)
+				}
+			},
+			id:        m1.ID,
+			want:      &TaskInfo{Msg: m1, Key: base.InProgressQueue},
+			wantEntry: base.InProgressQueue,
+		},
+		{
+			desc: "scheduled task",
+			setup: func() {
+				if err := r.Schedule(m2, now.Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			id:        m2.ID,
+			want:      &TaskInfo{Msg: m2, Key: base.ScheduledQueue, Score: now.Add(time.Hour).Unix()},
+			wantEntry: fmt.Sprintf("%s\n%d", base.ScheduledQueue, now.Add(time.Hour).Unix()),
+		},
+		{
+			desc: "rescheduled task",
+			setup: func() {
+				if err := r.Schedule(m2, now.Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+				if err := r.RescheduleTask(base.ScheduledQueue, m2, now.Add(2*time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			id:        m2.ID,
+			want:      &TaskInfo{Msg: m2, Key: base.ScheduledQueue, Score: now.Add(2 * time.Hour).Unix()},
+			wantEntry: fmt.Sprintf("%s\n%d", base.ScheduledQueue, now.Add(2*time.Hour).Unix()),
+		},
+		{
+			desc: "retried task",
+			setup: func() {
+				if err := r.Enqueue(m5); err != nil {
+					t.Fatal(err)
+				}
+				msg, err := r.Dequeue(base.DefaultQueueName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Retry(msg, now.Add(time.Minute), "some error"); err != nil {
+					t.Fatal(err)
+				}
+				m5Retried.EnqueuedAt = msg.EnqueuedAt
+			},
+			id:        m5.ID,
+			want:      &TaskInfo{Msg: &m5Retried, Key: base.RetryQueue, Score: now.Add(time.Minute).Unix()},
+			wantEntry: fmt.Sprintf("%s\n%d", base.RetryQueue, now.Add(time.Minute).Unix()),
+		},
+		{
+			desc: "task removed when done",
+			setup: func() {
+				if err := r.Enqueue(m1); err != nil {
+					t.Fatal(err)
+				}
+				msg, err := r.Dequeue(base.DefaultQueueName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Done(msg); err != nil {
+					t.Fatal(err)
+				}
+			},
+			id:   m1.ID,
+			want: nil,
+		},
+		{
+			desc: "task missing from the index",
+			setup: func() {
+				h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m3}, "low")
+				h.SeedDeadQueue(t, r.client, []h.ZSetEntry{{Msg: m4, Score: float64(now.Unix())}})
+				r.client.HDel(base.TaskIndex, m3.ID.String(), m4.ID.String())
+			},
+			id:   m4.ID,
+			want: nil,
+		},
+		{
+			desc: "stale index",
+			setup: func() {
+				h.SeedRetryQueue(t, r.client, []h.ZSetEntry{{Msg: m4, Score: float64(now.Unix())}})
+				if err := r.Schedule(m4, now.Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+				r.client.ZRem(base.ScheduledQueue, h.MustMarshal(t, m4))
+			},
+			id:        m4.ID,
+			want:      nil,
+			wantEntry: fmt.Sprintf("%s\n%d", base.ScheduledQueue, now.Add(time.Hour).Unix()),
+		},
+		{
+			desc:  "task not found",
+			setup: func() {},
+			id:    xid.New(),
+			want:  nil,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		tc.setup()
+
+		got, err := r.GetTask(tc.id)
+		if tc.want == nil {
+			if err != ErrTaskNotFound {
+				t.Errorf("%s: r.GetTask(%v) = %v, %v; want nil, ErrTaskNotFound", tc.desc, tc.id, got, err)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("%s: r.GetTask(%v) returned error: %v", tc.desc, tc.id, err)
+				continue
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s: r.GetTask(%v) = %v, want %v; (-want,+got)\n%s", tc.desc, tc.id, got, tc.want, diff)
+			}
+		}
+		if gotEntry := r.client.HGet(base.TaskIndex, tc.id.String()).Val(); gotEntry != tc.wantEntry {
+			t.Errorf("%s: index entry for %v = %q, want %q", tc.desc, tc.id, gotEntry, tc.wantEntry)
+		}
+	}
+}
+
+// indexedKey returns the key recorded in the task index for the task id.
+func indexedKey(r *RDB, id xid.ID) string {
+	entry := r.client.HGet(base.TaskIndex, id.String()).Val()
+	return strings.SplitN(entry, "\n", 2)[0]
+}
+
+func TestUpdateTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "bad@"})
+	m1.Retried = 25
+	m1.ErrorMsg = "invalid address"
+	m1Updated := *m1
+	m1Updated.Payload = map[string]interface{}{"to": "user@example.com"}
+	m1Updated.Retried = 0
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2.PayloadRef = m2.ID.String()
+	m2Updated := *m2
+	m2Updated.PayloadRef = ""
+	m2Updated.Payload = map[string]interface{}{"index": "users"}
+	m3 := h.NewTaskMessage("sync", map[string]interface{}{"user_id": "123"})
+	m3Stale := *m3
+	m3Stale.Retried = 3
+	m3Updated := *m3
+	m3Updated.Payload = map[string]interface{}{"user_id": "456"}
+
+	tests := []struct {
+		desc        string
+		key         string
+		entries     []h.ZSetEntry // initial entries of the key
+		old, msg    *base.TaskMessage
+		want        error
+		wantEntries []h.ZSetEntry
+		wantGarbage []string
+	}{
+		{
+			desc:        "dead task",
+			key:         base.DeadQueue,
+			entries:     []h.ZSetEntry{{Msg: m1, Score: float64(now.Unix())}},
+			old:         m1,
+			msg:         &m1Updated,
+			want:        nil,
+			wantEntries: []h.ZSetEntry{{Msg: &m1Updated, Score: float64(now.Unix())}},
+			wantGarbage: []string{},
+		},
+		{
+			desc:        "offloaded payload",
+			key:         base.RetryQueue,
+			entries:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Minute).Unix())}},
+			old:         m2,
+			msg:         &m2Updated,
+			want:        nil,
+			wantEntries: []h.ZSetEntry{{Msg: &m2Updated, Score: float64(now.Add(time.Minute).Unix())}},
+			wantGarbage: []string{m2.PayloadRef},
+		},
+		{
+			desc:        "task changed since read",
+			key:         base.ScheduledQueue,
+			entries:     []h.ZSetEntry{{Msg: &m3Stale, Score: float64(now.Add(time.Hour).Unix())}},
+			old:         m3,
+			msg:         &m3Updated,
+			want:        ErrTaskNotFound,
+			wantEntries: []h.ZSetEntry{{Msg: &m3Stale, Score: float64(now.Add(time.Hour).Unix())}},
+			wantGarbage: []string{},
+		},
+	}
+
+	seed := map[string]func(testing.TB, *redis.Client, []h.ZSetEntry){
+		base.ScheduledQueue: h.SeedScheduledQueue,
+		base.RetryQueue:     h.SeedRetryQueue,
+		base.DeadQueue:      h.SeedDeadQueue,
+	}
+	get := map[string]func(testing.TB, *redis.Client) []h.ZSetEntry{
+		base.ScheduledQueue: h.GetScheduledEntries,
+		base.RetryQueue:     h.GetRetryEntries,
+		base.DeadQueue:      h.GetDeadEntries,
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		seed[tc.key](t, r.client, tc.entries)
+
+		got := r.UpdateTask(tc.key, tc.old, tc.msg)
+		if got != tc.want {
+			t.Errorf("%s: r.UpdateTask(%q, %v, %v) = %v, want %v", tc.desc, tc.key, tc.old, tc.msg, got, tc.want)
+			continue
+		}
+		gotEntries := get[tc.key](t, r.client)
+		if diff := cmp.Diff(tc.wantEntries, gotEntries, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, tc.key, diff)
+		}
+		gotGarbage := r.client.SMembers(base.BlobGarbage).Val()
+		if diff := cmp.Diff(tc.wantGarbage, gotGarbage); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, base.BlobGarbage, diff)
+		}
+	}
+}
+
+func TestUpdateTaskError(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", nil)
+	h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1})
+
+	for _, key := range []string{base.DefaultQueue, base.InProgressQueue} {
+		if err := r.UpdateTask(key, m1, m1); err == nil {
+			t.Errorf("r.UpdateTask(%q, %v, %v) returned nil; want error", key, m1, m1)
+		}
+	}
+}
+
+func TestRescheduleTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2Stale := *m2
+	m2Stale.Retried = 1
+
+	tests := []struct {
+		scheduled     []h.ZSetEntry
+		retry         []h.ZSetEntry
+		key           string
+		msg           *base.TaskMessage
+		processAt     time.Time
+		want          error
+		wantScheduled []h.ZSetEntry
+		wantRetry     []h.ZSetEntry
+	}{
+		{
+			scheduled:     []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			retry:         []h.ZSetEntry{},
+			key:           base.ScheduledQueue,
+			msg:           m1,
+			processAt:     now.Add(5 * time.Minute),
+			want:          nil,
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(5 * time.Minute).Unix())}},
+			wantRetry:     []h.ZSetEntry{},
+		},
+		{
+			scheduled:     []h.ZSetEntry{},
+			retry:         []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}},
+			key:           base.RetryQueue,
+			msg:           m2,
+			processAt:     now,
+			want:          nil,
+			wantScheduled: []h.ZSetEntry{},
+			wantRetry:     []h.ZSetEntry{{Msg: m2, Score: float64(now.Unix())}},
+		},
+		{
+			scheduled:     []h.ZSetEntry{},
+			retry:         []h.ZSetEntry{{Msg: &m2Stale, Score: float64(now.Add(time.Hour).Unix())}},
+			key:           base.RetryQueue,
+			msg:           m2, // task changed since read
+			processAt:     now,
+			want:          ErrTaskNotFound,
+			wantScheduled: []h.ZSetEntry{},
+			wantRetry:     []h.ZSetEntry{{Msg: &m2Stale, Score: float64(now.Add(time.Hour).Unix())}},
+		},
+		{
+			scheduled:     []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			retry:         []h.ZSetEntry{},
+			key:           base.RetryQueue, // wrong queue
+			msg:           m1,
+			processAt:     now,
+			want:          ErrTaskNotFound,
+			wantScheduled: []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}},
+			wantRetry:     []h.ZSetEntry{},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedScheduledQueue(t, r.client, tc.scheduled)
+		h.SeedRetryQueue(t, r.client, tc.retry)
+
+		got := r.RescheduleTask(tc.key, tc.msg, tc.processAt)
+		if got != tc.want {
+			t.Errorf("r.RescheduleTask(%q, %v, %v) = %v, want %v", tc.key, tc.msg, tc.processAt, got, tc.want)
+			continue
+		}
+		gotScheduled := h.GetScheduledEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.ScheduledQueue, diff)
+		}
+		gotRetry := h.GetRetryEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.RetryQueue, diff)
+		}
+	}
+}
+
+func TestRescheduleTaskUniqueLock(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.UniqueKey = "send_email:nil:default"
+
+	tests := []struct {
+		desc      string
+		lockTTL   time.Duration // TTL of the lock before rescheduling
+		processAt time.Time
+		wantTTL   time.Duration // zero if the lock is deleted
+	}{
+		{
+			desc:      "reschedule later",
+			lockTTL:   2 * time.Hour,
+			processAt: now.Add(2 * time.Hour),
+			wantTTL:   3 * time.Hour,
+		},
+		{
+			desc:      "reschedule earlier",
+			lockTTL:   2 * time.Hour,
+			processAt: now.Add(30 * time.Minute),
+			wantTTL:   90 * time.Minute,
+		},
+		{
+			desc:      "reschedule earlier than the lock allows",
+			lockTTL:   10 * time.Minute,
+			processAt: now,
+			wantTTL:   0,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedScheduledQueue(t, r.client, []h.ZSetEntry{{Msg: m1, Score: float64(now.Add(time.Hour).Unix())}})
+		r.client.Set(m1.UniqueKey, m1.ID.String(), tc.lockTTL)
+
+		if err := r.RescheduleTask(base.ScheduledQueue, m1, tc.processAt); err != nil {
+			t.Errorf("%s: r.RescheduleTask returned error: %v", tc.desc, err)
+			continue
+		}
+		gotTTL := r.client.TTL(m1.UniqueKey).Val()
+		if tc.wantTTL == 0 {
+			if r.client.Exists(m1.UniqueKey).Val() != 0 {
+				t.Errorf("%s: uniqueness lock exists with TTL %v, want deleted", tc.desc, gotTTL)
+			}
+			continue
+		}
+		if diff := tc.wantTTL - gotTTL; diff < 0 || diff > 2*time.Second {
+			t.Errorf("%s: TTL of the uniqueness lock = %v, want %v", tc.desc, gotTTL, tc.wantTTL)
+		}
+	}
+}
+
+func TestMoveTask(t *testing.T) {
+	r := setup(t)
+	m1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user@example.com"})
+	m1.UniqueKey = `send_email:{"to":"user@example.com"}:default`
+	m2 := h.NewTaskMessage("reindex", nil)
+	m3 := h.NewTaskMessageWithQueue("send_email", map[string]interface{}{"to": "user@example.com"}, "critical")
+	m3.UniqueKey = `send_email:{"to":"user@example.com"}:critical`
+
+	tests := []struct {
+		desc         string
+		enqueued     map[string][]*base.TaskMessage
+		locks        map[string]string // unique key -> task ID
+		msg          *base.TaskMessage
+		qname        string
+		want         error
+		wantEnqueued map[string][]string // queue -> IDs of the tasks
+		wantLocks    map[string]string
+	}{
+		{
+			desc: "with uniqueness lock",
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {m1, m2},
+			},
+			locks: map[string]string{m1.UniqueKey: m1.ID.String()},
+			msg:   m1,
+			qname: "critical",
+			want:  nil,
+			wantEnqueued: map[string][]string{
+				"default":  {m2.ID.String()},
+				"critical": {m1.ID.String()},
+			},
+			wantLocks: map[string]string{m3.UniqueKey: m1.ID.String()},
+		},
+		{
+			desc: "without uniqueness lock",
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {m1, m2},
+			},
+			locks: map[string]string{},
+			msg:   m2,
+			qname: "low",
+			want:  nil,
+			wantEnqueued: map[string][]string{
+				"default": {m1.ID.String()},
+				"low":     {m2.ID.String()},
+			},
+			wantLocks: map[string]string{},
+		},
+		{
+			desc: "duplicate task in the new queue",
+			enqueued: map[string][]*base.TaskMessage{
+				"default":  {m1},
+				"critical": {m3},
+			},
+			locks: map[string]string{m1.UniqueKey: m1.ID.String(), m3.UniqueKey: m3.ID.String()},
+			msg:   m1,
+			qname: "critical",
+			want:  ErrDuplicateTask,
+			wantEnqueued: map[string][]string{
+				"default":  {m1.ID.String()},
+				"critical": {m3.ID.String()},
+			},
+			wantLocks: map[string]string{m1.UniqueKey: m1.ID.String(), m3.UniqueKey: m3.ID.String()},
+		},
+		{
+			desc: "task dequeued since read",
+			enqueued: map[string][]*base.TaskMessage{
+				"default": {m2},
+			},
+			locks: map[string]string{},
+			msg:   m1,
+			qname: "critical",
+			want:  ErrTaskNotFound,
+			wantEnqueued: map[string][]string{
+				"default": {m2.ID.String()},
+			},
+			wantLocks: map[string]string{},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		for qname, msgs := range tc.enqueued {
+			h.SeedEnqueuedQueue(t, r.client, msgs, qname)
+		}
+		for key, id := range tc.locks {
+			if err := r.client.Set(key, id, time.Hour).Err(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		msg := *tc.msg
+		got := r.MoveTask(&msg, tc.qname)
+		if got != tc.want {
+			t.Errorf("%s: r.MoveTask(%v, %q) = %v, want %v", tc.desc, tc.msg, tc.qname, got, tc.want)
+			continue
+		}
+		for qname, want := range tc.wantEnqueued {
+			var gotIDs []string
+			for _, m := range h.GetEnqueuedMessages(t, r.client, qname) {
+				gotIDs = append(gotIDs, m.ID.String())
+			}
+			if diff := cmp.Diff(want, gotIDs); diff != "" {
+				t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, base.QueueKey(qname), diff)
+			}
+		}
+		gotLocks := make(map[string]string)
+		for _, key := range []string{m1.UniqueKey, m3.UniqueKey} {
+			if id, err := r.client.Get(key).Result(); err == nil {
+				gotLocks[key] = id
+			}
+		}
+		if diff := cmp.Diff(tc.wantLocks, gotLocks); diff != "" {
+			t.Errorf("%s: mismatch found in uniqueness locks; (-want, +got)\n%s", tc.desc, diff)
+		}
+		if tc.want != nil {
+			continue
+		}
+		if msg.Queue != tc.qname {
+			t.Errorf("%s: queue of the moved task = %q, want %q", tc.desc, msg.Queue, tc.qname)
+		}
+		if got := indexedKey(r, msg.ID); got != base.QueueKey(tc.qname) {
+			t.Errorf("%s: index entry for %v = %q, want %q", tc.desc, msg.ID, got, base.QueueKey(tc.qname))
+		}
+	}
+}
+
+func TestCancelTask(t *testing.T) {
+	r := setup(t)
+	now := time.Now()
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.UniqueKey = "send_email:nil:default"
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2.UniqueKey = "reindex:nil:default"
+	m2.PayloadRef = m2.ID.String()
+	m3 := h.NewTaskMessage("sync", nil)
+
+	tests := []struct {
+		desc          string
+		key           string
+		msg           *base.TaskMessage
+		lock          string // owner of the uniqueness lock of msg
+		want          error
+		wantEnqueued  []*base.TaskMessage
+		wantScheduled []*base.TaskMessage
+		wantLock      bool
+		wantGarbage   []string
+	}{
+		{
+			desc:          "enqueued task",
+			key:           base.DefaultQueue,
+			msg:           m1,
+			lock:          m1.ID.String(),
+			want:          nil,
+			wantEnqueued:  []*base.TaskMessage{m3},
+			wantScheduled: []*base.TaskMessage{m2},
+			wantLock:      false,
+			wantGarbage:   []string{},
+		},
+		{
+			desc:          "scheduled task with offloaded payload",
+			key:           base.ScheduledQueue,
+			msg:           m2,
+			lock:          m2.ID.String(),
+			want:          nil,
+			wantEnqueued:  []*base.TaskMessage{m1, m3},
+			wantScheduled: []*base.TaskMessage{},
+			wantLock:      false,
+			wantGarbage:   []string{m2.PayloadRef},
+		},
+		{
+			desc:          "lock held by another task",
+			key:           base.DefaultQueue,
+			msg:           m1,
+			lock:          xid.New().String(),
+			want:          nil,
+			wantEnqueued:  []*base.TaskMessage{m3},
+			wantScheduled: []*base.TaskMessage{m2},
+			wantLock:      true,
+			wantGarbage:   []string{},
+		},
+		{
+			desc:          "task not in queue",
+			key:           base.ScheduledQueue,
+			msg:           m1,
+			lock:          m1.ID.String(),
+			want:          ErrTaskNotFound,
+			wantEnqueued:  []*base.TaskMessage{m1, m3},
+			wantScheduled: []*base.TaskMessage{m2},
+			wantLock:      true,
+			wantGarbage:   []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedEnqueuedQueue(t, r.client, []*base.TaskMessage{m1, m3})
+		h.SeedScheduledQueue(t, r.client, []h.ZSetEntry{{Msg: m2, Score: float64(now.Add(time.Hour).Unix())}})
+		if err := r.client.Set(tc.msg.UniqueKey, tc.lock, time.Hour).Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		got := r.CancelTask(tc.key, tc.msg)
+		if got != tc.want {
+			t.Errorf("%s: r.CancelTask(%q, %v) = %v, want %v", tc.desc, tc.key, tc.msg, got, tc.want)
+			continue
+		}
+		gotEnqueued := h.GetEnqueuedMessages(t, r.client)
+		if diff := cmp.Diff(tc.wantEnqueued, gotEnqueued, h.SortMsgOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, base.DefaultQueue, diff)
+		}
+		gotScheduled := h.GetScheduledMessages(t, r.client)
+		if diff := cmp.Diff(tc.wantScheduled, gotScheduled, h.SortMsgOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, base.ScheduledQueue, diff)
+		}
+		if gotLock := r.client.Exists(tc.msg.UniqueKey).Val() == 1; gotLock != tc.wantLock {
+			t.Errorf("%s: uniqueness lock exists = %t, want %t", tc.desc, gotLock, tc.wantLock)
+		}
+		gotGarbage := r.client.SMembers(base.BlobGarbage).Val()
+		if diff := cmp.Diff(tc.wantGarbage, gotGarbage); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want, +got)\n%s", tc.desc, base.BlobGarbage, diff)
+		}
+	}
+}
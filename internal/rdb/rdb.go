@@ -9,6 +9,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -25,90 +27,314 @@ var (
 
 	// ErrDuplicateTask indicates that another task with the same unique key holds the uniqueness lock.
 	ErrDuplicateTask = errors.New("task already exists")
+
+	// ErrQueueFull indicates that the queue has reached its maximum size.
+	ErrQueueFull = errors.New("queue is full")
+
+	// ErrQueueBlocked indicates that the queue has reached its maximum size
+	// and the enqueue should be retried once there is room in the queue.
+	ErrQueueBlocked = fmt.Errorf("%w; enqueue blocks until there is room", ErrQueueFull)
 )
 
 const statsTTL = 90 * 24 * time.Hour // 90 days
 
+// Maximum number of wait times of recently dequeued tasks kept per queue.
+const maxWaitTimes = 1000
+
+// Default retention of the per-queue and per-task-type processing stats.
+var defaultStatsRetention = map[base.Granularity]time.Duration{
+	base.Minute: 24 * time.Hour,
+	base.Hour:   7 * 24 * time.Hour,
+	base.Day:    statsTTL,
+}
+
 // RDB is a client interface to query and mutate task queues.
 type RDB struct {
 	client *redis.Client
+
+	// keys holds the redis keys of the namespace.
+	keys *base.Keys
+
+	// statsRetention is the retention of the processing stats
+	// for each enabled granularity.
+	statsRetention map[base.Granularity]time.Duration
 }
 
 // NewRDB returns a new instance of RDB.
 func NewRDB(client *redis.Client) *RDB {
-	return &RDB{client}
+	retention := make(map[base.Granularity]time.Duration)
+	for g, d := range defaultStatsRetention {
+		retention[g] = d
+	}
+	return &RDB{client: client, keys: base.DefaultKeys, statsRetention: retention}
+}
+
+// SetNamespace sets the namespace of the redis keys used by r.
+// An empty namespace means the default namespace.
+//
+// It's not safe to call SetNamespace concurrently with other methods.
+func (r *RDB) SetNamespace(ns string) {
+	r.keys = base.NewKeys(ns)
 }
 
+// Keys returns the redis keys of the namespace used by r.
+func (r *RDB) Keys() *base.Keys {
+	return r.keys
+}
+
+// SetStatsRetention sets how long the processing stats of granularity g
+// are kept after the end of their time bucket.
+// Zero means the default retention, negative disables the stats of g.
+//
+// It's not safe to call SetStatsRetention concurrently with other methods.
+func (r *RDB) SetStatsRetention(g base.Granularity, d time.Duration) {
+	switch {
+	case d == 0:
+		r.statsRetention[g] = defaultStatsRetention[g]
+	case d < 0:
+		delete(r.statsRetention, g)
+	default:
+		r.statsRetention[g] = d
+	}
+}
+
+// statsArgs returns the keys of the enabled processing stats buckets
+// containing t, and the expiration timestamps of the keys.
+func (r *RDB) statsArgs(t time.Time) (keys []string, expireAts []interface{}) {
+	for _, g := range base.Granularities {
+		d, ok := r.statsRetention[g]
+		if !ok {
+			continue
+		}
+		end := t.UTC().Truncate(g.Duration()).Add(g.Duration())
+		keys = append(keys, r.keys.StatsKey(g, t))
+		expireAts = append(expireAts, end.Add(d).Unix())
+	}
+	return keys, expireAts
+}
+
+// incrStatsFn is a lua snippet which defines a function to increment
+// the given counters of the queue and the task type in the processing
+// stats buckets.
+// The bucket keys are KEYS[firstKey] to the last key, and their
+// expiration timestamps are the ARGV values starting at ARGV[firstArg].
+//
+// incrStats(<index of first bucket key>, <index of first expiration timestamp>, <qname>, <task type>, <counter names>)
+const incrStatsFn = `
+local function incrStats(firstKey, firstArg, qname, tname, counters)
+	for i = firstKey, table.getn(KEYS) do
+		for _, c in ipairs(counters) do
+			redis.call("HINCRBY", KEYS[i], c .. ":queue:" .. qname, 1)
+			redis.call("HINCRBY", KEYS[i], c .. ":type:" .. tname, 1)
+		end
+		redis.call("EXPIREAT", KEYS[i], ARGV[firstArg + i - firstKey])
+	end
+end
+`
+
 // Close closes the connection with redis server.
 func (r *RDB) Close() error {
 	return r.client.Close()
 }
 
+// checkQueueLimitFn is a lua snippet which defines a function to check the
+// size of a queue against its limit in asynq:queue_limits before a task is
+// pushed to the queue. It returns 0 if the task can be pushed, -1 if the
+// queue is full and rejects tasks, or -2 if the queue is full and blocks.
+// Under the drop_oldest policy, the oldest tasks of the lowest priority are
+// deleted to make room, releasing their uniqueness locks and marking their
+// payload blobs for garbage collection.
+// Only new tasks are checked against the limit: tasks moved to the queue
+// from the scheduled, retry or dead queue are pushed even if the queue
+// is full, so that they are never dropped or held back.
+// It requires collectBlobFn and queueKeysFn.
+//
+// checkQueueLimit(<asynq:queues:<qname>>, <asynq:queue_limits>, <asynq:blob_garbage>, <asynq:task_index>)
+var checkQueueLimitFn = fmt.Sprintf(`
+local function checkQueueLimit(queue, limits, garbage, index)
+	local limit = redis.call("HGET", limits, queue)
+	if not limit then
+		return 0
+	end
+	limit = cjson.decode(limit)
+	local max = tonumber(limit["MaxSize"])
+	local keys = queueKeys(queue)
+	local n = 0
+	for _, key in ipairs(keys) do
+		n = n + redis.call("LLEN", key)
+	end
+	if n < max then
+		return 0
+	end
+	if limit["Policy"] == %q then
+		return -2
+	elseif limit["Policy"] ~= %q then
+		return -1
+	end
+	local i = table.getn(keys)
+	while n >= max do
+		local msg = redis.call("RPOP", keys[i])
+		if msg then
+			local decoded = cjson.decode(msg)
+			local ukey = decoded["UniqueKey"]
+			if type(ukey) == "string" and string.len(ukey) > 0 and redis.call("GET", ukey) == decoded["ID"] then
+				redis.call("DEL", ukey)
+			end
+			collectBlob(msg, garbage)
+			redis.call("HDEL", index, decoded["ID"])
+			n = n - 1
+		else
+			i = i - 1
+		end
+	end
+	return 0
+end
+`, base.QueueFullBlock, base.QueueFullDropOldest)
+
 // KEYS[1] -> asynq:queues:<qname>
 // KEYS[2] -> asynq:queues
+// KEYS[3] -> asynq:task_index
+// KEYS[4] -> asynq:queue_limits
+// KEYS[5] -> asynq:blob_garbage
+// KEYS[6] -> list for the priority of the task in asynq:queues:<qname>
 // ARGV[1] -> task message data
-var enqueueCmd = redis.NewScript(`
-redis.call("LPUSH", KEYS[1], ARGV[1])
+// ARGV[2] -> task ID
+var enqueueCmd = redis.NewScript(indexTaskFn + collectBlobFn + queueKeysFn + checkQueueLimitFn + `
+local res = checkQueueLimit(KEYS[1], KEYS[4], KEYS[5], KEYS[3])
+if res ~= 0 then
+	return res
+end
+redis.call("LPUSH", KEYS[6], ARGV[1])
 redis.call("SADD", KEYS[2], KEYS[1])
+indexTask(KEYS[3], ARGV[2], KEYS[6])
 return 1`)
 
-// Enqueue inserts the given task to the tail of the queue.
+// Enqueue inserts the given task to the tail of the queue, behind the
+// tasks of the same priority.
+// It sets msg.EnqueuedAt to the current time.
+//
+// If the queue is full, it returns ErrQueueFull, or ErrQueueBlocked if
+// the limit of the queue tells enqueue to block.
 func (r *RDB) Enqueue(msg *base.TaskMessage) error {
+	msg.EnqueuedAt = time.Now().UnixNano()
 	bytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	key := base.QueueKey(msg.Queue)
-	return enqueueCmd.Run(r.client, []string{key, base.AllQueues}, bytes).Err()
+	keys := []string{r.keys.QueueKey(msg.Queue), r.keys.AllQueues, r.keys.TaskIndex, r.keys.QueueLimits, r.keys.BlobGarbage,
+		r.keys.PriorityQueueKey(msg.Queue, msg.Priority)}
+	res, err := enqueueCmd.Run(r.client, keys, bytes, msg.ID.String()).Result()
+	if err != nil {
+		return err
+	}
+	return checkEnqueued(res)
 }
 
 // KEYS[1] -> unique key in the form <type>:<payload>:<qname>
 // KEYS[2] -> asynq:queues:<qname>
-// KEYS[2] -> asynq:queues
+// KEYS[3] -> asynq:queues
+// KEYS[4] -> asynq:task_index
+// KEYS[5] -> asynq:queue_limits
+// KEYS[6] -> asynq:blob_garbage
+// KEYS[7] -> list for the priority of the task in asynq:queues:<qname>
 // ARGV[1] -> task ID
 // ARGV[2] -> uniqueness lock TTL
 // ARGV[3] -> task message data
-var enqueueUniqueCmd = redis.NewScript(`
-local ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "EX", ARGV[2])
-if not ok then
-  return 0
+var enqueueUniqueCmd = redis.NewScript(indexTaskFn + collectBlobFn + queueKeysFn + checkQueueLimitFn + `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local res = checkQueueLimit(KEYS[2], KEYS[5], KEYS[6], KEYS[4])
+if res ~= 0 then
+	return res
 end
-redis.call("LPUSH", KEYS[2], ARGV[3])
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+redis.call("LPUSH", KEYS[7], ARGV[3])
 redis.call("SADD", KEYS[3], KEYS[2])
+indexTask(KEYS[4], ARGV[1], KEYS[7])
 return 1
 `)
 
 // EnqueueUnique inserts the given task if the task's uniqueness lock can be acquired.
 // It returns ErrDuplicateTask if the lock cannot be acquired.
+// It sets msg.EnqueuedAt to the current time.
+//
+// Like Enqueue, it returns ErrQueueFull or ErrQueueBlocked if the queue is full.
 func (r *RDB) EnqueueUnique(msg *base.TaskMessage, ttl time.Duration) error {
+	msg.EnqueuedAt = time.Now().UnixNano()
 	bytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	key := base.QueueKey(msg.Queue)
-	res, err := enqueueUniqueCmd.Run(r.client,
-		[]string{msg.UniqueKey, key, base.AllQueues},
-		msg.ID.String(), int(ttl.Seconds()), bytes).Result()
+	keys := []string{msg.UniqueKey, r.keys.QueueKey(msg.Queue), r.keys.AllQueues, r.keys.TaskIndex, r.keys.QueueLimits, r.keys.BlobGarbage,
+		r.keys.PriorityQueueKey(msg.Queue, msg.Priority)}
+	res, err := enqueueUniqueCmd.Run(r.client, keys, msg.ID.String(), int(ttl.Seconds()), bytes).Result()
 	if err != nil {
 		return err
 	}
+	return checkEnqueued(res)
+}
+
+// checkEnqueued returns the error for the result of an enqueue script.
+func checkEnqueued(res interface{}) error {
 	n, ok := res.(int64)
 	if !ok {
 		return fmt.Errorf("could not cast %v to int64", res)
 	}
-	if n == 0 {
+	switch n {
+	case 0:
 		return ErrDuplicateTask
+	case -1:
+		return ErrQueueFull
+	case -2:
+		return ErrQueueBlocked
 	}
 	return nil
 }
 
+// SetQueueLimit sets the limit of the given queue.
+func (r *RDB) SetQueueLimit(qname string, limit *base.QueueLimit) error {
+	if strings.ToLower(qname) == base.WebhookQueueName {
+		return fmt.Errorf("queue %q is an internal queue which cannot be limited", qname)
+	}
+	bytes, err := json.Marshal(limit)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(r.keys.QueueLimits, r.keys.QueueKey(qname), bytes).Err()
+}
+
+// ClearQueueLimit deletes the limit of the given queue.
+func (r *RDB) ClearQueueLimit(qname string) error {
+	return r.client.HDel(r.keys.QueueLimits, r.keys.QueueKey(qname)).Err()
+}
+
+// QueueLimits returns the limits of the queues by queue name.
+func (r *RDB) QueueLimits() (map[string]*base.QueueLimit, error) {
+	data, err := r.client.HGetAll(r.keys.QueueLimits).Result()
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]*base.QueueLimit)
+	for key, val := range data {
+		var limit base.QueueLimit
+		if err := json.Unmarshal([]byte(val), &limit); err != nil {
+			return nil, err
+		}
+		limits[strings.TrimPrefix(key, r.keys.QueuePrefix)] = &limit
+	}
+	return limits, nil
+}
+
 // Dequeue queries given queues in order and pops a task message if there is one and returns it.
+// Within a queue, the task of the highest priority is returned.
 // Dequeue skips a queue if the queue is paused.
+// Expired tasks are moved to the dead queue instead of being returned.
 // If all queues are empty, ErrNoProcessableTask error is returned.
 func (r *RDB) Dequeue(qnames ...string) (*base.TaskMessage, error) {
 	var qkeys []interface{}
 	for _, q := range qnames {
-		qkeys = append(qkeys, base.QueueKey(q))
+		qkeys = append(qkeys, r.keys.QueueKey(q))
 	}
 	data, err := r.dequeue(qkeys...)
 	if err == redis.Nil {
@@ -127,38 +353,101 @@ func (r *RDB) Dequeue(qnames ...string) (*base.TaskMessage, error) {
 
 // KEYS[1] -> asynq:in_progress
 // KEYS[2] -> asynq:paused
-// ARGV    -> List of queues to query in order
+// KEYS[3] -> asynq:dead
+// KEYS[4] -> asynq:expired:<yyyy-mm-dd>
+// KEYS[5] -> asynq:blob_garbage
+// KEYS[6] -> asynq:dead_retention
+// KEYS[7] -> asynq:dead_evicted
+// KEYS[8] -> asynq:task_index
+// ARGV[1]  -> current unix time
+// ARGV[2]  -> stats expiration timestamp
+// ARGV[3]  -> current time in unix nanoseconds
+// ARGV[4]  -> wait times key prefix
+// ARGV[5]  -> max number of wait times to keep per queue
+// ARGV[6:] -> List of queues to query in order
 //
 // dequeueCmd checks whether a queue is paused first, before
-// calling RPOPLPUSH to pop a task from the queue.
-var dequeueCmd = redis.NewScript(`
-for _, qkey in ipairs(ARGV) do
+// popping a task from the queue and pushing it to the in-progress list.
+// Tasks of higher priority are popped first.
+// Expired tasks are moved to the dead queue instead.
+// The time the task waited in the queue is recorded in microseconds.
+// Note: Script moves up to 100 expired tasks at a time to keep the runtime
+// of script short, and returns 0 once the limit is reached so that the
+// caller runs it again.
+var dequeueCmd = redis.NewScript(indexTaskFn + messageFieldFn + collectBlobFn + trimDeadFn + expireTaskFn + queueKeysFn + `
+local expired = 0
+for i = 6, table.getn(ARGV) do
+	local qkey = ARGV[i]
 	if redis.call("SISMEMBER", KEYS[2], qkey) == 0 then
-		local res = redis.call("RPOPLPUSH", qkey, KEYS[1])
-		if res then
-			return res
+		for _, key in ipairs(queueKeys(qkey)) do
+			local res = redis.call("RPOP", key)
+			while res do
+				if not expireTask(res, ARGV[1], KEYS[3], KEYS[4], ARGV[2], KEYS[8]) then
+					if expired > 0 then
+						trimDead(KEYS[3], ARGV[1], KEYS[5], KEYS[6], KEYS[7], KEYS[8])
+					end
+					redis.call("LPUSH", KEYS[1], res)
+					local decoded = cjson.decode(res)
+					indexTask(KEYS[8], decoded["ID"], KEYS[1])
+					local enqueuedAt = string.match(res, '"EnqueuedAt":(%d+)}$')
+					if enqueuedAt then
+						local wkey = ARGV[4] .. string.lower(decoded["Queue"])
+						local wait = math.max(0, math.floor((tonumber(ARGV[3]) - tonumber(enqueuedAt)) / 1000))
+						redis.call("LPUSH", wkey, string.format("%d", wait))
+						redis.call("LTRIM", wkey, 0, tonumber(ARGV[5]) - 1)
+					end
+					return res
+				end
+				expired = expired + 1
+				if expired >= 100 then
+					trimDead(KEYS[3], ARGV[1], KEYS[5], KEYS[6], KEYS[7], KEYS[8])
+					return 0
+				end
+				res = redis.call("RPOP", key)
+			end
 		end
 	end
 end
+if expired > 0 then
+	trimDead(KEYS[3], ARGV[1], KEYS[5], KEYS[6], KEYS[7], KEYS[8])
+end
 return nil`)
 
 func (r *RDB) dequeue(qkeys ...interface{}) (data string, err error) {
-	res, err := dequeueCmd.Run(r.client,
-		[]string{base.InProgressQueue, base.PausedQueues}, qkeys...).Result()
-	if err != nil {
-		return "", err
+	for {
+		now := time.Now()
+		args := []interface{}{now.Unix(), now.Add(statsTTL).Unix(),
+			strconv.FormatInt(now.UnixNano(), 10), r.keys.WaitTimesPrefix, maxWaitTimes}
+		res, err := dequeueCmd.Run(r.client,
+			[]string{r.keys.InProgressQueue, r.keys.PausedQueues, r.keys.DeadQueue, r.keys.ExpiredKey(now),
+				r.keys.BlobGarbage, r.keys.DeadRetention, r.keys.DeadEvicted, r.keys.TaskIndex},
+			append(args, qkeys...)...).Result()
+		if err != nil {
+			return "", err
+		}
+		if n, ok := res.(int64); ok && n == 0 {
+			// The script moved expired tasks and stopped; the queues may have more tasks.
+			continue
+		}
+		return cast.ToStringE(res)
 	}
-	return cast.ToStringE(res)
 }
 
 // KEYS[1] -> asynq:in_progress
 // KEYS[2] -> asynq:processed:<yyyy-mm-dd>
 // KEYS[3] -> unique key in the format <type>:<payload>:<qname>
+// KEYS[4] -> asynq:blob_garbage
+// KEYS[5] -> asynq:task_index
+// KEYS[6:] -> asynq:stats:<granularity>:<bucket>
 // ARGV[1] -> base.TaskMessage value
 // ARGV[2] -> stats expiration timestamp
 // ARGV[3] -> task ID
+// ARGV[4] -> payload blob key
+// ARGV[5] -> queue name
+// ARGV[6] -> task type
+// ARGV[7:] -> expiration timestamps of the stats buckets
 // Note: LREM count ZERO means "remove all elements equal to val"
-var doneCmd = redis.NewScript(`
+var doneCmd = redis.NewScript(incrStatsFn + `
 local x = redis.call("LREM", KEYS[1], 0, ARGV[1]) 
 if x == 0 then
   return redis.error_reply("NOT FOUND")
@@ -167,34 +456,44 @@ local n = redis.call("INCR", KEYS[2])
 if tonumber(n) == 1 then
 	redis.call("EXPIREAT", KEYS[2], ARGV[2])
 end
+incrStats(6, 7, ARGV[5], ARGV[6], {"processed"})
 if string.len(KEYS[3]) > 0 and redis.call("GET", KEYS[3]) == ARGV[3] then
   redis.call("DEL", KEYS[3])
 end
+if string.len(ARGV[4]) > 0 then
+  redis.call("SADD", KEYS[4], ARGV[4])
+end
+redis.call("HDEL", KEYS[5], ARGV[3])
 return redis.status_reply("OK")
 `)
 
 // Done removes the task from in-progress queue to mark the task as done.
-// It removes a uniqueness lock acquired by the task, if any.
+// It removes a uniqueness lock acquired by the task, if any, and marks
+// the payload blob of the task, if any, for garbage collection.
 func (r *RDB) Done(msg *base.TaskMessage) error {
 	bytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 	now := time.Now()
-	processedKey := base.ProcessedKey(now)
+	processedKey := r.keys.ProcessedKey(now)
 	expireAt := now.Add(statsTTL)
-	return doneCmd.Run(r.client,
-		[]string{base.InProgressQueue, processedKey, msg.UniqueKey},
-		bytes, expireAt.Unix(), msg.ID.String()).Err()
+	statsKeys, statsExpireAts := r.statsArgs(now)
+	keys := append([]string{r.keys.InProgressQueue, processedKey, msg.UniqueKey, r.keys.BlobGarbage, r.keys.TaskIndex}, statsKeys...)
+	args := []interface{}{bytes, expireAt.Unix(), msg.ID.String(), msg.PayloadRef, msg.Queue, msg.Type}
+	return doneCmd.Run(r.client, keys, append(args, statsExpireAts...)...).Err()
 }
 
 // KEYS[1] -> asynq:in_progress
-// KEYS[2] -> asynq:queues:<qname>
+// KEYS[2] -> list for the priority of the task in asynq:queues:<qname>
+// KEYS[3] -> asynq:task_index
 // ARGV[1] -> base.TaskMessage value
+// ARGV[2] -> task ID
 // Note: Use RPUSH to push to the head of the queue.
-var requeueCmd = redis.NewScript(`
+var requeueCmd = redis.NewScript(indexTaskFn + `
 redis.call("LREM", KEYS[1], 0, ARGV[1])
 redis.call("RPUSH", KEYS[2], ARGV[1])
+indexTask(KEYS[3], ARGV[2], KEYS[2])
 return redis.status_reply("OK")`)
 
 // Requeue moves the task from in-progress queue to the specified queue.
@@ -204,18 +503,21 @@ func (r *RDB) Requeue(msg *base.TaskMessage) error {
 		return err
 	}
 	return requeueCmd.Run(r.client,
-		[]string{base.InProgressQueue, base.QueueKey(msg.Queue)},
-		string(bytes)).Err()
+		[]string{r.keys.InProgressQueue, r.keys.PriorityQueueKey(msg.Queue, msg.Priority), r.keys.TaskIndex},
+		string(bytes), msg.ID.String()).Err()
 }
 
 // KEYS[1] -> asynq:scheduled
 // KEYS[2] -> asynq:queues
+// KEYS[3] -> asynq:task_index
 // ARGV[1] -> score (process_at timestamp)
 // ARGV[2] -> task message
 // ARGV[3] -> queue key
-var scheduleCmd = redis.NewScript(`
+// ARGV[4] -> task ID
+var scheduleCmd = redis.NewScript(indexTaskFn + `
 redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
 redis.call("SADD", KEYS[2], ARGV[3])
+indexTask(KEYS[3], ARGV[4], KEYS[1], ARGV[1])
 return 1
 `)
 
@@ -225,28 +527,30 @@ func (r *RDB) Schedule(msg *base.TaskMessage, processAt time.Time) error {
 	if err != nil {
 		return err
 	}
-	qkey := base.QueueKey(msg.Queue)
+	qkey := r.keys.QueueKey(msg.Queue)
 	score := float64(processAt.Unix())
 	return scheduleCmd.Run(r.client,
-		[]string{base.ScheduledQueue, base.AllQueues},
-		score, bytes, qkey).Err()
+		[]string{r.keys.ScheduledQueue, r.keys.AllQueues, r.keys.TaskIndex},
+		score, bytes, qkey, msg.ID.String()).Err()
 }
 
 // KEYS[1] -> unique key in the format <type>:<payload>:<qname>
 // KEYS[2] -> asynq:scheduled
 // KEYS[3] -> asynq:queues
+// KEYS[4] -> asynq:task_index
 // ARGV[1] -> task ID
 // ARGV[2] -> uniqueness lock TTL
 // ARGV[3] -> score (process_at timestamp)
 // ARGV[4] -> task message
 // ARGV[5] -> queue key
-var scheduleUniqueCmd = redis.NewScript(`
+var scheduleUniqueCmd = redis.NewScript(indexTaskFn + `
 local ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "EX", ARGV[2])
 if not ok then
   return 0
 end
 redis.call("ZADD", KEYS[2], ARGV[3], ARGV[4])
 redis.call("SADD", KEYS[3], ARGV[5])
+indexTask(KEYS[4], ARGV[1], KEYS[2], ARGV[3])
 return 1
 `)
 
@@ -257,10 +561,10 @@ func (r *RDB) ScheduleUnique(msg *base.TaskMessage, processAt time.Time, ttl tim
 	if err != nil {
 		return err
 	}
-	qkey := base.QueueKey(msg.Queue)
+	qkey := r.keys.QueueKey(msg.Queue)
 	score := float64(processAt.Unix())
 	res, err := scheduleUniqueCmd.Run(r.client,
-		[]string{msg.UniqueKey, base.ScheduledQueue, base.AllQueues},
+		[]string{msg.UniqueKey, r.keys.ScheduledQueue, r.keys.AllQueues, r.keys.TaskIndex},
 		msg.ID.String(), int(ttl.Seconds()), score, bytes, qkey).Result()
 	if err != nil {
 		return err
@@ -279,16 +583,23 @@ func (r *RDB) ScheduleUnique(msg *base.TaskMessage, processAt time.Time, ttl tim
 // KEYS[2] -> asynq:retry
 // KEYS[3] -> asynq:processed:<yyyy-mm-dd>
 // KEYS[4] -> asynq:failure:<yyyy-mm-dd>
-// ARGV[1] -> base.TaskMessage value to remove from base.InProgressQueue queue
+// KEYS[5] -> asynq:task_index
+// KEYS[6:] -> asynq:stats:<granularity>:<bucket>
+// ARGV[1] -> base.TaskMessage value to remove from r.keys.InProgressQueue queue
 // ARGV[2] -> base.TaskMessage value to add to Retry queue
 // ARGV[3] -> retry_at UNIX timestamp
 // ARGV[4] -> stats expiration timestamp
-var retryCmd = redis.NewScript(`
+// ARGV[5] -> task ID
+// ARGV[6] -> queue name
+// ARGV[7] -> task type
+// ARGV[8:] -> expiration timestamps of the stats buckets
+var retryCmd = redis.NewScript(indexTaskFn + incrStatsFn + `
 local x = redis.call("LREM", KEYS[1], 0, ARGV[1])
 if x == 0 then
   return redis.error_reply("NOT FOUND")
 end
 redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+indexTask(KEYS[5], ARGV[5], KEYS[2], ARGV[3])
 local n = redis.call("INCR", KEYS[3])
 if tonumber(n) == 1 then
 	redis.call("EXPIREAT", KEYS[3], ARGV[4])
@@ -297,6 +608,7 @@ local m = redis.call("INCR", KEYS[4])
 if tonumber(m) == 1 then
 	redis.call("EXPIREAT", KEYS[4], ARGV[4])
 end
+incrStats(6, 8, ARGV[6], ARGV[7], {"processed", "failed", "retried"})
 return redis.status_reply("OK")`)
 
 // Retry moves the task from in-progress to retry queue, incrementing retry count
@@ -314,45 +626,250 @@ func (r *RDB) Retry(msg *base.TaskMessage, processAt time.Time, errMsg string) e
 		return err
 	}
 	now := time.Now()
-	processedKey := base.ProcessedKey(now)
-	failureKey := base.FailureKey(now)
+	processedKey := r.keys.ProcessedKey(now)
+	failureKey := r.keys.FailureKey(now)
 	expireAt := now.Add(statsTTL)
-	return retryCmd.Run(r.client,
-		[]string{base.InProgressQueue, base.RetryQueue, processedKey, failureKey},
-		string(bytesToRemove), string(bytesToAdd), processAt.Unix(), expireAt.Unix()).Err()
+	statsKeys, statsExpireAts := r.statsArgs(now)
+	keys := append([]string{r.keys.InProgressQueue, r.keys.RetryQueue, processedKey, failureKey, r.keys.TaskIndex}, statsKeys...)
+	args := []interface{}{string(bytesToRemove), string(bytesToAdd), processAt.Unix(), expireAt.Unix(),
+		msg.ID.String(), msg.Queue, msg.Type}
+	return retryCmd.Run(r.client, keys, append(args, statsExpireAts...)...).Err()
 }
 
+// Default limits of the dead queue.
+// Note: The dead queue keeps up to maxDeadTasks tasks; versions before
+// the limits became configurable kept one task less.
 const (
 	maxDeadTasks         = 10000
 	deadExpirationInDays = 90
 )
 
+// collectBlobFn is a lua snippet which defines a function to mark the payload
+// blob of a task message, if any, for garbage collection.
+//
+// collectBlob(<task message>, <asynq:blob_garbage>)
+const collectBlobFn = `
+local function collectBlob(msg, garbage)
+	local ref = cjson.decode(msg)["PayloadRef"]
+	if ref then
+		redis.call("SADD", garbage, ref)
+	end
+end
+`
+
+// indexTaskFn is a lua snippet which defines a function to record the key
+// holding a task in asynq:task_index. For tasks in a zset, the score of the
+// task is recorded after the key, separated by a newline, so that the task
+// can be read by ID without scanning the zset.
+//
+// indexTask(<asynq:task_index>, <task ID>, <key>, <score of the task in a zset or nil>)
+const indexTaskFn = `
+local function indexTask(index, id, key, score)
+	if score then
+		redis.call("HSET", index, id, key .. "\n" .. score)
+	else
+		redis.call("HSET", index, id, key)
+	end
+end
+`
+
+// queueKeyFn is a lua snippet which defines a function to return the key of
+// the list for the given task message in its queue, given the queue key prefix.
+// Tasks of priority zero are kept in the queue key, others in the key for
+// their priority, like r.keys.PriorityQueueKey.
+//
+// queueKey(<asynq:queues:>, <decoded task message>)
+const queueKeyFn = `
+local function queueKey(prefix, decoded)
+	local qname = string.lower(decoded["Queue"])
+	local priority = tonumber(decoded["Priority"])
+	if priority and priority > 0 then
+		return string.sub(prefix, 1, -8) .. "prio:" .. priority .. ":" .. qname
+	end
+	return prefix .. qname
+end
+`
+
+// queueKeysFn is a lua snippet which defines a function to return the keys
+// of the lists for all priorities in the given queue, from the highest
+// priority to the lowest, like r.keys.PriorityQueueKeys.
+// The namespace is the part of the queue key before the first ":queues:".
+//
+// queueKeys(<asynq:queues:<qname>>)
+var queueKeysFn = fmt.Sprintf(`
+local function queueKeys(qkey)
+	local i, j = string.find(qkey, ":queues:", 1, true)
+	local prefix = string.sub(qkey, 1, i) .. "prio:"
+	local qname = string.sub(qkey, j + 1)
+	local keys = {}
+	for p = %d, 1, -1 do
+		table.insert(keys, prefix .. p .. ":" .. qname)
+	end
+	table.insert(keys, qkey)
+	return keys
+end
+`, base.MaxPriority)
+
+// trimDeadFn is a lua snippet which defines a function to trim the dead queue
+// by timestamp and set size. The limits are read from asynq:dead_retention,
+// falling back to the default limits.
+// Trimmed tasks are pushed to asynq:dead_evicted if archival is enabled,
+// otherwise their payload blobs are marked for garbage collection.
+// Trimmed tasks are removed from asynq:task_index.
+// It requires collectBlobFn.
+//
+// trimDead(<asynq:dead>, <current unix time>, <asynq:blob_garbage>, <asynq:dead_retention>, <asynq:dead_evicted>, <asynq:task_index>)
+var trimDeadFn = fmt.Sprintf(`
+local function trimDead(dead, now, garbage, retention, evicted, index)
+	local max, maxAge, archive = %d, %d, false
+	local cfg = redis.call("GET", retention)
+	if cfg then
+		cfg = cjson.decode(cfg)
+		if cfg["MaxTasks"] then
+			max = tonumber(cfg["MaxTasks"])
+		end
+		if cfg["MaxAge"] then
+			maxAge = tonumber(cfg["MaxAge"])
+		end
+		archive = cfg["Archive"] == true
+	end
+	local function evict(entries)
+		for i = 1, table.getn(entries), 2 do
+			redis.call("HDEL", index, cjson.decode(entries[i])["ID"])
+			if archive then
+				redis.call("RPUSH", evicted, '{"DiedAt":' .. entries[i+1] .. ',"Message":' .. entries[i] .. '}')
+			else
+				collectBlob(entries[i], garbage)
+			end
+		end
+	end
+	if maxAge > 0 then
+		local cutoff = tonumber(now) - maxAge
+		evict(redis.call("ZRANGEBYSCORE", dead, "-inf", cutoff, "WITHSCORES"))
+		redis.call("ZREMRANGEBYSCORE", dead, "-inf", cutoff)
+	end
+	if max > 0 then
+		evict(redis.call("ZRANGE", dead, 0, -max-1, "WITHSCORES"))
+		redis.call("ZREMRANGEBYRANK", dead, 0, -max-1)
+	end
+end
+`, maxDeadTasks, deadExpirationInDays*24*60*60)
+
+// messageFieldFn is a lua snippet which defines functions to update fields
+// of an encoded task message in place, like setEnqueuedAtFn, since
+// re-encoding the message with cjson loses the precision of large numbers
+// such as EnqueuedAt.
+// Fields are found by their last occurrence in the message, since the
+// payload, which may have keys of the same names, is encoded before them.
+//
+// setErrorMsg(<task message>, <error message encoded as a JSON string without quotes>)
+// clearExpireAt(<task message>, <current unix time>) removes ExpireAt if it has passed.
+const messageFieldFn = `
+local function findLast(msg, s)
+	local i
+	local j = 0
+	while true do
+		local k = string.find(msg, s, j + 1, true)
+		if not k then
+			return i
+		end
+		i, j = k, k
+	end
+end
+
+local function setErrorMsg(msg, errMsg)
+	local key = '"ErrorMsg":"'
+	local i = findLast(msg, key)
+	if not i then
+		return msg
+	end
+	local j = i + string.len(key)
+	local k = j
+	while string.sub(msg, k, k) ~= '"' do
+		if string.sub(msg, k, k) == "\\" then
+			k = k + 1
+		end
+		k = k + 1
+	end
+	return string.sub(msg, 1, j - 1) .. errMsg .. string.sub(msg, k)
+end
+
+local function clearExpireAt(msg, now)
+	local i = findLast(msg, ',"ExpireAt":')
+	if not i then
+		return msg
+	end
+	local expireAt, j = string.match(msg, '^,"ExpireAt":(%d+)()', i)
+	if not expireAt or tonumber(expireAt) > tonumber(now) then
+		return msg
+	end
+	return string.sub(msg, 1, i - 1) .. string.sub(msg, j)
+end
+`
+
+// expireTaskFn is a lua snippet which defines a function to move the given
+// task message to the dead queue if the task has expired.
+// Tasks without ExpireAt never expire.
+// It reports whether the task has expired.
+// It requires indexTaskFn and messageFieldFn.
+//
+// expireTask(<task message>, <current unix time>, <asynq:dead>, <asynq:expired:<yyyy-mm-dd>>, <stats expiration timestamp>, <asynq:task_index>)
+const expireTaskFn = `
+local function expireTask(msg, now, dead, counter, statsExpireAt, index)
+	local decoded = cjson.decode(msg)
+	local expireAt = tonumber(decoded["ExpireAt"])
+	if not expireAt or expireAt == 0 or expireAt > tonumber(now) then
+		return false
+	end
+	local data = setErrorMsg(msg, "expired")
+	redis.call("ZADD", dead, now, data)
+	indexTask(index, decoded["ID"], dead, now)
+	local ukey = decoded["UniqueKey"]
+	if type(ukey) == "string" and string.len(ukey) > 0 and redis.call("GET", ukey) == decoded["ID"] then
+		redis.call("DEL", ukey)
+	end
+	local n = redis.call("INCR", counter)
+	if tonumber(n) == 1 then
+		redis.call("EXPIREAT", counter, statsExpireAt)
+	end
+	return true
+end
+`
+
 // KEYS[1] -> asynq:in_progress
 // KEYS[2] -> asynq:dead
 // KEYS[3] -> asynq:processed:<yyyy-mm-dd>
 // KEYS[4] -> asynq.failure:<yyyy-mm-dd>
-// ARGV[1] -> base.TaskMessage value to remove from base.InProgressQueue queue
+// KEYS[5] -> asynq:blob_garbage
+// KEYS[6] -> asynq:dead_retention
+// KEYS[7] -> asynq:dead_evicted
+// KEYS[8] -> asynq:task_index
+// KEYS[9:] -> asynq:stats:<granularity>:<bucket>
+// ARGV[1] -> base.TaskMessage value to remove from r.keys.InProgressQueue queue
 // ARGV[2] -> base.TaskMessage value to add to Dead queue
 // ARGV[3] -> died_at UNIX timestamp
-// ARGV[4] -> cutoff timestamp (e.g., 90 days ago)
-// ARGV[5] -> max number of tasks in dead queue (e.g., 100)
-// ARGV[6] -> stats expiration timestamp
-var killCmd = redis.NewScript(`
+// ARGV[4] -> stats expiration timestamp
+// ARGV[5] -> task ID
+// ARGV[6] -> queue name
+// ARGV[7] -> task type
+// ARGV[8:] -> expiration timestamps of the stats buckets
+var killCmd = redis.NewScript(indexTaskFn + collectBlobFn + trimDeadFn + incrStatsFn + `
 local x = redis.call("LREM", KEYS[1], 0, ARGV[1])
 if x == 0 then
   return redis.error_reply("NOT FOUND")
 end
 redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
-redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[4])
-redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -ARGV[5])
+indexTask(KEYS[8], ARGV[5], KEYS[2], ARGV[3])
+trimDead(KEYS[2], ARGV[3], KEYS[5], KEYS[6], KEYS[7], KEYS[8])
 local n = redis.call("INCR", KEYS[3])
 if tonumber(n) == 1 then
-	redis.call("EXPIREAT", KEYS[3], ARGV[6])
+	redis.call("EXPIREAT", KEYS[3], ARGV[4])
 end
 local m = redis.call("INCR", KEYS[4])
 if tonumber(m) == 1 then
-	redis.call("EXPIREAT", KEYS[4], ARGV[6])
+	redis.call("EXPIREAT", KEYS[4], ARGV[4])
 end
+incrStats(9, 8, ARGV[6], ARGV[7], {"processed", "failed"})
 return redis.status_reply("OK")`)
 
 // Kill sends the task to "dead" queue from in-progress queue, assigning
@@ -370,31 +887,35 @@ func (r *RDB) Kill(msg *base.TaskMessage, errMsg string) error {
 		return err
 	}
 	now := time.Now()
-	limit := now.AddDate(0, 0, -deadExpirationInDays).Unix() // 90 days ago
-	processedKey := base.ProcessedKey(now)
-	failureKey := base.FailureKey(now)
+	processedKey := r.keys.ProcessedKey(now)
+	failureKey := r.keys.FailureKey(now)
 	expireAt := now.Add(statsTTL)
-	return killCmd.Run(r.client,
-		[]string{base.InProgressQueue, base.DeadQueue, processedKey, failureKey},
-		string(bytesToRemove), string(bytesToAdd), now.Unix(), limit, maxDeadTasks, expireAt.Unix()).Err()
+	statsKeys, statsExpireAts := r.statsArgs(now)
+	keys := append([]string{r.keys.InProgressQueue, r.keys.DeadQueue, processedKey, failureKey,
+		r.keys.BlobGarbage, r.keys.DeadRetention, r.keys.DeadEvicted, r.keys.TaskIndex}, statsKeys...)
+	args := []interface{}{string(bytesToRemove), string(bytesToAdd), now.Unix(), expireAt.Unix(),
+		msg.ID.String(), msg.Queue, msg.Type}
+	return killCmd.Run(r.client, keys, append(args, statsExpireAts...)...).Err()
 }
 
 // KEYS[1] -> asynq:in_progress
+// KEYS[2] -> asynq:task_index
 // ARGV[1] -> queue prefix
-var requeueAllCmd = redis.NewScript(`
+var requeueAllCmd = redis.NewScript(indexTaskFn + queueKeyFn + `
 local msgs = redis.call("LRANGE", KEYS[1], 0, -1)
 for _, msg in ipairs(msgs) do
 	local decoded = cjson.decode(msg)
-	local qkey = ARGV[1] .. decoded["Queue"]
+	local qkey = queueKey(ARGV[1], decoded)
 	redis.call("RPUSH", qkey, msg)
 	redis.call("LREM", KEYS[1], 0, msg)
+	indexTask(KEYS[2], decoded["ID"], qkey)
 end
 return table.getn(msgs)`)
 
 // RequeueAll moves all tasks from in-progress list to the queue
 // and reports the number of tasks restored.
 func (r *RDB) RequeueAll() (int64, error) {
-	res, err := requeueAllCmd.Run(r.client, []string{base.InProgressQueue}, base.QueuePrefix).Result()
+	res, err := requeueAllCmd.Run(r.client, []string{r.keys.InProgressQueue, r.keys.TaskIndex}, r.keys.QueuePrefix).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -406,9 +927,9 @@ func (r *RDB) RequeueAll() (int64, error) {
 }
 
 // CheckAndEnqueue checks for all scheduled/retry tasks and enqueues any tasks that
-// are ready to be processed.
+// are ready to be processed. Expired tasks are moved to the dead queue instead.
 func (r *RDB) CheckAndEnqueue() (err error) {
-	delayed := []string{base.ScheduledQueue, base.RetryQueue}
+	delayed := []string{r.keys.ScheduledQueue, r.keys.RetryQueue}
 	for _, zset := range delayed {
 		n := 1
 		for n != 0 {
@@ -421,26 +942,63 @@ func (r *RDB) CheckAndEnqueue() (err error) {
 	return nil
 }
 
+// setEnqueuedAtFn is a lua snippet which defines a function to return the
+// given task message with EnqueuedAt set to the given time in Unix nanoseconds.
+// The message is updated in place rather than re-encoded, so that it
+// stays identical to the message encoded by the Go code.
+//
+// setEnqueuedAt(<task message>, <current time in unix nanoseconds>)
+const setEnqueuedAtFn = `
+local function setEnqueuedAt(msg, now)
+	local res, n = string.gsub(msg, '"EnqueuedAt":%d+}$', '"EnqueuedAt":' .. now .. '}')
+	if n == 0 then
+		res = string.sub(msg, 1, -2) .. ',"EnqueuedAt":' .. now .. '}'
+	end
+	return res
+end
+`
+
 // KEYS[1] -> source queue (e.g. scheduled or retry queue)
+// KEYS[2] -> asynq:dead
+// KEYS[3] -> asynq:expired:<yyyy-mm-dd>
+// KEYS[4] -> asynq:blob_garbage
+// KEYS[5] -> asynq:dead_retention
+// KEYS[6] -> asynq:dead_evicted
+// KEYS[7] -> asynq:task_index
 // ARGV[1] -> current unix time
 // ARGV[2] -> queue prefix
+// ARGV[3] -> stats expiration timestamp
+// ARGV[4] -> current time in unix nanoseconds
 // Note: Script moves tasks up to 100 at a time to keep the runtime of script short.
-var forwardCmd = redis.NewScript(`
+// Expired tasks are moved to the dead queue instead.
+// The limit of the queue is not checked; see checkQueueLimitFn.
+var forwardCmd = redis.NewScript(indexTaskFn + messageFieldFn + collectBlobFn + trimDeadFn + expireTaskFn + setEnqueuedAtFn + queueKeyFn + `
 local msgs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 100)
+local expired = false
 for _, msg in ipairs(msgs) do
-	local decoded = cjson.decode(msg)
-	local qkey = ARGV[2] .. decoded["Queue"]
-	redis.call("LPUSH", qkey, msg)
+	if expireTask(msg, ARGV[1], KEYS[2], KEYS[3], ARGV[3], KEYS[7]) then
+		expired = true
+	else
+		local decoded = cjson.decode(msg)
+		local qkey = queueKey(ARGV[2], decoded)
+		local data = setEnqueuedAt(msg, ARGV[4])
+		redis.call("LPUSH", qkey, data)
+		indexTask(KEYS[7], decoded["ID"], qkey)
+	end
 	redis.call("ZREM", KEYS[1], msg)
 end
+if expired then
+	trimDead(KEYS[2], ARGV[1], KEYS[4], KEYS[5], KEYS[6], KEYS[7])
+end
 return table.getn(msgs)`)
 
 // forward moves tasks with a score less than the current unix time
 // from the src zset. It returns the number of tasks moved.
 func (r *RDB) forward(src string) (int, error) {
-	now := float64(time.Now().Unix())
+	now := time.Now()
 	res, err := forwardCmd.Run(r.client,
-		[]string{src}, now, base.QueuePrefix).Result()
+		[]string{src, r.keys.DeadQueue, r.keys.ExpiredKey(now), r.keys.BlobGarbage, r.keys.DeadRetention, r.keys.DeadEvicted, r.keys.TaskIndex},
+		float64(now.Unix()), r.keys.QueuePrefix, now.Add(statsTTL).Unix(), strconv.FormatInt(now.UnixNano(), 10)).Result()
 	if err != nil {
 		return 0, err
 	}
@@ -483,10 +1041,10 @@ func (r *RDB) WriteServerState(info *base.ServerInfo, workers []*base.WorkerInfo
 		}
 		args = append(args, w.ID, bytes)
 	}
-	skey := base.ServerInfoKey(info.Host, info.PID, info.ServerID)
-	wkey := base.WorkersKey(info.Host, info.PID, info.ServerID)
+	skey := r.keys.ServerInfoKey(info.Host, info.PID, info.ServerID)
+	wkey := r.keys.WorkersKey(info.Host, info.PID, info.ServerID)
 	return writeServerStateCmd.Run(r.client,
-		[]string{skey, base.AllServers, wkey, base.AllWorkers},
+		[]string{skey, r.keys.AllServers, wkey, r.keys.AllWorkers},
 		args...).Err()
 }
 
@@ -503,15 +1061,15 @@ return redis.status_reply("OK")`)
 
 // ClearServerState deletes server state data from redis.
 func (r *RDB) ClearServerState(host string, pid int, serverID string) error {
-	skey := base.ServerInfoKey(host, pid, serverID)
-	wkey := base.WorkersKey(host, pid, serverID)
+	skey := r.keys.ServerInfoKey(host, pid, serverID)
+	wkey := r.keys.WorkersKey(host, pid, serverID)
 	return clearProcessInfoCmd.Run(r.client,
-		[]string{base.AllServers, skey, base.AllWorkers, wkey}).Err()
+		[]string{r.keys.AllServers, skey, r.keys.AllWorkers, wkey}).Err()
 }
 
 // CancelationPubSub returns a pubsub for cancelation messages.
 func (r *RDB) CancelationPubSub() (*redis.PubSub, error) {
-	pubsub := r.client.Subscribe(base.CancelChannel)
+	pubsub := r.client.Subscribe(r.keys.CancelChannel)
 	_, err := pubsub.Receive()
 	if err != nil {
 		return nil, err
@@ -522,5 +1080,174 @@ func (r *RDB) CancelationPubSub() (*redis.PubSub, error) {
 // PublishCancelation publish cancelation message to all subscribers.
 // The message is the ID for the task to be canceled.
 func (r *RDB) PublishCancelation(id string) error {
-	return r.client.Publish(base.CancelChannel, id).Err()
+	return r.client.Publish(r.keys.CancelChannel, id).Err()
+}
+
+// PublishCancelationRequest publishes a request to cancel all matching
+// in-progress tasks to all subscribers.
+func (r *RDB) PublishCancelationRequest(req *base.CancelationRequest) error {
+	bytes, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(r.keys.CancelChannel, bytes).Err()
+}
+
+// ControlPubSub returns a pubsub for control messages.
+func (r *RDB) ControlPubSub() (*redis.PubSub, error) {
+	pubsub := r.client.Subscribe(r.keys.ControlChannel)
+	_, err := pubsub.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return pubsub, nil
+}
+
+// PublishControl publishes control message to all subscribers.
+func (r *RDB) PublishControl(msg *base.ControlMessage) error {
+	bytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(r.keys.ControlChannel, bytes).Err()
+}
+
+// EventPubSub returns a pubsub for task events.
+func (r *RDB) EventPubSub() (*redis.PubSub, error) {
+	pubsub := r.client.Subscribe(r.keys.EventChannel)
+	_, err := pubsub.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return pubsub, nil
+}
+
+// PublishEvent publishes task event to all subscribers.
+func (r *RDB) PublishEvent(e *base.TaskEvent) error {
+	bytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(r.keys.EventChannel, bytes).Err()
+}
+
+// ListBlobGarbage returns up to n keys of payload blobs which are no longer
+// referenced by any task.
+func (r *RDB) ListBlobGarbage(n int) ([]string, error) {
+	return r.client.SRandMemberN(r.keys.BlobGarbage, int64(n)).Result()
+}
+
+// RemoveBlobGarbage removes the given blob keys from the garbage set.
+// It should be called once the blobs have been deleted from the blob store.
+func (r *RDB) RemoveBlobGarbage(refs ...string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	var members []interface{}
+	for _, ref := range refs {
+		members = append(members, ref)
+	}
+	return r.client.SRem(r.keys.BlobGarbage, members...).Err()
+}
+
+// ReadServerConfig returns the shared server config.
+// It returns nil if the config is not set.
+func (r *RDB) ReadServerConfig() (*base.ServerConfig, error) {
+	data, err := r.client.Get(r.keys.ServerConfigKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg base.ServerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// WriteServerConfig sets the shared server config.
+func (r *RDB) WriteServerConfig(cfg *base.ServerConfig) error {
+	bytes, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(r.keys.ServerConfigKey, bytes, 0).Err()
+}
+
+// ClearServerConfig deletes the shared server config.
+func (r *RDB) ClearServerConfig() error {
+	return r.client.Del(r.keys.ServerConfigKey).Err()
+}
+
+// AddBlobGarbage marks the given blob keys for garbage collection.
+func (r *RDB) AddBlobGarbage(refs ...string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	var members []interface{}
+	for _, ref := range refs {
+		members = append(members, ref)
+	}
+	return r.client.SAdd(r.keys.BlobGarbage, members...).Err()
+}
+
+// WriteDeadRetention sets the limits of the dead queue and whether
+// tasks evicted from the dead queue are kept for archival.
+// The config expires after ttl, after which the default limits apply
+// and evicted tasks are discarded.
+func (r *RDB) WriteDeadRetention(cfg *base.DeadRetentionConfig, ttl time.Duration) error {
+	bytes, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(r.keys.DeadRetention, bytes, ttl).Err()
+}
+
+// KEYS[1] -> asynq:dead_evicted
+// ARGV[1] -> max number of tasks to claim
+var claimEvictedCmd = redis.NewScript(`
+local res = redis.call("LRANGE", KEYS[1], 0, tonumber(ARGV[1]) - 1)
+redis.call("LTRIM", KEYS[1], table.getn(res), -1)
+return res`)
+
+// ClaimEvictedTasks removes up to n tasks evicted from the dead queue
+// and returns them for archival.
+// Tasks which could not be archived should be returned with ReturnEvictedTasks.
+func (r *RDB) ClaimEvictedTasks(n int) ([]*base.EvictedTask, error) {
+	res, err := claimEvictedCmd.Run(r.client, []string{r.keys.DeadEvicted}, n).Result()
+	if err != nil {
+		return nil, err
+	}
+	data, err := cast.ToStringSliceE(res)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*base.EvictedTask
+	for _, s := range data {
+		var t base.EvictedTask
+		if err := json.Unmarshal([]byte(s), &t); err != nil {
+			continue // bad data, ignore and continue
+		}
+		tasks = append(tasks, &t)
+	}
+	return tasks, nil
+}
+
+// ReturnEvictedTasks pushes the tasks claimed with ClaimEvictedTasks
+// back to the list of evicted tasks.
+func (r *RDB) ReturnEvictedTasks(tasks []*base.EvictedTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	var values []interface{}
+	for _, t := range tasks {
+		bytes, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		values = append(values, bytes)
+	}
+	return r.client.LPush(r.keys.DeadEvicted, values...).Err()
 }
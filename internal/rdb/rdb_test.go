@@ -112,6 +112,145 @@ func TestEnqueueUnique(t *testing.T) {
 	}
 }
 
+func TestEnqueueQueueLimit(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user1"})
+	t1.UniqueKey = "send_email:to=user1:default"
+	t2 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user2"})
+	t2.PayloadRef = "blob:t2"
+	t3 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user3"})
+	t4 := h.NewTaskMessage("send_email", map[string]interface{}{"to": "user4"})
+	t4.UniqueKey = "send_email:to=user4:default"
+
+	tests := []struct {
+		desc         string
+		limit        *base.QueueLimit
+		enqueued     []*base.TaskMessage // oldest first
+		msg          *base.TaskMessage
+		uniqueTTL    time.Duration
+		wantErr      error
+		wantEnqueued []*base.TaskMessage // newest first
+		wantGarbage  []string
+		wantLocks    []string
+	}{
+		{
+			desc:         "queue below the limit",
+			limit:        &base.QueueLimit{MaxSize: 3, Policy: base.QueueFullReject},
+			enqueued:     []*base.TaskMessage{t2},
+			msg:          t3,
+			wantEnqueued: []*base.TaskMessage{t3, t2},
+		},
+		{
+			desc:         "full queue rejecting tasks",
+			limit:        &base.QueueLimit{MaxSize: 1, Policy: base.QueueFullReject},
+			enqueued:     []*base.TaskMessage{t2},
+			msg:          t3,
+			wantErr:      ErrQueueFull,
+			wantEnqueued: []*base.TaskMessage{t2},
+		},
+		{
+			desc:         "full queue blocking tasks",
+			limit:        &base.QueueLimit{MaxSize: 1, Policy: base.QueueFullBlock},
+			enqueued:     []*base.TaskMessage{t2},
+			msg:          t3,
+			wantErr:      ErrQueueBlocked,
+			wantEnqueued: []*base.TaskMessage{t2},
+		},
+		{
+			desc:         "unique task to a full queue rejecting tasks",
+			limit:        &base.QueueLimit{MaxSize: 1, Policy: base.QueueFullReject},
+			enqueued:     []*base.TaskMessage{t2},
+			msg:          t4,
+			uniqueTTL:    time.Minute,
+			wantErr:      ErrQueueFull,
+			wantEnqueued: []*base.TaskMessage{t2},
+		},
+		{
+			desc:         "full queue dropping the oldest tasks",
+			limit:        &base.QueueLimit{MaxSize: 2, Policy: base.QueueFullDropOldest},
+			enqueued:     []*base.TaskMessage{t1, t2, t3},
+			msg:          t4,
+			uniqueTTL:    time.Minute,
+			wantEnqueued: []*base.TaskMessage{t4, t3},
+			wantGarbage:  []string{"blob:t2"},
+			wantLocks:    []string{t4.UniqueKey},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedEnqueuedQueue(t, r.client, tc.enqueued)
+		for _, msg := range tc.enqueued {
+			r.client.HSet(base.TaskIndex, msg.ID.String(), base.DefaultQueue)
+			if msg.UniqueKey != "" {
+				r.client.Set(msg.UniqueKey, msg.ID.String(), time.Minute)
+			}
+		}
+		if err := r.SetQueueLimit(base.DefaultQueueName, tc.limit); err != nil {
+			t.Fatal(err)
+		}
+
+		var err error
+		if tc.uniqueTTL > 0 {
+			err = r.EnqueueUnique(tc.msg, tc.uniqueTTL)
+		} else {
+			err = r.Enqueue(tc.msg)
+		}
+		if err != tc.wantErr {
+			t.Errorf("%s: enqueue returned %v, want %v", tc.desc, err, tc.wantErr)
+			continue
+		}
+
+		gotEnqueued := h.GetEnqueuedMessages(t, r.client)
+		if diff := cmp.Diff(tc.wantEnqueued, gotEnqueued, cmpopts.IgnoreFields(base.TaskMessage{}, "EnqueuedAt")); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want,+got)\n%s", tc.desc, base.DefaultQueue, diff)
+		}
+		gotGarbage := r.client.SMembers(base.BlobGarbage).Val()
+		if diff := cmp.Diff(tc.wantGarbage, gotGarbage, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want,+got)\n%s", tc.desc, base.BlobGarbage, diff)
+		}
+		gotLocks := r.client.Keys("send_email:*").Val()
+		if diff := cmp.Diff(tc.wantLocks, gotLocks, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: mismatch found in uniqueness locks; (-want,+got)\n%s", tc.desc, diff)
+		}
+		var wantIndexed []string
+		for _, msg := range tc.wantEnqueued {
+			wantIndexed = append(wantIndexed, msg.ID.String())
+		}
+		gotIndexed := r.client.HKeys(base.TaskIndex).Val()
+		if diff := cmp.Diff(wantIndexed, gotIndexed, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q; (-want,+got)\n%s", tc.desc, base.TaskIndex, diff)
+		}
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r.client)
+	critical := &base.QueueLimit{MaxSize: 100, Policy: base.QueueFullBlock}
+	low := &base.QueueLimit{MaxSize: 10, Policy: base.QueueFullDropOldest}
+	for qname, limit := range map[string]*base.QueueLimit{"critical": critical, "low": low} {
+		if err := r.SetQueueLimit(qname, limit); err != nil {
+			t.Fatalf("(*RDB).SetQueueLimit(%q, %v) = %v", qname, limit, err)
+		}
+	}
+	if err := r.ClearQueueLimit("low"); err != nil {
+		t.Fatalf("(*RDB).ClearQueueLimit(%q) = %v", "low", err)
+	}
+	if err := r.SetQueueLimit(base.WebhookQueueName, low); err == nil {
+		t.Errorf("(*RDB).SetQueueLimit(%q, %v) returned nil error", base.WebhookQueueName, low)
+	}
+
+	got, err := r.QueueLimits()
+	if err != nil {
+		t.Fatalf("(*RDB).QueueLimits() returned error: %v", err)
+	}
+	want := map[string]*base.QueueLimit{"critical": critical}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(*RDB).QueueLimits() = %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+}
+
 func TestDequeue(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"subject": "hello!"})
@@ -227,6 +366,104 @@ func TestDequeue(t *testing.T) {
 	}
 }
 
+func TestDequeuePriority(t *testing.T) {
+	r := setup(t)
+	newTask := func(typename string, priority int) *base.TaskMessage {
+		msg := h.NewTaskMessage(typename, nil)
+		msg.Priority = priority
+		return msg
+	}
+	a, b, c, d, e := newTask("a", 0), newTask("b", 5), newTask("c", 0), newTask("d", 5), newTask("e", base.MaxPriority)
+	f := newTask("f", 5)
+	for _, msg := range []*base.TaskMessage{a, b, c, d, e} {
+		if err := r.Enqueue(msg); err != nil {
+			t.Fatalf("(*RDB).Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+	// Scheduled tasks are enqueued behind the tasks of the same priority.
+	if err := r.Schedule(f, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckAndEnqueue(); err != nil {
+		t.Fatal(err)
+	}
+	want := []*base.TaskMessage{e, b, d, f, a, c}
+
+	var wantTypes []string
+	for _, msg := range want {
+		wantTypes = append(wantTypes, msg.Type)
+	}
+	listed, err := r.ListEnqueued(base.DefaultQueueName, Pagination{Size: 2, Page: 1}, nil)
+	if err != nil {
+		t.Fatalf("(*RDB).ListEnqueued returned error: %v", err)
+	}
+	filtered, err := r.ListEnqueued(base.DefaultQueueName, Pagination{Size: 2, Page: 1}, &Filter{Queue: base.DefaultQueueName})
+	if err != nil {
+		t.Fatalf("(*RDB).ListEnqueued returned error: %v", err)
+	}
+	for _, tasks := range [][]*EnqueuedTask{listed, filtered} {
+		var got []string
+		for _, t := range tasks {
+			got = append(got, t.Type)
+		}
+		if diff := cmp.Diff(wantTypes[2:4], got); diff != "" {
+			t.Errorf("(*RDB).ListEnqueued returned tasks %v, want %v; (-want,+got)\n%s", got, wantTypes[2:4], diff)
+		}
+	}
+
+	for i, msg := range want {
+		got, err := r.Dequeue(base.DefaultQueueName)
+		if err != nil {
+			t.Fatalf("(*RDB).Dequeue returned error: %v", err)
+		}
+		if got.ID != msg.ID {
+			t.Errorf("(*RDB).Dequeue #%d returned task %q, want %q", i, got.Type, msg.Type)
+		}
+		// Requeued tasks are processed next.
+		if i == 0 {
+			if err := r.Requeue(got); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := r.Dequeue(base.DefaultQueueName); got == nil || got.ID != msg.ID {
+				t.Errorf("(*RDB).Dequeue after Requeue returned %v, want task %q", got, msg.Type)
+			}
+		}
+	}
+	if _, err := r.Dequeue(base.DefaultQueueName); err != ErrNoProcessableTask {
+		t.Errorf("(*RDB).Dequeue on an empty queue returned %v, want %v", err, ErrNoProcessableTask)
+	}
+	if n := r.client.SCard(base.AllQueues).Val(); n != 1 {
+		t.Errorf("%q has %d members, want 1", base.AllQueues, n)
+	}
+
+	// Queues named like the keys of other priorities are separate queues.
+	g := h.NewTaskMessageWithQueue("g", nil, "default:p5")
+	if err := r.Enqueue(g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Dequeue(base.DefaultQueueName); err != ErrNoProcessableTask {
+		t.Errorf("(*RDB).Dequeue(%q) returned %v, want %v", base.DefaultQueueName, err, ErrNoProcessableTask)
+	}
+	if got, err := r.Dequeue("default:p5"); err != nil || got.ID != g.ID {
+		t.Errorf("(*RDB).Dequeue(%q) = %v, %v; want task %q", "default:p5", got, err, g.Type)
+	}
+
+	// Full queues drop the oldest tasks of the lowest priority first.
+	if err := r.SetQueueLimit(base.DefaultQueueName, &base.QueueLimit{MaxSize: 2, Policy: base.QueueFullDropOldest}); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*base.TaskMessage{b, a, d} {
+		if err := r.Enqueue(msg); err != nil {
+			t.Fatalf("(*RDB).Enqueue(%v) returned error: %v", msg, err)
+		}
+	}
+	for _, msg := range []*base.TaskMessage{b, d} {
+		if got, _ := r.Dequeue(base.DefaultQueueName); got == nil || got.ID != msg.ID {
+			t.Errorf("(*RDB).Dequeue from a full queue returned %v, want task %q", got, msg.Type)
+		}
+	}
+}
+
 func TestDequeueIgnoresPausedQueues(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"subject": "hello!"})
@@ -753,6 +990,153 @@ func TestKill(t *testing.T) {
 	}
 }
 
+func TestKillTrimsDeadQueue(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t2 := h.NewTaskMessage("reindex", nil)
+	t3 := h.NewTaskMessage("generate_csv", nil)
+	t4 := h.NewTaskMessage("sync", nil)
+	t4.PayloadRef = t4.ID.String()
+	errMsg := "SMTP server not responding"
+	t1AfterKill := *t1
+	t1AfterKill.ErrorMsg = errMsg
+	now := time.Now()
+	twoDaysAgo := now.Add(-48 * time.Hour).Unix()
+	oneHourAgo := now.Add(-time.Hour).Unix()
+
+	tests := []struct {
+		desc        string
+		retention   *base.DeadRetentionConfig // nil for default retention
+		dead        []h.ZSetEntry
+		wantDead    []h.ZSetEntry
+		wantEvicted []*base.EvictedTask
+		wantGarbage []string
+	}{
+		{
+			desc:      "default retention",
+			retention: nil,
+			dead: []h.ZSetEntry{
+				{Msg: t2, Score: float64(twoDaysAgo)},
+				{Msg: t3, Score: float64(oneHourAgo)},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: &t1AfterKill, Score: float64(now.Unix())},
+				{Msg: t2, Score: float64(twoDaysAgo)},
+				{Msg: t3, Score: float64(oneHourAgo)},
+			},
+		},
+		{
+			desc:      "max tasks without archival keeps exactly max tasks",
+			retention: &base.DeadRetentionConfig{MaxTasks: 2},
+			dead: []h.ZSetEntry{
+				{Msg: t4, Score: float64(twoDaysAgo)},
+				{Msg: t3, Score: float64(oneHourAgo)},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: &t1AfterKill, Score: float64(now.Unix())},
+				{Msg: t3, Score: float64(oneHourAgo)},
+			},
+			wantGarbage: []string{t4.PayloadRef},
+		},
+		{
+			desc:      "max age with archival",
+			retention: &base.DeadRetentionConfig{MaxAge: 24 * 60 * 60, Archive: true},
+			dead: []h.ZSetEntry{
+				{Msg: t4, Score: float64(twoDaysAgo)},
+				{Msg: t3, Score: float64(oneHourAgo)},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: &t1AfterKill, Score: float64(now.Unix())},
+				{Msg: t3, Score: float64(oneHourAgo)},
+			},
+			wantEvicted: []*base.EvictedTask{{DiedAt: twoDaysAgo, Message: t4}},
+		},
+		{
+			desc:      "no limits",
+			retention: &base.DeadRetentionConfig{MaxTasks: -1, MaxAge: -1},
+			dead: []h.ZSetEntry{
+				{Msg: t2, Score: float64(now.AddDate(-1, 0, 0).Unix())},
+			},
+			wantDead: []h.ZSetEntry{
+				{Msg: &t1AfterKill, Score: float64(now.Unix())},
+				{Msg: t2, Score: float64(now.AddDate(-1, 0, 0).Unix())},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client) // clean up db before each test case
+		h.SeedInProgressQueue(t, r.client, []*base.TaskMessage{t1})
+		h.SeedDeadQueue(t, r.client, tc.dead)
+		if tc.retention != nil {
+			if err := r.WriteDeadRetention(tc.retention, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := r.Kill(t1, errMsg); err != nil {
+			t.Errorf("%s: (*RDB).Kill(%v, %v) = %v, want nil", tc.desc, t1, errMsg, err)
+			continue
+		}
+
+		gotDead := h.GetDeadEntries(t, r.client)
+		if diff := cmp.Diff(tc.wantDead, gotDead, h.SortZSetEntryOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q after calling (*RDB).Kill: (-want, +got):\n%s", tc.desc, base.DeadQueue, diff)
+		}
+		gotEvicted, err := r.ClaimEvictedTasks(100)
+		if err != nil {
+			t.Errorf("%s: (*RDB).ClaimEvictedTasks(100) returned error: %v", tc.desc, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.wantEvicted, gotEvicted); diff != "" {
+			t.Errorf("%s: mismatch found in evicted tasks: (-want, +got):\n%s", tc.desc, diff)
+		}
+		gotGarbage := r.client.SMembers(base.BlobGarbage).Val()
+		if diff := cmp.Diff(tc.wantGarbage, gotGarbage, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: mismatch found in %q: (-want, +got):\n%s", tc.desc, base.BlobGarbage, diff)
+		}
+	}
+}
+
+func TestClaimEvictedTasks(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r.client)
+	var evicted []*base.EvictedTask
+	for i := 0; i < 5; i++ {
+		evicted = append(evicted, &base.EvictedTask{
+			DiedAt:  time.Now().Unix(),
+			Message: h.NewTaskMessage("task"+fmt.Sprint(i), nil),
+		})
+	}
+	if err := r.ReturnEvictedTasks(evicted); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.ClaimEvictedTasks(3)
+	if err != nil {
+		t.Fatalf("(*RDB).ClaimEvictedTasks(3) returned error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("(*RDB).ClaimEvictedTasks(3) returned %d tasks, want 3", len(got))
+	}
+	if n := r.client.LLen(base.DeadEvicted).Val(); n != 2 {
+		t.Errorf("%q has %d tasks after claim, want 2", base.DeadEvicted, n)
+	}
+
+	// Returned tasks can be claimed again.
+	if err := r.ReturnEvictedTasks(got); err != nil {
+		t.Fatalf("(*RDB).ReturnEvictedTasks returned error: %v", err)
+	}
+	all, err := r.ClaimEvictedTasks(100)
+	if err != nil {
+		t.Fatalf("(*RDB).ClaimEvictedTasks(100) returned error: %v", err)
+	}
+	sortOpt := cmpopts.SortSlices(func(x, y *base.EvictedTask) bool { return x.Message.Type < y.Message.Type })
+	if diff := cmp.Diff(evicted, all, sortOpt); diff != "" {
+		t.Errorf("mismatch found in claimed tasks: (-want, +got):\n%s", diff)
+	}
+}
+
 func TestRequeueAll(t *testing.T) {
 	r := setup(t)
 	t1 := h.NewTaskMessage("send_email", nil)
@@ -931,7 +1315,7 @@ func TestCheckAndEnqueue(t *testing.T) {
 
 		for qname, want := range tc.wantEnqueued {
 			gotEnqueued := h.GetEnqueuedMessages(t, r.client, qname)
-			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt); diff != "" {
+			if diff := cmp.Diff(want, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 				t.Errorf("mismatch found in %q; (-want, +got)\n%s", base.QueueKey(qname), diff)
 			}
 		}
@@ -1262,3 +1646,500 @@ func TestCancelationPubSub(t *testing.T) {
 	}
 	mu.Unlock()
 }
+
+func TestControlPubSub(t *testing.T) {
+	r := setup(t)
+
+	pubsub, err := r.ControlPubSub()
+	if err != nil {
+		t.Fatalf("(*RDB).ControlPubSub() returned an error: %v", err)
+	}
+
+	controlCh := pubsub.Channel()
+
+	var (
+		mu       sync.Mutex
+		received []*base.ControlMessage
+	)
+
+	go func() {
+		for msg := range controlCh {
+			var m base.ControlMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				t.Errorf("could not decode control message %q: %v", msg.Payload, err)
+				continue
+			}
+			mu.Lock()
+			received = append(received, &m)
+			mu.Unlock()
+		}
+	}()
+
+	publish := []*base.ControlMessage{
+		{Command: base.ControlQuiet, ServerID: "server1"},
+		{Command: base.ControlStop, ServerID: ""},
+	}
+
+	for _, msg := range publish {
+		if err := r.PublishControl(msg); err != nil {
+			t.Fatalf("(*RDB).PublishControl(%+v) returned an error: %v", msg, err)
+		}
+	}
+
+	// allow for message to reach subscribers.
+	time.Sleep(time.Second)
+
+	pubsub.Close()
+
+	mu.Lock()
+	if diff := cmp.Diff(publish, received); diff != "" {
+		t.Errorf("subscriber received %v, want %v; (-want,+got)\n%s", received, publish, diff)
+	}
+	mu.Unlock()
+}
+
+func TestCollectPayloadBlobs(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	t1.PayloadRef = t1.ID.String()
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t2.PayloadRef = t2.ID.String()
+	t3 := h.NewTaskMessage("reindex", nil)
+	now := time.Now()
+
+	tests := []struct {
+		desc        string
+		inProgress  []*base.TaskMessage
+		dead        []h.ZSetEntry
+		op          func() error
+		wantGarbage []string
+	}{
+		{
+			desc:        "Done",
+			inProgress:  []*base.TaskMessage{t1, t3},
+			op:          func() error { return r.Done(t1) },
+			wantGarbage: []string{t1.PayloadRef},
+		},
+		{
+			desc:        "Done without payload ref",
+			inProgress:  []*base.TaskMessage{t1, t3},
+			op:          func() error { return r.Done(t3) },
+			wantGarbage: []string{},
+		},
+		{
+			desc: "DeleteDeadTask",
+			dead: []h.ZSetEntry{
+				{Msg: t1, Score: float64(now.Unix())},
+				{Msg: t2, Score: float64(now.Unix())},
+			},
+			op:          func() error { return r.DeleteDeadTask(t2.ID, now.Unix()) },
+			wantGarbage: []string{t2.PayloadRef},
+		},
+		{
+			desc: "DeleteAllDeadTasks",
+			dead: []h.ZSetEntry{
+				{Msg: t1, Score: float64(now.Unix())},
+				{Msg: t2, Score: float64(now.Unix())},
+				{Msg: t3, Score: float64(now.Unix())},
+			},
+			op: func() error {
+				_, err := r.DeleteAllDeadTasks(nil)
+				return err
+			},
+			wantGarbage: []string{t1.PayloadRef, t2.PayloadRef},
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedInProgressQueue(t, r.client, tc.inProgress)
+		h.SeedDeadQueue(t, r.client, tc.dead)
+
+		if err := tc.op(); err != nil {
+			t.Errorf("%s returned error: %v", tc.desc, err)
+			continue
+		}
+
+		gotGarbage := r.client.SMembers(base.BlobGarbage).Val()
+		if diff := cmp.Diff(tc.wantGarbage, gotGarbage, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("%s: mismatch found in %q: (-want, +got):\n%s", tc.desc, base.BlobGarbage, diff)
+		}
+	}
+}
+
+func TestDequeueExpiredTask(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"subject": "hello!"})
+	t1.ExpireAt = time.Now().Add(-time.Minute).Unix()
+	t2 := h.NewTaskMessage("export_csv", nil)
+	t2.ExpireAt = time.Now().Add(time.Hour).Unix()
+	t3 := h.NewTaskMessage("reindex", nil)
+	t3.ExpireAt = time.Now().Add(-time.Second).Unix()
+	t3.UniqueKey = "reindex:nil:default"
+	// A payload key named ExpireAt doesn't make the task expire.
+	t4 := h.NewTaskMessage("remind", map[string]interface{}{"ExpireAt": "tomorrow"})
+	expired1 := *t1
+	expired1.ErrorMsg = "expired"
+	expired3 := *t3
+	expired3.ErrorMsg = "expired"
+
+	tests := []struct {
+		enqueued       []*base.TaskMessage
+		want           *base.TaskMessage
+		err            error
+		wantInProgress []*base.TaskMessage
+		wantDead       []*base.TaskMessage
+		wantExpired    int
+		wantLock       bool // whether the uniqueness lock of t3 should exist
+	}{
+		{
+			enqueued:       []*base.TaskMessage{t1, t2},
+			want:           t2,
+			err:            nil,
+			wantInProgress: []*base.TaskMessage{t2},
+			wantDead:       []*base.TaskMessage{&expired1},
+			wantExpired:    1,
+			wantLock:       true,
+		},
+		{
+			enqueued:       []*base.TaskMessage{t3, t1},
+			want:           nil,
+			err:            ErrNoProcessableTask,
+			wantInProgress: []*base.TaskMessage{},
+			wantDead:       []*base.TaskMessage{&expired1, &expired3},
+			wantExpired:    2,
+			wantLock:       false,
+		},
+		{
+			enqueued:       []*base.TaskMessage{t4},
+			want:           t4,
+			err:            nil,
+			wantInProgress: []*base.TaskMessage{t4},
+			wantDead:       []*base.TaskMessage{},
+			wantExpired:    0,
+			wantLock:       true,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r.client)
+		h.SeedEnqueuedQueue(t, r.client, tc.enqueued)
+		if err := r.client.SetNX(t3.UniqueKey, t3.ID.String(), time.Minute).Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := r.Dequeue(base.DefaultQueueName)
+		if !cmp.Equal(got, tc.want) || err != tc.err {
+			t.Errorf("(*RDB).Dequeue() = %v, %v; want %v, %v", got, err, tc.want, tc.err)
+			continue
+		}
+		gotInProgress := h.GetInProgressMessages(t, r.client)
+		if diff := cmp.Diff(tc.wantInProgress, gotInProgress, h.SortMsgOpt); diff != "" {
+			t.Errorf("mismatch found in %q: (-want,+got):\n%s", base.InProgressQueue, diff)
+		}
+		gotDead := h.GetDeadMessages(t, r.client)
+		if diff := cmp.Diff(tc.wantDead, gotDead, h.SortMsgOpt); diff != "" {
+			t.Errorf("mismatch found in %q: (-want,+got):\n%s", base.DeadQueue, diff)
+		}
+		expiredKey := base.ExpiredKey(time.Now())
+		if got, _ := r.client.Get(expiredKey).Int(); got != tc.wantExpired {
+			t.Errorf("GET %q = %q, want %d", expiredKey, got, tc.wantExpired)
+		}
+		if gotLock := r.client.Exists(t3.UniqueKey).Val() == 1; gotLock != tc.wantLock {
+			t.Errorf("Uniqueness lock %q exists = %t, want %t", t3.UniqueKey, gotLock, tc.wantLock)
+		}
+	}
+}
+
+func TestDequeueExpiredTaskLimit(t *testing.T) {
+	r := setup(t)
+	var msgs []*base.TaskMessage
+	for i := 0; i < 150; i++ {
+		msg := h.NewTaskMessage("send_email", nil)
+		msg.ExpireAt = time.Now().Add(-time.Minute).Unix()
+		msgs = append(msgs, msg)
+	}
+	valid := h.NewTaskMessage("export_csv", nil)
+	h.SeedEnqueuedQueue(t, r.client, append(msgs, valid))
+
+	// The script moves at most 100 expired tasks per run, and Dequeue
+	// runs it again to reach the valid task behind them.
+	got, err := r.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("(*RDB).Dequeue() returned %v, want nil", err)
+	}
+	if diff := cmp.Diff(valid, got); diff != "" {
+		t.Errorf("(*RDB).Dequeue() = %v, want %v; (-want,+got)\n%s", got, valid, diff)
+	}
+	if n := r.client.ZCard(base.DeadQueue).Val(); n != 150 {
+		t.Errorf("ZCARD %q = %d, want 150", base.DeadQueue, n)
+	}
+}
+
+func TestEnqueuedAt(t *testing.T) {
+	r := setup(t)
+	t1 := h.NewTaskMessage("send_email", nil)
+	if err := r.Schedule(t1, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("(*RDB).Schedule() = %v, want nil", err)
+	}
+	start := time.Now()
+	if err := r.CheckAndEnqueue(); err != nil {
+		t.Fatalf("(*RDB).CheckAndEnqueue() = %v, want nil", err)
+	}
+
+	// Tasks moved to a queue record the time they were moved.
+	got, err := r.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("(*RDB).Dequeue() = %v, want nil", err)
+	}
+	if enqueuedAt := time.Unix(0, got.EnqueuedAt); enqueuedAt.Before(start) || enqueuedAt.After(time.Now()) {
+		t.Errorf("EnqueuedAt = %v, want between %v and now", enqueuedAt, start)
+	}
+	// The updated message must be found in the in-progress list.
+	if err := r.Done(got); err != nil {
+		t.Errorf("(*RDB).Done() = %v, want nil", err)
+	}
+	waits := r.client.LRange(base.WaitTimesKey(base.DefaultQueueName), 0, -1).Val()
+	if len(waits) != 1 {
+		t.Errorf("got %d wait times, want 1", len(waits))
+	}
+}
+
+func TestCheckAndEnqueueExpiredTask(t *testing.T) {
+	r := setup(t)
+	// The expired message keeps its encoding, e.g. EnqueuedAt in full
+	// precision and payload keys named like the fields of the message.
+	t1 := h.NewTaskMessage("send_email", map[string]interface{}{"ErrorMsg": "none", "ExpireAt": 1.0})
+	t1.ExpireAt = time.Now().Add(-time.Minute).Unix()
+	t1.EnqueuedAt = time.Now().Add(-time.Hour).UnixNano()
+	t2 := h.NewTaskMessage("generate_csv", nil)
+	t2.ExpireAt = time.Now().Add(time.Hour).Unix()
+	t3 := h.NewTaskMessage("gen_thumbnail", nil)
+	t3.ExpireAt = time.Now().Add(-time.Minute).Unix()
+	t3.ErrorMsg = `bad "input" \`
+	t3.EnqueuedAt = time.Now().Add(-time.Hour).UnixNano()
+	expired1 := *t1
+	expired1.ErrorMsg = "expired"
+	expired3 := *t3
+	expired3.ErrorMsg = "expired"
+	secondAgo := time.Now().Add(-time.Second)
+
+	h.FlushDB(t, r.client)
+	h.SeedScheduledQueue(t, r.client, []h.ZSetEntry{
+		{Msg: t1, Score: float64(secondAgo.Unix())},
+		{Msg: t2, Score: float64(secondAgo.Unix())},
+	})
+	h.SeedRetryQueue(t, r.client, []h.ZSetEntry{
+		{Msg: t3, Score: float64(secondAgo.Unix())},
+	})
+
+	if err := r.CheckAndEnqueue(); err != nil {
+		t.Fatalf("(*RDB).CheckAndEnqueue() = %v, want nil", err)
+	}
+
+	if diff := cmp.Diff([]*base.TaskMessage{t2}, h.GetEnqueuedMessages(t, r.client), h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got)\n%s", base.DefaultQueue, diff)
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{}, h.GetScheduledMessages(t, r.client), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got)\n%s", base.ScheduledQueue, diff)
+	}
+	if diff := cmp.Diff([]*base.TaskMessage{}, h.GetRetryMessages(t, r.client), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got)\n%s", base.RetryQueue, diff)
+	}
+	wantDead := []*base.TaskMessage{&expired1, &expired3}
+	if diff := cmp.Diff(wantDead, h.GetDeadMessages(t, r.client), h.SortMsgOpt); diff != "" {
+		t.Errorf("mismatch found in %q: (-want, +got)\n%s", base.DeadQueue, diff)
+	}
+	expiredKey := base.ExpiredKey(time.Now())
+	if got := r.client.Get(expiredKey).Val(); got != "2" {
+		t.Errorf("GET %q = %q, want 2", expiredKey, got)
+	}
+	if dead, err := r.ListDead(Pagination{Size: 10}, nil); err != nil || len(dead) != 2 {
+		t.Errorf("(*RDB).ListDead() = %v, %v; want 2 tasks", dead, err)
+	}
+	info, err := r.GetTask(t1.ID)
+	if err != nil {
+		t.Fatalf("(*RDB).GetTask(%v) returned error: %v", t1.ID, err)
+	}
+	if diff := cmp.Diff(&expired1, info.Msg); diff != "" {
+		t.Errorf("(*RDB).GetTask(%v) = %v, want %v; (-want, +got)\n%s", t1.ID, info.Msg, &expired1, diff)
+	}
+}
+
+func TestEventPubSub(t *testing.T) {
+	r := setup(t)
+
+	pubsub, err := r.EventPubSub()
+	if err != nil {
+		t.Fatalf("(*RDB).EventPubSub() returned an error: %v", err)
+	}
+
+	eventCh := pubsub.Channel()
+
+	var (
+		mu       sync.Mutex
+		received []*base.TaskEvent
+	)
+
+	go func() {
+		for msg := range eventCh {
+			var e base.TaskEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				t.Errorf("could not decode task event %q: %v", msg.Payload, err)
+				continue
+			}
+			mu.Lock()
+			received = append(received, &e)
+			mu.Unlock()
+		}
+	}()
+
+	now := time.Now().UTC()
+	publish := []*base.TaskEvent{
+		{State: "started", TaskID: "id1", Type: "send_email", Queue: "default", Time: now},
+		{State: "retried", TaskID: "id1", Type: "send_email", Queue: "default", Error: "timeout", Duration: time.Second, Time: now},
+	}
+
+	for _, e := range publish {
+		if err := r.PublishEvent(e); err != nil {
+			t.Fatalf("(*RDB).PublishEvent(%+v) returned an error: %v", e, err)
+		}
+	}
+
+	// allow for message to reach subscribers.
+	time.Sleep(time.Second)
+
+	pubsub.Close()
+
+	mu.Lock()
+	if diff := cmp.Diff(publish, received); diff != "" {
+		t.Errorf("subscriber received %v, want %v; (-want,+got)\n%s", received, publish, diff)
+	}
+	mu.Unlock()
+}
+
+func TestServerConfig(t *testing.T) {
+	r := setup(t)
+
+	tests := []*base.ServerConfig{
+		{Concurrency: 20},
+		{Queues: map[string]int{"critical": 6, "default": 3, "low": 1}, StrictPriority: true},
+		{Concurrency: 5, Queues: map[string]int{"default": 1}},
+	}
+
+	for _, want := range tests {
+		h.FlushDB(t, r.client)
+
+		got, err := r.ReadServerConfig()
+		if err != nil || got != nil {
+			t.Errorf("(*RDB).ReadServerConfig() = %v, %v; want nil, nil", got, err)
+			continue
+		}
+		if err := r.WriteServerConfig(want); err != nil {
+			t.Errorf("(*RDB).WriteServerConfig(%+v) returned an error: %v", want, err)
+			continue
+		}
+		got, err = r.ReadServerConfig()
+		if err != nil {
+			t.Errorf("(*RDB).ReadServerConfig() returned an error: %v", err)
+			continue
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(*RDB).ReadServerConfig() = %+v, want %+v; (-want,+got)\n%s", got, want, diff)
+		}
+		if err := r.ClearServerConfig(); err != nil {
+			t.Errorf("(*RDB).ClearServerConfig() returned an error: %v", err)
+			continue
+		}
+		if n := r.client.Exists(base.ServerConfigKey).Val(); n != 0 {
+			t.Errorf("%q exists after (*RDB).ClearServerConfig()", base.ServerConfigKey)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	r1 := setup(t)
+	r2 := NewRDB(r1.client)
+	r1.SetNamespace("app1")
+	r2.SetNamespace("app2")
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.UniqueKey = r1.Keys().UniqueKey("send_email:nil:default")
+	m2 := h.NewTaskMessage("send_email", nil)
+	m2.UniqueKey = r2.Keys().UniqueKey("send_email:nil:default")
+	m2.Priority = 5
+
+	if err := r1.EnqueueUnique(m1, time.Hour); err != nil {
+		t.Fatalf("(*RDB).EnqueueUnique(%v) in %q = %v, want nil", m1, "app1", err)
+	}
+	if err := r2.EnqueueUnique(m2, time.Hour); err != nil {
+		t.Fatalf("(*RDB).EnqueueUnique(%v) in %q = %v, want nil", m2, "app2", err)
+	}
+	if n := r1.client.LLen("app2:prio:5:default").Val(); n != 1 {
+		t.Errorf("%q has %d tasks, want 1", "app2:prio:5:default", n)
+	}
+	if err := r1.Pause(base.DefaultQueueName); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paused queue in app1 doesn't affect app2.
+	got, err := r2.Dequeue(base.DefaultQueueName)
+	if err != nil {
+		t.Fatalf("(*RDB).Dequeue in %q returned error: %v", "app2", err)
+	}
+	if diff := cmp.Diff(m2, got); diff != "" {
+		t.Errorf("(*RDB).Dequeue in %q = %v, want %v; (-want,+got)\n%s", "app2", got, m2, diff)
+	}
+	if _, err := r2.Dequeue(base.DefaultQueueName); err != ErrNoProcessableTask {
+		t.Errorf("second (*RDB).Dequeue in %q returned %v, want %v", "app2", err, ErrNoProcessableTask)
+	}
+	if err := r2.Done(got); err != nil {
+		t.Fatal(err)
+	}
+
+	s1, err := r1.CurrentStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := r2.CurrentStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.Enqueued != 1 || s1.Processed != 0 || len(s1.Queues) != 1 || !s1.Queues[0].Paused {
+		t.Errorf("CurrentStats in %q = %+v, want one enqueued task in one paused queue", "app1", s1)
+	}
+	if s2.Enqueued != 0 || s2.Processed != 1 || len(s2.Queues) != 1 || s2.Queues[0].Paused {
+		t.Errorf("CurrentStats in %q = %+v, want one processed task and one active queue", "app2", s2)
+	}
+	if _, err := r2.GetTask(m1.ID); err != ErrTaskNotFound {
+		t.Errorf("(*RDB).GetTask(%v) in %q returned %v, want %v", m1.ID, "app2", err, ErrTaskNotFound)
+	}
+
+	pubsub, err := r1.CancelationPubSub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pubsub.Close()
+	if err := r2.PublishCancelation("app2-task"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.PublishCancelation("app1-task"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-pubsub.Channel():
+		if msg.Payload != "app1-task" {
+			t.Errorf("cancelation message in %q = %q, want %q", "app1", msg.Payload, "app1-task")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no cancelation message received in %q", "app1")
+	}
+
+	keys, err := r1.client.Keys(base.DefaultNamespace + ":*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("keys in the default namespace = %v, want none", keys)
+	}
+}
@@ -177,6 +177,96 @@ func (tb *TestBroker) PublishCancelation(id string) error {
 	return tb.real.PublishCancelation(id)
 }
 
+func (tb *TestBroker) ControlPubSub() (*redis.PubSub, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.ControlPubSub()
+}
+
+func (tb *TestBroker) PublishControl(msg *base.ControlMessage) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.PublishControl(msg)
+}
+
+func (tb *TestBroker) PublishEvent(e *base.TaskEvent) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.PublishEvent(e)
+}
+
+func (tb *TestBroker) ListBlobGarbage(n int) ([]string, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.ListBlobGarbage(n)
+}
+
+func (tb *TestBroker) RemoveBlobGarbage(refs ...string) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.RemoveBlobGarbage(refs...)
+}
+
+func (tb *TestBroker) AddBlobGarbage(refs ...string) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.AddBlobGarbage(refs...)
+}
+
+func (tb *TestBroker) WriteDeadRetention(cfg *base.DeadRetentionConfig, ttl time.Duration) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.WriteDeadRetention(cfg, ttl)
+}
+
+func (tb *TestBroker) ClaimEvictedTasks(n int) ([]*base.EvictedTask, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.ClaimEvictedTasks(n)
+}
+
+func (tb *TestBroker) ReturnEvictedTasks(tasks []*base.EvictedTask) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return errRedisDown
+	}
+	return tb.real.ReturnEvictedTasks(tasks)
+}
+
+func (tb *TestBroker) ReadServerConfig() (*base.ServerConfig, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sleeping {
+		return nil, errRedisDown
+	}
+	return tb.real.ReadServerConfig()
+}
+
 func (tb *TestBroker) Close() error {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
@@ -8,8 +8,10 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hibiken/asynq/internal/base"
@@ -24,6 +26,16 @@ type processor struct {
 
 	handler Handler
 
+	// webhooks processes the tasks which deliver webhook events.
+	webhooks Handler
+
+	// webhookWorkers is the number of workers delivering webhook events.
+	// It must be accessed atomically.
+	webhookWorkers int32
+
+	// qmu guards queueConfig and orderedQueues which can be changed
+	// while the processor is running.
+	qmu         sync.Mutex
 	queueConfig map[string]int
 
 	// orderedQueues is set only in strict-priority mode.
@@ -33,6 +45,18 @@ type processor struct {
 
 	errHandler ErrorHandler
 
+	// eventHandler handles task events, may be nil.
+	eventHandler EventHandler
+
+	// publishEvents indicates whether task events are published to redis.
+	publishEvents bool
+
+	// encrypter decrypts task payloads, may be nil.
+	encrypter Encrypter
+
+	// blobStore stores offloaded task payloads, may be nil.
+	blobStore BlobStore
+
 	shutdownTimeout time.Duration
 
 	// channel via which to send sync requests to syncer.
@@ -42,8 +66,9 @@ type processor struct {
 	errLogLimiter *rate.Limiter
 
 	// sema is a counting semaphore to ensure the number of active workers
-	// does not exceed the limit.
-	sema chan struct{}
+	// does not exceed the limit. The limit can be changed while the
+	// processor is running.
+	sema *semaphore
 
 	// channel to communicate back to the long running "processor" goroutine.
 	// once is used to send value to the channel only once.
@@ -56,9 +81,17 @@ type processor struct {
 	// quit channel communicates to the in-flight worker goroutines to stop.
 	quit chan struct{}
 
+	// abandoned holds the messages of the tasks whose worker quit
+	// before the handler returned.
+	mu        sync.Mutex
+	abandoned []*base.TaskMessage
+
 	// cancelations is a set of cancel functions for all in-progress tasks.
 	cancelations *base.Cancelations
 
+	// progress holds the progress reported by handlers of in-progress tasks.
+	progress *base.ProgressReports
+
 	starting chan<- *base.TaskMessage
 	finished chan<- *base.TaskMessage
 }
@@ -71,10 +104,16 @@ type processorParams struct {
 	retryDelayFunc  retryDelayFunc
 	syncCh          chan<- *syncRequest
 	cancelations    *base.Cancelations
+	progress        *base.ProgressReports
 	concurrency     int
 	queues          map[string]int
 	strictPriority  bool
 	errHandler      ErrorHandler
+	eventHandler    EventHandler
+	publishEvents   bool
+	encrypter       Encrypter
+	blobStore       BlobStore
+	webhookSecret   []byte
 	shutdownTimeout time.Duration
 	starting        chan<- *base.TaskMessage
 	finished        chan<- *base.TaskMessage
@@ -82,11 +121,7 @@ type processorParams struct {
 
 // newProcessor constructs a new processor.
 func newProcessor(params processorParams) *processor {
-	queues := normalizeQueues(params.queues)
-	orderedQueues := []string(nil)
-	if params.strictPriority {
-		orderedQueues = sortByPriority(queues)
-	}
+	queues, orderedQueues := queueSettings(params.queues, params.strictPriority)
 	return &processor{
 		logger:         params.logger,
 		broker:         params.broker,
@@ -95,18 +130,52 @@ func newProcessor(params processorParams) *processor {
 		retryDelayFunc: params.retryDelayFunc,
 		syncRequestCh:  params.syncCh,
 		cancelations:   params.cancelations,
+		progress:       params.progress,
 		errLogLimiter:  rate.NewLimiter(rate.Every(3*time.Second), 1),
-		sema:           make(chan struct{}, params.concurrency),
+		sema:           newSemaphore(params.concurrency),
 		done:           make(chan struct{}),
 		abort:          make(chan struct{}),
 		quit:           make(chan struct{}),
 		errHandler:     params.errHandler,
+		eventHandler:   params.eventHandler,
+		publishEvents:  params.publishEvents,
+		encrypter:      params.encrypter,
+		blobStore:      params.blobStore,
 		handler:        HandlerFunc(func(ctx context.Context, t *Task) error { return fmt.Errorf("handler not set") }),
+		webhooks:       &webhookHandler{client: &http.Client{}, secret: params.webhookSecret},
 		starting:       params.starting,
 		finished:       params.finished,
 	}
 }
 
+// queueSettings returns the normalized queue config and the queue names
+// ordered by priority in strict-priority mode.
+func queueSettings(queues map[string]int, strict bool) (map[string]int, []string) {
+	queues = normalizeQueues(queues)
+	var orderedQueues []string
+	if strict {
+		orderedQueues = sortByPriority(queues)
+	}
+	return queues, orderedQueues
+}
+
+// setConcurrency changes the maximum number of active workers.
+// Workers running in excess of the new limit are not interrupted,
+// new tasks are not processed until the number of active workers
+// drops below the limit.
+func (p *processor) setConcurrency(n int) {
+	p.sema.resize(n)
+}
+
+// setQueues changes the queues to process and their priorities.
+func (p *processor) setQueues(queues map[string]int, strict bool) {
+	queues, orderedQueues := queueSettings(queues, strict)
+	p.qmu.Lock()
+	defer p.qmu.Unlock()
+	p.queueConfig = queues
+	p.orderedQueues = orderedQueues
+}
+
 // Note: stops only the "processor" goroutine, does not stop workers.
 // It's safe to call this method multiple times.
 func (p *processor) stop() {
@@ -133,13 +202,58 @@ func (p *processor) terminate() {
 	}
 
 	// block until all workers have released the token
-	for i := 0; i < cap(p.sema); i++ {
-		p.sema <- struct{}{}
-	}
+	p.sema.wait()
 	p.logger.Info("All workers have finished")
 	p.restore() // move any unfinished tasks back to the queue.
 }
 
+// shutdown stops the processor and waits for all workers to finish
+// until ctx is done. If cancelHandlers is true, the contexts of all
+// in-progress task handlers are canceled right away, otherwise they are
+// canceled only when ctx is done.
+//
+// Unlike terminate, shutdown moves only the tasks abandoned by this processor
+// back to their queues, and returns them.
+//
+// NOTE: once shut down, processor cannot be re-started.
+func (p *processor) shutdown(ctx context.Context, cancelHandlers bool) (abandoned []*base.TaskMessage, err error) {
+	p.stop()
+
+	p.logger.Info("Waiting for all workers to finish...")
+	if cancelHandlers {
+		for _, cancel := range p.cancelations.GetAll() {
+			cancel()
+		}
+	}
+
+	// block until all workers have released the token
+	finished := make(chan struct{})
+	go func() {
+		p.sema.wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		p.logger.Info("All workers have finished")
+	case <-ctx.Done():
+		err = ctx.Err()
+		p.logger.Warnf("Abandoning unfinished tasks: %v", err)
+		for _, cancel := range p.cancelations.GetAll() {
+			cancel()
+		}
+		close(p.quit)
+		<-finished
+	}
+
+	p.mu.Lock()
+	abandoned, p.abandoned = p.abandoned, nil
+	p.mu.Unlock()
+	for _, msg := range abandoned {
+		p.requeue(msg)
+	}
+	return abandoned, err
+}
+
 func (p *processor) start(wg *sync.WaitGroup) {
 	// NOTE: The call to "restore" needs to complete before starting
 	// the processor goroutine.
@@ -162,7 +276,13 @@ func (p *processor) start(wg *sync.WaitGroup) {
 // exec pulls a task out of the queue and starts a worker goroutine to
 // process the task.
 func (p *processor) exec() {
+	// Webhook events are delivered ahead of the tasks in the other queues,
+	// but only by a share of the workers so that a burst of events cannot
+	// hold up the other queues.
 	qnames := p.queues()
+	if int(atomic.LoadInt32(&p.webhookWorkers)) < maxWebhookWorkers(p.sema.size()) {
+		qnames = append([]string{base.WebhookQueueName}, qnames...)
+	}
 	msg, err := p.broker.Dequeue(qnames...)
 	switch {
 	case err == rdb.ErrNoProcessableTask:
@@ -180,55 +300,105 @@ func (p *processor) exec() {
 		return
 	}
 
-	select {
-	case <-p.abort:
+	isWebhook := msg.Type == webhookTaskType && msg.Queue == base.WebhookQueueName
+	if isWebhook {
+		atomic.AddInt32(&p.webhookWorkers, 1)
+	}
+	if !p.sema.acquire(p.abort) {
 		// shutdown is starting, return immediately after requeuing the message.
 		p.requeue(msg)
+		if isWebhook {
+			atomic.AddInt32(&p.webhookWorkers, -1)
+		}
 		return
-	case p.sema <- struct{}{}: // acquire token
-		p.starting <- msg
-		go func() {
-			defer func() {
-				p.finished <- msg
-				<-p.sema // release token
-			}()
-
-			ctx, cancel := createContext(msg)
-			p.cancelations.Add(msg.ID.String(), cancel)
-			defer func() {
-				cancel()
-				p.cancelations.Delete(msg.ID.String())
-			}()
-
-			resCh := make(chan error, 1)
-			task := NewTask(msg.Type, msg.Payload)
-			go func() { resCh <- perform(ctx, task, p.handler) }()
+	}
+	p.starting <- msg
+	go func() {
+		defer func() {
+			p.finished <- msg
+			if isWebhook {
+				atomic.AddInt32(&p.webhookWorkers, -1)
+			}
+			p.sema.release() // release token
+		}()
+		started := time.Now()
+		p.emit(EventStarted, msg, nil, 0)
+
+		ctx, cancel := createContext(msg)
+		p.cancelations.AddTask(msg, cancel)
+		defer func() {
+			cancel()
+			p.cancelations.Delete(msg.ID.String())
+			p.progress.Delete(msg.ID.String())
+		}()
+		ctx = withProgressReporter(ctx, func(percent int, message string) {
+			p.progress.Set(msg.ID.String(), base.Progress{Percent: percent, Message: message, Updated: time.Now()})
+		})
+		var result taskResult
+		ctx = withResultRecorder(ctx, result.set)
+
+		resCh := make(chan error, 1)
+		task, err := p.newTask(msg)
+		if err != nil {
+			// Payload cannot be handed to the handler; treat it as a failed attempt
+			// so that the task is retried (e.g. after the key has been deployed).
+			resCh <- fmt.Errorf("could not load payload: %v", err)
+		} else {
+			handler := p.handler
+			if isWebhook {
+				handler = p.webhooks
+			}
+			go func() { resCh <- perform(ctx, task, handler) }()
+		}
 
-			select {
-			case <-p.quit:
-				// time is up, quit this worker goroutine.
-				p.logger.Warnf("Quitting worker. task id=%s", msg.ID)
-				return
-			case resErr := <-resCh:
-				// Note: One of three things should happen.
-				// 1) Done  -> Removes the message from InProgress
-				// 2) Retry -> Removes the message from InProgress & Adds the message to Retry
-				// 3) Kill  -> Removes the message from InProgress & Adds the message to Dead
-				if resErr != nil {
-					if p.errHandler != nil {
-						p.errHandler.HandleError(task, resErr, msg.Retried, msg.Retry)
-					}
-					if msg.Retried >= msg.Retry {
-						p.kill(msg, resErr)
-					} else {
-						p.retry(msg, resErr)
-					}
-					return
+		select {
+		case <-p.quit:
+			// time is up, quit this worker goroutine.
+			p.logger.Warnf("Quitting worker. task id=%s", msg.ID)
+			p.mu.Lock()
+			p.abandoned = append(p.abandoned, msg)
+			p.mu.Unlock()
+			return
+		case resErr := <-resCh:
+			// Note: One of three things should happen.
+			// 1) Done  -> Removes the message from InProgress
+			// 2) Retry -> Removes the message from InProgress & Adds the message to Retry
+			// 3) Kill  -> Removes the message from InProgress & Adds the message to Dead
+			if resErr != nil {
+				if p.errHandler != nil {
+					p.errHandler.HandleError(task, resErr, msg.Retried, msg.Retry)
 				}
-				p.markAsDone(msg)
+				// Tasks canceled to be killed skip the remaining retries.
+				if msg.Retried >= msg.Retry || p.cancelations.Killed(msg.ID.String()) {
+					p.kill(msg, resErr)
+					p.emit(EventKilled, msg, resErr, time.Since(started))
+					p.enqueueWebhook(msg, EventKilled, resErr, result.get())
+				} else {
+					p.retry(msg, task, resErr)
+					p.emit(EventRetried, msg, resErr, time.Since(started))
+				}
+				return
 			}
-		}()
+			p.markAsDone(msg)
+			p.emit(EventCompleted, msg, nil, time.Since(started))
+			p.enqueueWebhook(msg, EventCompleted, nil, result.get())
+		}
+	}()
+}
+
+// newTask returns a task to be passed to the handler for the given message.
+// The payload is read from the blob store if it was offloaded by the client,
+// and decrypted if it was encrypted by the client.
+func (p *processor) newTask(msg *base.TaskMessage) (*Task, error) {
+	loaded, err := loadPayload(p.blobStore, msg)
+	if err != nil {
+		return NewTask(msg.Type, nil), err
 	}
+	payload, err := decryptPayload(p.encrypter, loaded)
+	if err != nil {
+		return NewTask(msg.Type, nil), err
+	}
+	return NewTask(msg.Type, payload), nil
 }
 
 // restore moves all tasks from "in-progress" back to queue
@@ -253,7 +423,7 @@ func (p *processor) requeue(msg *base.TaskMessage) {
 func (p *processor) markAsDone(msg *base.TaskMessage) {
 	err := p.broker.Done(msg)
 	if err != nil {
-		errMsg := fmt.Sprintf("Could not remove task id=%s type=%q from %q err: %+v", msg.ID, msg.Type, base.InProgressQueue, err)
+		errMsg := fmt.Sprintf("Could not remove task id=%s type=%q from in-progress tasks err: %+v", msg.ID, msg.Type, err)
 		p.logger.Warnf("%s; Will retry syncing", errMsg)
 		p.syncRequestCh <- &syncRequest{
 			fn: func() error {
@@ -264,12 +434,12 @@ func (p *processor) markAsDone(msg *base.TaskMessage) {
 	}
 }
 
-func (p *processor) retry(msg *base.TaskMessage, e error) {
-	d := p.retryDelayFunc(msg.Retried, e, NewTask(msg.Type, msg.Payload))
+func (p *processor) retry(msg *base.TaskMessage, task *Task, e error) {
+	d := p.retryDelayFunc(msg.Retried, e, task)
 	retryAt := time.Now().Add(d)
 	err := p.broker.Retry(msg, retryAt, e.Error())
 	if err != nil {
-		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.RetryQueue)
+		errMsg := fmt.Sprintf("Could not move task id=%s from in-progress to retry state", msg.ID)
 		p.logger.Warnf("%s; Will retry syncing", errMsg)
 		p.syncRequestCh <- &syncRequest{
 			fn: func() error {
@@ -284,7 +454,7 @@ func (p *processor) kill(msg *base.TaskMessage, e error) {
 	p.logger.Warnf("Retry exhausted for task id=%s", msg.ID)
 	err := p.broker.Kill(msg, e.Error())
 	if err != nil {
-		errMsg := fmt.Sprintf("Could not move task id=%s from %q to %q", msg.ID, base.InProgressQueue, base.DeadQueue)
+		errMsg := fmt.Sprintf("Could not move task id=%s from in-progress to dead state", msg.ID)
 		p.logger.Warnf("%s; Will retry syncing", errMsg)
 		p.syncRequestCh <- &syncRequest{
 			fn: func() error {
@@ -295,12 +465,37 @@ func (p *processor) kill(msg *base.TaskMessage, e error) {
 	}
 }
 
+// enqueueWebhook enqueues a task to post the event for msg to its webhook.
+// It's a no-op if msg has no webhook.
+func (p *processor) enqueueWebhook(msg *base.TaskMessage, state EventState, e error, result []byte) {
+	if msg.Webhook == "" {
+		return
+	}
+	wmsg, err := newWebhookMessage(p.encrypter, msg, state, e, result)
+	if err != nil {
+		p.logger.Errorf("Could not create webhook event for task id=%s: %v", msg.ID, err)
+		return
+	}
+	if err := p.broker.Enqueue(wmsg); err != nil {
+		p.logger.Errorf("Could not enqueue webhook event for task id=%s: %v", msg.ID, err)
+	}
+}
+
+// emit sends the event for msg to the event handler and redis.
+func (p *processor) emit(state EventState, msg *base.TaskMessage, err error, d time.Duration) {
+	if err := emitEvent(p.broker, p.eventHandler, p.publishEvents, state, msg, err, d); err != nil {
+		p.logger.Errorf("Could not publish %s event for task id=%s: %v", state, msg.ID, err)
+	}
+}
+
 // queues returns a list of queues to query.
 // Order of the queue names is based on the priority of each queue.
 // Queue names is sorted by their priority level if strict-priority is true.
 // If strict-priority is false, then the order of queue names are roughly based on
 // the priority level but randomized in order to avoid starving low priority queues.
 func (p *processor) queues() []string {
+	p.qmu.Lock()
+	defer p.qmu.Unlock()
 	// skip the overhead of generating a list of queue names
 	// if we are processing one queue.
 	if len(p.queueConfig) == 1 {
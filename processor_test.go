@@ -86,6 +86,7 @@ func TestProcessorSuccess(t *testing.T) {
 			retryDelayFunc:  defaultDelayFunc,
 			syncCh:          nil,
 			cancelations:    base.NewCancelations(),
+			progress:        base.NewProgressReports(),
 			concurrency:     10,
 			queues:          defaultQueueConfig,
 			strictPriority:  false,
@@ -201,6 +202,7 @@ func TestProcessorRetry(t *testing.T) {
 			retryDelayFunc:  delayFunc,
 			syncCh:          nil,
 			cancelations:    base.NewCancelations(),
+			progress:        base.NewProgressReports(),
 			concurrency:     10,
 			queues:          defaultQueueConfig,
 			strictPriority:  false,
@@ -224,7 +226,7 @@ func TestProcessorRetry(t *testing.T) {
 
 		cmpOpt := cmpopts.EquateApprox(0, float64(time.Second)) // allow up to a second difference in zset score
 		gotRetry := h.GetRetryEntries(t, r)
-		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt, cmpOpt); diff != "" {
+		if diff := cmp.Diff(tc.wantRetry, gotRetry, h.SortZSetEntryOpt, cmpOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 			t.Errorf("mismatch found in %q after running processor; (-want, +got)\n%s", base.RetryQueue, diff)
 		}
 
@@ -243,6 +245,152 @@ func TestProcessorRetry(t *testing.T) {
 	}
 }
 
+func TestProcessorCanceledTask(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	m1 := h.NewTaskMessage("report:generate", nil)
+	m2 := h.NewTaskMessage("report:generate", nil)
+
+	tests := []struct {
+		kill      bool // whether the task is canceled to be killed
+		msg       *base.TaskMessage
+		wantRetry int // number of tasks in retry queue at the end
+		wantDead  int // number of tasks in dead queue at the end
+	}{
+		{kill: false, msg: m1, wantRetry: 1, wantDead: 0},
+		{kill: true, msg: m2, wantRetry: 0, wantDead: 1},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{tc.msg})
+
+		starting := make(chan *base.TaskMessage)
+		finished := make(chan *base.TaskMessage)
+		done := make(chan struct{})
+		defer func() { close(done) }()
+		go fakeHeartbeater(starting, finished, done)
+		cancelations := base.NewCancelations()
+		p := newProcessor(processorParams{
+			logger:          testLogger,
+			broker:          rdbClient,
+			retryDelayFunc:  defaultDelayFunc,
+			syncCh:          nil,
+			cancelations:    cancelations,
+			progress:        base.NewProgressReports(),
+			concurrency:     10,
+			queues:          defaultQueueConfig,
+			strictPriority:  false,
+			errHandler:      nil,
+			shutdownTimeout: defaultShutdownTimeout,
+			starting:        starting,
+			finished:        finished,
+		})
+		p.handler = HandlerFunc(func(ctx context.Context, task *Task) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		p.start(&sync.WaitGroup{})
+		time.Sleep(time.Second) // wait for the task to start
+		if !cancelations.Cancel(tc.msg.ID.String(), tc.kill) {
+			t.Errorf("task %s is not in progress", tc.msg.ID)
+		}
+		time.Sleep(time.Second) // wait for the handler to return
+		p.terminate()
+
+		if n := len(h.GetRetryMessages(t, r)); n != tc.wantRetry {
+			t.Errorf("%q has %d tasks, want %d", base.RetryQueue, n, tc.wantRetry)
+		}
+		if n := len(h.GetDeadMessages(t, r)); n != tc.wantDead {
+			t.Errorf("%q has %d tasks, want %d", base.DeadQueue, n, tc.wantDead)
+		}
+	}
+}
+
+func TestProcessorDecryptsPayload(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	enc, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]interface{}{"user_id": float64(42)}
+	encrypted, err := encryptPayload(enc, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.EncryptedPayload = encrypted
+
+	tests := []struct {
+		encrypter     Encrypter
+		wantProcessed []*Task // tasks passed to the handler
+		wantRetry     int     // number of tasks in retry queue at the end
+	}{
+		{
+			encrypter:     enc,
+			wantProcessed: []*Task{NewTask(m1.Type, payload)},
+			wantRetry:     0,
+		},
+		{
+			encrypter:     nil, // server without the key cannot process the task.
+			wantProcessed: nil,
+			wantRetry:     1,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1})
+
+		var mu sync.Mutex
+		var processed []*Task
+		handler := func(ctx context.Context, task *Task) error {
+			mu.Lock()
+			defer mu.Unlock()
+			processed = append(processed, task)
+			return nil
+		}
+		starting := make(chan *base.TaskMessage)
+		finished := make(chan *base.TaskMessage)
+		done := make(chan struct{})
+		defer func() { close(done) }()
+		go fakeHeartbeater(starting, finished, done)
+		p := newProcessor(processorParams{
+			logger:          testLogger,
+			broker:          rdbClient,
+			retryDelayFunc:  defaultDelayFunc,
+			syncCh:          nil,
+			cancelations:    base.NewCancelations(),
+			progress:        base.NewProgressReports(),
+			concurrency:     10,
+			queues:          defaultQueueConfig,
+			strictPriority:  false,
+			errHandler:      nil,
+			encrypter:       tc.encrypter,
+			shutdownTimeout: defaultShutdownTimeout,
+			starting:        starting,
+			finished:        finished,
+		})
+		p.handler = HandlerFunc(handler)
+
+		p.start(&sync.WaitGroup{})
+		time.Sleep(2 * time.Second)
+		p.terminate()
+
+		mu.Lock()
+		if diff := cmp.Diff(tc.wantProcessed, processed, cmp.AllowUnexported(Payload{})); diff != "" {
+			t.Errorf("mismatch found in processed tasks; (-want, +got)\n%s", diff)
+		}
+		mu.Unlock()
+		if got := len(h.GetRetryMessages(t, r)); got != tc.wantRetry {
+			t.Errorf("%q has %d tasks, want %d", base.RetryQueue, got, tc.wantRetry)
+		}
+	}
+}
+
 func TestProcessorQueues(t *testing.T) {
 	sortOpt := cmp.Transformer("SortStrings", func(in []string) []string {
 		out := append([]string(nil), in...) // Copy input to avoid mutating it
@@ -282,6 +430,7 @@ func TestProcessorQueues(t *testing.T) {
 			retryDelayFunc:  defaultDelayFunc,
 			syncCh:          nil,
 			cancelations:    base.NewCancelations(),
+			progress:        base.NewProgressReports(),
 			concurrency:     10,
 			queues:          tc.queueCfg,
 			strictPriority:  false,
@@ -365,6 +514,7 @@ func TestProcessorWithStrictPriority(t *testing.T) {
 			retryDelayFunc:  defaultDelayFunc,
 			syncCh:          nil,
 			cancelations:    base.NewCancelations(),
+			progress:        base.NewProgressReports(),
 			concurrency:     1, // Set concurrency to 1 to make sure tasks are processed one at a time.
 			queues:          queueCfg,
 			strictPriority:  true,
@@ -90,7 +90,7 @@ func TestScheduler(t *testing.T) {
 		}
 
 		gotEnqueued := h.GetEnqueuedMessages(t, r)
-		if diff := cmp.Diff(tc.wantQueue, gotEnqueued, h.SortMsgOpt); diff != "" {
+		if diff := cmp.Diff(tc.wantQueue, gotEnqueued, h.SortMsgOpt, h.IgnoreEnqueuedAtOpt); diff != "" {
 			t.Errorf("mismatch found in %q after running scheduler: (-want, +got)\n%s", base.DefaultQueue, diff)
 		}
 	}
@@ -0,0 +1,92 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import "sync"
+
+// semaphore is a counting semaphore whose capacity can be changed
+// while tokens are held.
+//
+// Shrinking the capacity below the number of held tokens does not
+// affect the holders; new tokens are handed out only after enough
+// tokens have been released.
+type semaphore struct {
+	mu       sync.Mutex
+	capacity int
+	held     int
+
+	// notify is closed and replaced when a token is released
+	// or the capacity changes.
+	notify chan struct{}
+}
+
+func newSemaphore(n int) *semaphore {
+	return &semaphore{
+		capacity: n,
+		notify:   make(chan struct{}),
+	}
+}
+
+// acquire blocks until a token is acquired or abort is closed.
+// It reports whether a token is acquired.
+func (s *semaphore) acquire(abort <-chan struct{}) bool {
+	for {
+		s.mu.Lock()
+		if s.held < s.capacity {
+			s.held++
+			s.mu.Unlock()
+			return true
+		}
+		ch := s.notify
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-abort:
+			return false
+		}
+	}
+}
+
+// release releases a token acquired with acquire.
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.held--
+	s.broadcast()
+}
+
+// resize changes the capacity to n.
+func (s *semaphore) resize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = n
+	s.broadcast()
+}
+
+// size returns the capacity.
+func (s *semaphore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.capacity
+}
+
+// wait blocks until all tokens are released.
+func (s *semaphore) wait() {
+	for {
+		s.mu.Lock()
+		if s.held == 0 {
+			s.mu.Unlock()
+			return
+		}
+		ch := s.notify
+		s.mu.Unlock()
+		<-ch
+	}
+}
+
+func (s *semaphore) broadcast() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
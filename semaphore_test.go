@@ -0,0 +1,89 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := newSemaphore(2)
+	abort := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		if !s.acquire(abort) {
+			t.Fatalf("acquire() = false, want true")
+		}
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- s.acquire(abort) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquire() returned while the semaphore was full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Growing the semaphore should let the blocked caller in.
+	s.resize(3)
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("acquire() = false, want true")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire() did not return after resize")
+	}
+
+	// Shrinking below the held tokens should block new callers
+	// until enough tokens have been released.
+	s.resize(1)
+	go func() { acquired <- s.acquire(abort) }()
+	s.release()
+	s.release()
+	select {
+	case <-acquired:
+		t.Fatal("acquire() returned while the semaphore was over capacity")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.release()
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("acquire() = false, want true")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire() did not return after release")
+	}
+
+	// Closing abort should unblock the caller.
+	go func() { acquired <- s.acquire(abort) }()
+	close(abort)
+	select {
+	case ok := <-acquired:
+		if ok {
+			t.Fatal("acquire() = true after abort, want false")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire() did not return after abort")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		s.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait() returned while a token was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.release()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("wait() did not return after all tokens were released")
+	}
+}
@@ -17,7 +17,6 @@ import (
 
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/log"
-	"github.com/hibiken/asynq/internal/rdb"
 )
 
 // Server is responsible for managing the background-task processing.
@@ -46,6 +45,28 @@ type Server struct {
 	syncer      *syncer
 	heartbeater *heartbeater
 	subscriber  *subscriber
+	collector   *blobCollector
+	archiver    *deadArchiver
+	controller  *controller
+	watcher     *configWatcher
+
+	// mu guards the processing config below.
+	mu sync.Mutex
+
+	// concurrency, queues and strictPriority hold the config given by
+	// Config or the latest call to SetConcurrency and SetQueues.
+	concurrency    int
+	queues         map[string]int
+	strictPriority bool
+
+	// shared is the config read from redis, nil if unset.
+	shared *base.ServerConfig
+
+	// stopped is closed once the server has been stopped.
+	stopped chan struct{}
+
+	// shutdownOnce makes sure that the server is shut down only once.
+	shutdownOnce sync.Once
 }
 
 // Config specifies the server's background-task processing behavior.
@@ -92,6 +113,15 @@ type Config struct {
 	// higher priorities are empty.
 	StrictPriority bool
 
+	// WatchConfig indicates whether the server should pick up the
+	// processing config set via Inspector.SetSharedConfig.
+	//
+	// If set to true, the server periodically reads the shared config
+	// from redis. The shared concurrency and queues take precedence over
+	// Concurrency, Queues and StrictPriority, which are restored once the
+	// shared config is cleared.
+	WatchConfig bool
+
 	// ErrorHandler handles errors returned by the task handler.
 	//
 	// HandleError is invoked only if the task handler returns a non-nil error.
@@ -107,6 +137,69 @@ type Config struct {
 	// ErrorHandler: asynq.ErrorHandlerFunc(reportError)
 	ErrorHandler ErrorHandler
 
+	// EventHandler handles task events such as the start and completion
+	// of task processing.
+	//
+	// If unset, events are not handled.
+	EventHandler EventHandler
+
+	// PublishEvents indicates whether the server should publish task events
+	// to redis so that they can be followed with Inspector.TailEvents or
+	// the "asynq tail" command.
+	PublishEvents bool
+
+	// WebhookSecret is the key used to sign the events posted to the
+	// webhooks of tasks. See Webhook option.
+	//
+	// If unset, the events are posted without a signature.
+	WebhookSecret []byte
+
+	// Encrypter decrypts task payloads encrypted by clients.
+	//
+	// It needs to hold every key that clients may have used to encrypt
+	// the payloads of tasks that are still in redis.
+	// If unset, encrypted tasks fail with an error and are retried.
+	Encrypter Encrypter
+
+	// BlobStore stores task payloads offloaded by clients.
+	//
+	// If set, the server reads offloaded payloads from the store and
+	// deletes blobs once they are no longer referenced by any task.
+	// If unset, tasks with offloaded payloads fail with an error and are retried.
+	BlobStore BlobStore
+
+	// DeadMaxTasks is the maximum number of tasks kept in the dead queue.
+	// The oldest tasks are evicted once the limit is reached.
+	//
+	// If unset or zero, up to 10000 tasks are kept.
+	// If set to a negative value, the number of tasks is not limited.
+	DeadMaxTasks int
+
+	// DeadMaxAge is the maximum duration tasks are kept in the dead queue.
+	//
+	// If unset or zero, tasks are kept for 90 days.
+	// If set to a negative value, the age of tasks is not limited.
+	DeadMaxAge time.Duration
+
+	// Archiver keeps a record of tasks evicted from the dead queue.
+	//
+	// If set, evicted tasks are kept in redis until they are passed to
+	// the archiver, even while no server with an archiver is running.
+	// If unset, evicted tasks are discarded.
+	//
+	// The dead queue limits and archival apply to all servers and
+	// inspectors sharing the redis, so servers should use the same values.
+	// They apply as long as a server with the values is running, and the
+	// defaults apply again shortly after the last such server stops.
+	Archiver Archiver
+
+	// StatsRetention specifies how long the per-queue and per-task-type
+	// processing statistics are kept.
+	//
+	// If unset, minute statistics are kept for 24 hours, hour statistics
+	// for 7 days and day statistics for 90 days.
+	StatsRetention StatsRetention
+
 	// Logger specifies the logger used by the server instance.
 	//
 	// If unset, default logger is used.
@@ -262,15 +355,7 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	if delayFunc == nil {
 		delayFunc = defaultDelayFunc
 	}
-	queues := make(map[string]int)
-	for qname, p := range cfg.Queues {
-		if p > 0 {
-			queues[qname] = p
-		}
-	}
-	if len(queues) == 0 {
-		queues = defaultQueueConfig
-	}
+	queues := queueConfig(cfg.Queues)
 	shutdownTimeout := cfg.ShutdownTimeout
 	if shutdownTimeout == 0 {
 		shutdownTimeout = defaultShutdownTimeout
@@ -282,12 +367,16 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 	}
 	logger.SetLevel(toInternalLogLevel(loglevel))
 
-	rdb := rdb.NewRDB(createRedisClient(r))
+	rdb := createRDB(r)
+	rdb.SetStatsRetention(base.Minute, cfg.StatsRetention.Minute)
+	rdb.SetStatsRetention(base.Hour, cfg.StatsRetention.Hour)
+	rdb.SetStatsRetention(base.Day, cfg.StatsRetention.Day)
 	starting := make(chan *base.TaskMessage)
 	finished := make(chan *base.TaskMessage)
 	syncCh := make(chan *syncRequest)
 	status := base.NewServerStatus(base.StatusIdle)
 	cancels := base.NewCancelations()
+	progress := base.NewProgressReports()
 
 	syncer := newSyncer(syncerParams{
 		logger:     logger,
@@ -302,6 +391,7 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 		queues:         queues,
 		strictPriority: cfg.StrictPriority,
 		status:         status,
+		progress:       progress,
 		starting:       starting,
 		finished:       finished,
 	})
@@ -314,6 +404,22 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 		logger:       logger,
 		broker:       rdb,
 		cancelations: cancels,
+		serverID:     heartbeater.serverID,
+	})
+	collector := newBlobCollector(blobCollectorParams{
+		logger:   logger,
+		broker:   rdb,
+		store:    cfg.BlobStore,
+		interval: 5 * time.Second,
+	})
+	archiver := newDeadArchiver(deadArchiverParams{
+		logger:    logger,
+		broker:    rdb,
+		retention: deadRetention(cfg),
+		archiver:  cfg.Archiver,
+		encrypter: cfg.Encrypter,
+		store:     cfg.BlobStore,
+		interval:  5 * time.Second,
 	})
 	processor := newProcessor(processorParams{
 		logger:          logger,
@@ -321,15 +427,21 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 		retryDelayFunc:  delayFunc,
 		syncCh:          syncCh,
 		cancelations:    cancels,
+		progress:        progress,
 		concurrency:     n,
 		queues:          queues,
 		strictPriority:  cfg.StrictPriority,
 		errHandler:      cfg.ErrorHandler,
+		eventHandler:    cfg.EventHandler,
+		publishEvents:   cfg.PublishEvents,
+		encrypter:       cfg.Encrypter,
+		blobStore:       cfg.BlobStore,
+		webhookSecret:   cfg.WebhookSecret,
 		shutdownTimeout: shutdownTimeout,
 		starting:        starting,
 		finished:        finished,
 	})
-	return &Server{
+	srv := &Server{
 		logger:      logger,
 		broker:      rdb,
 		status:      status,
@@ -338,7 +450,134 @@ func NewServer(r RedisConnOpt, cfg Config) *Server {
 		syncer:      syncer,
 		heartbeater: heartbeater,
 		subscriber:  subscriber,
+		collector:   collector,
+		archiver:    archiver,
+		stopped:     make(chan struct{}),
+
+		concurrency:    n,
+		queues:         queues,
+		strictPriority: cfg.StrictPriority,
 	}
+	srv.controller = newController(controllerParams{
+		logger:   logger,
+		broker:   rdb,
+		serverID: heartbeater.serverID,
+		quiet:    srv.Quiet,
+		stop:     srv.Stop,
+	})
+	srv.watcher = newConfigWatcher(configWatcherParams{
+		logger:   logger,
+		broker:   rdb,
+		enabled:  cfg.WatchConfig,
+		interval: 5 * time.Second,
+		apply:    srv.applySharedConfig,
+	})
+	return srv
+}
+
+// StatsRetention specifies how long the processing statistics of each
+// granularity are kept after the end of their time bucket.
+//
+// Zero means the default retention of the granularity.
+// A negative value disables the statistics of the granularity.
+type StatsRetention struct {
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+}
+
+// deadRetention returns the dead queue retention config for cfg,
+// or nil if cfg uses the default config.
+func deadRetention(cfg Config) *base.DeadRetentionConfig {
+	if cfg.DeadMaxTasks == 0 && cfg.DeadMaxAge == 0 && cfg.Archiver == nil {
+		return nil
+	}
+	var maxAge int64
+	switch {
+	case cfg.DeadMaxAge < 0:
+		maxAge = -1
+	case cfg.DeadMaxAge > 0:
+		maxAge = int64(math.Ceil(cfg.DeadMaxAge.Seconds()))
+	}
+	return &base.DeadRetentionConfig{
+		MaxTasks: cfg.DeadMaxTasks,
+		MaxAge:   maxAge,
+		Archive:  cfg.Archiver != nil,
+	}
+}
+
+// queueConfig returns the queues with positive priority,
+// or the default queue config if there are none.
+func queueConfig(queues map[string]int) map[string]int {
+	res := make(map[string]int)
+	for qname, p := range queues {
+		if p > 0 {
+			res[qname] = p
+		}
+	}
+	if len(res) == 0 {
+		return defaultQueueConfig
+	}
+	return res
+}
+
+// SetConcurrency changes the maximum number of concurrent processing of tasks.
+//
+// If n is zero or negative, the number of CPUs usable by the current
+// process is used. Active workers are not interrupted when the concurrency
+// is lowered; new tasks are processed once enough of them have finished.
+//
+// If the server watches the shared config and the shared config sets
+// the concurrency, the new value takes effect once the shared config is cleared.
+func (srv *Server) SetConcurrency(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.concurrency = n
+	srv.applyConfig()
+}
+
+// SetQueues changes the queues to process and their priorities.
+// See Config.Queues and Config.StrictPriority for details.
+//
+// If the server watches the shared config and the shared config sets
+// the queues, the new values take effect once the shared config is cleared.
+func (srv *Server) SetQueues(queues map[string]int, strict bool) {
+	queues = queueConfig(queues)
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.queues = queues
+	srv.strictPriority = strict
+	srv.applyConfig()
+}
+
+func (srv *Server) applySharedConfig(cfg *base.ServerConfig) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.shared = cfg
+	srv.applyConfig()
+}
+
+// applyConfig applies the effective processing config to the processor
+// and the heartbeater.
+//
+// It must be called with srv.mu held.
+func (srv *Server) applyConfig() {
+	concurrency, queues, strict := srv.concurrency, srv.queues, srv.strictPriority
+	if cfg := srv.shared; cfg != nil {
+		if cfg.Concurrency > 0 {
+			concurrency = cfg.Concurrency
+		}
+		if len(cfg.Queues) > 0 {
+			queues = queueConfig(cfg.Queues)
+			strict = cfg.StrictPriority
+		}
+	}
+	srv.processor.setConcurrency(concurrency)
+	srv.processor.setQueues(queues, strict)
+	srv.heartbeater.setConfig(concurrency, queues, strict)
 }
 
 // A Handler processes tasks.
@@ -371,6 +610,9 @@ var ErrServerStopped = errors.New("asynq: the server has been stopped")
 // a signal, it gracefully shuts down all active workers and other
 // goroutines to process the tasks.
 //
+// Run also returns once the server is stopped by a stop command sent
+// via Inspector.StopServer or the CLI.
+//
 // Run returns any error encountered during server startup time.
 // If the server has already been stopped, ErrServerStopped is returned.
 func (srv *Server) Run(handler Handler) error {
@@ -406,8 +648,12 @@ func (srv *Server) Start(handler Handler) error {
 
 	srv.heartbeater.start(&srv.wg)
 	srv.subscriber.start(&srv.wg)
+	srv.controller.start(&srv.wg)
 	srv.syncer.start(&srv.wg)
 	srv.scheduler.start(&srv.wg)
+	srv.collector.start(&srv.wg)
+	srv.archiver.start(&srv.wg)
+	srv.watcher.start(&srv.wg)
 	srv.processor.start(&srv.wg)
 	return nil
 }
@@ -422,14 +668,82 @@ func (srv *Server) Stop() {
 		// server is not running, do nothing and return.
 		return
 	}
+	srv.shutdown(srv.processor.terminate)
+}
+
+// Shutdown gracefully shuts down the server without interrupting the
+// process, which makes it suitable for servers embedded in an application
+// with its own lifecycle management.
+//
+// Shutdown stops pulling new tasks off queues, cancels the contexts passed to
+// active handlers, and waits for the handlers to return.
+// If ctx is done before all handlers return, the unfinished tasks are
+// abandoned and pushed back to their queues.
+//
+// Shutdown returns the abandoned tasks. The returned error is ctx.Err() if
+// any task was abandoned because ctx is done, otherwise nil.
+// Once Shutdown returns, the server cannot be restarted.
+func (srv *Server) Shutdown(ctx context.Context) ([]*EnqueuedTask, error) {
+	return srv.shutdownWithContext(ctx, true)
+}
+
+// Drain is like Shutdown but lets active handlers finish processing
+// their tasks without canceling their contexts.
+// The contexts are canceled only when ctx is done.
+func (srv *Server) Drain(ctx context.Context) ([]*EnqueuedTask, error) {
+	return srv.shutdownWithContext(ctx, false)
+}
+
+func (srv *Server) shutdownWithContext(ctx context.Context, cancelHandlers bool) ([]*EnqueuedTask, error) {
+	switch srv.status.Get() {
+	case base.StatusIdle, base.StatusStopped:
+		// server is not running, do nothing and return.
+		return nil, nil
+	}
+	var (
+		abandoned []*base.TaskMessage
+		err       error
+	)
+	srv.shutdown(func() {
+		abandoned, err = srv.processor.shutdown(ctx, cancelHandlers)
+	})
+	var res []*EnqueuedTask
+	for _, msg := range abandoned {
+		task, loadErr := srv.processor.newTask(msg)
+		res = append(res, &EnqueuedTask{
+			Task:     task,
+			ID:       msg.ID.String(),
+			Queue:    msg.Queue,
+			Redacted: loadErr != nil,
+		})
+	}
+	return res, err
+}
+
+// shutdown terminates all components of the server, calling
+// stopProcessor to terminate the processor.
+//
+// The server is shut down only once, by the first caller. Later callers,
+// e.g. a stop command racing a signal, wait until the server is stopped.
+func (srv *Server) shutdown(stopProcessor func()) {
+	srv.shutdownOnce.Do(func() { srv.terminate(stopProcessor) })
+	<-srv.stopped
+}
 
+func (srv *Server) terminate(stopProcessor func()) {
 	srv.logger.Info("Starting graceful shutdown")
 	// Note: The order of termination is important.
 	// Sender goroutines should be terminated before the receiver goroutines.
 	// processor -> syncer (via syncCh)
 	// processor -> heartbeater (via starting, finished channels)
+	// controller -> processor (via Quiet)
+	// watcher -> processor, heartbeater (via applySharedConfig)
+	srv.controller.terminate()
+	srv.watcher.terminate()
 	srv.scheduler.terminate()
-	srv.processor.terminate()
+	srv.collector.terminate()
+	srv.archiver.terminate()
+	stopProcessor()
 	srv.syncer.terminate()
 	srv.subscriber.terminate()
 	srv.heartbeater.terminate()
@@ -438,6 +752,7 @@ func (srv *Server) Stop() {
 
 	srv.broker.Close()
 	srv.status.Set(base.StatusStopped)
+	close(srv.stopped)
 
 	srv.logger.Info("Exiting")
 }
@@ -7,10 +7,13 @@ package asynq
 import (
 	"context"
 	"fmt"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
 
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/hibiken/asynq/internal/testbroker"
 	"go.uber.org/goleak"
@@ -238,3 +241,199 @@ func TestLogLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestServerShutdownWithContext(t *testing.T) {
+	r := setup(t)
+	block := make(chan struct{})
+	defer close(block)
+
+	tests := []struct {
+		desc          string
+		drain         bool
+		handler       func(ctx context.Context) error
+		timeout       time.Duration
+		wantAbandoned int
+		wantErr       error
+	}{
+		{
+			desc:  "Shutdown cancels active handlers",
+			drain: false,
+			handler: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			timeout:       5 * time.Second,
+			wantAbandoned: 0,
+			wantErr:       nil,
+		},
+		{
+			desc:  "Drain waits for active handlers",
+			drain: true,
+			handler: func(ctx context.Context) error {
+				select {
+				case <-ctx.Done():
+					return fmt.Errorf("handler canceled: %v", ctx.Err())
+				case <-time.After(time.Second):
+					return nil
+				}
+			},
+			timeout:       5 * time.Second,
+			wantAbandoned: 0,
+			wantErr:       nil,
+		},
+		{
+			desc:  "Drain abandons unfinished tasks when context is done",
+			drain: true,
+			handler: func(ctx context.Context) error {
+				<-block
+				return nil
+			},
+			timeout:       time.Second,
+			wantAbandoned: 1,
+			wantErr:       context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range tests {
+		h.FlushDB(t, r)
+		c := NewClient(RedisClientOpt{Addr: redisAddr, DB: redisDB})
+		srv := NewServer(RedisClientOpt{Addr: redisAddr, DB: redisDB}, Config{
+			Concurrency: 1,
+			LogLevel:    testLogLevel,
+		})
+		started := make(chan struct{}, 1)
+		var handlerErr error
+		var mu sync.Mutex
+		handler := func(ctx context.Context, task *Task) error {
+			started <- struct{}{}
+			err := tc.handler(ctx)
+			mu.Lock()
+			handlerErr = err
+			mu.Unlock()
+			return err
+		}
+		if err := srv.Start(HandlerFunc(handler)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Enqueue(NewTask("transcode", nil)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: handler was not called", tc.desc)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+		var abandoned []*EnqueuedTask
+		var err error
+		if tc.drain {
+			abandoned, err = srv.Drain(ctx)
+		} else {
+			abandoned, err = srv.Shutdown(ctx)
+		}
+		cancel()
+		c.Close()
+
+		if err != tc.wantErr {
+			t.Errorf("%s: returned error %v, want %v", tc.desc, err, tc.wantErr)
+		}
+		if len(abandoned) != tc.wantAbandoned {
+			t.Errorf("%s: returned %d abandoned tasks, want %d", tc.desc, len(abandoned), tc.wantAbandoned)
+		}
+		if got := len(h.GetEnqueuedMessages(t, r)); got != tc.wantAbandoned {
+			t.Errorf("%s: %d tasks in the queue, want %d", tc.desc, got, tc.wantAbandoned)
+		}
+		if got := len(h.GetInProgressMessages(t, r)); got != 0 {
+			t.Errorf("%s: %d tasks in %q, want 0", tc.desc, got, base.InProgressQueue)
+		}
+		mu.Lock()
+		if tc.drain && tc.wantAbandoned == 0 && handlerErr != nil {
+			t.Errorf("%s: handler returned error %v, want nil", tc.desc, handlerErr)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestServerNamespace(t *testing.T) {
+	r := setup(t)
+	opt1 := RedisClientOpt{Addr: redisAddr, DB: redisDB, Namespace: "app1"}
+	opt2 := RedisClientOpt{Addr: redisAddr, DB: redisDB, Namespace: "app2"}
+	c1, c2 := NewClient(opt1), NewClient(opt2)
+	defer c1.Close()
+	defer c2.Close()
+	i1, i2 := NewInspector(opt1), NewInspector(opt2)
+	defer i1.Close()
+	defer i2.Close()
+
+	// The same unique task can be enqueued in both namespaces.
+	task := NewTask("send_email", map[string]interface{}{"user_id": "42"})
+	if err := c1.Enqueue(task, Unique(time.Hour)); err != nil {
+		t.Fatalf("could not enqueue a task in %q: %v", "app1", err)
+	}
+	if err := c2.Enqueue(task, Unique(time.Hour)); err != nil {
+		t.Fatalf("could not enqueue a task in %q: %v", "app2", err)
+	}
+
+	var mu sync.Mutex
+	processed := 0
+	srv := NewServer(opt1, Config{Concurrency: 1, LogLevel: testLogLevel})
+	handler := func(ctx context.Context, task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		processed++
+		return nil
+	}
+	if err := srv.Start(HandlerFunc(handler)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+
+	servers1, err := i1.ListServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers2, err := i2.ListServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Stop()
+
+	mu.Lock()
+	if processed != 1 {
+		t.Errorf("server in %q processed %d tasks, want 1", "app1", processed)
+	}
+	mu.Unlock()
+	if len(servers1) != 1 || len(servers2) != 0 {
+		t.Errorf("ListServers returned %d servers in %q and %d in %q, want 1 and 0",
+			len(servers1), "app1", len(servers2), "app2")
+	}
+
+	tests := []struct {
+		ns            string
+		inspector     *Inspector
+		wantEnqueued  int
+		wantProcessed int
+	}{
+		{"app1", i1, 0, 1},
+		{"app2", i2, 1, 0},
+	}
+	for _, tc := range tests {
+		stats, err := tc.inspector.CurrentStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Enqueued != tc.wantEnqueued || stats.Processed != tc.wantProcessed {
+			t.Errorf("CurrentStats in %q = {Enqueued: %d, Processed: %d}, want {Enqueued: %d, Processed: %d}",
+				tc.ns, stats.Enqueued, stats.Processed, tc.wantEnqueued, tc.wantProcessed)
+		}
+	}
+
+	keys, err := r.Keys(base.DefaultNamespace + ":*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("keys in the default namespace = %v, want none", keys)
+	}
+}
@@ -20,10 +20,13 @@ func (srv *Server) waitForSignals() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, unix.SIGTERM, unix.SIGINT, unix.SIGTSTP)
 	for {
-		sig := <-sigs
-		if sig == unix.SIGTSTP {
-			srv.Quiet()
-			continue
+		select {
+		case sig := <-sigs:
+			if sig == unix.SIGTSTP {
+				srv.Quiet()
+				continue
+			}
+		case <-srv.stopped:
 		}
 		break
 	}
@@ -18,5 +18,8 @@ func (srv *Server) waitForSignals() {
 	srv.logger.Info("Send signal TERM or INT to terminate the process")
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, windows.SIGTERM, windows.SIGINT)
-	<-sigs
+	select {
+	case <-sigs:
+	case <-srv.stopped:
+	}
 }
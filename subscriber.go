@@ -5,6 +5,9 @@
 package asynq
 
 import (
+	"encoding/json"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +26,9 @@ type subscriber struct {
 	// cancelations hold cancel functions for all in-progress tasks.
 	cancelations *base.Cancelations
 
+	// serverID is the ID of the server the subscriber belongs to.
+	serverID string
+
 	// time to wait before retrying to connect to redis.
 	retryTimeout time.Duration
 }
@@ -31,6 +37,7 @@ type subscriberParams struct {
 	logger       *log.Logger
 	broker       base.Broker
 	cancelations *base.Cancelations
+	serverID     string
 }
 
 func newSubscriber(params subscriberParams) *subscriber {
@@ -39,6 +46,7 @@ func newSubscriber(params subscriberParams) *subscriber {
 		broker:       params.broker,
 		done:         make(chan struct{}),
 		cancelations: params.cancelations,
+		serverID:     params.serverID,
 		retryTimeout: 5 * time.Second,
 	}
 }
@@ -80,11 +88,44 @@ func (s *subscriber) start(wg *sync.WaitGroup) {
 				s.logger.Debug("Subscriber done")
 				return
 			case msg := <-cancelCh:
-				cancel, ok := s.cancelations.Get(msg.Payload)
-				if ok {
-					cancel()
-				}
+				s.cancel(msg.Payload)
 			}
 		}
 	}()
 }
+
+// cancel cancels the in-progress tasks given by the cancelation message,
+// which is either a task ID or a cancelation request.
+func (s *subscriber) cancel(payload string) {
+	if !strings.HasPrefix(payload, "{") {
+		s.cancelations.Cancel(payload, false)
+		return
+	}
+	var req base.CancelationRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		s.logger.Errorf("Could not decode cancelation request %q: %v", payload, err)
+		return
+	}
+	if req.ServerID != "" && req.ServerID != s.serverID {
+		return
+	}
+	ids := s.cancelations.Match(func(msg *base.TaskMessage) bool {
+		return matchPattern(req.Type, msg.Type) && matchPattern(req.Queue, msg.Queue)
+	})
+	for _, id := range ids {
+		s.cancelations.Cancel(id, req.Kill)
+	}
+	if len(ids) > 0 {
+		s.logger.Infof("Canceled %d in-progress tasks", len(ids))
+	}
+}
+
+// matchPattern reports whether name matches the pattern in path.Match syntax.
+// Empty pattern matches any name.
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
@@ -9,6 +9,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	h "github.com/hibiken/asynq/internal/asynqtest"
 	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/hibiken/asynq/internal/testbroker"
@@ -120,3 +122,94 @@ func TestSubscriberWithRedisDown(t *testing.T) {
 	}
 	mu.Unlock()
 }
+
+func TestSubscriberCancelationRequest(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	m1 := h.NewTaskMessageWithQueue("report:generate", nil, "default")
+	m2 := h.NewTaskMessageWithQueue("report:generate", nil, "critical")
+	m3 := h.NewTaskMessageWithQueue("send_email", nil, "default")
+	const serverID = "server1"
+
+	tests := []struct {
+		desc       string
+		req        *base.CancelationRequest
+		wantCalled []string // IDs of the tasks whose cancel func should be called
+		wantKilled []string // IDs of the tasks which should be killed
+	}{
+		{
+			desc:       "by type",
+			req:        &base.CancelationRequest{Type: "report:generate"},
+			wantCalled: []string{m1.ID.String(), m2.ID.String()},
+		},
+		{
+			desc:       "by type pattern and queue",
+			req:        &base.CancelationRequest{Type: "report:*", Queue: "critical", Kill: true},
+			wantCalled: []string{m2.ID.String()},
+			wantKilled: []string{m2.ID.String()},
+		},
+		{
+			desc:       "by server",
+			req:        &base.CancelationRequest{ServerID: serverID, Kill: true},
+			wantCalled: []string{m1.ID.String(), m2.ID.String(), m3.ID.String()},
+			wantKilled: []string{m1.ID.String(), m2.ID.String(), m3.ID.String()},
+		},
+		{
+			desc:       "other server",
+			req:        &base.CancelationRequest{Type: "report:generate", ServerID: "server2"},
+			wantCalled: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		var (
+			mu     sync.Mutex
+			called []string
+		)
+		cancelations := base.NewCancelations()
+		for _, msg := range []*base.TaskMessage{m1, m2, m3} {
+			id := msg.ID.String()
+			cancelations.AddTask(msg, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				called = append(called, id)
+			})
+		}
+
+		subscriber := newSubscriber(subscriberParams{
+			logger:       testLogger,
+			broker:       rdbClient,
+			cancelations: cancelations,
+			serverID:     serverID,
+		})
+		var wg sync.WaitGroup
+		subscriber.start(&wg)
+
+		// wait for subscriber to establish connection to pubsub channel
+		time.Sleep(time.Second)
+
+		if err := rdbClient.PublishCancelationRequest(tc.req); err != nil {
+			subscriber.terminate()
+			t.Fatalf("could not publish cancelation request: %v", err)
+		}
+
+		// wait for redis to publish message
+		time.Sleep(time.Second)
+		subscriber.terminate()
+
+		mu.Lock()
+		if diff := cmp.Diff(tc.wantCalled, called, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("%s: canceled tasks = %v, want %v; (-want,+got)\n%s", tc.desc, called, tc.wantCalled, diff)
+		}
+		mu.Unlock()
+		var killed []string
+		for _, msg := range []*base.TaskMessage{m1, m2, m3} {
+			if cancelations.Killed(msg.ID.String()) {
+				killed = append(killed, msg.ID.String())
+			}
+		}
+		if diff := cmp.Diff(tc.wantKilled, killed, h.SortStringSliceOpt); diff != "" {
+			t.Errorf("%s: killed tasks = %v, want %v; (-want,+got)\n%s", tc.desc, killed, tc.wantKilled, diff)
+		}
+	}
+}
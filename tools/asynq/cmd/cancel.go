@@ -6,48 +6,113 @@ package cmd
 
 import (
 	"fmt"
-	"os"
+	"path"
+	"strings"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/hibiken/asynq"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // cancelCmd represents the cancel command
 var cancelCmd = &cobra.Command{
 	Use:   "cancel [task id]",
-	Short: "Sends a cancelation signal to the goroutine processing the specified task",
-	Long: `Cancel (asynq cancel) will send a cancelation signal to the goroutine processing 
-the specified task. 
+	Short: "Cancels the specified task or in-progress tasks",
+	Long: `Cancel (asynq cancel) will cancel the specified task.
 
 The command takes one argument which specifies the task to cancel.
-The task should be in in-progress state.
-Identifier for a task should be obtained by running "asynq ls" command.
+Enqueued, scheduled and retry tasks are deleted before they start processing,
+and their uniqueness locks are released. For in-progress tasks, a cancelation
+signal is sent to the goroutine processing the task.
+
+Without an argument, the command sends a cancelation signal for all
+in-progress tasks matching the --type, --queue and --server flags.
+The type and queue flags accept patterns such as "report:*".
+Canceled tasks are retried, or moved to the dead queue with --kill.
 
 Handler implementation needs to be context aware for cancelation signal to
 actually cancel the processing.
 
-Example: asynq cancel bnogo8gt6toe23vhef0g`,
-	Args: cobra.ExactArgs(1),
+Example: asynq cancel bnogo8gt6toe23vhef0g
+Example: asynq cancel --type=report:generate --kill`,
+	Args: cobra.MaximumNArgs(1),
 	Run:  cancel,
 }
 
+var (
+	cancelType   string
+	cancelQueue  string
+	cancelServer string
+	cancelKill   bool
+)
+
 func init() {
 	rootCmd.AddCommand(cancelCmd)
+	cancelCmd.Flags().StringVar(&cancelType, "type", "", "cancel in-progress tasks whose type matches the pattern")
+	cancelCmd.Flags().StringVar(&cancelQueue, "queue", "", "cancel in-progress tasks in the queues matching the pattern")
+	cancelCmd.Flags().StringVar(&cancelServer, "server", "", "cancel in-progress tasks processed by the server with the ID")
+	cancelCmd.Flags().BoolVar(&cancelKill, "kill", false, "move the canceled in-progress tasks to the dead queue instead of retrying them")
 }
 
 func cancel(cmd *cobra.Command, args []string) {
-	r := rdb.NewRDB(redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	}))
-
-	err := r.PublishCancelation(args[0])
+	bulk := cancelType != "" || cancelQueue != "" || cancelServer != ""
+	switch {
+	case len(args) == 1 && bulk:
+		failUsage("task id cannot be used with --type, --queue or --server")
+	case len(args) == 1 && cancelKill:
+		failUsage("--kill can be used only with --type, --queue or --server")
+	case len(args) == 0 && !bulk:
+		failUsage("task id or one of --type, --queue and --server is required")
+	}
+	i := newInspector()
+	defer i.Close()
+	if bulk {
+		cancelAll(i)
+		return
+	}
+	t, err := i.GetTask(args[0])
 	if err != nil {
-		fmt.Printf("could not send cancelation signal: %v\n", err)
-		os.Exit(1)
+		fail(err)
+	}
+	if err := i.CancelTask(args[0]); err != nil {
+		fail(fmt.Errorf("could not cancel task: %w", err))
+	}
+	if t.State == "inprogress" {
+		printResult("cancel", args[0], nil, fmt.Sprintf("Successfully sent cancelation signal for task %s", args[0]))
+		return
+	}
+	printResult("cancel", args[0], taskCount(1), fmt.Sprintf("Successfully canceled %s task %s", t.State, args[0]))
+}
+
+// cancelAll sends a cancelation signal for the in-progress tasks
+// matching the flags.
+func cancelAll(i *asynq.Inspector) {
+	var (
+		opts   []asynq.CancelOption
+		target []string
+	)
+	if cancelType != "" {
+		opts = append(opts, asynq.CancelType(cancelType))
+		target = append(target, "type="+cancelType)
+	}
+	if cancelQueue != "" {
+		opts = append(opts, asynq.CancelQueue(cancelQueue))
+		target = append(target, "queue="+cancelQueue)
+	}
+	if cancelServer != "" {
+		opts = append(opts, asynq.CancelServer(cancelServer))
+		target = append(target, "server="+cancelServer)
+	}
+	if cancelKill {
+		opts = append(opts, asynq.KillCanceled())
+	}
+	for _, pattern := range []string{cancelType, cancelQueue} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			failUsage("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if err := i.CancelTasks(opts...); err != nil {
+		fail(fmt.Errorf("could not send cancelation signal: %w", err))
 	}
-	fmt.Printf("Successfully sent cancelation siganl for task %s\n", args[0])
+	printResult("cancel", strings.Join(target, ","), nil,
+		fmt.Sprintf("Successfully sent cancelation signal for in-progress tasks with %s", strings.Join(target, " ")))
 }
@@ -0,0 +1,642 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/rs/xid"
+	"github.com/spf13/cobra"
+)
+
+// dashCmd represents the dash command
+var dashCmd = &cobra.Command{
+	Use:   "dash",
+	Short: "Shows a live dashboard of queues, servers and workers",
+	Long: `Dash (asynq dash) will show a dashboard in the terminal which refreshes
+the state of queues, servers and workers until you quit.
+
+The dashboard shows the following:
+* Size and latency of each queue
+* Number of tasks of each queue processed and failed in the last minute
+* Number of tasks in each state
+* Running servers and active workers
+
+Select a queue and press enter to list its tasks. The tasks can be run,
+killed or deleted, and the queue paused or resumed, with the keys shown
+at the bottom of the screen. Press q to quit.
+
+Example: asynq dash --interval=5s`,
+	Args: cobra.NoArgs,
+	Run:  dash,
+}
+
+var dashInterval time.Duration
+
+func init() {
+	rootCmd.AddCommand(dashCmd)
+	dashCmd.Flags().DurationVar(&dashInterval, "interval", 3*time.Second, "interval to refresh the dashboard")
+}
+
+func dash(cmd *cobra.Command, args []string) {
+	if dashInterval <= 0 {
+		failUsage("--interval must be positive")
+	}
+	d := &dashboard{
+		rdb: newRDB(),
+		fd:  int(os.Stdin.Fd()),
+	}
+	if err := d.run(); err != nil {
+		fail(err)
+	}
+}
+
+// dashStates are the states of tasks listed in the queue view.
+var dashStates = []string{"enqueued", "scheduled", "retry", "dead"}
+
+// dashboard holds the state of the dash command.
+type dashboard struct {
+	rdb *rdb.RDB
+	fd  int // file descriptor of the terminal
+
+	// Data of the last refresh.
+	stats   *rdb.Stats
+	rates   map[string]*rdb.ProcessingStats // last complete minute of each queue
+	servers []*base.ServerInfo
+	workers []*base.WorkerInfo
+	tasks   []*dashTask
+	updated time.Time
+	err     error
+
+	queue   string // queue listed in the queue view, or empty for the overview
+	state   int    // index of the state in dashStates listed in the queue view
+	page    int    // page of the tasks listed in the queue view
+	cursor  int    // selected queue or task
+	confirm *dashAction
+	message string // result of the last action
+}
+
+// dashTask is a task listed in the queue view.
+type dashTask struct {
+	id      xid.ID
+	score   int64
+	typ     string
+	payload interface{}
+	detail  string
+}
+
+// dashAction is an action taken from the dashboard.
+type dashAction struct {
+	verb   string // e.g. "delete"
+	target string // e.g. "task bnogo8gt6toe23vhef0g"
+	fn     func() error
+}
+
+// dashPastTense is the past tense of the verbs of actions.
+var dashPastTense = map[string]string{
+	"run":     "Enqueued",
+	"kill":    "Killed",
+	"delete":  "Deleted",
+	"pause":   "Paused",
+	"unpause": "Resumed",
+}
+
+func (d *dashboard) run() error {
+	restore, err := makeRaw(d.fd)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	// Use the alternate screen with a hidden cursor, and restore both on exit.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(dashInterval)
+	defer ticker.Stop()
+	d.refresh()
+	for {
+		d.render()
+		select {
+		case k, ok := <-keys:
+			if !ok || !d.handleKey(k) {
+				return nil
+			}
+		case <-ticker.C:
+			d.refresh()
+		}
+	}
+}
+
+// readKeys sends the keys read from r to ch until reading fails.
+func readKeys(r io.Reader, ch chan<- string) {
+	defer close(ch)
+	buf := make([]byte, 32)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			ch <- k
+		}
+	}
+}
+
+// parseKeys returns the keys in the input read from a raw terminal.
+// Special keys are named, e.g. "up" and "enter".
+func parseKeys(b []byte) []string {
+	var keys []string
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == 0x1b && i+2 < len(b) && b[i+1] == '[':
+			switch b[i+2] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			case 'C':
+				keys = append(keys, "right")
+			case 'D':
+				keys = append(keys, "left")
+			}
+			i += 2
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == '\t':
+			keys = append(keys, "tab")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			keys = append(keys, string(c))
+		}
+	}
+	return keys
+}
+
+// handleKey updates the dashboard for the pressed key.
+// It returns false if the dashboard should quit.
+func (d *dashboard) handleKey(k string) bool {
+	if a := d.confirm; a != nil {
+		d.confirm = nil
+		if k == "y" {
+			d.do(a)
+		} else {
+			d.message = "Canceled"
+		}
+		return true
+	}
+	d.message = ""
+	switch k {
+	case "q", "ctrl-c":
+		return false
+	case "up", "k":
+		if d.cursor > 0 {
+			d.cursor--
+		}
+		return true
+	case "down", "j":
+		if d.cursor < d.rows()-1 {
+			d.cursor++
+		}
+		return true
+	case "p":
+		d.togglePause()
+		return true
+	}
+	if d.queue == "" {
+		if k == "enter" && d.cursor < d.rows() {
+			d.queue = d.stats.Queues[d.cursor].Name
+			d.state, d.page, d.cursor = 0, 0, 0
+			d.refresh()
+		}
+		return true
+	}
+	switch k {
+	case "esc", "backspace":
+		qname := d.queue
+		d.queue, d.cursor = "", 0
+		d.refresh()
+		for i, q := range d.stats.Queues {
+			if q.Name == qname {
+				d.cursor = i
+			}
+		}
+	case "tab":
+		d.state = (d.state + 1) % len(dashStates)
+		d.page, d.cursor = 0, 0
+		d.refresh()
+	case "right":
+		if len(d.tasks) == d.pageSize() {
+			d.page, d.cursor = d.page+1, 0
+			d.refresh()
+		}
+	case "left":
+		if d.page > 0 {
+			d.page, d.cursor = d.page-1, 0
+			d.refresh()
+		}
+	case "r":
+		d.taskAction("run")
+	case "x":
+		d.taskAction("kill")
+	case "d":
+		d.taskAction("delete")
+	}
+	return true
+}
+
+// rows returns the number of selectable rows in the current view.
+func (d *dashboard) rows() int {
+	if d.queue != "" {
+		return len(d.tasks)
+	}
+	if d.stats == nil {
+		return 0
+	}
+	return len(d.stats.Queues)
+}
+
+// togglePause pauses or resumes the queue in the queue view, or the
+// selected queue in the overview.
+func (d *dashboard) togglePause() {
+	if d.stats == nil {
+		return
+	}
+	var queue *rdb.Queue
+	for i, q := range d.stats.Queues {
+		if q.Name == d.queue || (d.queue == "" && i == d.cursor) {
+			queue = q
+		}
+	}
+	if queue == nil {
+		return
+	}
+	qname := queue.Name
+	if queue.Paused {
+		d.do(&dashAction{"unpause", "queue " + qname, func() error { return d.rdb.Unpause(qname) }})
+	} else {
+		d.do(&dashAction{"pause", "queue " + qname, func() error { return d.rdb.Pause(qname) }})
+	}
+}
+
+// taskAction takes the action on the selected task.
+// Kill and delete wait for confirmation.
+func (d *dashboard) taskAction(verb string) {
+	if d.cursor >= len(d.tasks) {
+		return
+	}
+	t := d.tasks[d.cursor]
+	state := dashStates[d.state]
+	var fn func(xid.ID, int64) error
+	switch verb + " " + state {
+	case "run scheduled":
+		fn = d.rdb.EnqueueScheduledTask
+	case "run retry":
+		fn = d.rdb.EnqueueRetryTask
+	case "run dead":
+		fn = d.rdb.EnqueueDeadTask
+	case "kill scheduled":
+		fn = d.rdb.KillScheduledTask
+	case "kill retry":
+		fn = d.rdb.KillRetryTask
+	case "delete scheduled":
+		fn = d.rdb.DeleteScheduledTask
+	case "delete retry":
+		fn = d.rdb.DeleteRetryTask
+	case "delete dead":
+		fn = d.rdb.DeleteDeadTask
+	default:
+		d.message = fmt.Sprintf("Cannot %s %s tasks", verb, state)
+		return
+	}
+	a := &dashAction{verb, "task " + t.id.String(), func() error { return fn(t.id, t.score) }}
+	if verb == "run" {
+		d.do(a)
+		return
+	}
+	d.confirm = a
+}
+
+// do takes the action and refreshes the dashboard.
+func (d *dashboard) do(a *dashAction) {
+	if err := a.fn(); err != nil {
+		d.message = fmt.Sprintf("Could not %s %s: %v", a.verb, a.target, err)
+	} else {
+		d.message = fmt.Sprintf("%s %s", dashPastTense[a.verb], a.target)
+	}
+	d.refresh()
+}
+
+// refresh reloads the data shown on the dashboard.
+func (d *dashboard) refresh() {
+	d.err = d.load()
+	d.updated = time.Now()
+	if n := d.rows(); d.cursor >= n && n > 0 {
+		d.cursor = n - 1
+	}
+}
+
+func (d *dashboard) load() error {
+	stats, err := d.rdb.CurrentStats()
+	if err != nil {
+		return err
+	}
+	d.stats = stats
+	d.rates = make(map[string]*rdb.ProcessingStats)
+	for _, q := range stats.Queues {
+		res, err := d.rdb.QueueHistory(q.Name, base.Minute, 2)
+		if err != nil {
+			return err
+		}
+		d.rates[q.Name] = res[1]
+	}
+	if d.servers, err = d.rdb.ListServers(); err != nil {
+		return err
+	}
+	sort.Slice(d.servers, func(i, j int) bool {
+		x, y := d.servers[i], d.servers[j]
+		if x.Host != y.Host {
+			return x.Host < y.Host
+		}
+		return x.PID < y.PID
+	})
+	if d.workers, err = d.rdb.ListWorkers(); err != nil {
+		return err
+	}
+	sort.Slice(d.workers, func(i, j int) bool {
+		x, y := d.workers[i], d.workers[j]
+		if x.Started != y.Started {
+			return x.Started.Before(y.Started)
+		}
+		return x.ID < y.ID
+	})
+	if d.queue != "" {
+		d.tasks, err = d.listTasks()
+	}
+	return err
+}
+
+// listTasks returns the page of tasks of the queue in the selected state.
+func (d *dashboard) listTasks() ([]*dashTask, error) {
+	pgn := rdb.Pagination{Size: d.pageSize(), Page: d.page}
+	f := &rdb.Filter{Queue: d.queue}
+	var res []*dashTask
+	switch dashStates[d.state] {
+	case "enqueued":
+		tasks, err := d.rdb.ListEnqueued(d.queue, pgn, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			res = append(res, &dashTask{
+				id:      t.ID,
+				typ:     t.Type,
+				payload: formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+			})
+		}
+	case "scheduled":
+		tasks, err := d.rdb.ListScheduled(pgn, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			res = append(res, &dashTask{
+				id:      t.ID,
+				score:   t.Score,
+				typ:     t.Type,
+				payload: formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+				detail:  "process " + timeUntil(t.ProcessAt),
+			})
+		}
+	case "retry":
+		tasks, err := d.rdb.ListRetry(pgn, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			res = append(res, &dashTask{
+				id:      t.ID,
+				score:   t.Score,
+				typ:     t.Type,
+				payload: formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+				detail:  fmt.Sprintf("retry %d/%d %s: %s", t.Retried, t.Retry, timeUntil(t.ProcessAt), t.ErrorMsg),
+			})
+		}
+	case "dead":
+		tasks, err := d.rdb.ListDead(pgn, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			res = append(res, &dashTask{
+				id:      t.ID,
+				score:   t.Score,
+				typ:     t.Type,
+				payload: formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+				detail:  fmt.Sprintf("died %s: %s", timeAgo(t.LastFailedAt), t.ErrorMsg),
+			})
+		}
+	}
+	return res, nil
+}
+
+// timeUntil takes a time and returns a string of the format "in <duration>".
+func timeUntil(t time.Time) string {
+	d := time.Until(t).Round(time.Second)
+	if d <= 0 {
+		return "now"
+	}
+	return fmt.Sprintf("in %v", d)
+}
+
+// size returns the number of columns and rows of the terminal.
+func (d *dashboard) size() (width, height int) {
+	width, height, err := terminalSize(d.fd)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// pageSize returns the number of tasks which fit in the queue view.
+func (d *dashboard) pageSize() int {
+	_, height := d.size()
+	if n := height - 10; n > 5 {
+		return n
+	}
+	return 5
+}
+
+// render draws the dashboard on the screen.
+func (d *dashboard) render() {
+	width, height := d.size()
+	var lines []string
+	lines = append(lines, fmt.Sprintf("asynq dash  refreshed at %s every %v", d.updated.Format("15:04:05"), dashInterval))
+	if d.err != nil {
+		lines = append(lines, "error: "+d.err.Error())
+	}
+	lines = append(lines, "")
+
+	var help string
+	if d.queue == "" {
+		lines = append(lines, d.overview(height-len(lines)-3)...)
+		help = "[↑/↓] select  [enter] list tasks  [p] pause/resume  [q] quit"
+	} else {
+		lines = append(lines, d.queueView()...)
+		help = "[↑/↓] select  [←/→] page  [tab] state  [r] run  [x] kill  [d] delete  [p] pause/resume  [esc] back  [q] quit"
+	}
+
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	lines = lines[:height-2]
+	switch {
+	case d.confirm != nil:
+		lines = append(lines, fmt.Sprintf("%s %s? (y/n)", strings.Title(d.confirm.verb), d.confirm.target))
+	default:
+		lines = append(lines, d.message)
+	}
+	lines = append(lines, help)
+
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	for i, l := range lines {
+		if r := []rune(l); len(r) > width {
+			l = string(r[:width])
+		}
+		b.WriteString(l)
+		if i < len(lines)-1 {
+			b.WriteString("\n")
+		}
+	}
+	os.Stdout.Write(b.Bytes())
+}
+
+// overview returns the lines of the overview, with up to maxLines lines.
+func (d *dashboard) overview(maxLines int) []string {
+	if d.stats == nil {
+		return nil
+	}
+	var lines []string
+	lines = append(lines, "QUEUES")
+	var rows [][]interface{}
+	for i, q := range d.stats.Queues {
+		state := "running"
+		if q.Paused {
+			state = "paused"
+		}
+		var processed, failed int
+		if s := d.rates[q.Name]; s != nil {
+			processed, failed = s.Processed, s.Failed
+		}
+		rows = append(rows, []interface{}{d.marker(i), q.Name, state, q.Size,
+			formatLatency(q.OldestPendingAge), formatLatency(q.WaitP99), processed, failed})
+	}
+	lines = append(lines, dashTable([]string{"", "Queue", "State", "Size", "Oldest Task Age", "P99 Wait", "Processed/min", "Failed/min"}, rows)...)
+	lines = append(lines, "", "STATES")
+	s := d.stats
+	lines = append(lines, dashTable([]string{"InProgress", "Enqueued", "Scheduled", "Retry", "Dead"},
+		[][]interface{}{{s.InProgress, s.Enqueued, s.Scheduled, s.Retry, s.Dead}})...)
+
+	lines = append(lines, "", fmt.Sprintf("SERVERS (%d)", len(d.servers)))
+	rows = nil
+	for _, srv := range d.servers {
+		rows = append(rows, []interface{}{srv.ServerID, fmt.Sprintf("%s:%d", srv.Host, srv.PID), srv.Status,
+			fmt.Sprintf("%d/%d", srv.ActiveWorkerCount, srv.Concurrency), formatQueues(srv.Queues), timeAgo(srv.Started)})
+	}
+	lines = append(lines, dashTable([]string{"ID", "Process", "State", "Active Workers", "Queues", "Started"}, rows)...)
+
+	lines = append(lines, "", fmt.Sprintf("WORKERS (%d)", len(d.workers)))
+	rows = nil
+	for _, wk := range d.workers {
+		rows = append(rows, []interface{}{fmt.Sprintf("%s:%d", wk.Host, wk.PID), wk.ID, wk.Type, wk.Queue,
+			timeAgo(wk.Started), formatProgress(wk.Progress)})
+	}
+	workers := dashTable([]string{"Process", "Task ID", "Type", "Queue", "Started", "Progress"}, rows)
+	// Show as many workers as fit on the screen.
+	if n := maxLines - len(lines); len(workers) > n && n > 0 {
+		workers = append(workers[:n-1], fmt.Sprintf("... and %d more", len(workers)-n+1))
+	}
+	return append(lines, workers...)
+}
+
+// queueView returns the lines of the queue view.
+func (d *dashboard) queueView() []string {
+	var queue *rdb.Queue
+	if d.stats != nil {
+		for _, q := range d.stats.Queues {
+			if q.Name == d.queue {
+				queue = q
+			}
+		}
+	}
+	var lines []string
+	if queue == nil {
+		lines = append(lines, fmt.Sprintf("QUEUE %s", d.queue))
+	} else if queue.Paused {
+		lines = append(lines, fmt.Sprintf("QUEUE %s (paused)  size %d", queue.Name, queue.Size))
+	} else {
+		lines = append(lines, fmt.Sprintf("QUEUE %s  size %d", queue.Name, queue.Size))
+	}
+	var tabs []string
+	for i, s := range dashStates {
+		if i == d.state {
+			s = "[" + s + "]"
+		}
+		tabs = append(tabs, s)
+	}
+	lines = append(lines, strings.Join(tabs, "  "), "")
+	if len(d.tasks) == 0 {
+		return append(lines, fmt.Sprintf("No %s tasks", dashStates[d.state]))
+	}
+	var rows [][]interface{}
+	for i, t := range d.tasks {
+		rows = append(rows, []interface{}{d.marker(i), t.id, t.typ, t.payload, t.detail})
+	}
+	lines = append(lines, dashTable([]string{"", "ID", "Type", "Payload", "Info"}, rows)...)
+	return append(lines, "", fmt.Sprintf("Showing %d tasks from page %d", len(d.tasks), d.page))
+}
+
+// marker returns the marker of the selected row.
+func (d *dashboard) marker(row int) string {
+	if row == d.cursor {
+		return ">"
+	}
+	return ""
+}
+
+// dashTable returns the lines of a table with the columns and rows.
+func dashTable(cols []string, rows [][]interface{}) []string {
+	var b bytes.Buffer
+	format := strings.Repeat("%v\t", len(cols)) + "\n"
+	tw := new(tabwriter.Writer).Init(&b, 0, 8, 2, ' ', 0)
+	var headers, seps []interface{}
+	for _, name := range cols {
+		headers = append(headers, name)
+		seps = append(seps, strings.Repeat("-", len(name)))
+	}
+	fmt.Fprintf(tw, format, headers...)
+	fmt.Fprintf(tw, format, seps...)
+	for _, r := range rows {
+		fmt.Fprintf(tw, format, r...)
+	}
+	tw.Flush()
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // delCmd represents the del command
@@ -23,6 +19,7 @@ var delCmd = &cobra.Command{
 The command takes one argument which specifies the task to delete.
 The task should be in either scheduled, retry or dead state.
 Identifier for a task should be obtained by running "asynq ls" command.
+The ID of the task can be used as well.
 
 Example: asynq enq d:1575732274:bnogo8gt6toe23vhef0g`,
 	Args: cobra.ExactArgs(1),
@@ -44,16 +41,11 @@ func init() {
 }
 
 func del(cmd *cobra.Command, args []string) {
-	id, score, qtype, err := parseQueryID(args[0])
+	r := newRDB()
+	id, score, qtype, err := resolveQueryID(r, args[0])
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	r := rdb.NewRDB(redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	}))
 	switch qtype {
 	case "s":
 		err = r.DeleteScheduledTask(id, score)
@@ -62,12 +54,10 @@ func del(cmd *cobra.Command, args []string) {
 	case "d":
 		err = r.DeleteDeadTask(id, score)
 	default:
-		fmt.Println("invalid argument")
-		os.Exit(1)
+		fail(errInvalidID)
 	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Successfully deleted %v\n", args[0])
+	printResult("del", args[0], taskCount(1), fmt.Sprintf("Successfully deleted %v", args[0]))
 }
@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var delallValidArgs = []string{"scheduled", "retry", "dead"}
@@ -24,7 +20,11 @@ var delallCmd = &cobra.Command{
 
 The argument should be one of "scheduled", "retry", or "dead".
 
-Example: asynq delall dead -> Deletes all dead tasks`,
+Use --type, --payload, --error-contains, --from and --to to delete
+only the matching tasks.
+
+Example: asynq delall dead -> Deletes all dead tasks
+Example: asynq delall dead --type=email:send --to=2020-06-01T00:00:00Z`,
 	ValidArgs: delallValidArgs,
 	Args:      cobra.ExactValidArgs(1),
 	Run:       delall,
@@ -32,40 +32,26 @@ Example: asynq delall dead -> Deletes all dead tasks`,
 
 func init() {
 	rootCmd.AddCommand(delallCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// delallCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// delallCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	addFilterFlags(delallCmd)
 }
 
 func delall(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
+	f := taskFilter()
+	var n int64
 	var err error
 	switch args[0] {
 	case "scheduled":
-		err = r.DeleteAllScheduledTasks()
+		n, err = r.DeleteAllScheduledTasks(f)
 	case "retry":
-		err = r.DeleteAllRetryTasks()
+		n, err = r.DeleteAllRetryTasks(f)
 	case "dead":
-		err = r.DeleteAllDeadTasks()
+		n, err = r.DeleteAllDeadTasks(f)
 	default:
-		fmt.Printf("error: `asynq delall [state]` only accepts %v as the argument.\n", delallValidArgs)
-		os.Exit(1)
+		failUsage("`asynq delall [state]` only accepts %v as the argument", delallValidArgs)
 	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Deleted all tasks in %q state\n", args[0])
+	printResult("delall", args[0], taskCount(n), fmt.Sprintf("Deleted %d tasks in %q state", n, args[0]))
 }
@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // enqCmd represents the enq command
@@ -23,6 +19,7 @@ var enqCmd = &cobra.Command{
 The command takes one argument which specifies the task to enqueue.
 The task should be in either scheduled, retry or dead state.
 Identifier for a task should be obtained by running "asynq ls" command.
+The ID of the task can be used as well.
 
 The task enqueued by this command will be processed as soon as the task 
 gets dequeued by a processor.
@@ -47,16 +44,11 @@ func init() {
 }
 
 func enq(cmd *cobra.Command, args []string) {
-	id, score, qtype, err := parseQueryID(args[0])
+	r := newRDB()
+	id, score, qtype, err := resolveQueryID(r, args[0])
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	r := rdb.NewRDB(redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	}))
 	switch qtype {
 	case "s":
 		err = r.EnqueueScheduledTask(id, score)
@@ -65,12 +57,10 @@ func enq(cmd *cobra.Command, args []string) {
 	case "d":
 		err = r.EnqueueDeadTask(id, score)
 	default:
-		fmt.Println("invalid argument")
-		os.Exit(1)
+		fail(errInvalidID)
 	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Successfully enqueued %v\n", args[0])
+	printResult("enq", args[0], taskCount(1), fmt.Sprintf("Successfully enqueued %v", args[0]))
 }
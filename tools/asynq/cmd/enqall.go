@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var enqallValidArgs = []string{"scheduled", "retry", "dead"}
@@ -27,7 +23,11 @@ The argument should be one of "scheduled", "retry", or "dead".
 The tasks enqueued by this command will be processed as soon as it
 gets dequeued by a processor.
 
-Example: asynq enqall dead -> Enqueues all dead tasks`,
+Use --type, --payload, --error-contains, --from and --to to enqueue
+only the matching tasks.
+
+Example: asynq enqall dead -> Enqueues all dead tasks
+Example: asynq enqall dead --type=email:send --payload=user_id=42`,
 	ValidArgs: enqallValidArgs,
 	Args:      cobra.ExactValidArgs(1),
 	Run:       enqall,
@@ -35,41 +35,26 @@ Example: asynq enqall dead -> Enqueues all dead tasks`,
 
 func init() {
 	rootCmd.AddCommand(enqallCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// enqallCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// enqallCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	addFilterFlags(enqallCmd)
 }
 
 func enqall(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
+	f := taskFilter()
 	var n int64
 	var err error
 	switch args[0] {
 	case "scheduled":
-		n, err = r.EnqueueAllScheduledTasks()
+		n, err = r.EnqueueAllScheduledTasks(f)
 	case "retry":
-		n, err = r.EnqueueAllRetryTasks()
+		n, err = r.EnqueueAllRetryTasks(f)
 	case "dead":
-		n, err = r.EnqueueAllDeadTasks()
+		n, err = r.EnqueueAllDeadTasks(f)
 	default:
-		fmt.Printf("error: `asynq enqall [state]` only accepts %v as the argument.\n", enqallValidArgs)
-		os.Exit(1)
+		failUsage("`asynq enqall [state]` only accepts %v as the argument", enqallValidArgs)
 	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Enqueued %d tasks in %q state\n", n, args[0])
+	printResult("enqall", args[0], taskCount(n), fmt.Sprintf("Enqueued %d tasks in %q state", n, args[0]))
 }
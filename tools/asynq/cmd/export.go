@@ -0,0 +1,70 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports tasks to a file",
+	Long: `Export (asynq export) will write tasks to a file which can be read by
+the import command, e.g. to move tasks to another redis instance.
+
+Enqueued, scheduled, retry and dead tasks are exported with their IDs,
+scores and retry counts. In-progress tasks are not exported.
+
+Use --queue, --type and --state to export only the matching tasks.
+The tasks are written to stdout in JSON lines format unless --file is given.
+
+Example: asynq export --state=dead --file=dead.jsonl`,
+	Args: cobra.NoArgs,
+	Run:  export,
+}
+
+var (
+	exportQueues []string
+	exportTypes  []string
+	exportStates []string
+	exportFile   string
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringSliceVar(&exportQueues, "queue", nil, "export only tasks in the queues")
+	exportCmd.Flags().StringSliceVar(&exportTypes, "type", nil, "export only tasks of the types")
+	exportCmd.Flags().StringSliceVar(&exportStates, "state", nil, "export only tasks in the states (enqueued, scheduled, retry, dead)")
+	exportCmd.Flags().StringVarP(&exportFile, "file", "f", "", "file to write the tasks to (default stdout)")
+}
+
+func export(cmd *cobra.Command, args []string) {
+	var w io.Writer = os.Stdout
+	if exportFile != "" && exportFile != "-" {
+		f, err := os.Create(exportFile)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	i := newInspector()
+	defer i.Close()
+	n, err := i.ExportTasks(w, asynq.ExportFilter{
+		Queues: exportQueues,
+		States: exportStates,
+		Types:  exportTypes,
+	})
+	if err != nil {
+		fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d tasks\n", n)
+}
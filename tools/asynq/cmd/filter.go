@@ -0,0 +1,69 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/cobra"
+)
+
+// Flags to select tasks, shared by ls and the bulk commands.
+var (
+	filterType          string
+	filterPayload       map[string]string
+	filterErrorContains string
+	filterFrom          string
+	filterTo            string
+)
+
+// addFilterFlags adds the flags to select tasks to cmd.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&filterType, "type", "", "select only tasks of the type")
+	cmd.Flags().StringToStringVar(&filterPayload, "payload", nil, "select only tasks with the payload values (e.g. user_id=42,plan=pro)")
+	cmd.Flags().StringVar(&filterErrorContains, "error-contains", "", "select only tasks whose last error contains the string")
+	cmd.Flags().StringVar(&filterFrom, "from", "", "select only tasks scheduled, or died for dead tasks, at or after the time (RFC3339)")
+	cmd.Flags().StringVar(&filterTo, "to", "", "select only tasks scheduled, or died for dead tasks, at or before the time (RFC3339)")
+}
+
+// taskFilter returns the filter specified by the flags added by addFilterFlags.
+// It exits the program if a flag value is invalid.
+func taskFilter() *rdb.Filter {
+	f := rdb.Filter{
+		Type:          filterType,
+		Payload:       filterPayload,
+		ErrorContains: filterErrorContains,
+	}
+	for _, x := range []struct {
+		name  string
+		value string
+		score *int64
+	}{
+		{"--from", filterFrom, &f.MinScore},
+		{"--to", filterTo, &f.MaxScore},
+	} {
+		if x.value == "" {
+			continue
+		}
+		*x.score = parseTimeFlag(x.name, x.value).Unix()
+	}
+	return &f
+}
+
+// hasTimeFilter reports whether the --from or --to flag is set.
+func hasTimeFilter() bool {
+	return filterFrom != "" || filterTo != ""
+}
+
+// parseTimeFlag returns the time given by the flag in RFC3339 format.
+// It exits the program if the value is invalid.
+func parseTimeFlag(name, value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		failUsage("%s must be a time in RFC3339 format (e.g. 2020-06-01T15:04:05Z)", name)
+	}
+	return t
+}
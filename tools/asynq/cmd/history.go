@@ -9,14 +9,19 @@ import (
 	"os"
 	"strings"
 	"text/tabwriter"
+	"time"
 
-	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
-var days int
+var (
+	days               int
+	historyQueue       string
+	historyType        string
+	historyGranularity string
+)
 
 // historyCmd represents the history command
 var historyCmd = &cobra.Command{
@@ -27,30 +32,61 @@ from the last x days.
 
 By default, it will show the data from the last 10 days.
 
-Example: asynq history -x=30 -> Shows stats from the last 30 days`,
+With the --queue or --type flag, the command shows the number of processed,
+failed and retried tasks of the queue or the task type instead.
+These can be shown per minute, per hour or per day with the --granularity
+flag, in which case -x is the number of minutes, hours or days to show.
+
+Example: asynq history -x=30 -> Shows stats from the last 30 days
+Example: asynq history --type=email:send --granularity=hour -> Shows stats of email:send tasks from the last 10 hours`,
 	Args: cobra.NoArgs,
 	Run:  history,
 }
 
 func init() {
 	rootCmd.AddCommand(historyCmd)
-	historyCmd.Flags().IntVarP(&days, "days", "x", 10, "show data from last x days")
+	historyCmd.Flags().IntVarP(&days, "days", "x", 10, "show data from last x days, or x time buckets of the granularity")
+	historyCmd.Flags().StringVar(&historyQueue, "queue", "", "show stats of the queue")
+	historyCmd.Flags().StringVar(&historyType, "type", "", "show stats of the task type")
+	historyCmd.Flags().StringVar(&historyGranularity, "granularity", "day", "size of the time buckets: minute, hour or day")
+}
+
+// dailyStatsOutput holds the number of tasks processed in a day.
+type dailyStatsOutput struct {
+	Date      string `json:"date" yaml:"date"`
+	Processed int    `json:"processed" yaml:"processed"`
+	Failed    int    `json:"failed" yaml:"failed"`
+}
+
+// processingStatsOutput holds the number of tasks of a queue or a task type
+// processed in a time bucket starting at Time.
+type processingStatsOutput struct {
+	Time      time.Time `json:"time" yaml:"time"`
+	Processed int       `json:"processed" yaml:"processed"`
+	Failed    int       `json:"failed" yaml:"failed"`
+	Retried   int       `json:"retried" yaml:"retried"`
 }
 
 func history(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
 
+	if historyQueue != "" || historyType != "" {
+		processingHistory(r)
+		return
+	}
 	stats, err := r.HistoricalStats(days)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	printDailyStats(stats)
+	out := make([]*dailyStatsOutput, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, &dailyStatsOutput{
+			Date:      s.Time.Format("2006-01-02"),
+			Processed: s.Processed,
+			Failed:    s.Failed,
+		})
+	}
+	printOutput(out, func() { printDailyStats(stats) })
 }
 
 func printDailyStats(stats []*rdb.DailyStats) {
@@ -69,3 +105,57 @@ func printDailyStats(stats []*rdb.DailyStats) {
 	}
 	tw.Flush()
 }
+
+func processingHistory(r *rdb.RDB) {
+	if historyQueue != "" && historyType != "" {
+		failUsage("--queue and --type cannot be used together")
+	}
+	g := base.Granularity(historyGranularity)
+	if g.Duration() == 0 {
+		failUsage("unknown granularity %q; want minute, hour or day", historyGranularity)
+	}
+	var (
+		stats []*rdb.ProcessingStats
+		err   error
+	)
+	if historyQueue != "" {
+		stats, err = r.QueueHistory(historyQueue, g, days)
+	} else {
+		stats, err = r.TypeHistory(historyType, g, days)
+	}
+	if err != nil {
+		fail(err)
+	}
+	out := make([]*processingStatsOutput, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, &processingStatsOutput{
+			Time:      s.Time,
+			Processed: s.Processed,
+			Failed:    s.Failed,
+			Retried:   s.Retried,
+		})
+	}
+	printOutput(out, func() { printProcessingStats(stats, g) })
+}
+
+func printProcessingStats(stats []*rdb.ProcessingStats, g base.Granularity) {
+	layout := "2006-01-02"
+	if g != base.Day {
+		layout = "2006-01-02 15:04"
+	}
+	format := strings.Repeat("%v\t", 5) + "\n"
+	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, format, "Time (UTC)", "Processed", "Failed", "Retried", "Error Rate")
+	fmt.Fprintf(tw, format, "----------", "---------", "------", "-------", "----------")
+	for _, s := range stats {
+		fmt.Fprintf(tw, format, s.Time.Format(layout), s.Processed, s.Failed, s.Retried, errorRate(s.Processed, s.Failed))
+	}
+	tw.Flush()
+}
+
+func errorRate(processed, failed int) string {
+	if processed == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.2f%%", float64(failed)/float64(processed)*100)
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Imports tasks from a file",
+	Long: `Import (asynq import) will add the tasks in a file written by the export
+command, preserving their IDs, scores and retry counts.
+The tasks are read from stdin if the file is "-".
+
+Enqueued tasks are added to the front of their queues.
+Importing the same file twice duplicates enqueued tasks.
+
+Example: asynq import dead.jsonl`,
+	Args: cobra.ExactArgs(1),
+	Run:  importTasks,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
+
+func importTasks(cmd *cobra.Command, args []string) {
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	i := newInspector()
+	defer i.Close()
+	n, err := i.ImportTasks(r)
+	if err != nil {
+		fail(fmt.Errorf("%w\nImported %d tasks before the error", err, n))
+	}
+	printResult("import", args[0], taskCount(int64(n)), fmt.Sprintf("Imported %d tasks", n))
+}
@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // killCmd represents the kill command
@@ -23,6 +19,7 @@ var killCmd = &cobra.Command{
 The command takes one argument which specifies the task to kill.
 The task should be in either scheduled or retry state.
 Identifier for a task should be obtained by running "asynq ls" command.
+The ID of the task can be used as well.
 
 Example: asynq kill r:1575732274:bnogo8gt6toe23vhef0g`,
 	Args: cobra.ExactArgs(1),
@@ -44,29 +41,22 @@ func init() {
 }
 
 func kill(cmd *cobra.Command, args []string) {
-	id, score, qtype, err := parseQueryID(args[0])
+	r := newRDB()
+	id, score, qtype, err := resolveQueryID(r, args[0])
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	r := rdb.NewRDB(redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	}))
 	switch qtype {
 	case "s":
 		err = r.KillScheduledTask(id, score)
 	case "r":
 		err = r.KillRetryTask(id, score)
 	default:
-		fmt.Println("invalid argument")
-		os.Exit(1)
+		fail(errInvalidID)
 	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Successfully killed %v\n", args[0])
+	printResult("kill", args[0], taskCount(1), fmt.Sprintf("Successfully killed %v", args[0]))
 
 }
@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var killallValidArgs = []string{"scheduled", "retry"}
@@ -24,7 +20,11 @@ var killallCmd = &cobra.Command{
 
 The argument should be either "scheduled" or "retry".
 
-Example: asynq killall retry -> Update all retry tasks to dead tasks`,
+Use --type, --payload, --error-contains, --from and --to to kill
+only the matching tasks.
+
+Example: asynq killall retry -> Update all retry tasks to dead tasks
+Example: asynq killall retry --error-contains=timeout`,
 	ValidArgs: killallValidArgs,
 	Args:      cobra.ExactValidArgs(1),
 	Run:       killall,
@@ -32,39 +32,24 @@ Example: asynq killall retry -> Update all retry tasks to dead tasks`,
 
 func init() {
 	rootCmd.AddCommand(killallCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// killallCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// killallCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	addFilterFlags(killallCmd)
 }
 
 func killall(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
+	f := taskFilter()
 	var n int64
 	var err error
 	switch args[0] {
 	case "scheduled":
-		n, err = r.KillAllScheduledTasks()
+		n, err = r.KillAllScheduledTasks(f)
 	case "retry":
-		n, err = r.KillAllRetryTasks()
+		n, err = r.KillAllRetryTasks(f)
 	default:
-		fmt.Printf("error: `asynq killall [state]` only accepts %v as the argument.\n", killallValidArgs)
-		os.Exit(1)
+		failUsage("`asynq killall [state]` only accepts %v as the argument", killallValidArgs)
 	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Successfully updated %d tasks to \"dead\" state\n", n)
+	printResult("killall", args[0], taskCount(n), fmt.Sprintf("Successfully updated %d tasks to \"dead\" state", n))
 }
@@ -7,16 +7,13 @@ package cmd
 import (
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v7"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/rs/xid"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var lsValidArgs = []string{"enqueued", "inprogress", "scheduled", "retry", "dead"}
@@ -34,10 +31,17 @@ The argument value should be one of "enqueued", "inprogress", "scheduled",
 Example:
 asynq ls dead -> Lists all tasks in dead state
 
-Enqueued tasks requires a queue name after ":"
+Enqueued tasks requires a queue name after ":", and are listed in the order
+they are processed: by priority, then by enqueue time.
 Example:
 asynq ls enqueued:default  -> List tasks from default queue
 asynq ls enqueued:critical -> List tasks from critical queue 
+
+Use --type, --payload and --error-contains to list only the matching tasks.
+Scheduled, retry and dead tasks can also be selected by time with --from and --to.
+Example:
+asynq ls dead --type=email:send --payload=user_id=42
+asynq ls retry --error-contains=timeout --from=2020-06-01T00:00:00Z
 `,
 	Args: cobra.ExactValidArgs(1),
 	Run:  ls,
@@ -51,45 +55,44 @@ func init() {
 	rootCmd.AddCommand(lsCmd)
 	lsCmd.Flags().IntVar(&pageSize, "size", 30, "page size")
 	lsCmd.Flags().IntVar(&pageNum, "page", 0, "page number - zero indexed (default 0)")
+	addFilterFlags(lsCmd)
 }
 
 func ls(cmd *cobra.Command, args []string) {
 	if pageSize < 0 {
-		fmt.Println("page size cannot be negative.")
-		os.Exit(1)
+		failUsage("page size cannot be negative")
 	}
 	if pageNum < 0 {
-		fmt.Println("page number cannot be negative.")
-		os.Exit(1)
+		failUsage("page number cannot be negative")
 	}
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
+	f := taskFilter()
 	parts := strings.Split(args[0], ":")
+	if (parts[0] == "enqueued" || parts[0] == "inprogress") && hasTimeFilter() {
+		failUsage("--from and --to cannot be used with %s tasks", parts[0])
+	}
 	switch parts[0] {
 	case "enqueued":
 		if len(parts) != 2 {
-			fmt.Printf("error: Missing queue name\n`asynq ls enqueued:[queue name]`\n")
-			os.Exit(1)
+			failUsage("missing queue name\n`asynq ls enqueued:[queue name]`")
 		}
-		listEnqueued(r, parts[1])
+		listEnqueued(r, parts[1], f)
 	case "inprogress":
-		listInProgress(r)
+		listInProgress(r, f)
 	case "scheduled":
-		listScheduled(r)
+		listScheduled(r, f)
 	case "retry":
-		listRetry(r)
+		listRetry(r, f)
 	case "dead":
-		listDead(r)
+		listDead(r, f)
 	default:
-		fmt.Printf("error: `asynq ls [state]`\nonly accepts %v as the argument.\n", lsValidArgs)
-		os.Exit(1)
+		failUsage("`asynq ls [state]`\nonly accepts %v as the argument", lsValidArgs)
 	}
 }
 
+// errInvalidID is returned for a malformed task or query ID.
+var errInvalidID = errorf(exitUsage, "invalid id")
+
 // queryID returns an identifier used for "enq" command.
 // score is the zset score and queryType should be one
 // of "s", "r" or "d" (scheduled, retry, dead respectively).
@@ -104,126 +107,208 @@ func queryID(id xid.ID, score int64, qtype string) string {
 func parseQueryID(queryID string) (id xid.ID, score int64, qtype string, err error) {
 	parts := strings.Split(queryID, ":")
 	if len(parts) != 3 {
-		return xid.NilID(), 0, "", fmt.Errorf("invalid id")
+		return xid.NilID(), 0, "", errInvalidID
 	}
 	id, err = xid.FromString(parts[2])
 	if err != nil {
-		return xid.NilID(), 0, "", fmt.Errorf("invalid id")
+		return xid.NilID(), 0, "", errInvalidID
 	}
 	score, err = strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
-		return xid.NilID(), 0, "", fmt.Errorf("invalid id")
+		return xid.NilID(), 0, "", errInvalidID
 	}
 	qtype = parts[0]
 	if len(qtype) != 1 || !strings.Contains("srd", qtype) {
-		return xid.NilID(), 0, "", fmt.Errorf("invalid id")
+		return xid.NilID(), 0, "", errInvalidID
 	}
 	return id, score, qtype, nil
 }
 
-func listEnqueued(r *rdb.RDB, qname string) {
-	tasks, err := r.ListEnqueued(qname, rdb.Pagination{Size: pageSize, Page: pageNum})
+// resolveQueryID is like parseQueryID, but also accepts a task ID
+// and looks up the task to build its query ID.
+func resolveQueryID(r *rdb.RDB, arg string) (id xid.ID, score int64, qtype string, err error) {
+	if !strings.Contains(arg, ":") {
+		if id, err = xid.FromString(arg); err != nil {
+			return xid.NilID(), 0, "", errInvalidID
+		}
+		t, err := r.GetTask(id)
+		if err != nil {
+			return xid.NilID(), 0, "", err
+		}
+		if qtype = queryType(r.Keys(), t.Key); qtype == "" {
+			return xid.NilID(), 0, "", errorf(exitConflict, "task is in %s state", taskState(r.Keys(), t.Key))
+		}
+		return id, t.Score, qtype, nil
+	}
+	return parseQueryID(arg)
+}
+
+// taskOutput is the output of a task listed by ls or shown by task info.
+// Fields which are unknown for the state of the task are omitted.
+type taskOutput struct {
+	ID        string      `json:"id" yaml:"id"`
+	QueryID   string      `json:"query_id,omitempty" yaml:"query_id,omitempty"`
+	State     string      `json:"state" yaml:"state"`
+	Queue     string      `json:"queue,omitempty" yaml:"queue,omitempty"`
+	Priority  int         `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Type      string      `json:"type" yaml:"type"`
+	Payload   interface{} `json:"payload" yaml:"payload"`
+	Retried   *int        `json:"retried,omitempty" yaml:"retried,omitempty"`
+	MaxRetry  *int        `json:"max_retry,omitempty" yaml:"max_retry,omitempty"`
+	Error     string      `json:"error,omitempty" yaml:"error,omitempty"`
+	ProcessAt *time.Time  `json:"process_at,omitempty" yaml:"process_at,omitempty"`
+	DiedAt    *time.Time  `json:"died_at,omitempty" yaml:"died_at,omitempty"`
+}
+
+// printTasks prints the listed tasks, or msg for the table format if
+// there are no tasks.
+func printTasks(tasks []*taskOutput, msg string, cols []string, printRows func(w io.Writer, tmpl string)) {
+	printOutput(tasks, func() {
+		if len(tasks) == 0 {
+			fmt.Println(msg)
+			return
+		}
+		printTable(cols, printRows)
+		fmt.Printf("\nShowing %d tasks from page %d\n", len(tasks), pageNum)
+	})
+}
+
+func listEnqueued(r *rdb.RDB, qname string, f *rdb.Filter) {
+	tasks, err := r.ListEnqueued(qname, rdb.Pagination{Size: pageSize, Page: pageNum}, f)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	if len(tasks) == 0 {
-		fmt.Printf("No enqueued tasks in %q queue\n", qname)
-		return
+	out := make([]*taskOutput, 0, len(tasks))
+	for _, t := range tasks {
+		out = append(out, &taskOutput{
+			ID:       t.ID.String(),
+			State:    "enqueued",
+			Queue:    t.Queue,
+			Priority: t.Priority,
+			Type:     t.Type,
+			Payload:  formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+		})
 	}
-	cols := []string{"ID", "Type", "Payload", "Queue"}
+	cols := []string{"ID", "Type", "Payload", "Queue", "Priority"}
 	printRows := func(w io.Writer, tmpl string) {
-		for _, t := range tasks {
-			fmt.Fprintf(w, tmpl, t.ID, t.Type, t.Payload, t.Queue)
+		for _, t := range out {
+			fmt.Fprintf(w, tmpl, t.ID, t.Type, t.Payload, t.Queue, t.Priority)
 		}
 	}
-	printTable(cols, printRows)
-	fmt.Printf("\nShowing %d tasks from page %d\n", len(tasks), pageNum)
+	printTasks(out, fmt.Sprintf("No enqueued tasks in %q queue", qname), cols, printRows)
 }
 
-func listInProgress(r *rdb.RDB) {
-	tasks, err := r.ListInProgress(rdb.Pagination{Size: pageSize, Page: pageNum})
+func listInProgress(r *rdb.RDB, f *rdb.Filter) {
+	tasks, err := r.ListInProgress(rdb.Pagination{Size: pageSize, Page: pageNum}, f)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	if len(tasks) == 0 {
-		fmt.Println("No in-progress tasks")
-		return
+	out := make([]*taskOutput, 0, len(tasks))
+	for _, t := range tasks {
+		out = append(out, &taskOutput{
+			ID:      t.ID.String(),
+			State:   "inprogress",
+			Type:    t.Type,
+			Payload: formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+		})
 	}
 	cols := []string{"ID", "Type", "Payload"}
 	printRows := func(w io.Writer, tmpl string) {
-		for _, t := range tasks {
+		for _, t := range out {
 			fmt.Fprintf(w, tmpl, t.ID, t.Type, t.Payload)
 		}
 	}
-	printTable(cols, printRows)
-	fmt.Printf("\nShowing %d tasks from page %d\n", len(tasks), pageNum)
+	printTasks(out, "No in-progress tasks", cols, printRows)
 }
 
-func listScheduled(r *rdb.RDB) {
-	tasks, err := r.ListScheduled(rdb.Pagination{Size: pageSize, Page: pageNum})
+func listScheduled(r *rdb.RDB, f *rdb.Filter) {
+	tasks, err := r.ListScheduled(rdb.Pagination{Size: pageSize, Page: pageNum}, f)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	if len(tasks) == 0 {
-		fmt.Println("No scheduled tasks")
-		return
+	out := make([]*taskOutput, 0, len(tasks))
+	for _, t := range tasks {
+		processAt := t.ProcessAt
+		out = append(out, &taskOutput{
+			ID:        t.ID.String(),
+			QueryID:   queryID(t.ID, t.Score, "s"),
+			State:     "scheduled",
+			Queue:     t.Queue,
+			Type:      t.Type,
+			Payload:   formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+			ProcessAt: &processAt,
+		})
 	}
 	cols := []string{"ID", "Type", "Payload", "Process In", "Queue"}
 	printRows := func(w io.Writer, tmpl string) {
-		for _, t := range tasks {
+		for _, t := range out {
 			processIn := fmt.Sprintf("%.0f seconds", t.ProcessAt.Sub(time.Now()).Seconds())
-			fmt.Fprintf(w, tmpl, queryID(t.ID, t.Score, "s"), t.Type, t.Payload, processIn, t.Queue)
+			fmt.Fprintf(w, tmpl, t.QueryID, t.Type, t.Payload, processIn, t.Queue)
 		}
 	}
-	printTable(cols, printRows)
-	fmt.Printf("\nShowing %d tasks from page %d\n", len(tasks), pageNum)
+	printTasks(out, "No scheduled tasks", cols, printRows)
 }
 
-func listRetry(r *rdb.RDB) {
-	tasks, err := r.ListRetry(rdb.Pagination{Size: pageSize, Page: pageNum})
+func listRetry(r *rdb.RDB, f *rdb.Filter) {
+	tasks, err := r.ListRetry(rdb.Pagination{Size: pageSize, Page: pageNum}, f)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	if len(tasks) == 0 {
-		fmt.Println("No retry tasks")
-		return
+	out := make([]*taskOutput, 0, len(tasks))
+	for _, t := range tasks {
+		t := t
+		out = append(out, &taskOutput{
+			ID:        t.ID.String(),
+			QueryID:   queryID(t.ID, t.Score, "r"),
+			State:     "retry",
+			Queue:     t.Queue,
+			Type:      t.Type,
+			Payload:   formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+			Retried:   &t.Retried,
+			MaxRetry:  &t.Retry,
+			Error:     t.ErrorMsg,
+			ProcessAt: &t.ProcessAt,
+		})
 	}
 	cols := []string{"ID", "Type", "Payload", "Next Retry", "Last Error", "Retried", "Max Retry", "Queue"}
 	printRows := func(w io.Writer, tmpl string) {
-		for _, t := range tasks {
+		for _, t := range out {
 			var nextRetry string
 			if d := t.ProcessAt.Sub(time.Now()); d > 0 {
 				nextRetry = fmt.Sprintf("in %v", d.Round(time.Second))
 			} else {
 				nextRetry = "right now"
 			}
-			fmt.Fprintf(w, tmpl, queryID(t.ID, t.Score, "r"), t.Type, t.Payload, nextRetry, t.ErrorMsg, t.Retried, t.Retry, t.Queue)
+			fmt.Fprintf(w, tmpl, t.QueryID, t.Type, t.Payload, nextRetry, t.Error, *t.Retried, *t.MaxRetry, t.Queue)
 		}
 	}
-	printTable(cols, printRows)
-	fmt.Printf("\nShowing %d tasks from page %d\n", len(tasks), pageNum)
+	printTasks(out, "No retry tasks", cols, printRows)
 }
 
-func listDead(r *rdb.RDB) {
-	tasks, err := r.ListDead(rdb.Pagination{Size: pageSize, Page: pageNum})
+func listDead(r *rdb.RDB, f *rdb.Filter) {
+	tasks, err := r.ListDead(rdb.Pagination{Size: pageSize, Page: pageNum}, f)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	if len(tasks) == 0 {
-		fmt.Println("No dead tasks")
-		return
+	out := make([]*taskOutput, 0, len(tasks))
+	for _, t := range tasks {
+		t := t
+		out = append(out, &taskOutput{
+			ID:      t.ID.String(),
+			QueryID: queryID(t.ID, t.Score, "d"),
+			State:   "dead",
+			Queue:   t.Queue,
+			Type:    t.Type,
+			Payload: formatPayload(t.Payload, t.EncryptedPayload, t.PayloadRef),
+			Error:   t.ErrorMsg,
+			DiedAt:  &t.LastFailedAt,
+		})
 	}
 	cols := []string{"ID", "Type", "Payload", "Last Failed", "Last Error", "Queue"}
 	printRows := func(w io.Writer, tmpl string) {
-		for _, t := range tasks {
-			fmt.Fprintf(w, tmpl, queryID(t.ID, t.Score, "d"), t.Type, t.Payload, t.LastFailedAt, t.ErrorMsg, t.Queue)
+		for _, t := range out {
+			fmt.Fprintf(w, tmpl, t.QueryID, t.Type, t.Payload, *t.DiedAt, t.Error, t.Queue)
 		}
 	}
-	printTable(cols, printRows)
-	fmt.Printf("\nShowing %d tasks from page %d\n", len(tasks), pageNum)
+	printTasks(out, "No dead tasks", cols, printRows)
 }
@@ -0,0 +1,146 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/hibiken/asynq"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats given by the output flag.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// Exit codes of the CLI.
+//
+// Scripts can rely on them to tell the kinds of failures apart,
+// so the values must not change.
+const (
+	exitError      = 1 // unexpected error
+	exitUsage      = 2 // invalid arguments or flags
+	exitNotFound   = 3 // task, queue or server not found
+	exitConnection = 4 // could not talk to redis
+	exitConflict   = 5 // operation conflicts with the current state
+)
+
+var output string
+
+// validateOutput reads the output format from the flag or the config file
+// before any command is run.
+func validateOutput(cmd *cobra.Command, args []string) {
+	output = viper.GetString("output")
+	switch output {
+	case outputTable, outputJSON, outputYAML:
+	default:
+		failUsage("unknown output format %q; want table, json or yaml", output)
+	}
+}
+
+// printOutput prints v in the format given by the output flag.
+// printTable is called to print v for the table format.
+//
+// v should be a type declared for output with json and yaml tags,
+// so that the fields make a stable schema for scripts.
+func printOutput(v interface{}, printTable func()) {
+	switch output {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			fail(err)
+		}
+	case outputYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			fail(err)
+		}
+		os.Stdout.Write(data)
+	default:
+		printTable()
+	}
+}
+
+// resultOutput is the output of commands which change tasks, queues or servers.
+type resultOutput struct {
+	// Action is the name of the command, e.g. "enq" or "pause".
+	Action string `json:"action" yaml:"action"`
+	// Target is the task, queue, state or server the action was applied to.
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+	// Count is the number of tasks changed by the action.
+	// It's omitted for actions on queues and servers.
+	Count *int64 `json:"count,omitempty" yaml:"count,omitempty"`
+}
+
+// printResult prints the result of an action, or msg for the table format.
+func printResult(action, target string, count *int64, msg string) {
+	res := &resultOutput{Action: action, Target: target, Count: count}
+	printOutput(res, func() { fmt.Println(msg) })
+}
+
+// taskCount returns n as the count of a result.
+func taskCount(n int64) *int64 { return &n }
+
+// cliError is an error with the exit code to report it with.
+type cliError struct {
+	code int
+	msg  string
+}
+
+func (e *cliError) Error() string { return e.msg }
+
+// errorf returns an error which exits the program with the given code.
+func errorf(code int, format string, args ...interface{}) error {
+	return &cliError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// exitCode returns the exit code to report err with.
+func exitCode(err error) int {
+	var cerr *cliError
+	if errors.As(err, &cerr) {
+		return cerr.code
+	}
+	var (
+		queueNotFound *rdb.ErrQueueNotFound
+		queueNotEmpty *rdb.ErrQueueNotEmpty
+		netErr        net.Error
+	)
+	switch {
+	case errors.Is(err, rdb.ErrTaskNotFound), errors.Is(err, asynq.ErrTaskNotFound),
+		errors.Is(err, asynq.ErrServerNotFound), errors.As(err, &queueNotFound):
+		return exitNotFound
+	case errors.Is(err, rdb.ErrDuplicateTask), errors.Is(err, asynq.ErrDuplicateTask),
+		errors.Is(err, asynq.ErrTaskNotUpdatable), errors.Is(err, asynq.ErrQueueFull),
+		errors.As(err, &queueNotEmpty):
+		return exitConflict
+	case errors.As(err, &netErr), strings.Contains(err.Error(), "sentinels are unreachable"):
+		return exitConnection
+	default:
+		return exitError
+	}
+}
+
+// fail prints err to stderr and exits with the exit code for err.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(exitCode(err))
+}
+
+// failUsage reports an invalid argument or flag and exits.
+func failUsage(format string, args ...interface{}) {
+	fail(errorf(exitUsage, format, args...))
+}
@@ -6,12 +6,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // pauseCmd represents the pause command
@@ -32,16 +28,10 @@ func init() {
 }
 
 func pause(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
 	err := r.Pause(args[0])
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Successfully paused queue %q\n", args[0])
+	printResult("pause", args[0], nil, fmt.Sprintf("Successfully paused queue %q", args[0]))
 }
@@ -0,0 +1,157 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hibiken/asynq"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/spf13/cobra"
+)
+
+// queueCmd represents the queue command
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Manages queue settings",
+}
+
+// queueLimitCmd represents the queue limit command
+var queueLimitCmd = &cobra.Command{
+	Use:   "limit",
+	Short: "Manages the maximum size of queues",
+	Long: `Limit (asynq queue limit) manages the maximum number of tasks in queues.
+
+When a task is enqueued to a full queue, the policy of the limit applies:
+* reject: the enqueue fails with ErrQueueFull
+* drop_oldest: the oldest tasks in the queue are deleted to make room
+* block: the enqueue waits until there is room in the queue
+
+The limit applies to tasks enqueued to be processed immediately. Scheduled
+and retry tasks, and tasks enqueued with the enq command, are moved to the
+queue regardless of the limit. A blocked "asynq task enqueue" fails instead
+of waiting.`,
+}
+
+// queueLimitGetCmd represents the queue limit get command
+var queueLimitGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Shows the limits of the queues",
+	Args:  cobra.NoArgs,
+	Run:   queueLimitGet,
+}
+
+// queueLimitSetCmd represents the queue limit set command
+var queueLimitSetCmd = &cobra.Command{
+	Use:   "set [queue name]",
+	Short: "Sets the limit of the specified queue",
+	Long: `Set (asynq queue limit set) sets the maximum size of the specified queue
+and the policy applied when the queue is full.
+
+Example: asynq queue limit set default --max-size=10000 --policy=drop_oldest`,
+	Args: cobra.ExactArgs(1),
+	Run:  queueLimitSet,
+}
+
+// queueLimitClearCmd represents the queue limit clear command
+var queueLimitClearCmd = &cobra.Command{
+	Use:   "clear [queue name]",
+	Short: "Clears the limit of the specified queue",
+	Args:  cobra.ExactArgs(1),
+	Run:   queueLimitClear,
+}
+
+var (
+	limitMaxSize int
+	limitPolicy  string
+)
+
+func init() {
+	rootCmd.AddCommand(queueCmd)
+	queueCmd.AddCommand(queueLimitCmd)
+	queueLimitCmd.AddCommand(queueLimitGetCmd)
+	queueLimitCmd.AddCommand(queueLimitSetCmd)
+	queueLimitCmd.AddCommand(queueLimitClearCmd)
+	queueLimitSetCmd.Flags().IntVar(&limitMaxSize, "max-size", 0, "maximum number of tasks in the queue")
+	queueLimitSetCmd.Flags().StringVar(&limitPolicy, "policy", base.QueueFullReject, "policy applied when the queue is full: reject, drop_oldest or block")
+	queueLimitSetCmd.MarkFlagRequired("max-size")
+}
+
+// queueLimitOutput is the limit of a queue.
+type queueLimitOutput struct {
+	MaxSize int64  `json:"max_size" yaml:"max_size"`
+	Policy  string `json:"policy" yaml:"policy"`
+}
+
+func toQueueLimitOutput(l *base.QueueLimit) *queueLimitOutput {
+	if l == nil {
+		return nil
+	}
+	return &queueLimitOutput{MaxSize: l.MaxSize, Policy: l.Policy}
+}
+
+func queueLimitGet(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	limits, err := i.GetQueueLimits()
+	if err != nil {
+		fail(err)
+	}
+	var qnames []string
+	out := make(map[string]*queueLimitOutput)
+	for qname, l := range limits {
+		qnames = append(qnames, qname)
+		out[qname] = &queueLimitOutput{MaxSize: int64(l.MaxSize), Policy: string(l.Policy)}
+	}
+	sort.Strings(qnames)
+	printOutput(out, func() {
+		if len(qnames) == 0 {
+			fmt.Println("No queue limits are set")
+			return
+		}
+		format := strings.Repeat("%v\t", 3) + "\n"
+		tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, format, "Queue", "Max Size", "Policy")
+		fmt.Fprintf(tw, format, "-----", "--------", "------")
+		for _, qname := range qnames {
+			fmt.Fprintf(tw, format, qname, out[qname].MaxSize, out[qname].Policy)
+		}
+		tw.Flush()
+	})
+}
+
+func queueLimitSet(cmd *cobra.Command, args []string) {
+	if limitMaxSize < 1 {
+		failUsage("--max-size must be positive")
+	}
+	switch limitPolicy {
+	case base.QueueFullReject, base.QueueFullDropOldest, base.QueueFullBlock:
+	default:
+		failUsage("unknown policy %q; want reject, drop_oldest or block", limitPolicy)
+	}
+	i := newInspector()
+	defer i.Close()
+	err := i.SetQueueLimit(args[0], asynq.QueueLimit{
+		MaxSize: limitMaxSize,
+		Policy:  asynq.QueueFullPolicy(limitPolicy),
+	})
+	if err != nil {
+		fail(err)
+	}
+	printResult("queue limit set", args[0], nil, fmt.Sprintf("Successfully set limit of queue %q", args[0]))
+}
+
+func queueLimitClear(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	if err := i.ClearQueueLimit(args[0]); err != nil {
+		fail(err)
+	}
+	printResult("queue limit clear", args[0], nil, fmt.Sprintf("Successfully cleared limit of queue %q", args[0]))
+}
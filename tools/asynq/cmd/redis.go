@@ -0,0 +1,163 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/spf13/viper"
+)
+
+// redisConnOpt returns the redis connection option given by the
+// connection flags.
+//
+// The uri flag is either a redis URI supported by asynq.ParseRedisURI
+// or a plain host:port address. The db and password flags are used
+// unless the URI specifies them.
+func redisConnOpt() (asynq.RedisConnOpt, error) {
+	uri := viper.GetString("uri")
+	var opt asynq.RedisConnOpt = asynq.RedisClientOpt{Addr: uri}
+	if strings.Contains(uri, "://") {
+		var err error
+		if opt, err = asynq.ParseRedisURI(uri); err != nil {
+			return nil, err
+		}
+	}
+	db, password, ns := viper.GetInt("db"), viper.GetString("password"), viper.GetString("namespace")
+	switch o := opt.(type) {
+	case asynq.RedisClientOpt:
+		o.Namespace = ns
+		if o.DB == 0 {
+			o.DB = db
+		}
+		if o.Password == "" {
+			o.Password = password
+		}
+		cfg, err := tlsConfig(o.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		o.TLSConfig = cfg
+		return o, nil
+	case asynq.RedisFailoverClientOpt:
+		o.Namespace = ns
+		if o.DB == 0 {
+			o.DB = db
+		}
+		if o.Password == "" {
+			o.Password = password
+		}
+		cfg, err := tlsConfig(o.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		o.TLSConfig = cfg
+		return o, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis connection option %T", opt)
+	}
+}
+
+// tlsConfig returns the TLS config given by the tls flags, based on cfg.
+// It returns cfg if the flags don't enable TLS.
+func tlsConfig(cfg *tls.Config) (*tls.Config, error) {
+	var (
+		caCert     = viper.GetString("tls_ca_cert")
+		cert       = viper.GetString("tls_cert")
+		key        = viper.GetString("tls_key")
+		serverName = viper.GetString("tls_server_name")
+		insecure   = viper.GetBool("tls_insecure_skip_verify")
+	)
+	if !viper.GetBool("tls") && cfg == nil && caCert == "" && cert == "" && key == "" && serverName == "" && !insecure {
+		return nil, nil
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if serverName != "" {
+		cfg.ServerName = serverName
+	}
+	cfg.InsecureSkipVerify = insecure
+	if caCert != "" {
+		data, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		cfg.RootCAs = pool
+	}
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, fmt.Errorf("both --tls-cert and --tls-key must be set")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// mustRedisConnOpt returns the redis connection option given by the
+// connection flags, or exits if the flags are invalid.
+func mustRedisConnOpt() asynq.RedisConnOpt {
+	opt, err := redisConnOpt()
+	if err != nil {
+		failUsage("%v", err)
+	}
+	return opt
+}
+
+// createRedisClient returns a redis client given by the connection flags.
+// It exits if the flags are invalid.
+func createRedisClient() *redis.Client {
+	switch opt := mustRedisConnOpt().(type) {
+	case asynq.RedisFailoverClientOpt:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opt.MasterName,
+			SentinelAddrs:    opt.SentinelAddrs,
+			SentinelPassword: opt.SentinelPassword,
+			Password:         opt.Password,
+			DB:               opt.DB,
+			TLSConfig:        opt.TLSConfig,
+		})
+	case asynq.RedisClientOpt:
+		return redis.NewClient(&redis.Options{
+			Network:   opt.Network,
+			Addr:      opt.Addr,
+			Password:  opt.Password,
+			DB:        opt.DB,
+			TLSConfig: opt.TLSConfig,
+		})
+	default:
+		panic(fmt.Sprintf("unexpected redis connection option %T", opt))
+	}
+}
+
+// newRDB returns an RDB connected with the redis given by the connection
+// flags, which uses the keys of the namespace flag.
+// It exits if the flags are invalid.
+func newRDB() *rdb.RDB {
+	r := rdb.NewRDB(createRedisClient())
+	r.SetNamespace(viper.GetString("namespace"))
+	return r
+}
+
+// newInspector returns an inspector connected with the redis given
+// by the connection flags. It exits if the flags are invalid.
+func newInspector() *asynq.Inspector {
+	return asynq.NewInspector(mustRedisConnOpt())
+}
@@ -6,12 +6,9 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/go-redis/redis/v7"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // rmqCmd represents the rmq command
@@ -35,20 +32,13 @@ func init() {
 }
 
 func rmq(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
 	err := r.RemoveQueue(args[0], rmqForce)
 	if err != nil {
 		if _, ok := err.(*rdb.ErrQueueNotEmpty); ok {
-			fmt.Printf("error: %v\nIf you are sure you want to delete it, run 'asynq rmq --force %s'\n", err, args[0])
-			os.Exit(1)
+			err = fmt.Errorf("%w\nIf you are sure you want to delete it, run 'asynq rmq --force %s'", err, args[0])
 		}
-		fmt.Printf("error: %v", err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Printf("Successfully removed queue %q\n", args[0])
+	printResult("rmq", args[0], nil, fmt.Sprintf("Successfully removed queue %q", args[0]))
 }
@@ -5,12 +5,17 @@
 package cmd
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"text/tabwriter"
 
+	"github.com/hibiken/asynq"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/spf13/cobra"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -23,20 +28,27 @@ var cfgFile string
 var uri string
 var db int
 var password string
+var namespace string
+var encryptionKeys []string
+var useTLS bool
+var tlsCACert, tlsCert, tlsKey, tlsServerName string
+var tlsInsecureSkipVerify bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "asynq",
 	Short: "A monitoring tool for asynq queues",
 	Long:  `Asynq is a montoring CLI to inspect tasks and queues managed by asynq.`,
+
+	PersistentPreRun: validateOutput,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		// cobra has printed the error along with the usage.
+		os.Exit(exitUsage)
 	}
 }
 
@@ -44,12 +56,30 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file to set flag defaut values (default is $HOME/.asynq.yaml)")
-	rootCmd.PersistentFlags().StringVarP(&uri, "uri", "u", "127.0.0.1:6379", "redis server URI")
+	rootCmd.PersistentFlags().StringVarP(&uri, "uri", "u", "127.0.0.1:6379", "redis server address or URI (redis://, rediss://, redis-sentinel:// or redis-socket://)")
 	rootCmd.PersistentFlags().IntVarP(&db, "db", "n", 0, "redis database number (default is 0)")
 	rootCmd.PersistentFlags().StringVarP(&password, "password", "p", "", "password to use when connecting to redis server")
+	rootCmd.PersistentFlags().StringVar(&namespace, "namespace", "asynq", "namespace of the redis keys used by the application")
+	rootCmd.PersistentFlags().StringSliceVar(&encryptionKeys, "encryption-key", nil, "key to decrypt task payloads in <key id>:<hex encoded key> format (can be repeated)")
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", outputTable, "output format: table, json or yaml")
+	rootCmd.PersistentFlags().BoolVar(&useTLS, "tls", false, "connect to redis over TLS")
+	rootCmd.PersistentFlags().StringVar(&tlsCACert, "tls-ca-cert", "", "CA certificate file to verify the redis server with (implies --tls)")
+	rootCmd.PersistentFlags().StringVar(&tlsCert, "tls-cert", "", "client certificate file for TLS (implies --tls)")
+	rootCmd.PersistentFlags().StringVar(&tlsKey, "tls-key", "", "client private key file for TLS (implies --tls)")
+	rootCmd.PersistentFlags().StringVar(&tlsServerName, "tls-server-name", "", "server name to verify the redis server certificate with (implies --tls)")
+	rootCmd.PersistentFlags().BoolVar(&tlsInsecureSkipVerify, "tls-insecure-skip-verify", false, "skip verification of the redis server certificate (implies --tls)")
 	viper.BindPFlag("uri", rootCmd.PersistentFlags().Lookup("uri"))
 	viper.BindPFlag("db", rootCmd.PersistentFlags().Lookup("db"))
 	viper.BindPFlag("password", rootCmd.PersistentFlags().Lookup("password"))
+	viper.BindPFlag("namespace", rootCmd.PersistentFlags().Lookup("namespace"))
+	viper.BindPFlag("encryption_keys", rootCmd.PersistentFlags().Lookup("encryption-key"))
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("tls", rootCmd.PersistentFlags().Lookup("tls"))
+	viper.BindPFlag("tls_ca_cert", rootCmd.PersistentFlags().Lookup("tls-ca-cert"))
+	viper.BindPFlag("tls_cert", rootCmd.PersistentFlags().Lookup("tls-cert"))
+	viper.BindPFlag("tls_key", rootCmd.PersistentFlags().Lookup("tls-key"))
+	viper.BindPFlag("tls_server_name", rootCmd.PersistentFlags().Lookup("tls-server-name"))
+	viper.BindPFlag("tls_insecure_skip_verify", rootCmd.PersistentFlags().Lookup("tls-insecure-skip-verify"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -61,8 +91,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fail(err)
 		}
 
 		// Search config in home directory with name ".asynq" (without extension).
@@ -74,7 +103,7 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
 
@@ -110,3 +139,67 @@ func printTable(cols []string, printRows func(w io.Writer, tmpl string)) {
 	printRows(tw, format)
 	tw.Flush()
 }
+
+// redactedPayload is printed in place of payloads which cannot be decrypted.
+const redactedPayload = "<redacted>"
+
+// createEncrypter returns an encrypter with the keys given via the
+// encryption-key flag, or nil if no keys are given.
+func createEncrypter() (asynq.Encrypter, error) {
+	var keys []asynq.EncryptionKey
+	for _, s := range viper.GetStringSlice("encryption_keys") {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid encryption key %q: want <key id>:<hex encoded key>", s)
+		}
+		key, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %v", parts[0], err)
+		}
+		keys = append(keys, asynq.EncryptionKey{ID: parts[0], Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	enc, err := asynq.NewAESEncrypter(keys[0], keys[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+var (
+	encrypterOnce    sync.Once
+	payloadEncrypter asynq.Encrypter
+)
+
+// formatPayload returns the payload to print for a task.
+// Encrypted payloads are decrypted if a matching key was given,
+// otherwise they are redacted. Payloads offloaded to a blob store are
+// printed as a reference to the blob.
+func formatPayload(payload map[string]interface{}, encrypted *base.EncryptedPayload, ref string) interface{} {
+	if ref != "" {
+		return fmt.Sprintf("<offloaded:%s>", ref)
+	}
+	if encrypted == nil {
+		return payload
+	}
+	encrypterOnce.Do(func() {
+		var err error
+		if payloadEncrypter, err = createEncrypter(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	})
+	if payloadEncrypter == nil {
+		return redactedPayload
+	}
+	data, err := payloadEncrypter.Decrypt(encrypted.KeyID, encrypted.Data)
+	if err != nil {
+		return redactedPayload
+	}
+	var decrypted map[string]interface{}
+	if err := json.Unmarshal(data, &decrypted); err != nil {
+		return redactedPayload
+	}
+	return decrypted
+}
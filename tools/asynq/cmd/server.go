@@ -0,0 +1,229 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+)
+
+// serverCmd represents the server command
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Controls running worker servers",
+	Long: `Server (asynq server) sends commands to running worker servers.
+
+Use the "servers" command to find the ID of a server.`,
+}
+
+// serverQuietCmd represents the server quiet command
+var serverQuietCmd = &cobra.Command{
+	Use:   "quiet [server id]",
+	Short: "Stops the server from pulling new tasks off queues",
+	Long: `Quiet (asynq server quiet) will signal the specified server to stop
+pulling new tasks off queues. It has the same effect as sending TSTP signal
+to the server process. Use --all to signal all running servers.
+
+Example: asynq server quiet bsl6g2tvn5qbl1bq2sb0`,
+	Args: serverArgs,
+	Run:  serverQuiet,
+}
+
+// serverStopCmd represents the server stop command
+var serverStopCmd = &cobra.Command{
+	Use:   "stop [server id]",
+	Short: "Shuts down the server",
+	Long: `Stop (asynq server stop) will signal the specified server to shut down.
+It has the same effect as sending TERM signal to the server process.
+Use --all to signal all running servers.
+
+Example: asynq server stop bsl6g2tvn5qbl1bq2sb0`,
+	Args: serverArgs,
+	Run:  serverStop,
+}
+
+// serverConfigCmd represents the server config command
+var serverConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manages the config shared by running servers",
+	Long: `Config (asynq server config) manages the processing config picked up by
+servers started with Config.WatchConfig set to true.
+
+The shared config takes precedence over the config of each server,
+which is restored once the shared config is cleared.`,
+}
+
+// serverConfigGetCmd represents the server config get command
+var serverConfigGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Shows the shared config",
+	Args:  cobra.NoArgs,
+	Run:   serverConfigGet,
+}
+
+// serverConfigSetCmd represents the server config set command
+var serverConfigSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Sets the shared config",
+	Long: `Set (asynq server config set) sets the shared concurrency and queues.
+Omitted values leave the config of each server in effect.
+
+Example: asynq server config set --concurrency=20 --queues=critical=6,default=3,low=1`,
+	Args: cobra.NoArgs,
+	Run:  serverConfigSet,
+}
+
+// serverConfigClearCmd represents the server config clear command
+var serverConfigClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clears the shared config",
+	Args:  cobra.NoArgs,
+	Run:   serverConfigClear,
+}
+
+var (
+	serverAll bool
+
+	configConcurrency int
+	configQueues      map[string]int
+	configStrict      bool
+)
+
+func init() {
+	rootCmd.AddCommand(serverCmd)
+	serverCmd.AddCommand(serverQuietCmd)
+	serverCmd.AddCommand(serverStopCmd)
+	for _, c := range []*cobra.Command{serverQuietCmd, serverStopCmd} {
+		c.Flags().BoolVar(&serverAll, "all", false, "send the command to all running servers")
+	}
+
+	serverCmd.AddCommand(serverConfigCmd)
+	serverConfigCmd.AddCommand(serverConfigGetCmd)
+	serverConfigCmd.AddCommand(serverConfigSetCmd)
+	serverConfigCmd.AddCommand(serverConfigClearCmd)
+	serverConfigSetCmd.Flags().IntVar(&configConcurrency, "concurrency", 0, "maximum number of concurrent processing of tasks")
+	serverConfigSetCmd.Flags().StringToIntVar(&configQueues, "queues", nil, "queues to process with priority (e.g. critical=6,default=3,low=1)")
+	serverConfigSetCmd.Flags().BoolVar(&configStrict, "strict", false, "treat queue priority strictly; requires --queues")
+}
+
+// serverArgs requires either a server ID or the --all flag.
+func serverArgs(cmd *cobra.Command, args []string) error {
+	if serverAll {
+		return cobra.NoArgs(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+// serverTarget returns the target of a server command to report.
+func serverTarget(args []string) string {
+	if serverAll {
+		return "all"
+	}
+	return args[0]
+}
+
+func serverQuiet(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	var err error
+	if serverAll {
+		err = i.QuietAllServers()
+	} else {
+		err = i.QuietServer(args[0])
+	}
+	if err != nil {
+		fail(err)
+	}
+	printResult("server quiet", serverTarget(args), nil, "Successfully sent quiet command")
+}
+
+func serverStop(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	var err error
+	if serverAll {
+		err = i.StopAllServers()
+	} else {
+		err = i.StopServer(args[0])
+	}
+	if err != nil {
+		fail(err)
+	}
+	printResult("server stop", serverTarget(args), nil, "Successfully sent stop command")
+}
+
+// sharedConfigOutput is the output of the shared config.
+// Zero values leave the config of each server in effect.
+type sharedConfigOutput struct {
+	Concurrency    int            `json:"concurrency" yaml:"concurrency"`
+	Queues         map[string]int `json:"queues" yaml:"queues"`
+	StrictPriority bool           `json:"strict_priority" yaml:"strict_priority"`
+}
+
+func serverConfigGet(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	cfg, err := i.GetSharedConfig()
+	if err != nil {
+		fail(err)
+	}
+	// The output is null if no shared config is set.
+	var out *sharedConfigOutput
+	if cfg != nil {
+		out = &sharedConfigOutput{
+			Concurrency:    cfg.Concurrency,
+			Queues:         cfg.Queues,
+			StrictPriority: cfg.StrictPriority,
+		}
+	}
+	printOutput(out, func() {
+		if cfg == nil {
+			fmt.Println("No shared config is set")
+			return
+		}
+		concurrency, queues := "-", "-"
+		if cfg.Concurrency > 0 {
+			concurrency = strconv.Itoa(cfg.Concurrency)
+		}
+		if len(cfg.Queues) > 0 {
+			queues = formatQueues(cfg.Queues)
+		}
+		fmt.Printf("Concurrency:      %s\n", concurrency)
+		fmt.Printf("Queues:           %s\n", queues)
+		fmt.Printf("Strict Priority:  %t\n", cfg.StrictPriority)
+	})
+}
+
+func serverConfigSet(cmd *cobra.Command, args []string) {
+	if configConcurrency < 1 && len(configQueues) == 0 {
+		failUsage("at least one of --concurrency or --queues is required")
+	}
+	if configStrict && len(configQueues) == 0 {
+		failUsage("--strict requires --queues")
+	}
+	i := newInspector()
+	defer i.Close()
+	err := i.SetSharedConfig(asynq.SharedConfig{
+		Concurrency:    configConcurrency,
+		Queues:         configQueues,
+		StrictPriority: configStrict,
+	})
+	if err != nil {
+		fail(err)
+	}
+	printResult("server config set", "", nil, "Successfully set shared config")
+}
+
+func serverConfigClear(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	if err := i.ClearSharedConfig(); err != nil {
+		fail(err)
+	}
+	printResult("server config clear", "", nil, "Successfully cleared shared config")
+}
@@ -7,15 +7,11 @@ package cmd
 import (
 	"fmt"
 	"io"
-	"os"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // serversCmd represents the servers command
@@ -26,6 +22,7 @@ var serversCmd = &cobra.Command{
 pulling tasks from the specified redis instance.
 
 The command shows the following for each server:
+* ID of the server
 * Host and PID of the process in which the server is running
 * Number of active workers out of worker pool
 * Queue configuration
@@ -33,7 +30,9 @@ The command shows the following for each server:
 * Time the server was started
 
 A "running" server is pulling tasks from queues and processing them.
-A "quiet" server is no longer pulling new tasks from queues`,
+A "quiet" server is no longer pulling new tasks from queues
+
+Use the "server" command to quiet or stop a server by its ID.`,
 	Args: cobra.NoArgs,
 	Run:  servers,
 }
@@ -42,21 +41,25 @@ func init() {
 	rootCmd.AddCommand(serversCmd)
 }
 
+// serverOutput is the output of a running server.
+type serverOutput struct {
+	ID             string         `json:"id" yaml:"id"`
+	Host           string         `json:"host" yaml:"host"`
+	PID            int            `json:"pid" yaml:"pid"`
+	State          string         `json:"state" yaml:"state"`
+	ActiveWorkers  int            `json:"active_workers" yaml:"active_workers"`
+	Concurrency    int            `json:"concurrency" yaml:"concurrency"`
+	Queues         map[string]int `json:"queues" yaml:"queues"`
+	StrictPriority bool           `json:"strict_priority" yaml:"strict_priority"`
+	Started        time.Time      `json:"started" yaml:"started"`
+}
+
 func servers(cmd *cobra.Command, args []string) {
-	r := rdb.NewRDB(redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	}))
+	r := newRDB()
 
 	servers, err := r.ListServers()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	if len(servers) == 0 {
-		fmt.Println("No running servers")
-		return
+		fail(err)
 	}
 
 	// sort by hostname and pid
@@ -68,17 +71,37 @@ func servers(cmd *cobra.Command, args []string) {
 		return x.PID < y.PID
 	})
 
-	// print server info
-	cols := []string{"Host", "PID", "State", "Active Workers", "Queues", "Started"}
-	printRows := func(w io.Writer, tmpl string) {
-		for _, info := range servers {
-			fmt.Fprintf(w, tmpl,
-				info.Host, info.PID, info.Status,
-				fmt.Sprintf("%d/%d", info.ActiveWorkerCount, info.Concurrency),
-				formatQueues(info.Queues), timeAgo(info.Started))
-		}
+	out := make([]*serverOutput, 0, len(servers))
+	for _, info := range servers {
+		out = append(out, &serverOutput{
+			ID:             info.ServerID,
+			Host:           info.Host,
+			PID:            info.PID,
+			State:          info.Status,
+			ActiveWorkers:  info.ActiveWorkerCount,
+			Concurrency:    info.Concurrency,
+			Queues:         info.Queues,
+			StrictPriority: info.StrictPriority,
+			Started:        info.Started,
+		})
 	}
-	printTable(cols, printRows)
+	printOutput(out, func() {
+		if len(servers) == 0 {
+			fmt.Println("No running servers")
+			return
+		}
+		// print server info
+		cols := []string{"ID", "Host", "PID", "State", "Active Workers", "Queues", "Started"}
+		printRows := func(w io.Writer, tmpl string) {
+			for _, info := range servers {
+				fmt.Fprintf(w, tmpl,
+					info.ServerID, info.Host, info.PID, info.Status,
+					fmt.Sprintf("%d/%d", info.ActiveWorkerCount, info.Concurrency),
+					formatQueues(info.Queues), timeAgo(info.Started))
+			}
+		}
+		printTable(cols, printRows)
+	})
 }
 
 // timeAgo takes a time and returns a string of the format "<duration> ago".
@@ -10,13 +10,15 @@ import (
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
-	"github.com/go-redis/redis/v7"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/hibiken/asynq/internal/rdb"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
+var statsQueue string
+
 // statsCmd represents the stats command
 var statsCmd = &cobra.Command{
 	Use:   "stats",
@@ -25,65 +27,222 @@ var statsCmd = &cobra.Command{
 
 Specifically, the command shows the following:
 * Number of tasks in each state
-* Number of tasks in each queue
+* Number of tasks in each queue, and the limit of the queue if set
+* Age of the oldest task and wait time percentiles in each queue
 * Aggregate data for the current day
 * Basic information about the running redis instance
 
+With the --queue flag, the command shows the size of the queue and
+the number of processed, failed and retried tasks of the queue in the
+current minute, hour and day instead.
+
 To monitor the tasks continuously, it's recommended that you run this
 command in conjunction with the watch command.
 
-Example: watch -n 3 asynq stats -> Shows current state of tasks every three seconds`,
+Example: watch -n 3 asynq stats -> Shows current state of tasks every three seconds
+Example: asynq stats --queue=critical -> Shows current state of the critical queue`,
 	Args: cobra.NoArgs,
 	Run:  stats,
 }
 
 func init() {
 	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringVar(&statsQueue, "queue", "", "show stats of the queue")
+}
 
-	// Here you will define your flags and configuration settings.
+// statsOutput is the output of the stats command.
+type statsOutput struct {
+	States    statesOutput     `json:"states" yaml:"states"`
+	Queues    []*queueOutput   `json:"queues" yaml:"queues"`
+	Today     todayStatsOutput `json:"today" yaml:"today"`
+	Redis     redisInfoOutput  `json:"redis" yaml:"redis"`
+	Timestamp time.Time        `json:"timestamp" yaml:"timestamp"`
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// statsCmd.PersistentFlags().String("foo", "", "A help for foo")
+type statesOutput struct {
+	InProgress int `json:"inprogress" yaml:"inprogress"`
+	Enqueued   int `json:"enqueued" yaml:"enqueued"`
+	Scheduled  int `json:"scheduled" yaml:"scheduled"`
+	Retry      int `json:"retry" yaml:"retry"`
+	Dead       int `json:"dead" yaml:"dead"`
+}
+
+// queueOutput holds the size and latency of a queue.
+// Durations are in seconds and zero if unknown.
+type queueOutput struct {
+	Name             string  `json:"name" yaml:"name"`
+	Paused           bool    `json:"paused" yaml:"paused"`
+	Size             int     `json:"size" yaml:"size"`
+	OldestPendingAge float64 `json:"oldest_pending_age_seconds" yaml:"oldest_pending_age_seconds"`
+	WaitP50          float64 `json:"wait_p50_seconds" yaml:"wait_p50_seconds"`
+	WaitP99          float64 `json:"wait_p99_seconds" yaml:"wait_p99_seconds"`
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// statsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	// Limit is null if the queue has no limit.
+	Limit *queueLimitOutput `json:"limit" yaml:"limit"`
+}
+
+type todayStatsOutput struct {
+	Date      string `json:"date" yaml:"date"`
+	Processed int    `json:"processed" yaml:"processed"`
+	Failed    int    `json:"failed" yaml:"failed"`
+	Expired   int    `json:"expired" yaml:"expired"`
+}
+
+type redisInfoOutput struct {
+	Version         string `json:"version" yaml:"version"`
+	UptimeDays      int    `json:"uptime_days" yaml:"uptime_days"`
+	Connections     int    `json:"connections" yaml:"connections"`
+	MemoryUsage     int64  `json:"memory_usage_bytes" yaml:"memory_usage_bytes"`
+	PeakMemoryUsage int64  `json:"peak_memory_usage_bytes" yaml:"peak_memory_usage_bytes"`
+}
+
+// queueStatsOutput is the output of the stats command with the queue flag.
+type queueStatsOutput struct {
+	Queue     *queueOutput         `json:"queue" yaml:"queue"`
+	Periods   []*periodStatsOutput `json:"periods" yaml:"periods"`
+	Timestamp time.Time            `json:"timestamp" yaml:"timestamp"`
+}
+
+// periodStatsOutput holds the number of tasks processed in the current
+// minute, hour or day.
+type periodStatsOutput struct {
+	Period    string `json:"period" yaml:"period"`
+	Processed int    `json:"processed" yaml:"processed"`
+	Failed    int    `json:"failed" yaml:"failed"`
+	Retried   int    `json:"retried" yaml:"retried"`
 }
 
 func stats(cmd *cobra.Command, args []string) {
-	c := redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	})
-	r := rdb.NewRDB(c)
+	r := newRDB()
 
 	stats, err := r.CurrentStats()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
+	}
+	if statsQueue != "" {
+		queueStats(r, stats)
+		return
 	}
 	info, err := r.RedisInfo()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
-	fmt.Println("STATES")
-	printStates(stats)
-	fmt.Println()
+	out := &statsOutput{
+		States: statesOutput{
+			InProgress: stats.InProgress,
+			Enqueued:   stats.Enqueued,
+			Scheduled:  stats.Scheduled,
+			Retry:      stats.Retry,
+			Dead:       stats.Dead,
+		},
+		Queues: toQueueOutputs(stats.Queues),
+		Today: todayStatsOutput{
+			Date:      stats.Timestamp.UTC().Format("2006-01-02"),
+			Processed: stats.Processed,
+			Failed:    stats.Failed,
+			Expired:   stats.Expired,
+		},
+		Redis:     toRedisInfoOutput(info),
+		Timestamp: stats.Timestamp,
+	}
+	printOutput(out, func() {
+		fmt.Println("STATES")
+		printStates(stats)
+		fmt.Println()
+
+		fmt.Println("QUEUES")
+		printQueues(stats.Queues)
+		fmt.Println()
 
-	fmt.Println("QUEUES")
-	printQueues(stats.Queues)
-	fmt.Println()
+		fmt.Println("QUEUE LATENCY")
+		printLatency(stats.Queues)
+		fmt.Println()
 
-	fmt.Printf("STATS FOR %s UTC\n", stats.Timestamp.UTC().Format("2006-01-02"))
-	printStats(stats)
-	fmt.Println()
+		fmt.Printf("STATS FOR %s UTC\n", stats.Timestamp.UTC().Format("2006-01-02"))
+		printStats(stats)
+		fmt.Println()
 
-	fmt.Println("REDIS INFO")
-	printInfo(info)
-	fmt.Println()
+		fmt.Println("REDIS INFO")
+		printInfo(info)
+		fmt.Println()
+	})
+}
+
+func queueStats(r *rdb.RDB, stats *rdb.Stats) {
+	var queue *rdb.Queue
+	for _, q := range stats.Queues {
+		if q.Name == strings.ToLower(statsQueue) {
+			queue = q
+		}
+	}
+	if queue == nil {
+		fail(errorf(exitNotFound, "queue %q not found", statsQueue))
+	}
+	out := &queueStatsOutput{
+		Queue:     toQueueOutputs([]*rdb.Queue{queue})[0],
+		Timestamp: stats.Timestamp,
+	}
+	for _, g := range base.Granularities {
+		res, err := r.QueueHistory(queue.Name, g, 1)
+		if err != nil {
+			fail(err)
+		}
+		out.Periods = append(out.Periods, &periodStatsOutput{
+			Period:    string(g),
+			Processed: res[0].Processed,
+			Failed:    res[0].Failed,
+			Retried:   res[0].Retried,
+		})
+	}
+	printOutput(out, func() {
+		fmt.Println("QUEUE")
+		printQueues([]*rdb.Queue{queue})
+		fmt.Println()
+
+		fmt.Println("LATENCY")
+		printLatency([]*rdb.Queue{queue})
+		fmt.Println()
+
+		fmt.Printf("STATS FOR %s UTC\n", stats.Timestamp.UTC().Format("2006-01-02 15:04"))
+		format := strings.Repeat("%v\t", 5) + "\n"
+		tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, format, "Period", "Processed", "Failed", "Retried", "Error Rate")
+		fmt.Fprintf(tw, format, "------", "---------", "------", "-------", "----------")
+		for _, p := range out.Periods {
+			fmt.Fprintf(tw, format, "This "+p.Period, p.Processed, p.Failed, p.Retried, errorRate(p.Processed, p.Failed))
+		}
+		tw.Flush()
+	})
+}
+
+func toQueueOutputs(queues []*rdb.Queue) []*queueOutput {
+	res := make([]*queueOutput, 0, len(queues))
+	for _, q := range queues {
+		res = append(res, &queueOutput{
+			Name:             q.Name,
+			Paused:           q.Paused,
+			Size:             q.Size,
+			OldestPendingAge: q.OldestPendingAge.Seconds(),
+			WaitP50:          q.WaitP50.Seconds(),
+			WaitP99:          q.WaitP99.Seconds(),
+			Limit:            toQueueLimitOutput(q.Limit),
+		})
+	}
+	return res
+}
+
+func toRedisInfoOutput(info map[string]string) redisInfoOutput {
+	atoi := func(key string) int64 {
+		n, _ := strconv.ParseInt(info[key], 10, 64)
+		return n
+	}
+	return redisInfoOutput{
+		Version:         info["redis_version"],
+		UptimeDays:      int(atoi("uptime_in_days")),
+		Connections:     int(atoi("connected_clients")),
+		MemoryUsage:     atoi("used_memory"),
+		PeakMemoryUsage: atoi("used_memory_peak"),
+	}
 }
 
 func printStates(s *rdb.Stats) {
@@ -101,7 +260,7 @@ func printQueues(queues []*rdb.Queue) {
 		title := queueTitle(q)
 		headers = append(headers, title)
 		seps = append(seps, strings.Repeat("-", len(title)))
-		counts = append(counts, strconv.Itoa(q.Size))
+		counts = append(counts, queueSize(q))
 	}
 	format := strings.Repeat("%v\t", len(headers)) + "\n"
 	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
@@ -111,6 +270,39 @@ func printQueues(queues []*rdb.Queue) {
 	tw.Flush()
 }
 
+func printLatency(queues []*rdb.Queue) {
+	format := strings.Repeat("%v\t", 4) + "\n"
+	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, format, "Queue", "Oldest Task Age", "P50 Wait", "P99 Wait")
+	fmt.Fprintf(tw, format, "-----", "---------------", "--------", "--------")
+	for _, q := range queues {
+		fmt.Fprintf(tw, format, q.Name, formatLatency(q.OldestPendingAge), formatLatency(q.WaitP50), formatLatency(q.WaitP99))
+	}
+	tw.Flush()
+}
+
+// formatLatency returns d rounded for display, or "-" if d is zero.
+func formatLatency(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Minute:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// queueSize returns the size of q for display, with the limit if set.
+func queueSize(q *rdb.Queue) string {
+	if q.Limit == nil {
+		return strconv.Itoa(q.Size)
+	}
+	return fmt.Sprintf("%d/%d (%s)", q.Size, q.Limit.MaxSize, q.Limit.Policy)
+}
+
 func queueTitle(q *rdb.Queue) string {
 	var b strings.Builder
 	b.WriteString(strings.Title(q.Name))
@@ -121,17 +313,11 @@ func queueTitle(q *rdb.Queue) string {
 }
 
 func printStats(s *rdb.Stats) {
-	format := strings.Repeat("%v\t", 3) + "\n"
+	format := strings.Repeat("%v\t", 4) + "\n"
 	tw := new(tabwriter.Writer).Init(os.Stdout, 0, 8, 2, ' ', 0)
-	fmt.Fprintf(tw, format, "Processed", "Failed", "Error Rate")
-	fmt.Fprintf(tw, format, "---------", "------", "----------")
-	var errrate string
-	if s.Processed == 0 {
-		errrate = "N/A"
-	} else {
-		errrate = fmt.Sprintf("%.2f%%", float64(s.Failed)/float64(s.Processed)*100)
-	}
-	fmt.Fprintf(tw, format, s.Processed, s.Failed, errrate)
+	fmt.Fprintf(tw, format, "Processed", "Failed", "Expired", "Error Rate")
+	fmt.Fprintf(tw, format, "---------", "------", "-------", "----------")
+	fmt.Fprintf(tw, format, s.Processed, s.Failed, s.Expired, errorRate(s.Processed, s.Failed))
 	tw.Flush()
 }
 
@@ -0,0 +1,126 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// tailCmd represents the tail command
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Follows task events live",
+	Long: `Tail (asynq tail) will print task events as they happen until interrupted.
+
+Only the events published by servers started with Config.PublishEvents
+and clients with SetPublishEvents(true) are shown.
+
+The command shows the following for each event:
+* Time of the event
+* State the task has transitioned to
+* Queue, type and ID of the task
+* Processing duration and error for finished tasks
+
+Use --queue, --type and --state to show only the matching events.
+
+Example: asynq tail --queue=critical --state=retried,killed`,
+	Args: cobra.NoArgs,
+	Run:  tail,
+}
+
+var (
+	tailQueues []string
+	tailTypes  []string
+	tailStates []string
+)
+
+func init() {
+	rootCmd.AddCommand(tailCmd)
+	tailCmd.Flags().StringSliceVar(&tailQueues, "queue", nil, "show only events of tasks in the queues")
+	tailCmd.Flags().StringSliceVar(&tailTypes, "type", nil, "show only events of tasks of the types")
+	tailCmd.Flags().StringSliceVar(&tailStates, "state", nil, "show only events with the states (enqueued, scheduled, started, completed, retried, killed)")
+}
+
+// eventOutput is the output of a task event.
+type eventOutput struct {
+	Time     time.Time `json:"time" yaml:"time"`
+	State    string    `json:"state" yaml:"state"`
+	Queue    string    `json:"queue" yaml:"queue"`
+	Type     string    `json:"type" yaml:"type"`
+	TaskID   string    `json:"task_id" yaml:"task_id"`
+	Duration float64   `json:"duration_seconds,omitempty" yaml:"duration_seconds,omitempty"`
+	Error    string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func tail(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	enc := json.NewEncoder(os.Stdout)
+	err := i.TailEvents(context.Background(), func(e *asynq.Event) {
+		if !matchAny(tailQueues, e.Queue) || !matchAny(tailTypes, e.TaskType) || !matchAny(tailStates, string(e.State)) {
+			return
+		}
+		out := &eventOutput{
+			Time:     e.Time,
+			State:    string(e.State),
+			Queue:    e.Queue,
+			Type:     e.TaskType,
+			TaskID:   e.TaskID,
+			Duration: e.Duration.Seconds(),
+			Error:    e.Error,
+		}
+		// Events are streamed as JSON lines, or as a YAML document each.
+		switch output {
+		case outputJSON:
+			enc.Encode(out)
+		case outputYAML:
+			data, err := yaml.Marshal(out)
+			if err != nil {
+				fail(err)
+			}
+			fmt.Printf("---\n%s", data)
+		default:
+			fmt.Println(formatEvent(e))
+		}
+	})
+	if err != nil {
+		fail(err)
+	}
+}
+
+// matchAny reports whether s is in list, or list is empty.
+func matchAny(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, x := range list {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEvent(e *asynq.Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %-9s  queue=%s type=%s id=%s",
+		e.Time.Local().Format(time.RFC3339), e.State, e.Queue, e.TaskType, e.TaskID)
+	if e.Duration > 0 {
+		fmt.Fprintf(&b, " duration=%v", e.Duration.Round(time.Millisecond))
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, " error=%q", e.Error)
+	}
+	return b.String()
+}
@@ -0,0 +1,575 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/rs/xid"
+	"github.com/spf13/cobra"
+)
+
+// taskCmd represents the task command
+var taskCmd = &cobra.Command{
+	Use:   "task",
+	Short: "Manages a single task",
+}
+
+// taskInfoCmd represents the task info command
+var taskInfoCmd = &cobra.Command{
+	Use:   "info [task id]",
+	Short: "Shows a task given its ID",
+	Long: `Info (asynq task info) will find the task with the given ID in any queue
+or state and show its details.
+
+The command shows the following for the task:
+* State and queue of the task
+* Type and payload of the task
+* Retry count and last error
+* Time the task is enqueued, or the time the task died for dead tasks
+* Identifier of the task to use with enq, kill and del commands
+
+Example: asynq task info bnogo8gt6toe23vhef0g`,
+	Args: cobra.ExactArgs(1),
+	Run:  taskInfo,
+}
+
+// taskEnqueueCmd represents the task enqueue command
+var taskEnqueueCmd = &cobra.Command{
+	Use:   "enqueue",
+	Short: "Enqueues a new task",
+	Long: `Enqueue (asynq task enqueue) will create a task of the given type and
+enqueue it, with the same options as the Client.
+
+The payload is a JSON object given with --payload, or read from a file
+with --payload-file ("-" reads stdin). The file may have many JSON objects,
+e.g. one per line, to enqueue a task for each of them.
+
+Use --in or --at to schedule the task to be processed later.
+Payloads are encrypted with the first key if --encryption-key is given.
+
+Example: asynq task enqueue --type=email:send --payload='{"to":"x"}' --queue=critical --in=5m --retry=3 --unique=1h
+Example: asynq task enqueue --type=email:send --payload-file=payloads.jsonl
+Example: asynq task enqueue --type=report:urgent --priority=9`,
+	Args: cobra.NoArgs,
+	Run:  taskEnqueue,
+}
+
+// taskEditCmd represents the task edit command
+var taskEditCmd = &cobra.Command{
+	Use:   "edit [task id]",
+	Short: "Edits the payload of a scheduled, retry or dead task",
+	Long: `Edit (asynq task edit) will open the payload of the task with the given ID
+in $EDITOR as JSON, and update the task with the edited payload.
+The task keeps its ID and history. Only scheduled, retry and dead tasks
+can be edited.
+
+The task is updated atomically, so the command fails if the task is
+enqueued while it's being edited. Use --patch to set keys of the payload
+without the editor; keys set to null are removed.
+
+Use --reset-retry to give the task the full number of retries again, and
+--enqueue to enqueue the task right after the update.
+Encrypted payloads can be edited if --encryption-key is given.
+
+Example: asynq task edit bnogo8gt6toe23vhef0g --reset-retry --enqueue
+Example: asynq task edit bnogo8gt6toe23vhef0g --patch='{"to":"user@example.com"}'`,
+	Args: cobra.ExactArgs(1),
+	Run:  taskEdit,
+}
+
+// taskRescheduleCmd represents the task reschedule command
+var taskRescheduleCmd = &cobra.Command{
+	Use:   "reschedule [task id]",
+	Short: "Changes the time to process a scheduled or retry task",
+	Long: `Reschedule (asynq task reschedule) will change the time to process the
+scheduled or retry task with the given ID to the time given with --in or --at.
+The task keeps its ID and its uniqueness lock, if any.
+
+Example: asynq task reschedule bnogo8gt6toe23vhef0g --in=2h
+Example: asynq task reschedule bnogo8gt6toe23vhef0g --at=2020-06-10T09:00:00Z`,
+	Args: cobra.ExactArgs(1),
+	Run:  taskReschedule,
+}
+
+// taskMoveCmd represents the task move command
+var taskMoveCmd = &cobra.Command{
+	Use:   "move [task id] [queue name]",
+	Short: "Moves an enqueued task to another queue",
+	Long: `Move (asynq task move) will move the enqueued task with the given ID to the
+end of the given queue, e.g. to process it sooner in a queue with a higher
+priority. The uniqueness lock of the task, if any, moves with the task.
+
+The command fails if a duplicate of a unique task is in the queue.
+
+Example: asynq task move bnogo8gt6toe23vhef0g critical`,
+	Args: cobra.ExactArgs(2),
+	Run:  taskMove,
+}
+
+var (
+	rescheduleIn time.Duration
+	rescheduleAt string
+)
+
+var (
+	editPatch      string
+	editResetRetry bool
+	editEnqueue    bool
+)
+
+var (
+	enqueueType        string
+	enqueuePayload     string
+	enqueuePayloadFile string
+	enqueueQueue       string
+	enqueueIn          time.Duration
+	enqueueAt          string
+	enqueueRetry       int
+	enqueueTimeout     time.Duration
+	enqueueDeadline    string
+	enqueueUnique      time.Duration
+	enqueueExpireIn    time.Duration
+	enqueueWebhook     string
+	enqueuePriority    int
+)
+
+func init() {
+	rootCmd.AddCommand(taskCmd)
+	taskCmd.AddCommand(taskInfoCmd)
+
+	taskCmd.AddCommand(taskEditCmd)
+	taskEditCmd.Flags().StringVar(&editPatch, "patch", "", "JSON object of the keys to set in the payload, instead of opening the editor")
+	taskEditCmd.Flags().BoolVar(&editResetRetry, "reset-retry", false, "reset the retry count of the task")
+	taskEditCmd.Flags().BoolVar(&editEnqueue, "enqueue", false, "enqueue the task after the update")
+
+	taskCmd.AddCommand(taskRescheduleCmd)
+	taskRescheduleCmd.Flags().DurationVar(&rescheduleIn, "in", 0, "process the task after the duration")
+	taskRescheduleCmd.Flags().StringVar(&rescheduleAt, "at", "", "process the task at the time (RFC3339)")
+
+	taskCmd.AddCommand(taskMoveCmd)
+
+	taskCmd.AddCommand(taskEnqueueCmd)
+	taskEnqueueCmd.Flags().StringVar(&enqueueType, "type", "", "type of the task (required)")
+	taskEnqueueCmd.Flags().StringVar(&enqueuePayload, "payload", "", "payload of the task as a JSON object")
+	taskEnqueueCmd.Flags().StringVar(&enqueuePayloadFile, "payload-file", "", "file to read the payloads from, or - for stdin")
+	taskEnqueueCmd.Flags().StringVar(&enqueueQueue, "queue", base.DefaultQueueName, "queue to enqueue the task into")
+	taskEnqueueCmd.Flags().DurationVar(&enqueueIn, "in", 0, "process the task after the duration")
+	taskEnqueueCmd.Flags().StringVar(&enqueueAt, "at", "", "process the task at the time (RFC3339)")
+	taskEnqueueCmd.Flags().IntVar(&enqueueRetry, "retry", 0, "max number of times the task is retried (default is the Client's default)")
+	taskEnqueueCmd.Flags().DurationVar(&enqueueTimeout, "timeout", 0, "how long the task may run")
+	taskEnqueueCmd.Flags().StringVar(&enqueueDeadline, "deadline", "", "deadline of the task (RFC3339)")
+	taskEnqueueCmd.Flags().DurationVar(&enqueueUnique, "unique", 0, "enqueue the task only if it's unique within the duration")
+	taskEnqueueCmd.Flags().DurationVar(&enqueueExpireIn, "expire-in", 0, "discard the task if it has not started processing within the duration")
+	taskEnqueueCmd.Flags().StringVar(&enqueueWebhook, "webhook", "", "URL to post an event to when the task is processed or killed")
+	taskEnqueueCmd.Flags().IntVar(&enqueuePriority, "priority", 0, fmt.Sprintf("priority of the task in the queue, from 0 to %d", asynq.MaxPriority))
+}
+
+func taskInfo(cmd *cobra.Command, args []string) {
+	id, err := xid.FromString(args[0])
+	if err != nil {
+		failUsage("invalid task id %q", args[0])
+	}
+	r := newRDB()
+	t, err := r.GetTask(id)
+	if err == rdb.ErrTaskNotFound {
+		fail(errorf(exitNotFound, "task %s not found", id))
+	}
+	if err != nil {
+		fail(err)
+	}
+	msg := t.Msg
+	out := &taskOutput{
+		ID:       msg.ID.String(),
+		State:    taskState(r.Keys(), t.Key),
+		Queue:    msg.Queue,
+		Priority: msg.Priority,
+		Type:     msg.Type,
+		Payload:  formatPayload(msg.Payload, msg.EncryptedPayload, msg.PayloadRef),
+		Retried:  &msg.Retried,
+		MaxRetry: &msg.Retry,
+		Error:    msg.ErrorMsg,
+	}
+	if qtype := queryType(r.Keys(), t.Key); qtype != "" {
+		out.QueryID = queryID(msg.ID, t.Score, qtype)
+	}
+	switch score := time.Unix(t.Score, 0); out.State {
+	case "scheduled", "retry":
+		out.ProcessAt = &score
+	case "dead":
+		out.DiedAt = &score
+	}
+	printOutput(out, func() {
+		fmt.Printf("ID:          %s\n", out.ID)
+		fmt.Printf("State:       %s\n", out.State)
+		fmt.Printf("Queue:       %s\n", out.Queue)
+		fmt.Printf("Priority:    %d\n", out.Priority)
+		fmt.Printf("Type:        %s\n", out.Type)
+		fmt.Printf("Payload:     %v\n", out.Payload)
+		fmt.Printf("Retried:     %d/%d\n", msg.Retried, msg.Retry)
+		if out.Error != "" {
+			fmt.Printf("Last Error:  %s\n", out.Error)
+		}
+		if out.ProcessAt != nil {
+			fmt.Printf("Enqueue At:  %v\n", *out.ProcessAt)
+		}
+		if out.DiedAt != nil {
+			fmt.Printf("Died At:     %v\n", *out.DiedAt)
+		}
+		if out.QueryID != "" {
+			fmt.Printf("Query ID:    %s\n", out.QueryID)
+		}
+	})
+}
+
+func taskReschedule(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed("in") == (rescheduleAt != "") {
+		failUsage("exactly one of --in and --at is required")
+	}
+	processAt := time.Now().Add(rescheduleIn)
+	if rescheduleAt != "" {
+		processAt = parseTimeFlag("--at", rescheduleAt)
+	}
+	i := newInspector()
+	defer i.Close()
+	if err := i.RescheduleTask(args[0], processAt); err != nil {
+		fail(err)
+	}
+	printResult("reschedule", args[0], taskCount(1),
+		fmt.Sprintf("Rescheduled task %s to be processed at %v", args[0], processAt.Format(time.RFC3339)))
+}
+
+func taskMove(cmd *cobra.Command, args []string) {
+	i := newInspector()
+	defer i.Close()
+	if err := i.MoveTask(args[0], args[1]); err != nil {
+		fail(err)
+	}
+	printResult("move", args[0], taskCount(1), fmt.Sprintf("Moved task %s to queue %q", args[0], args[1]))
+}
+
+func taskEnqueue(cmd *cobra.Command, args []string) {
+	if enqueueType == "" {
+		failUsage("--type is required")
+	}
+	if enqueuePayload != "" && enqueuePayloadFile != "" {
+		failUsage("--payload and --payload-file cannot be used together")
+	}
+	if enqueueIn != 0 && enqueueAt != "" {
+		failUsage("--in and --at cannot be used together")
+	}
+	processAt := time.Now().Add(enqueueIn)
+	if enqueueAt != "" {
+		processAt = parseTimeFlag("--at", enqueueAt)
+	}
+	opts := []asynq.Option{asynq.Queue(enqueueQueue)}
+	if cmd.Flags().Changed("retry") {
+		opts = append(opts, asynq.MaxRetry(enqueueRetry))
+	}
+	if enqueueTimeout != 0 {
+		opts = append(opts, asynq.Timeout(enqueueTimeout))
+	}
+	if enqueueDeadline != "" {
+		opts = append(opts, asynq.Deadline(parseTimeFlag("--deadline", enqueueDeadline)))
+	}
+	if enqueueUnique != 0 {
+		opts = append(opts, asynq.Unique(enqueueUnique))
+	}
+	if enqueueExpireIn != 0 {
+		opts = append(opts, asynq.ExpireIn(enqueueExpireIn))
+	}
+	if enqueueWebhook != "" {
+		opts = append(opts, asynq.Webhook(enqueueWebhook))
+	}
+	if enqueuePriority < 0 || enqueuePriority > asynq.MaxPriority {
+		failUsage("--priority must be between 0 and %d", asynq.MaxPriority)
+	}
+	opts = append(opts, asynq.Priority(enqueuePriority))
+	payloads := readPayloads()
+
+	c := asynq.NewClient(mustRedisConnOpt())
+	defer c.Close()
+	enc, err := createEncrypter()
+	if err != nil {
+		failUsage("%v", err)
+	}
+	if enc != nil {
+		c.SetEncrypter(enc)
+	}
+	out := make([]*taskOutput, 0, len(payloads))
+	for _, payload := range payloads {
+		info, err := c.EnqueueAtInfo(processAt, asynq.NewTask(enqueueType, payload), opts...)
+		if err != nil {
+			if len(out) > 0 {
+				err = fmt.Errorf("%w\nEnqueued %d tasks before the error", err, len(out))
+			}
+			fail(err)
+		}
+		t := &taskOutput{
+			ID:      info.ID,
+			State:   info.State,
+			Queue:   info.Queue,
+			Type:    info.Type,
+			Payload: payload,
+		}
+		if info.State == "scheduled" {
+			t.ProcessAt = &info.NextEnqueueAt
+		}
+		out = append(out, t)
+	}
+	printOutput(out, func() {
+		for _, t := range out {
+			if t.ProcessAt != nil {
+				fmt.Printf("Scheduled task %s to be processed at %v\n", t.ID, t.ProcessAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("Enqueued task %s\n", t.ID)
+			}
+		}
+	})
+}
+
+func taskEdit(cmd *cobra.Command, args []string) {
+	id, err := xid.FromString(args[0])
+	if err != nil {
+		failUsage("invalid task id %q", args[0])
+	}
+	r := newRDB()
+	t, err := r.GetTask(id)
+	if err == rdb.ErrTaskNotFound {
+		fail(errorf(exitNotFound, "task %s not found", id))
+	}
+	if err != nil {
+		fail(err)
+	}
+	if queryType(r.Keys(), t.Key) == "" {
+		fail(errorf(exitConflict, "task is in %s state; only scheduled, retry and dead tasks can be edited", taskState(r.Keys(), t.Key)))
+	}
+	payload, ok := formatPayload(t.Msg.Payload, t.Msg.EncryptedPayload, t.Msg.PayloadRef).(map[string]interface{})
+	if !ok {
+		fail(errorf(exitConflict, "payload of the task is encrypted or offloaded and cannot be read; give its key with --encryption-key"))
+	}
+
+	var patch map[string]interface{}
+	if editPatch != "" {
+		if err := json.Unmarshal([]byte(editPatch), &patch); err != nil || patch == nil {
+			failUsage("--patch is not a JSON object")
+		}
+	} else {
+		edited, err := editPayload(payload)
+		if err != nil {
+			fail(err)
+		}
+		patch = payloadPatch(payload, edited)
+	}
+	if len(patch) == 0 && !editResetRetry {
+		printResult("edit", id.String(), taskCount(0), "No changes made to the task")
+		return
+	}
+
+	i := newInspector()
+	defer i.Close()
+	if payloadEncrypter != nil {
+		i.SetEncrypter(payloadEncrypter)
+	}
+	var opts []asynq.UpdateOption
+	if editResetRetry {
+		opts = append(opts, asynq.ResetRetried())
+	}
+	info, err := i.UpdateTaskPayload(id.String(), patch, opts...)
+	if err != nil {
+		fail(err)
+	}
+
+	updated := make(map[string]interface{})
+	for k, v := range payload {
+		updated[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(updated, k)
+		} else {
+			updated[k] = v
+		}
+	}
+	out := &taskOutput{
+		ID:       info.ID,
+		State:    info.State,
+		Queue:    info.Queue,
+		Priority: info.Priority,
+		Type:     info.Type,
+		Payload:  updated,
+		Retried:  &info.Retried,
+		MaxRetry: &info.MaxRetry,
+		Error:    info.ErrorMsg,
+	}
+	if editEnqueue {
+		if err := enqueueTask(r, info); err != nil {
+			fail(fmt.Errorf("%w\nThe task was edited but not enqueued", err))
+		}
+		out.State = "enqueued"
+	} else {
+		switch info.State {
+		case "scheduled", "retry":
+			out.ProcessAt = &info.NextEnqueueAt
+		case "dead":
+			out.DiedAt = &info.LastFailedAt
+		}
+	}
+	printOutput(out, func() {
+		if editEnqueue {
+			fmt.Printf("Successfully edited and enqueued %s\n", out.ID)
+		} else {
+			fmt.Printf("Successfully edited %s\n", out.ID)
+		}
+	})
+}
+
+// editPayload opens the payload in the editor given by $EDITOR
+// and returns the edited payload.
+func editPayload(payload map[string]interface{}) (map[string]interface{}, error) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "asynq-payload-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	c := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("editor %s failed: %v", editor[0], err)
+	}
+	data, err = ioutil.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	var edited map[string]interface{}
+	if err := json.Unmarshal(data, &edited); err != nil || edited == nil {
+		return nil, errorf(exitUsage, "edited payload is not a JSON object")
+	}
+	return edited, nil
+}
+
+// payloadPatch returns the patch to turn the payload old into new.
+// Keys removed from old are set to nil in the patch.
+func payloadPatch(old, new map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for k, v := range new {
+		if ov, ok := old[k]; !ok || !reflect.DeepEqual(ov, v) {
+			patch[k] = v
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// enqueueTask enqueues the scheduled, retry or dead task t.
+func enqueueTask(r *rdb.RDB, t *asynq.TaskInfo) error {
+	id, err := xid.FromString(t.ID)
+	if err != nil {
+		return err
+	}
+	switch t.State {
+	case "scheduled":
+		return r.EnqueueScheduledTask(id, t.NextEnqueueAt.Unix())
+	case "retry":
+		return r.EnqueueRetryTask(id, t.NextEnqueueAt.Unix())
+	default:
+		return r.EnqueueDeadTask(id, t.LastFailedAt.Unix())
+	}
+}
+
+// readPayloads returns the payloads given by the payload flags.
+// It exits the program if a payload is invalid.
+func readPayloads() []map[string]interface{} {
+	var r io.Reader
+	switch {
+	case enqueuePayloadFile == "-":
+		r = os.Stdin
+	case enqueuePayloadFile != "":
+		f, err := os.Open(enqueuePayloadFile)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		r = f
+	case enqueuePayload != "":
+		r = strings.NewReader(enqueuePayload)
+	default:
+		return []map[string]interface{}{nil}
+	}
+	var payloads []map[string]interface{}
+	dec := json.NewDecoder(r)
+	for {
+		var payload map[string]interface{}
+		err := dec.Decode(&payload)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			failUsage("payload %d is not a JSON object: %v", len(payloads)+1, err)
+		}
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) == 0 {
+		failUsage("no payloads are given")
+	}
+	return payloads
+}
+
+// taskState returns the state of tasks in the given key.
+func taskState(keys *base.Keys, key string) string {
+	if state := keys.State(key); state != "" {
+		return state
+	}
+	return "unknown"
+}
+
+// queryType returns the query type of tasks in the given key,
+// or an empty string if the tasks have no query ID.
+func queryType(keys *base.Keys, key string) string {
+	switch key {
+	case keys.ScheduledQueue:
+		return "s"
+	case keys.RetryQueue:
+		return "r"
+	case keys.DeadQueue:
+		return "d"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package cmd
+
+import "errors"
+
+var errTerminalNotSupported = errors.New("interactive terminal is not supported on this platform")
+
+// makeRaw is not supported on this platform.
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errTerminalNotSupported
+}
+
+// terminalSize is not supported on this platform.
+func terminalSize(fd int) (width, height int, err error) {
+	return 0, 0, errTerminalNotSupported
+}
@@ -7,13 +7,11 @@ package cmd
 import (
 	"fmt"
 	"io"
-	"os"
 	"sort"
+	"time"
 
-	"github.com/go-redis/redis/v7"
-	"github.com/hibiken/asynq/internal/rdb"
+	"github.com/hibiken/asynq/internal/base"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // workersCmd represents the workers command
@@ -28,31 +26,57 @@ The command shows the following for each worker:
 * Type of the task worker is processing
 * Payload of the task worker is processing
 * Queue that the task was pulled from.
-* Time the worker started processing the task`,
+* Time the worker started processing the task
+* Progress reported by the handler and the time of the last report
+
+Use --stale to show only the workers whose handler has not reported
+progress for the given duration.`,
 	Args: cobra.NoArgs,
 	Run:  workers,
 }
 
+var workersStale time.Duration
+
 func init() {
 	rootCmd.AddCommand(workersCmd)
+	workersCmd.Flags().DurationVar(&workersStale, "stale", 0, "show only workers which have not reported progress for the duration")
+}
+
+// workerOutput is the output of a running worker.
+type workerOutput struct {
+	Host     string          `json:"host" yaml:"host"`
+	PID      int             `json:"pid" yaml:"pid"`
+	TaskID   string          `json:"task_id" yaml:"task_id"`
+	Type     string          `json:"type" yaml:"type"`
+	Payload  interface{}     `json:"payload" yaml:"payload"`
+	Queue    string          `json:"queue" yaml:"queue"`
+	Started  time.Time       `json:"started" yaml:"started"`
+	Progress *progressOutput `json:"progress,omitempty" yaml:"progress,omitempty"`
+}
+
+// progressOutput is the latest progress reported by a handler.
+type progressOutput struct {
+	Percent int       `json:"percent" yaml:"percent"`
+	Message string    `json:"message,omitempty" yaml:"message,omitempty"`
+	Updated time.Time `json:"updated" yaml:"updated"`
 }
 
 func workers(cmd *cobra.Command, args []string) {
-	r := rdb.NewRDB(redis.NewClient(&redis.Options{
-		Addr:     viper.GetString("uri"),
-		DB:       viper.GetInt("db"),
-		Password: viper.GetString("password"),
-	}))
+	r := newRDB()
 
 	workers, err := r.ListWorkers()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(err)
 	}
 
-	if len(workers) == 0 {
-		fmt.Println("No workers")
-		return
+	if workersStale > 0 {
+		var stale []*base.WorkerInfo
+		for _, wk := range workers {
+			if time.Since(lastActive(wk)) > workersStale {
+				stale = append(stale, wk)
+			}
+		}
+		workers = stale
 	}
 
 	// sort by started timestamp or ID.
@@ -64,12 +88,54 @@ func workers(cmd *cobra.Command, args []string) {
 		return x.ID < y.ID
 	})
 
-	cols := []string{"Process", "ID", "Type", "Payload", "Queue", "Started"}
-	printRows := func(w io.Writer, tmpl string) {
-		for _, wk := range workers {
-			fmt.Fprintf(w, tmpl,
-				fmt.Sprintf("%s:%d", wk.Host, wk.PID), wk.ID, wk.Type, wk.Payload, wk.Queue, timeAgo(wk.Started))
+	out := make([]*workerOutput, 0, len(workers))
+	for _, wk := range workers {
+		o := &workerOutput{
+			Host:    wk.Host,
+			PID:     wk.PID,
+			TaskID:  wk.ID,
+			Type:    wk.Type,
+			Payload: formatPayload(wk.Payload, wk.EncryptedPayload, wk.PayloadRef),
+			Queue:   wk.Queue,
+			Started: wk.Started,
+		}
+		if p := wk.Progress; p != nil {
+			o.Progress = &progressOutput{Percent: p.Percent, Message: p.Message, Updated: p.Updated}
 		}
+		out = append(out, o)
+	}
+	printOutput(out, func() {
+		if len(workers) == 0 {
+			fmt.Println("No workers")
+			return
+		}
+		cols := []string{"Process", "ID", "Type", "Payload", "Queue", "Started", "Progress", "Last Update"}
+		printRows := func(w io.Writer, tmpl string) {
+			for i, wk := range workers {
+				fmt.Fprintf(w, tmpl,
+					fmt.Sprintf("%s:%d", wk.Host, wk.PID), wk.ID, wk.Type, out[i].Payload, wk.Queue, timeAgo(wk.Started),
+					formatProgress(wk.Progress), timeAgo(lastActive(wk)))
+			}
+		}
+		printTable(cols, printRows)
+	})
+}
+
+// lastActive returns the time the worker last reported progress,
+// or the time it started processing if it has not reported any.
+func lastActive(wk *base.WorkerInfo) time.Time {
+	if wk.Progress != nil {
+		return wk.Progress.Updated
+	}
+	return wk.Started
+}
+
+func formatProgress(p *base.Progress) string {
+	if p == nil {
+		return "-"
+	}
+	if p.Message == "" {
+		return fmt.Sprintf("%d%%", p.Percent)
 	}
-	printTable(cols, printRows)
+	return fmt.Sprintf("%d%% %s", p.Percent, p.Message)
 }
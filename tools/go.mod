@@ -9,6 +9,8 @@ require (
 	github.com/rs/xid v1.2.1
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.6.2
+	golang.org/x/sys v0.0.0-20191204072324-ce4227a45e2e
+	gopkg.in/yaml.v2 v2.2.7
 )
 
 replace github.com/hibiken/asynq => ./..
@@ -0,0 +1,187 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/rs/xid"
+)
+
+// WebhookEvent is the body of the request posted to the webhook of a task.
+type WebhookEvent struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Queue string `json:"queue"`
+
+	// State is either EventCompleted or EventKilled.
+	State EventState `json:"state"`
+
+	// Error is the error returned by the last attempt to process the task.
+	// It's set only if the task was killed.
+	Error string `json:"error,omitempty"`
+
+	// Result is the result set by the handler with SetResult.
+	// It is omitted if the result is not valid JSON.
+	Result json.RawMessage `json:"result,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// WebhookSignatureHeader is the name of the header which holds
+// the signature of a webhook request body.
+//
+// The signature is "sha256=" followed by the hex encoded HMAC-SHA256
+// of the body keyed with Config.WebhookSecret.
+const WebhookSignatureHeader = "X-Asynq-Signature"
+
+// VerifyWebhookSignature reports whether signature is a valid signature
+// of the webhook request body for the given secret.
+func VerifyWebhookSignature(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signWebhook(secret, body)), []byte(signature))
+}
+
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func isWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Webhook events are delivered by internal tasks enqueued to the internal
+// webhook queue, so that failed deliveries are retried like other tasks.
+// Every server processes the webhook queue, which is not subject to
+// queue limits.
+const (
+	webhookTaskType = "asynq:webhook"
+	webhookMaxRetry = 10
+	webhookTimeout  = 30 * time.Second
+)
+
+// maxWebhookWorkers returns the number of workers, out of n, which may
+// deliver webhook events at the same time: a tenth of them, at least one.
+func maxWebhookWorkers(n int) int {
+	if n < 10 {
+		return 1
+	}
+	return n / 10
+}
+
+// newWebhookMessage returns a message of the task which delivers
+// the event for msg to its webhook.
+// The result is left out of the event if it is not valid JSON, so that
+// the event is still delivered.
+// The payload of the message is encrypted with enc if enc is not nil.
+func newWebhookMessage(enc Encrypter, msg *base.TaskMessage, state EventState, e error, result []byte) (*base.TaskMessage, error) {
+	event := WebhookEvent{
+		ID:    msg.ID.String(),
+		Type:  msg.Type,
+		Queue: msg.Queue,
+		State: state,
+		Time:  time.Now(),
+	}
+	if json.Valid(result) {
+		event.Result = result
+	}
+	if e != nil {
+		event.Error = e.Error()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	wmsg := &base.TaskMessage{
+		ID:   xid.New(),
+		Type: webhookTaskType,
+		Payload: map[string]interface{}{
+			"url":  msg.Webhook,
+			"body": string(body),
+		},
+		Queue:    base.WebhookQueueName,
+		Retry:    webhookMaxRetry,
+		Timeout:  webhookTimeout.String(),
+		Deadline: time.Time{}.Format(time.RFC3339),
+	}
+	if enc != nil {
+		if wmsg.EncryptedPayload, err = encryptPayload(enc, wmsg.Payload); err != nil {
+			return nil, err
+		}
+		wmsg.Payload = nil
+	}
+	return wmsg, nil
+}
+
+// webhookHandler processes the tasks which deliver webhook events.
+type webhookHandler struct {
+	client *http.Client
+	secret []byte
+}
+
+func (h *webhookHandler) ProcessTask(ctx context.Context, task *Task) error {
+	url, err := task.Payload.GetString("url")
+	if err != nil {
+		return err
+	}
+	body, err := task.Payload.GetString("body")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(h.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, signWebhook(h.secret, []byte(body)))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// taskResult holds the result set by a handler with SetResult.
+type taskResult struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (r *taskResult) set(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data = data
+}
+
+func (r *taskResult) get() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.data
+}
@@ -0,0 +1,340 @@
+// Copyright 2020 Kentaro Hibino. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package asynq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	h "github.com/hibiken/asynq/internal/asynqtest"
+	"github.com/hibiken/asynq/internal/base"
+	"github.com/hibiken/asynq/internal/rdb"
+)
+
+var testWebhookSecret = []byte("webhook-secret")
+
+// webhookRecorder is a webhook endpoint which records the events
+// with a valid signature.
+type webhookRecorder struct {
+	mu     sync.Mutex
+	status int
+	events []*WebhookEvent
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !VerifyWebhookSignature(testWebhookSecret, body, r.Header.Get(WebhookSignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var e WebhookEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.events = append(rec.events, &e)
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+}
+
+func (rec *webhookRecorder) get() []*WebhookEvent {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]*WebhookEvent(nil), rec.events...)
+}
+
+func TestWebhookHandler(t *testing.T) {
+	msg := h.NewTaskMessage("send_email", nil)
+	tests := []struct {
+		desc    string
+		secret  []byte
+		status  int
+		wantErr bool
+		wantN   int // number of events recorded by the endpoint
+	}{
+		{desc: "delivered", secret: testWebhookSecret, status: http.StatusOK, wantErr: false, wantN: 1},
+		{desc: "server error", secret: testWebhookSecret, status: http.StatusInternalServerError, wantErr: true, wantN: 1},
+		{desc: "wrong secret", secret: []byte("wrong"), status: http.StatusOK, wantErr: true, wantN: 0},
+		{desc: "unsigned", secret: nil, status: http.StatusOK, wantErr: true, wantN: 0},
+	}
+
+	for _, tc := range tests {
+		rec := &webhookRecorder{status: tc.status}
+		srv := httptest.NewServer(rec)
+		msg.Webhook = srv.URL
+
+		wmsg, err := newWebhookMessage(nil, msg, EventCompleted, nil, []byte(`{"sent":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := &webhookHandler{client: srv.Client(), secret: tc.secret}
+		err = handler.ProcessTask(context.Background(), NewTask(wmsg.Type, wmsg.Payload))
+		srv.Close()
+
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: ProcessTask returned error %v, want error %t", tc.desc, err, tc.wantErr)
+		}
+		got := rec.get()
+		if len(got) != tc.wantN {
+			t.Errorf("%s: endpoint recorded %d events, want %d", tc.desc, len(got), tc.wantN)
+			continue
+		}
+		if tc.wantN == 0 {
+			continue
+		}
+		want := &WebhookEvent{
+			ID:     msg.ID.String(),
+			Type:   msg.Type,
+			Queue:  msg.Queue,
+			State:  EventCompleted,
+			Result: json.RawMessage(`{"sent":true}`),
+		}
+		if diff := cmp.Diff(want, got[0], cmpopts.IgnoreFields(WebhookEvent{}, "Time")); diff != "" {
+			t.Errorf("%s: endpoint recorded %+v, want %+v; (-want,+got)\n%s", tc.desc, got[0], want, diff)
+		}
+	}
+}
+
+func TestProcessorDeliversWebhook(t *testing.T) {
+	r := setup(t)
+	h.FlushDB(t, r)
+	rdbClient := rdb.NewRDB(r)
+
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	m1 := h.NewTaskMessage("send_email", nil)
+	m1.Webhook = srv.URL
+	m2 := h.NewTaskMessage("reindex", nil)
+	m2.Retried = m2.Retry // m2 has reached its max retry count
+	m2.Webhook = srv.URL
+	m3 := h.NewTaskMessage("sync", nil) // no webhook
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{m1, m2, m3})
+
+	starting := make(chan *base.TaskMessage)
+	finished := make(chan *base.TaskMessage)
+	done := make(chan struct{})
+	defer func() { close(done) }()
+	go fakeHeartbeater(starting, finished, done)
+	p := newProcessor(processorParams{
+		logger:          testLogger,
+		broker:          rdbClient,
+		retryDelayFunc:  defaultDelayFunc,
+		syncCh:          nil,
+		cancelations:    base.NewCancelations(),
+		progress:        base.NewProgressReports(),
+		concurrency:     10,
+		queues:          defaultQueueConfig,
+		strictPriority:  false,
+		webhookSecret:   testWebhookSecret,
+		shutdownTimeout: defaultShutdownTimeout,
+		starting:        starting,
+		finished:        finished,
+	})
+	p.handler = HandlerFunc(func(ctx context.Context, task *Task) error {
+		if task.Type == "reindex" {
+			return errors.New("index not found")
+		}
+		return SetResult(ctx, map[string]interface{}{"type": task.Type})
+	})
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(3 * time.Second)
+	p.terminate()
+
+	want := []*WebhookEvent{
+		{
+			ID:     m1.ID.String(),
+			Type:   m1.Type,
+			Queue:  m1.Queue,
+			State:  EventCompleted,
+			Result: json.RawMessage(`{"type":"send_email"}`),
+		},
+		{
+			ID:    m2.ID.String(),
+			Type:  m2.Type,
+			Queue: m2.Queue,
+			State: EventKilled,
+			Error: "index not found",
+		},
+	}
+	got := rec.get()
+	sortOpt := cmpopts.SortSlices(func(x, y *WebhookEvent) bool { return x.Type < y.Type })
+	if diff := cmp.Diff(want, got, sortOpt, cmpopts.IgnoreFields(WebhookEvent{}, "Time")); diff != "" {
+		t.Errorf("endpoint recorded %v, want %v; (-want,+got)\n%s", got, want, diff)
+	}
+	if l := r.LLen(base.DefaultQueue).Val(); l != 0 {
+		t.Errorf("%q has %d tasks, want 0", base.DefaultQueue, l)
+	}
+}
+
+func TestProcessorLimitsWebhookWorkers(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+
+	var (
+		mu       sync.Mutex
+		active   int
+		maxCount int
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxCount {
+			maxCount = active
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	var webhooks []*base.TaskMessage
+	for i := 0; i < 5; i++ {
+		msg := h.NewTaskMessage("send_email", nil)
+		msg.Webhook = srv.URL
+		wmsg, err := newWebhookMessage(nil, msg, EventCompleted, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		webhooks = append(webhooks, wmsg)
+	}
+	h.SeedEnqueuedQueue(t, r, webhooks, base.WebhookQueueName)
+	tasks := []*base.TaskMessage{h.NewTaskMessage("sync", nil), h.NewTaskMessage("sync", nil), h.NewTaskMessage("sync", nil)}
+	h.SeedEnqueuedQueue(t, r, tasks)
+
+	starting := make(chan *base.TaskMessage)
+	finished := make(chan *base.TaskMessage)
+	done := make(chan struct{})
+	defer func() { close(done) }()
+	go fakeHeartbeater(starting, finished, done)
+	p := newProcessor(processorParams{
+		logger:          testLogger,
+		broker:          rdbClient,
+		retryDelayFunc:  defaultDelayFunc,
+		cancelations:    base.NewCancelations(),
+		progress:        base.NewProgressReports(),
+		concurrency:     10,
+		queues:          defaultQueueConfig,
+		shutdownTimeout: defaultShutdownTimeout,
+		starting:        starting,
+		finished:        finished,
+	})
+	var processed int32
+	p.handler = HandlerFunc(func(ctx context.Context, task *Task) error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+
+	p.start(&sync.WaitGroup{})
+	time.Sleep(2 * time.Second)
+	close(release)
+	p.terminate()
+
+	if n := atomic.LoadInt32(&processed); n != int32(len(tasks)) {
+		t.Errorf("processed %d tasks while webhook deliveries were pending, want %d", n, len(tasks))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxCount != maxWebhookWorkers(10) {
+		t.Errorf("%d webhook events were delivered at the same time, want %d", maxCount, maxWebhookWorkers(10))
+	}
+}
+
+func TestEnqueueWebhook(t *testing.T) {
+	r := setup(t)
+	rdbClient := rdb.NewRDB(r)
+	enc, err := NewAESEncrypter(testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The queue of the task is full, which doesn't affect webhook events.
+	if err := rdbClient.SetQueueLimit(base.DefaultQueueName, &base.QueueLimit{MaxSize: 1, Policy: base.QueueFullReject}); err != nil {
+		t.Fatal(err)
+	}
+	h.SeedEnqueuedQueue(t, r, []*base.TaskMessage{h.NewTaskMessage("sync", nil)})
+
+	p := newProcessor(processorParams{
+		logger:          testLogger,
+		broker:          rdbClient,
+		retryDelayFunc:  defaultDelayFunc,
+		cancelations:    base.NewCancelations(),
+		progress:        base.NewProgressReports(),
+		concurrency:     10,
+		queues:          defaultQueueConfig,
+		encrypter:       enc,
+		shutdownTimeout: defaultShutdownTimeout,
+	})
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.Webhook = "https://example.com/hook"
+	p.enqueueWebhook(msg, EventCompleted, nil, []byte(`{"token":"s3cret"}`))
+
+	data := r.LRange(base.QueueKey(base.WebhookQueueName), 0, -1).Val()
+	if len(data) != 1 {
+		t.Fatalf("%q has %d tasks, want 1", base.QueueKey(base.WebhookQueueName), len(data))
+	}
+	if strings.Contains(data[0], "s3cret") {
+		t.Errorf("webhook task %s holds the result in plaintext", data[0])
+	}
+	wmsg := h.MustUnmarshal(t, data[0])
+	if wmsg.Type != webhookTaskType || wmsg.Payload != nil || wmsg.EncryptedPayload == nil {
+		t.Fatalf("webhook task = %+v, want an encrypted %q task", wmsg, webhookTaskType)
+	}
+	task, err := p.newTask(wmsg)
+	if err != nil {
+		t.Fatalf("could not decrypt webhook task: %v", err)
+	}
+	if body, _ := task.Payload.GetString("body"); !strings.Contains(body, "s3cret") {
+		t.Errorf("webhook event body = %q, want the result", body)
+	}
+}
+
+func TestNewWebhookMessageInvalidResult(t *testing.T) {
+	msg := h.NewTaskMessage("send_email", nil)
+	msg.Webhook = "https://example.com/hook"
+	wmsg, err := newWebhookMessage(nil, msg, EventCompleted, nil, []byte(`{"token":`))
+	if err != nil {
+		t.Fatalf("newWebhookMessage with an invalid result returned error: %v", err)
+	}
+	body, ok := wmsg.Payload["body"].(string)
+	if !ok {
+		t.Fatalf("webhook task payload = %v, want a body", wmsg.Payload)
+	}
+	var e WebhookEvent
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatalf("webhook event body %q is not valid JSON: %v", body, err)
+	}
+	if e.ID != msg.ID.String() || e.State != EventCompleted || e.Result != nil {
+		t.Errorf("webhook event = %+v, want the event of task %q without a result", e, msg.ID)
+	}
+}
+
+func TestSetResultWithoutTask(t *testing.T) {
+	if err := SetResult(context.Background(), "result"); err == nil {
+		t.Error("SetResult with a context not associated with a task returned nil error")
+	}
+}